@@ -0,0 +1,407 @@
+// Package anthropic provides middleware for partial compatibility with the
+// Anthropic Messages API.
+package anthropic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/goobla/goobla/api"
+)
+
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
+
+func NewError(code int, message string) ErrorResponse {
+	var etype string
+	switch code {
+	case http.StatusBadRequest:
+		etype = "invalid_request_error"
+	case http.StatusUnauthorized:
+		etype = "authentication_error"
+	case http.StatusForbidden:
+		etype = "permission_error"
+	case http.StatusNotFound:
+		etype = "not_found_error"
+	case http.StatusTooManyRequests:
+		etype = "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		etype = "overloaded_error"
+	default:
+		etype = "api_error"
+	}
+	return ErrorResponse{Type: "error", Error: Error{Type: etype, Message: message}}
+}
+
+type MessagesRequest struct {
+	Model         string          `json:"model"`
+	MaxTokens     int             `json:"max_tokens"`
+	Messages      []Message       `json:"messages"`
+	System        json.RawMessage `json:"system"`
+	StopSequences []string        `json:"stop_sequences"`
+	Stream        bool            `json:"stream"`
+	Temperature   *float64        `json:"temperature"`
+	TopP          *float64        `json:"top_p"`
+	TopK          *int            `json:"top_k"`
+	Tools         []Tool          `json:"tools"`
+	ToolChoice    *ToolChoice     `json:"tool_choice"`
+	Thinking      *Thinking       `json:"thinking"`
+}
+
+type Message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// ContentBlock is a block of message content. Which fields are set depends
+// on the block's type: text, image, tool_use, tool_result or thinking.
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text *string `json:"text,omitempty"`
+
+	// image
+	Source *ImageSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+
+	// thinking
+	Thinking  *string `json:"thinking,omitempty"`
+	Signature *string `json:"signature,omitempty"`
+}
+
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use"`
+}
+
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type MessagesResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []ContentBlock `json:"content"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        Usage          `json:"usage"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Event is an event of a streamed message. Type is sent both as the SSE
+// event name and in the payload.
+type Event struct {
+	Type         string            `json:"type"`
+	Message      *MessagesResponse `json:"message,omitempty"`
+	Index        *int              `json:"index,omitempty"`
+	ContentBlock *ContentBlock     `json:"content_block,omitempty"`
+	Delta        any               `json:"delta,omitempty"`
+	Usage        *Usage            `json:"usage,omitempty"`
+}
+
+// Delta is the delta of a content_block_delta event.
+type Delta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	Thinking    string `json:"thinking,omitempty"`
+	PartialJSON string `json:"partial_json,omitempty"`
+}
+
+// MessageDelta is the delta of a message_delta event.
+type MessageDelta struct {
+	StopReason   string  `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+func randomID(prefix string) string {
+	const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, 24)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return prefix + string(b)
+}
+
+// MessageID returns a new random message id.
+func MessageID() string {
+	return randomID("msg_")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// toToolUse converts a tool call to a tool_use content block.
+func toToolUse(tc api.ToolCall) ContentBlock {
+	input, err := json.Marshal(tc.Function.Arguments)
+	if err != nil || tc.Function.Arguments == nil {
+		input = []byte("{}")
+	}
+	return ContentBlock{Type: "tool_use", ID: randomID("toolu_"), Name: tc.Function.Name, Input: input}
+}
+
+// toStopReason returns the stop reason of a finished chat response.
+func toStopReason(r api.ChatResponse, toolUse bool) string {
+	switch {
+	case toolUse:
+		return "tool_use"
+	case r.DoneReason == "length":
+		return "max_tokens"
+	default:
+		return "end_turn"
+	}
+}
+
+func toUsage(r api.ChatResponse) Usage {
+	return Usage{InputTokens: r.PromptEvalCount, OutputTokens: r.EvalCount}
+}
+
+// ToMessagesResponse converts a complete chat response to a message.
+func ToMessagesResponse(id string, r api.ChatResponse) MessagesResponse {
+	content := []ContentBlock{}
+	if r.Message.Thinking != "" {
+		content = append(content, ContentBlock{Type: "thinking", Thinking: ptr(r.Message.Thinking), Signature: ptr("")})
+	}
+
+	if r.Message.Content != "" {
+		content = append(content, ContentBlock{Type: "text", Text: ptr(r.Message.Content)})
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		content = append(content, toToolUse(tc))
+	}
+
+	return MessagesResponse{
+		ID:         id,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      r.Model,
+		Content:    content,
+		StopReason: ptr(toStopReason(r, len(r.Message.ToolCalls) > 0)),
+		Usage:      toUsage(r),
+	}
+}
+
+// FromMessagesRequest converts a Messages API request to a chat request.
+func FromMessagesRequest(r MessagesRequest) (*api.ChatRequest, error) {
+	var messages []api.Message
+	if len(r.System) > 0 {
+		system, err := textContent(r.System)
+		if err != nil {
+			return nil, fmt.Errorf("invalid system prompt: %w", err)
+		}
+		if system != "" {
+			messages = append(messages, api.Message{Role: "system", Content: system})
+		}
+	}
+
+	for _, m := range r.Messages {
+		msgs, err := fromMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msgs...)
+	}
+
+	options := make(map[string]any)
+	if r.MaxTokens > 0 {
+		options["num_predict"] = r.MaxTokens
+	}
+	if len(r.StopSequences) > 0 {
+		options["stop"] = r.StopSequences
+	}
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	}
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	}
+	if r.TopK != nil {
+		options["top_k"] = *r.TopK
+	}
+
+	var tools []api.Tool
+	for _, t := range r.Tools {
+		tool := api.Tool{Type: "function"}
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		if len(t.InputSchema) > 0 {
+			if err := json.Unmarshal(t.InputSchema, &tool.Function.Parameters); err != nil {
+				return nil, fmt.Errorf("invalid input_schema for tool %q: %w", t.Name, err)
+			}
+		}
+		tools = append(tools, tool)
+	}
+
+	var toolChoice *api.ToolChoice
+	var parallelToolCalls *bool
+	if r.ToolChoice != nil {
+		toolChoice = &api.ToolChoice{}
+		switch r.ToolChoice.Type {
+		case "auto":
+			toolChoice.Type = api.ToolChoiceAuto
+		case "any":
+			toolChoice.Type = api.ToolChoiceRequired
+		case "tool":
+			toolChoice.Type = api.ToolChoiceFunction
+			toolChoice.Function.Name = r.ToolChoice.Name
+		case "none":
+			toolChoice.Type = api.ToolChoiceNone
+		default:
+			return nil, fmt.Errorf("invalid tool_choice type %q", r.ToolChoice.Type)
+		}
+
+		if r.ToolChoice.DisableParallelToolUse {
+			parallelToolCalls = ptr(false)
+		}
+	}
+
+	var think *bool
+	if r.Thinking != nil {
+		think = ptr(r.Thinking.Type == "enabled")
+	}
+
+	return &api.ChatRequest{
+		Model:             r.Model,
+		Messages:          messages,
+		Options:           options,
+		Stream:            &r.Stream,
+		Tools:             tools,
+		ToolChoice:        toolChoice,
+		ParallelToolCalls: parallelToolCalls,
+		Think:             think,
+	}, nil
+}
+
+// fromMessage converts a message to chat messages. Tool results become tool
+// messages ahead of the rest of the message's content, which is merged into
+// a single message.
+func fromMessage(m Message) ([]api.Message, error) {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return []api.Message{{Role: m.Role, Content: text}}, nil
+	}
+
+	var blocks []ContentBlock
+	if err := json.Unmarshal(m.Content, &blocks); err != nil {
+		return nil, errors.New("invalid message content: expected a string or a list of content blocks")
+	}
+
+	var messages []api.Message
+	msg := api.Message{Role: m.Role}
+	var sb strings.Builder
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			if b.Text != nil {
+				sb.WriteString(*b.Text)
+			}
+		case "image":
+			if b.Source == nil || b.Source.Type != "base64" {
+				return nil, errors.New("invalid image: only base64 image sources are supported")
+			}
+			img, err := base64.StdEncoding.DecodeString(b.Source.Data)
+			if err != nil {
+				return nil, errors.New("invalid image: data is not base64 encoded")
+			}
+			msg.Images = append(msg.Images, img)
+		case "tool_use":
+			var args api.ToolCallFunctionArguments
+			if len(b.Input) > 0 {
+				if err := json.Unmarshal(b.Input, &args); err != nil {
+					return nil, fmt.Errorf("invalid input for tool_use %q", b.Name)
+				}
+			}
+			msg.ToolCalls = append(msg.ToolCalls, api.ToolCall{Function: api.ToolCallFunction{Name: b.Name, Arguments: args}})
+		case "tool_result":
+			content, err := textContent(b.Content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tool_result content: %w", err)
+			}
+			messages = append(messages, api.Message{Role: "tool", Content: content})
+		case "thinking":
+			if b.Thinking != nil {
+				msg.Thinking += *b.Thinking
+			}
+		case "redacted_thinking":
+		default:
+			return nil, fmt.Errorf("unsupported content block type %q", b.Type)
+		}
+	}
+
+	msg.Content = sb.String()
+	if msg.Content != "" || msg.Thinking != "" || len(msg.Images) > 0 || len(msg.ToolCalls) > 0 {
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// textContent returns the text of content that is either a string or a
+// list of text blocks.
+func textContent(b json.RawMessage) (string, error) {
+	if len(b) == 0 {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(b, &text); err == nil {
+		return text, nil
+	}
+
+	var blocks []ContentBlock
+	if err := json.Unmarshal(b, &blocks); err != nil {
+		return "", errors.New("expected a string or a list of text blocks")
+	}
+
+	var sb strings.Builder
+	for _, b := range blocks {
+		if b.Type != "text" || b.Text == nil {
+			return "", fmt.Errorf("unsupported content block type %q", b.Type)
+		}
+		sb.WriteString(*b.Text)
+	}
+	return sb.String(), nil
+}
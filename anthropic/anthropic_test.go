@@ -0,0 +1,127 @@
+package anthropic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/goobla/goobla/api"
+)
+
+func TestFromMessagesRequest(t *testing.T) {
+	img := base64.StdEncoding.EncodeToString([]byte("image"))
+
+	var r MessagesRequest
+	if err := json.Unmarshal([]byte(`{
+		"model": "test-model",
+		"max_tokens": 128,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "What's the weather here?"}, {"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "`+img+`"}}]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "need the weather", "signature": "sig"},
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]}, {"type": "text", "text": "Thanks"}]}
+		],
+		"stop_sequences": ["\n\n"],
+		"top_k": 40,
+		"tools": [{"name": "get_weather", "description": "Get the weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}],
+		"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+		"thinking": {"type": "enabled", "budget_tokens": 1024}
+	}`), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	chat, err := FromMessagesRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []api.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What's the weather here?", Images: []api.ImageData{[]byte("image")}},
+		{Role: "assistant", Content: "Let me check.", Thinking: "need the weather", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}},
+		{Role: "tool", Content: "sunny"},
+		{Role: "user", Content: "Thanks"},
+	}
+	if diff := cmp.Diff(want, chat.Messages); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]any{"num_predict": 128, "stop": []string{"\n\n"}, "top_k": 40}, chat.Options); diff != "" {
+		t.Errorf("options mismatch (-want +got):\n%s", diff)
+	}
+
+	if len(chat.Tools) != 1 || chat.Tools[0].Function.Name != "get_weather" || chat.Tools[0].Function.Parameters.Required[0] != "city" {
+		t.Errorf("unexpected tools: %v", chat.Tools)
+	}
+
+	if chat.ToolChoice == nil || chat.ToolChoice.Type != api.ToolChoiceRequired {
+		t.Errorf("unexpected tool_choice: %+v", chat.ToolChoice)
+	}
+
+	if chat.ParallelToolCalls == nil || *chat.ParallelToolCalls {
+		t.Errorf("expected parallel tool calls to be disabled")
+	}
+
+	if chat.Think == nil || !*chat.Think {
+		t.Errorf("expected thinking to be enabled")
+	}
+}
+
+func TestFromMessagesRequestErrors(t *testing.T) {
+	cases := map[string]string{
+		"invalid content":     `{"messages": [{"role": "user", "content": 42}]}`,
+		"unknown block":       `{"messages": [{"role": "user", "content": [{"type": "document"}]}]}`,
+		"url image":           `{"messages": [{"role": "user", "content": [{"type": "image", "source": {"type": "url", "url": "https://example.com/a.png"}}]}]}`,
+		"invalid tool_choice": `{"messages": [{"role": "user", "content": "hi"}], "tool_choice": {"type": "always"}}`,
+	}
+
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			var r MessagesRequest
+			if err := json.Unmarshal([]byte(body), &r); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := FromMessagesRequest(r); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestToMessagesResponse(t *testing.T) {
+	resp := ToMessagesResponse("msg_1", api.ChatResponse{
+		Model: "test-model",
+		Message: api.Message{
+			Role:      "assistant",
+			Content:   "Let me check.",
+			Thinking:  "need the weather",
+			ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}},
+		},
+		Done:       true,
+		DoneReason: "stop",
+		Metrics:    api.Metrics{PromptEvalCount: 10, EvalCount: 20},
+	})
+
+	if resp.ID != "msg_1" || resp.Type != "message" || resp.Role != "assistant" || *resp.StopReason != "tool_use" {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+
+	if len(resp.Content) != 3 || *resp.Content[0].Thinking != "need the weather" || *resp.Content[1].Text != "Let me check." || resp.Content[2].Name != "get_weather" || string(resp.Content[2].Input) != `{"city":"Paris"}` {
+		t.Errorf("unexpected content: %#v", resp.Content)
+	}
+
+	if resp.Usage != (Usage{InputTokens: 10, OutputTokens: 20}) {
+		t.Errorf("unexpected usage: %#v", resp.Usage)
+	}
+
+	resp = ToMessagesResponse("msg_2", api.ChatResponse{Message: api.Message{Role: "assistant", Content: "Once upon"}, Done: true, DoneReason: "length"})
+	if *resp.StopReason != "max_tokens" {
+		t.Errorf("unexpected stop reason %q", *resp.StopReason)
+	}
+}
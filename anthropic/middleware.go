@@ -0,0 +1,201 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+)
+
+// MessagesWriter translates chat responses to the Messages API. When
+// streaming, the chat response deltas are turned into content_block events
+// between message_start and message_stop.
+type MessagesWriter struct {
+	gin.ResponseWriter
+	Stream bool
+	ID     string
+
+	started bool
+	index   int
+	// block is the type of the open content block, if any
+	block   string
+	toolUse bool
+}
+
+func (w *MessagesWriter) writeError(data []byte) (int, error) {
+	var serr api.StatusError
+	if err := json.Unmarshal(data, &serr); err != nil {
+		return 0, err
+	}
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w.ResponseWriter).Encode(NewError(w.ResponseWriter.Status(), serr.Error())); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *MessagesWriter) writeResponse(data []byte) (int, error) {
+	var r api.ChatResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return 0, err
+	}
+
+	if !w.Stream {
+		w.ResponseWriter.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w.ResponseWriter).Encode(ToMessagesResponse(w.ID, r)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+	if !w.started {
+		w.started = true
+		if err := w.writeEvent(Event{Type: "message_start", Message: &MessagesResponse{
+			ID:      w.ID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   r.Model,
+			Content: []ContentBlock{},
+			Usage:   toUsage(r),
+		}}); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.Message.Thinking != "" {
+		if err := w.writeDelta(ContentBlock{Type: "thinking", Thinking: ptr(""), Signature: ptr("")}, Delta{Type: "thinking_delta", Thinking: r.Message.Thinking}); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.Message.Content != "" {
+		if err := w.writeDelta(ContentBlock{Type: "text", Text: ptr("")}, Delta{Type: "text_delta", Text: r.Message.Content}); err != nil {
+			return 0, err
+		}
+	}
+
+	// tool calls are parsed from the model output in full, so each is sent
+	// as a block with a single input delta
+	for _, tc := range r.Message.ToolCalls {
+		w.toolUse = true
+		block := toToolUse(tc)
+		input := string(block.Input)
+		block.Input = json.RawMessage("{}")
+		if err := w.closeBlock(); err != nil {
+			return 0, err
+		}
+		if err := w.writeDelta(block, Delta{Type: "input_json_delta", PartialJSON: input}); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.Done {
+		if err := w.closeBlock(); err != nil {
+			return 0, err
+		}
+
+		usage := toUsage(r)
+		if err := w.writeEvent(Event{
+			Type:  "message_delta",
+			Delta: MessageDelta{StopReason: toStopReason(r, w.toolUse)},
+			Usage: &usage,
+		}); err != nil {
+			return 0, err
+		}
+
+		if err := w.writeEvent(Event{Type: "message_stop"}); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+// writeDelta writes delta to the open content block, first closing it and
+// starting block if the open block is of another type.
+func (w *MessagesWriter) writeDelta(block ContentBlock, delta Delta) error {
+	if w.block != block.Type {
+		if err := w.closeBlock(); err != nil {
+			return err
+		}
+
+		if err := w.writeEvent(Event{Type: "content_block_start", Index: ptr(w.index), ContentBlock: &block}); err != nil {
+			return err
+		}
+		w.block = block.Type
+	}
+
+	return w.writeEvent(Event{Type: "content_block_delta", Index: ptr(w.index), Delta: delta})
+}
+
+func (w *MessagesWriter) closeBlock() error {
+	if w.block == "" {
+		return nil
+	}
+
+	if err := w.writeEvent(Event{Type: "content_block_stop", Index: ptr(w.index)}); err != nil {
+		return err
+	}
+
+	w.block = ""
+	w.index++
+	return nil
+}
+
+func (w *MessagesWriter) writeEvent(event Event) error {
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, d)))
+	return err
+}
+
+func (w *MessagesWriter) Write(data []byte) (int, error) {
+	if w.ResponseWriter.Status() != http.StatusOK {
+		return w.writeError(data)
+	}
+	return w.writeResponse(data)
+}
+
+func MessagesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MessagesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		if len(req.Messages) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "messages: at least one message is required"))
+			return
+		}
+		if req.MaxTokens <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "max_tokens: must be greater than 0"))
+			return
+		}
+		var b bytes.Buffer
+		chatReq, err := FromMessagesRequest(req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+		c.Request.Body = io.NopCloser(&b)
+		c.Writer = &MessagesWriter{
+			ResponseWriter: c.Writer,
+			Stream:         req.Stream,
+			ID:             MessageID(),
+		}
+		c.Next()
+	}
+}
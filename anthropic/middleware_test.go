@@ -0,0 +1,198 @@
+package anthropic
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+)
+
+func newTestWriter(status int) (gin.ResponseWriter, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Status(status)
+	return c.Writer, rec
+}
+
+func TestMessagesWriter(t *testing.T) {
+	resp := api.ChatResponse{
+		Model:      "test-model",
+		Message:    api.Message{Role: "assistant", Content: "hello"},
+		Done:       true,
+		DoneReason: "stop",
+		Metrics:    api.Metrics{PromptEvalCount: 1, EvalCount: 2},
+	}
+	data, _ := json.Marshal(resp)
+
+	w, rec := newTestWriter(http.StatusOK)
+	mw := &MessagesWriter{ID: "msg_1", ResponseWriter: w}
+	if _, err := mw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var got MessagesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := ToMessagesResponse("msg_1", resp); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected response: %#v", got)
+	}
+
+	serr := api.StatusError{StatusCode: 404, Status: "404", ErrorMessage: "model not found"}
+	data, _ = json.Marshal(serr)
+	w, rec = newTestWriter(http.StatusNotFound)
+	mw = &MessagesWriter{ID: "msg_1", ResponseWriter: w}
+	if _, err := mw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var errResp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+		t.Fatal(err)
+	}
+	if want := NewError(http.StatusNotFound, serr.Error()); !reflect.DeepEqual(errResp, want) {
+		t.Fatalf("unexpected error response: %#v", errResp)
+	}
+}
+
+func TestMessagesWriterStream(t *testing.T) {
+	w, rec := newTestWriter(http.StatusOK)
+	mw := &MessagesWriter{ID: "msg_1", Stream: true, ResponseWriter: w}
+	for _, r := range []api.ChatResponse{
+		{Model: "test-model", Message: api.Message{Role: "assistant", Thinking: "need "}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", Thinking: "weather"}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", Content: "Let me check."}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}},
+			{Function: api.ToolCallFunction{Name: "get_time"}},
+		}}},
+		{Model: "test-model", Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 3, EvalCount: 5}},
+	} {
+		data, _ := json.Marshal(r)
+		if _, err := mw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	var events []map[string]any
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			names = append(names, name)
+		} else if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event map[string]any
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event["type"] != names[len(names)-1] {
+				t.Fatalf("event name %q does not match payload type %q", names[len(names)-1], event["type"])
+			}
+			events = append(events, event)
+		}
+	}
+
+	want := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta",
+		"message_stop",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected events:\n got %v\nwant %v", names, want)
+	}
+
+	if block := events[1]["content_block"].(map[string]any); block["type"] != "thinking" || block["thinking"] != "" {
+		t.Errorf("unexpected thinking block: %v", block)
+	}
+
+	if delta := events[6]["delta"].(map[string]any); delta["type"] != "text_delta" || delta["text"] != "Let me check." || events[6]["index"] != 1.0 {
+		t.Errorf("unexpected text delta: %v", events[6])
+	}
+
+	if block := events[8]["content_block"].(map[string]any); block["type"] != "tool_use" || block["name"] != "get_weather" || !reflect.DeepEqual(block["input"], map[string]any{}) {
+		t.Errorf("unexpected tool_use block: %v", block)
+	}
+
+	if delta := events[9]["delta"].(map[string]any); delta["type"] != "input_json_delta" || delta["partial_json"] != `{"city":"Paris"}` {
+		t.Errorf("unexpected input delta: %v", delta)
+	}
+
+	if delta := events[12]["delta"].(map[string]any); delta["partial_json"] != `{}` || events[12]["index"] != 3.0 {
+		t.Errorf("unexpected input delta: %v", events[12])
+	}
+
+	messageDelta := events[14]
+	if delta := messageDelta["delta"].(map[string]any); delta["stop_reason"] != "tool_use" {
+		t.Errorf("unexpected message delta: %v", delta)
+	}
+	if usage := messageDelta["usage"].(map[string]any); usage["input_tokens"] != 3.0 || usage["output_tokens"] != 5.0 {
+		t.Errorf("unexpected usage: %v", usage)
+	}
+}
+
+func TestMessagesMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var captured api.ChatRequest
+	router := gin.New()
+	router.POST("/v1/messages", MessagesMiddleware(), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		if err := json.Unmarshal(body, &captured); err != nil {
+			t.Fatal(err)
+		}
+		c.JSON(http.StatusOK, api.ChatResponse{Model: captured.Model, Message: api.Message{Role: "assistant", Content: "hi"}, Done: true})
+	})
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "valid", body: `{"model": "test-model", "max_tokens": 16, "messages": [{"role": "user", "content": "Hello"}]}`, status: http.StatusOK},
+		{name: "missing messages", body: `{"model": "test-model", "max_tokens": 16, "messages": []}`, status: http.StatusBadRequest},
+		{name: "missing max_tokens", body: `{"model": "test-model", "messages": [{"role": "user", "content": "Hello"}]}`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+
+			if tt.status != http.StatusOK {
+				var errResp ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+					t.Fatal(err)
+				}
+				if errResp.Type != "error" || errResp.Error.Type != "invalid_request_error" {
+					t.Errorf("unexpected error response: %#v", errResp)
+				}
+				return
+			}
+
+			var resp MessagesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Model != "test-model" || *resp.Content[0].Text != "hi" {
+				t.Errorf("unexpected response: %#v", resp)
+			}
+		})
+	}
+}
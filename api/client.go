@@ -87,6 +87,42 @@ func getAuthorizationToken(ctx context.Context, challenge string) (string, error
 	return token, nil
 }
 
+// newRequest returns a request to path, authenticated with a signature or an
+// API key if the environment configures one.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	requestURL := c.base.JoinPath(path)
+
+	var token string
+	if envconfig.UseAuth() || c.base.Hostname() == "goobla.com" {
+		var err error
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		chal := fmt.Sprintf("%s,%s?ts=%s", method, path, now)
+		token, err = getAuthorizationToken(ctx, chal)
+		if err != nil {
+			return nil, err
+		}
+
+		q := requestURL.Query()
+		q.Set("ts", now)
+		requestURL.RawQuery = q.Encode()
+	} else if key := envconfig.APIKey(); key != "" {
+		token = "Bearer " + key
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("User-Agent", fmt.Sprintf("goobla/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+
+	return request, nil
+}
+
 func (c *Client) do(ctx context.Context, method, path string, reqData, respData any) error {
 	var reqBody io.Reader
 	var data []byte
@@ -107,34 +143,13 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 		reqBody = bytes.NewReader(data)
 	}
 
-	requestURL := c.base.JoinPath(path)
-
-	var token string
-	if envconfig.UseAuth() || c.base.Hostname() == "goobla.com" {
-		now := strconv.FormatInt(time.Now().Unix(), 10)
-		chal := fmt.Sprintf("%s,%s?ts=%s", method, path, now)
-		token, err = getAuthorizationToken(ctx, chal)
-		if err != nil {
-			return err
-		}
-
-		q := requestURL.Query()
-		q.Set("ts", now)
-		requestURL.RawQuery = q.Encode()
-	}
-
-	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), reqBody)
+	request, err := c.newRequest(ctx, method, path, reqBody)
 	if err != nil {
 		return err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
-	request.Header.Set("User-Agent", fmt.Sprintf("goobla/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
-
-	if token != "" {
-		request.Header.Set("Authorization", token)
-	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -172,35 +187,13 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 		buf = bytes.NewBuffer(bts)
 	}
 
-	requestURL := c.base.JoinPath(path)
-
-	var token string
-	if envconfig.UseAuth() || c.base.Hostname() == "goobla.com" {
-		var err error
-		now := strconv.FormatInt(time.Now().Unix(), 10)
-		chal := fmt.Sprintf("%s,%s?ts=%s", method, path, now)
-		token, err = getAuthorizationToken(ctx, chal)
-		if err != nil {
-			return err
-		}
-
-		q := requestURL.Query()
-		q.Set("ts", now)
-		requestURL.RawQuery = q.Encode()
-	}
-
-	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), buf)
+	request, err := c.newRequest(ctx, method, path, buf)
 	if err != nil {
 		return err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
-	request.Header.Set("User-Agent", fmt.Sprintf("goobla/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
-
-	if token != "" {
-		request.Header.Set("Authorization", token)
-	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -357,6 +350,74 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// ListRequests lists the generate, chat and embed requests that are queued
+// or running on the server.
+func (c *Client) ListRequests(ctx context.Context) (*ListRequestsResponse, error) {
+	var lr ListRequestsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/requests", nil, &lr); err != nil {
+		return nil, err
+	}
+	return &lr, nil
+}
+
+// CancelRequest cancels a queued or running request by the ID the server
+// returned for it in the X-Goobla-Request-Id response header, or listed in
+// [Client.ListRequests].
+func (c *Client) CancelRequest(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/requests/"+url.PathEscape(id), nil, nil)
+}
+
+// CreateBatch creates a batch of generate, chat and embed requests that the
+// server runs in the background at low priority.
+func (c *Client) CreateBatch(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	var resp BatchResponse
+	if err := c.do(ctx, http.MethodPost, "/api/batch", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Batch returns the status of a batch.
+func (c *Client) Batch(ctx context.Context, id string) (*BatchResponse, error) {
+	var resp BatchResponse
+	if err := c.do(ctx, http.MethodGet, "/api/batch/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListBatches lists the batches on the server, newest first.
+func (c *Client) ListBatches(ctx context.Context) (*ListBatchesResponse, error) {
+	var resp ListBatchesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/batch", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelBatch cancels a batch. Requests that have not finished running are
+// left out of its results.
+func (c *Client) CancelBatch(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/batch/"+url.PathEscape(id), nil, nil)
+}
+
+// BatchResultFunc is a function that [Client.BatchResults] invokes for each
+// result of a batch.
+type BatchResultFunc func(BatchResult) error
+
+// BatchResults calls fn with the result of each finished request of a batch,
+// successful requests first.
+func (c *Client) BatchResults(ctx context.Context, id string, fn BatchResultFunc) error {
+	return c.stream(ctx, http.MethodGet, "/api/batch/"+url.PathEscape(id)+"/results", nil, func(bts []byte) error {
+		var result BatchResult
+		if err := json.Unmarshal(bts, &result); err != nil {
+			return err
+		}
+
+		return fn(result)
+	})
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -374,6 +435,24 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+// Prefix registers a named prefix holding a system prompt for a model and
+// pins its evaluation in the model's memory. Generate and chat requests that
+// name the prefix share its evaluation instead of evaluating the system
+// prompt again.
+func (c *Client) Prefix(ctx context.Context, req *PrefixRequest) (*PrefixResponse, error) {
+	var resp PrefixResponse
+	if err := c.do(ctx, http.MethodPost, "/api/prefix", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeletePrefix removes a prefix registered with [Client.Prefix] and releases
+// its memory.
+func (c *Client) DeletePrefix(ctx context.Context, req *DeletePrefixRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/prefix", req, nil)
+}
+
 // Show obtains model information, including details, modelfile, license etc.
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
@@ -410,12 +489,100 @@ func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*Embedd
 	return &resp, nil
 }
 
+// Tokenize converts text into the token ids of a model's vocabulary.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detokenize converts token ids of a model's vocabulary back into text.
+func (c *Client) Detokenize(ctx context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	var resp DetokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/detokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // CreateBlob creates a blob from a file on the server. digest is the
 // expected SHA256 digest of the file, and r represents the file.
 func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) error {
 	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/blobs/%s", digest), r, nil)
 }
 
+// Tag creates an alias referring to a model, or points an existing alias at
+// another model. Unlike a copy, an alias follows the model it refers to when
+// that model is pulled or created again.
+func (c *Client) Tag(ctx context.Context, req *TagRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/tag", req, nil)
+}
+
+// Export writes a bundle of a model to w, as a tar archive holding its
+// manifest and blobs in the OCI image layout.
+func (c *Client) Export(ctx context.Context, req *ExportRequest, w io.Writer) error {
+	bts, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodPost, "/api/export", bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/x-tar")
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return checkError(response, body)
+	}
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// Import imports the models in a bundle written by [Client.Export], verifying
+// the digest of each blob.
+func (c *Client) Import(ctx context.Context, r io.Reader) (*ImportResponse, error) {
+	var resp ImportResponse
+	if err := c.do(ctx, http.MethodPost, "/api/import", r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DiskUsage reports the disk usage of the models on the server.
+func (c *Client) DiskUsage(ctx context.Context) (*DiskUsageResponse, error) {
+	var resp DiskUsageResponse
+	if err := c.do(ctx, http.MethodGet, "/api/du", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GC removes the blobs no model uses, and evicts the least recently used
+// models if the models directory is over its quota.
+func (c *Client) GC(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	var resp GCResponse
+	if err := c.do(ctx, http.MethodPost, "/api/gc", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Version returns the Goobla server version as a string.
 func (c *Client) Version(ctx context.Context) (string, error) {
 	var version struct {
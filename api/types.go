@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -39,6 +40,9 @@ func (e StatusError) Error() string {
 // ImageData represents the raw binary data of an image file.
 type ImageData []byte
 
+// AudioData represents the raw binary data of a WAV file.
+type AudioData []byte
+
 // GenerateRequest describes a request sent by [Client.Generate]. While you
 // have to specify the Model and Prompt fields, all the other fields have
 // reasonable defaults for basic uses.
@@ -69,7 +73,8 @@ type GenerateRequest struct {
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
-	// Format specifies the format to return a response in.
+	// Format specifies the format to return a response in: "json", a JSON
+	// schema or a {"type": "regex"} or {"type": "gbnf"} format object.
 	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded in memory following
@@ -80,6 +85,10 @@ type GenerateRequest struct {
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
 
+	// Audios is an optional list of raw audio bytes accompanying this
+	// request, for models with audio input.
+	Audios []AudioData `json:"audios,omitempty"`
+
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]any `json:"options"`
@@ -89,6 +98,28 @@ type GenerateRequest struct {
 	// (request that thinking _not_ be used) and unset (use the old behavior
 	// before this option was introduced)
 	Think *bool `json:"think,omitempty"`
+
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternatives to return for
+	// each generated token, between 0 and [MaxTopLogprobs]. Setting it
+	// implies Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// N is the number of completions to generate for the prompt, each
+	// sampled independently. Responses are identified by their Index. It
+	// defaults to 1.
+	N int `json:"n,omitempty"`
+
+	// Priority is the scheduling priority of the request while it waits
+	// for a runner. It defaults to [PriorityNormal].
+	Priority Priority `json:"priority,omitempty"`
+
+	// Prefix is the name of a prefix registered with [Client.Prefix] whose
+	// system prompt is used instead of System. Its evaluation is shared by
+	// every request that uses it.
+	Prefix string `json:"prefix,omitempty"`
 }
 
 // ChatRequest describes a request sent by [Client.Chat].
@@ -102,7 +133,8 @@ type ChatRequest struct {
 	// Stream enables streaming of returned responses; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
-	// Format is the format to return the response in (e.g. "json").
+	// Format is the format to return the response in: "json", a JSON schema
+	// or a {"type": "regex"} or {"type": "gbnf"} format object.
 	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded into memory
@@ -112,16 +144,146 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// ToolChoice controls whether the model may, must or must not call one
+	// of Tools. It defaults to "auto".
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+
+	// ParallelToolCalls controls whether the model may make more than one
+	// tool call in a response; true by default.
+	ParallelToolCalls *bool `json:"parallel_tool_calls,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]any `json:"options"`
 
 	// Think controls whether thinking/reasoning models will think before
 	// responding
 	Think *bool `json:"think,omitempty"`
+
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternatives to return for
+	// each generated token, as in [GenerateRequest].
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// N is the number of completions to generate, as in [GenerateRequest].
+	N int `json:"n,omitempty"`
+
+	// Priority is the scheduling priority, as in [GenerateRequest].
+	Priority Priority `json:"priority,omitempty"`
+
+	// Prefix is the name of a registered prefix whose system prompt comes
+	// before Messages, as in [GenerateRequest].
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// Priority is the scheduling priority of a request. When requests are
+// queued, each client gets a share of the server proportional to the
+// priority of its requests, so higher priority requests overtake lower
+// priority ones.
+type Priority string
+
+const (
+	// PriorityHigh is for interactive requests that a user is waiting on.
+	PriorityHigh Priority = "high"
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = "normal"
+	// PriorityLow is for bulk and background work.
+	PriorityLow Priority = "low"
+)
+
+// MaxTopLogprobs is the largest number of alternatives that may be requested
+// with TopLogprobs.
+const MaxTopLogprobs = 20
+
+// TokenLogprob is the log probability of a single token.
+type TokenLogprob struct {
+	// Token is the text of the token.
+	Token string `json:"token"`
+
+	// Logprob is the natural log of the token's probability.
+	Logprob float64 `json:"logprob"`
+
+	// Bytes is the UTF-8 encoding of the token, which is useful when a single
+	// character spans several tokens.
+	Bytes []int `json:"bytes,omitempty"`
+}
+
+// Logprob is the log probability of a generated token together with the most
+// likely alternatives the model considered at that position.
+type Logprob struct {
+	TokenLogprob
+
+	// TopLogprobs lists the most likely tokens at this position, most likely
+	// first.
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
 }
 
 type Tools []Tool
 
+// ToolChoice controls how the model uses the tools in a [ChatRequest]. In
+// JSON it is either one of the strings "none", "auto" or "required", or an
+// object naming a function that must be called:
+//
+//	{"type": "function", "function": {"name": "get_weather"}}
+type ToolChoice struct {
+	// Type is one of ToolChoiceNone, ToolChoiceAuto, ToolChoiceRequired or
+	// ToolChoiceFunction.
+	Type string `json:"type"`
+
+	// Function names the function to call when Type is ToolChoiceFunction.
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+const (
+	// ToolChoiceNone prevents the model from calling any tool.
+	ToolChoiceNone = "none"
+	// ToolChoiceAuto lets the model decide whether to call tools.
+	ToolChoiceAuto = "auto"
+	// ToolChoiceRequired forces the model to call at least one tool.
+	ToolChoiceRequired = "required"
+	// ToolChoiceFunction forces the model to call the named function.
+	ToolChoiceFunction = "function"
+)
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (tc *ToolChoice) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		switch s {
+		case ToolChoiceNone, ToolChoiceAuto, ToolChoiceRequired:
+			*tc = ToolChoice{Type: s}
+			return nil
+		}
+		return fmt.Errorf("invalid tool_choice %q: expected \"none\", \"auto\", \"required\" or a function", s)
+	}
+
+	type Alias ToolChoice
+	var a Alias
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+
+	if a.Type != ToolChoiceFunction || a.Function.Name == "" {
+		return errors.New("invalid tool_choice: expected a function with a name")
+	}
+
+	*tc = ToolChoice(a)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (tc ToolChoice) MarshalJSON() ([]byte, error) {
+	if tc.Type != ToolChoiceFunction {
+		return json.Marshal(tc.Type)
+	}
+
+	type Alias ToolChoice
+	return json.Marshal(Alias(tc))
+}
+
 func (t Tools) String() string {
 	bts, _ := json.Marshal(t)
 	return string(bts)
@@ -133,8 +295,8 @@ func (t Tool) String() string {
 }
 
 // Message is a single message in a chat sequence. The message contains the
-// role ("system", "user", or "assistant"), the content and an optional list
-// of images.
+// role ("system", "user", or "assistant"), the content and optional lists
+// of images and audios.
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -142,6 +304,7 @@ type Message struct {
 	// original model output when ChatRequest.Think is enabled.
 	Thinking  string      `json:"thinking,omitempty"`
 	Images    []ImageData `json:"images,omitempty"`
+	Audios    []AudioData `json:"audios,omitempty"`
 	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
 }
 
@@ -252,8 +415,16 @@ type ChatResponse struct {
 	Message    Message   `json:"message"`
 	DoneReason string    `json:"done_reason,omitempty"`
 
+	// Index identifies the completion this response belongs to when
+	// ChatRequest.N is greater than 1.
+	Index int `json:"index,omitempty"`
+
 	Done bool `json:"done"`
 
+	// Logprobs contains the log probabilities of the tokens in this response
+	// when ChatRequest.Logprobs is enabled.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
@@ -264,6 +435,11 @@ type Metrics struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
 	EvalCount          int           `json:"eval_count,omitempty"`
 	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+
+	// DraftCount is the number of tokens proposed by the draft model, of
+	// which DraftAcceptedCount were accepted by the model.
+	DraftCount         int `json:"draft_count,omitempty"`
+	DraftAcceptedCount int `json:"draft_accepted_count,omitempty"`
 }
 
 // Options specified in [GenerateRequest].  If you add a new option here, also
@@ -285,6 +461,11 @@ type Options struct {
 	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
 	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+
+	// LogitBias is added to the logits of the given tokens before sampling.
+	// Keys are token ids or text, which is biased for every token it
+	// encodes to. A bias of -100 effectively bans a token and 100 forces it.
+	LogitBias map[string]float32 `json:"logit_bias,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -295,6 +476,7 @@ type Runner struct {
 	MainGPU   int   `json:"main_gpu,omitempty"`
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+	NumDraft  int   `json:"num_draft,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -313,6 +495,9 @@ type EmbedRequest struct {
 
 	// Options lists model-specific options.
 	Options map[string]any `json:"options"`
+
+	// Priority is the scheduling priority, as in [GenerateRequest].
+	Priority Priority `json:"priority,omitempty"`
 }
 
 // EmbedResponse is the response from [Client.Embed].
@@ -339,6 +524,9 @@ type EmbeddingRequest struct {
 
 	// Options lists model-specific options.
 	Options map[string]any `json:"options"`
+
+	// Priority is the scheduling priority, as in [GenerateRequest].
+	Priority Priority `json:"priority,omitempty"`
 }
 
 // EmbeddingResponse is the response from [Client.Embeddings].
@@ -346,6 +534,58 @@ type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
 }
 
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Content is the text to tokenize.
+	Content string `json:"content"`
+
+	// VocabOnly loads only the model's vocabulary instead of scheduling a
+	// runner, so no weights are loaded onto a device.
+	VocabOnly bool `json:"vocab_only,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request. It is ignored when VocabOnly is set.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model  string `json:"model"`
+	Tokens []int  `json:"tokens"`
+}
+
+// DetokenizeRequest is the request passed to [Client.Detokenize].
+type DetokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Tokens is the list of token ids to convert back into text.
+	Tokens []int `json:"tokens"`
+
+	// VocabOnly loads only the model's vocabulary instead of scheduling a
+	// runner, so no weights are loaded onto a device.
+	VocabOnly bool `json:"vocab_only,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request. It is ignored when VocabOnly is set.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// DetokenizeResponse is the response from [Client.Detokenize].
+type DetokenizeResponse struct {
+	Model   string `json:"model"`
+	Content string `json:"content"`
+}
+
 // CreateRequest is the request passed to [Client.Create].
 type CreateRequest struct {
 	Model    string `json:"model"`
@@ -361,6 +601,11 @@ type CreateRequest struct {
 	Parameters map[string]any    `json:"parameters,omitempty"`
 	Messages   []Message         `json:"messages,omitempty"`
 
+	// Draft is the name of a smaller model sharing the vocabulary of the
+	// model, which speeds up generation by proposing tokens for it to
+	// verify.
+	Draft string `json:"draft,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 	// Deprecated: use Quantize instead
@@ -375,6 +620,46 @@ type DeleteRequest struct {
 	Name string `json:"name"`
 }
 
+// PrefixRequest is the request passed to [Client.Prefix].
+type PrefixRequest struct {
+	// Model is the model the prefix is registered for.
+	Model string `json:"model"`
+
+	// Name is the name requests use to refer to the prefix.
+	Name string `json:"name"`
+
+	// System is the system prompt held by the prefix.
+	System string `json:"system"`
+
+	// KeepAlive controls how long the model will stay loaded in memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// PrefixResponse is the response returned by [Client.Prefix].
+type PrefixResponse struct {
+	Model string `json:"model"`
+	PinnedPrefix
+}
+
+// PinnedPrefix is a prefix whose evaluation is pinned in the memory of a
+// loaded model.
+type PinnedPrefix struct {
+	Name string `json:"name"`
+
+	// Tokens is the number of tokens of the prefix.
+	Tokens int `json:"tokens"`
+
+	// Size is the memory in bytes used by the prefix.
+	Size int64 `json:"size"`
+}
+
+// DeletePrefixRequest is the request passed to [Client.DeletePrefix].
+type DeletePrefixRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name"`
+}
+
 // ShowRequest is the request passed to [Client.Show].
 type ShowRequest struct {
 	Model  string `json:"model"`
@@ -404,6 +689,19 @@ type ShowResponse struct {
 	Tensors       []Tensor           `json:"tensors,omitempty"`
 	Capabilities  []model.Capability `json:"capabilities,omitempty"`
 	ModifiedAt    time.Time          `json:"modified_at,omitempty"`
+	Signer        *Signer            `json:"signer,omitempty"`
+}
+
+// Signer describes the key that signed the manifest of a model.
+type Signer struct {
+	// Fingerprint is the SHA256 fingerprint of the key.
+	Fingerprint string `json:"fingerprint"`
+
+	// Name is the comment of the key in the trust store, if it is trusted.
+	Name string `json:"name,omitempty"`
+
+	// Trusted reports whether the key is in the trust store.
+	Trusted bool `json:"trusted"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -412,6 +710,95 @@ type CopyRequest struct {
 	Destination string `json:"destination"`
 }
 
+// TagRequest is the request passed to [Client.Tag].
+type TagRequest struct {
+	// Model is the model the alias refers to, which may itself be an alias.
+	Model string `json:"model"`
+
+	// Alias is the name of the alias to create or retarget.
+	Alias string `json:"alias"`
+}
+
+// ExportRequest is the request passed to [Client.Export].
+type ExportRequest struct {
+	Model string `json:"model"`
+}
+
+// ImportResponse is the response from [Client.Import].
+type ImportResponse struct {
+	// Models are the names of the models imported.
+	Models []string `json:"models"`
+}
+
+// DiskUsageResponse is the response from [Client.DiskUsage].
+type DiskUsageResponse struct {
+	Models []ModelDiskUsage `json:"models"`
+
+	// SharedLayers are the layers used by more than one model.
+	SharedLayers []LayerDiskUsage `json:"shared_layers,omitempty"`
+
+	// Unreferenced is the size of blobs no model uses, which [Client.GC]
+	// removes.
+	Unreferenced int64 `json:"unreferenced"`
+
+	// Size is the size of all blobs in the models directory.
+	Size int64 `json:"size"`
+
+	// Quota is the maximum size of the models directory, or 0 if it is
+	// not limited.
+	Quota uint64 `json:"quota,omitempty"`
+}
+
+// ModelDiskUsage is the disk usage of a single model in [DiskUsageResponse].
+type ModelDiskUsage struct {
+	Model string `json:"model"`
+	Size  int64  `json:"size"`
+
+	// Unique is the size of the layers used by this model only, which is
+	// freed by removing it.
+	Unique int64 `json:"unique"`
+
+	// Shared is the size of the layers used by other models too.
+	Shared int64 `json:"shared"`
+
+	LastUsed time.Time `json:"last_used"`
+
+	// Pinned models are never evicted to stay under the quota.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// LayerDiskUsage is a layer shared by several models in [DiskUsageResponse].
+type LayerDiskUsage struct {
+	Digest string   `json:"digest"`
+	Size   int64    `json:"size"`
+	Models []string `json:"models"`
+}
+
+// GCRequest is the request passed to [Client.GC].
+type GCRequest struct {
+	// DryRun reports what would be removed without removing it.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// GCResponse is the response from [Client.GC].
+type GCResponse struct {
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Evicted are the least recently used models removed to bring the
+	// models directory under its quota.
+	Evicted []string `json:"evicted,omitempty"`
+
+	// Blobs are the digests of the blobs removed.
+	Blobs []string `json:"blobs,omitempty"`
+
+	// Freed is the size of the blobs removed.
+	Freed int64 `json:"freed"`
+
+	// Size is the size of the models directory after collection.
+	Size  int64  `json:"size"`
+	Quota uint64 `json:"quota,omitempty"`
+}
+
 // PullRequest is the request passed to [Client.Pull].
 type PullRequest struct {
 	Model    string `json:"model"`
@@ -441,6 +828,10 @@ type PushRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// Sign pushes a signature of the model's manifest made with the
+	// server's key.
+	Sign bool `json:"sign,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -453,6 +844,10 @@ type ListResponse struct {
 // ProcessResponse is the response from [Client.Process].
 type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
+
+	// Queue lists the requests waiting for a runner, in the order they
+	// will be scheduled unless other requests arrive.
+	Queue []ProcessQueueResponse `json:"queue,omitempty"`
 }
 
 // ListModelResponse is a single model description in [ListResponse].
@@ -463,6 +858,10 @@ type ListModelResponse struct {
 	Size       int64        `json:"size"`
 	Digest     string       `json:"digest"`
 	Details    ModelDetails `json:"details,omitempty"`
+
+	// Target is the model an alias refers to. It is empty for models that
+	// are not aliases.
+	Target string `json:"target,omitempty"`
 }
 
 // ProcessModelResponse is a single model description in [ProcessResponse].
@@ -474,6 +873,145 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+
+	// Prefixes are the prefixes pinned in the model's memory.
+	Prefixes []PinnedPrefix `json:"prefixes,omitempty"`
+}
+
+// ProcessQueueResponse is a single queued request in [ProcessResponse].
+type ProcessQueueResponse struct {
+	ID       string    `json:"id"`
+	Position int       `json:"position"`
+	Model    string    `json:"model"`
+	Client   string    `json:"client"`
+	Priority Priority  `json:"priority"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// RequestState is the state of a request listed in [ListRequestsResponse].
+type RequestState string
+
+const (
+	// RequestStateQueued is a request waiting for a runner.
+	RequestStateQueued RequestState = "queued"
+	// RequestStateRunning is a request that is being processed by a runner.
+	RequestStateRunning RequestState = "running"
+)
+
+// ListRequestsResponse is the response from [Client.ListRequests].
+type ListRequestsResponse struct {
+	Requests []RequestResponse `json:"requests"`
+}
+
+// RequestResponse is a single request in [ListRequestsResponse].
+type RequestResponse struct {
+	// ID is the ID returned in the X-Goobla-Request-Id header of the
+	// request's response, which can be passed to [Client.CancelRequest].
+	ID        string       `json:"id"`
+	Model     string       `json:"model"`
+	Client    string       `json:"client"`
+	Priority  Priority     `json:"priority"`
+	State     RequestState `json:"state"`
+	CreatedAt time.Time    `json:"created_at"`
+
+	// Tokens is the number of tokens generated so far.
+	Tokens int `json:"tokens"`
+}
+
+// BatchRequest is the request passed to [Client.CreateBatch].
+type BatchRequest struct {
+	// Requests are the requests to run. They are run at [PriorityLow], so
+	// they only use capacity interactive requests leave free.
+	Requests []BatchRequestItem `json:"requests"`
+
+	// Metadata is returned with the batch as given.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchRequestItem is a single request in a [BatchRequest].
+type BatchRequestItem struct {
+	// CustomID identifies the request's result. It must be unique within
+	// the batch.
+	CustomID string `json:"custom_id"`
+
+	// URL is the endpoint to send the request to: /api/generate,
+	// /api/chat or /api/embed.
+	URL string `json:"url"`
+
+	// Body is the request body. Responses are never streamed.
+	Body json.RawMessage `json:"body"`
+}
+
+// BatchStatus is the status of a batch.
+type BatchStatus string
+
+const (
+	BatchStatusValidating BatchStatus = "validating"
+	BatchStatusFailed     BatchStatus = "failed"
+	BatchStatusInProgress BatchStatus = "in_progress"
+	BatchStatusFinalizing BatchStatus = "finalizing"
+	BatchStatusCompleted  BatchStatus = "completed"
+	BatchStatusExpired    BatchStatus = "expired"
+	BatchStatusCancelling BatchStatus = "cancelling"
+	BatchStatusCancelled  BatchStatus = "cancelled"
+)
+
+// Done reports whether a batch with the status has finished running.
+func (s BatchStatus) Done() bool {
+	switch s {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// BatchResponse is the response from [Client.CreateBatch] and
+// [Client.Batch].
+type BatchResponse struct {
+	ID            string             `json:"id"`
+	Status        BatchStatus        `json:"status"`
+	RequestCounts BatchRequestCounts `json:"request_counts"`
+	CreatedAt     time.Time          `json:"created_at"`
+	ExpiresAt     time.Time          `json:"expires_at"`
+	CompletedAt   *time.Time         `json:"completed_at,omitempty"`
+
+	// Errors are the reasons a batch failed validation.
+	Errors   []BatchError      `json:"errors,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchRequestCounts counts the requests of a batch by their outcome.
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchError is a problem with a request of a batch, identified by its
+// index in [BatchRequest.Requests].
+type BatchError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// ListBatchesResponse is the response from [Client.ListBatches].
+type ListBatchesResponse struct {
+	Batches []BatchResponse `json:"batches"`
+}
+
+// BatchResult is the result of a request of a batch, passed into
+// [BatchResultFunc].
+type BatchResult struct {
+	CustomID string `json:"custom_id"`
+
+	// StatusCode is the HTTP status of the response, or 0 if the request
+	// was not run, for example because the batch was cancelled.
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body,omitempty"`
+
+	// ErrorMessage is the reason a request was not run.
+	ErrorMessage string `json:"error_message,omitempty"`
 }
 
 type TokenResponse struct {
@@ -491,6 +1029,10 @@ type GenerateResponse struct {
 	// Response is the textual response itself.
 	Response string `json:"response"`
 
+	// Index identifies the completion this response belongs to when
+	// GenerateRequest.N is greater than 1.
+	Index int `json:"index,omitempty"`
+
 	// Thinking contains the text that was inside thinking tags in the
 	// original model output when ChatRequest.Think is enabled.
 	Thinking string `json:"thinking,omitempty"`
@@ -505,6 +1047,10 @@ type GenerateResponse struct {
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Logprobs contains the log probabilities of the tokens in this response
+	// when GenerateRequest.Logprobs is enabled.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
@@ -551,6 +1097,10 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "eval duration:        %s\n", m.EvalDuration)
 		fmt.Fprintf(os.Stderr, "eval rate:            %.2f tokens/s\n", float64(m.EvalCount)/m.EvalDuration.Seconds())
 	}
+
+	if m.DraftCount > 0 {
+		fmt.Fprintf(os.Stderr, "draft acceptance:     %.2f%% (%d/%d token(s))\n", 100*float64(m.DraftAcceptedCount)/float64(m.DraftCount), m.DraftAcceptedCount, m.DraftCount)
+	}
 }
 
 func (opts *Options) FromMap(m map[string]any) error {
@@ -625,6 +1175,21 @@ func (opts *Options) FromMap(m map[string]any) error {
 					slice[i] = str
 				}
 				field.Set(reflect.ValueOf(slice))
+			case reflect.Map:
+				// JSON unmarshals to map[string]any, not map[string]float32
+				val, ok := val.(map[string]any)
+				if !ok {
+					return fmt.Errorf("option %q must be of type object", key)
+				}
+				m := make(map[string]float32, len(val))
+				for k, v := range val {
+					f, ok := v.(float64)
+					if !ok {
+						return fmt.Errorf("option %q must be an object of numbers", key)
+					}
+					m[k] = float32(f)
+				}
+				field.Set(reflect.ValueOf(m))
 			case reflect.Pointer:
 				var b bool
 				if field.Type() == reflect.TypeOf(&b) {
@@ -671,6 +1236,7 @@ func DefaultOptions() Options {
 			NumGPU:    -1, // -1 here indicates that NumGPU should be set dynamically
 			NumThread: 0,  // let the runtime decide
 			UseMMap:   nil,
+			NumDraft:  4,
 		},
 	}
 }
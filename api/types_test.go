@@ -145,6 +145,52 @@ func TestUseMmapParsingFromJSON(t *testing.T) {
 	}
 }
 
+func TestLogitBiasParsingFromJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		req  string
+		exp  map[string]float32
+		err  string
+	}{
+		{
+			name: "Undefined",
+			req:  `{ }`,
+			exp:  nil,
+		},
+		{
+			name: "Token ids and text",
+			req:  `{ "logit_bias": { "42": -100, " yes": 5.5 } }`,
+			exp:  map[string]float32{"42": -100, " yes": 5.5},
+		},
+		{
+			name: "Not an object",
+			req:  `{ "logit_bias": [42] }`,
+			err:  `option "logit_bias" must be of type object`,
+		},
+		{
+			name: "Not a number",
+			req:  `{ "logit_bias": { "42": "ban" } }`,
+			err:  `option "logit_bias" must be an object of numbers`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var oMap map[string]any
+			err := json.Unmarshal([]byte(test.req), &oMap)
+			require.NoError(t, err)
+			opts := DefaultOptions()
+			err = opts.FromMap(oMap)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, opts.LogitBias)
+		})
+	}
+}
+
 func TestUseMmapFormatParams(t *testing.T) {
 	tr := true
 	fa := false
@@ -373,6 +419,43 @@ func TestPropertyType_MarshalJSON(t *testing.T) {
 	}
 }
 
+func TestToolChoice_JSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected ToolChoice
+		err      bool
+	}{
+		{name: "none", input: `"none"`, expected: ToolChoice{Type: ToolChoiceNone}},
+		{name: "auto", input: `"auto"`, expected: ToolChoice{Type: ToolChoiceAuto}},
+		{name: "required", input: `"required"`, expected: ToolChoice{Type: ToolChoiceRequired}},
+		{name: "function", input: `{"type":"function","function":{"name":"get_weather"}}`, expected: func() ToolChoice {
+			tc := ToolChoice{Type: ToolChoiceFunction}
+			tc.Function.Name = "get_weather"
+			return tc
+		}()},
+		{name: "unknown mode", input: `"always"`, err: true},
+		{name: "function without name", input: `{"type":"function"}`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tc ToolChoice
+			err := json.Unmarshal([]byte(test.input), &tc)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, tc)
+
+			data, err := json.Marshal(tc)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.input, string(data))
+		})
+	}
+}
+
 func TestThinking_UnmarshalJSON(t *testing.T) {
 	trueVal := true
 	falseVal := false
@@ -107,3 +107,34 @@ func Sign(ctx context.Context, bts []byte) (string, error) {
 	// signature is <pubkey>:<signature>
 	return fmt.Sprintf("%s:%s", bytes.TrimSpace(parts[1]), base64.StdEncoding.EncodeToString(signedData.Blob)), nil
 }
+
+// Verify checks that signature, of the form "<public_key>:<signature>" made
+// by [Sign], is a valid signature of bts, and returns the public key that
+// made it.
+func Verify(bts []byte, signature string) (ssh.PublicKey, error) {
+	encodedKey, encodedSig, ok := strings.Cut(signature, ":")
+	if !ok {
+		return nil, errors.New("malformed signature")
+	}
+
+	keyData, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+
+	publicKey, err := ssh.ParsePublicKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	if err := publicKey.Verify(bts, &ssh.Signature{Format: publicKey.Type(), Blob: sig}); err != nil {
+		return nil, err
+	}
+
+	return publicKey, nil
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -72,3 +73,34 @@ func TestGetPublicKeyAndSign(t *testing.T) {
 		t.Errorf("signature verify failed: %v", err)
 	}
 }
+
+func TestVerify(t *testing.T) {
+	t.Setenv(envPrivateKey, writeTestKey(t, t.TempDir()))
+
+	signer, err := loadPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("hello")
+	sig, err := Sign(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := Verify(msg, sig)
+	if err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+		t.Errorf("expected the signing key to be returned")
+	}
+
+	if _, err := Verify([]byte("goodbye"), sig); err == nil {
+		t.Error("expected an error verifying different data")
+	}
+
+	if _, err := Verify(msg, "malformed"); err == nil {
+		t.Error("expected an error verifying a malformed signature")
+	}
+}
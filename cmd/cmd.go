@@ -515,7 +515,12 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
+	sign, err := cmd.Flags().GetBool("sign")
+	if err != nil {
+		return err
+	}
+
+	request := api.PushRequest{Name: args[0], Insecure: insecure, Sign: sign}
 
 	n := model.ParseName(args[0])
 	if err := client.Push(cmd.Context(), &request, fn); err != nil {
@@ -556,7 +561,11 @@ func ListHandler(cmd *cobra.Command, args []string) error {
 
 	for _, m := range models.Models {
 		if len(args) == 0 || strings.HasPrefix(strings.ToLower(m.Name), strings.ToLower(args[0])) {
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")})
+			name := m.Name
+			if m.Target != "" {
+				name += " -> " + m.Target
+			}
+			data = append(data, []string{name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")})
 		}
 	}
 
@@ -628,6 +637,53 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func ListRequestsHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	requests, err := client.ListRequests(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, r := range requests.Requests {
+		if len(args) == 0 || strings.HasPrefix(r.Model, args[0]) {
+			data = append(data, []string{r.ID, r.Model, r.Client, string(r.Priority), string(r.State), format.HumanTime(r.CreatedAt, "Never"), strconv.Itoa(r.Tokens)})
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "MODEL", "CLIENT", "PRIORITY", "STATE", "CREATED", "TOKENS"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
+func CancelRequestHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range args {
+		if err := client.CancelRequest(cmd.Context(), id); err != nil {
+			return err
+		}
+		fmt.Printf("canceled '%s'\n", id)
+	}
+	return nil
+}
+
 func DeleteHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -775,6 +831,17 @@ func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
 		})
 	}
 
+	if resp.Signer != nil {
+		tableRender("Signature", func() (rows [][]string) {
+			if resp.Signer.Name != "" {
+				rows = append(rows, []string{"", "signer", resp.Signer.Name})
+			}
+			rows = append(rows, []string{"", "fingerprint", resp.Signer.Fingerprint})
+			rows = append(rows, []string{"", "trusted", strconv.FormatBool(resp.Signer.Trusted)})
+			return
+		})
+	}
+
 	if resp.ProjectorInfo != nil {
 		tableRender("Projector", func() (rows [][]string) {
 			arch := resp.ProjectorInfo["general.architecture"].(string)
@@ -914,6 +981,203 @@ func CopyHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func TagHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	req := api.TagRequest{Model: args[0], Alias: args[1]}
+	if err := client.Tag(cmd.Context(), &req); err != nil {
+		return err
+	}
+	fmt.Printf("tagged '%s' as '%s'\n", args[0], args[1])
+	return nil
+}
+
+func ExportHandler(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	spinner := progress.NewSpinner(fmt.Sprintf("exporting %s", args[0]))
+	p.Add("", spinner)
+
+	f, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+"-partial-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := client.Export(cmd.Context(), &api.ExportRequest{Model: args[0]}, f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), output); err != nil {
+		return err
+	}
+
+	spinner.Stop()
+	fmt.Fprintf(os.Stderr, "exported '%s' to '%s'\n", args[0], output)
+	return nil
+}
+
+func ImportHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	var pw progressWriter
+	status := fmt.Sprintf("importing %s 0%%", args[0])
+	spinner := progress.NewSpinner(status)
+	p.Add(status, spinner)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(60 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				spinner.SetMessage(fmt.Sprintf("importing %s %d%%", args[0], int(100*pw.n.Load()/max(fi.Size(), 1))))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	resp, err := client.Import(cmd.Context(), io.TeeReader(f, &pw))
+	if err != nil {
+		return err
+	}
+
+	spinner.Stop()
+	for _, m := range resp.Models {
+		fmt.Fprintf(os.Stderr, "imported '%s'\n", m)
+	}
+	return nil
+}
+
+func DiskUsageHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	du, err := client.DiskUsage(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	newTable := func(header []string) *tablewriter.Table {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetHeaderLine(false)
+		table.SetBorder(false)
+		table.SetNoWhiteSpace(true)
+		table.SetTablePadding("    ")
+		return table
+	}
+
+	var data [][]string
+	for _, m := range du.Models {
+		pinned := ""
+		if m.Pinned {
+			pinned = "pinned"
+		}
+		data = append(data, []string{m.Model, format.HumanBytes(m.Size), format.HumanBytes(m.Unique), format.HumanBytes(m.Shared), format.HumanTime(m.LastUsed, "Never"), pinned})
+	}
+
+	table := newTable([]string{"NAME", "SIZE", "UNIQUE", "SHARED", "LAST USED", ""})
+	table.AppendBulk(data)
+	table.Render()
+
+	if len(du.SharedLayers) > 0 {
+		data = data[:0]
+		for _, l := range du.SharedLayers {
+			digest := strings.TrimPrefix(l.Digest, "sha256:")
+			data = append(data, []string{digest[:min(12, len(digest))], format.HumanBytes(l.Size), strings.Join(l.Models, ", ")})
+		}
+
+		fmt.Println()
+		table = newTable([]string{"SHARED LAYER", "SIZE", "MODELS"})
+		table.AppendBulk(data)
+		table.Render()
+	}
+
+	fmt.Println()
+	if du.Unreferenced > 0 {
+		fmt.Printf("unreferenced: %s (run 'goobla gc' to remove)\n", format.HumanBytes(du.Unreferenced))
+	}
+	if du.Quota > 0 {
+		fmt.Printf("total: %s of %s quota\n", format.HumanBytes(du.Size), format.HumanBytes2(du.Quota))
+	} else {
+		fmt.Printf("total: %s\n", format.HumanBytes(du.Size))
+	}
+	return nil
+}
+
+func GCHandler(cmd *cobra.Command, args []string) error {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GC(cmd.Context(), &api.GCRequest{DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+
+	verb := "removed"
+	if resp.DryRun {
+		verb = "would remove"
+	}
+
+	for _, m := range resp.Evicted {
+		fmt.Printf("%s '%s'\n", verb, m)
+	}
+	fmt.Printf("%s %d unreferenced blobs, freeing %s\n", verb, len(resp.Blobs), format.HumanBytes(resp.Freed))
+	return nil
+}
+
 func PullHandler(cmd *cobra.Command, args []string) error {
 	insecure, err := cmd.Flags().GetBool("insecure")
 	if err != nil {
@@ -1316,6 +1580,27 @@ func RunServer(_ *cobra.Command, _ []string) error {
 	return err
 }
 
+// RunRegistry serves the local models as a registry that other Goobla
+// instances can push models to and pull models from.
+func RunRegistry(cmd *cobra.Command, _ []string) error {
+	addr, err := cmd.Flags().GetString("address")
+	if err != nil {
+		return err
+	}
+
+	keysFile, err := cmd.Flags().GetString("authorized-keys")
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return server.ServeRegistry(ln, keysFile)
+}
+
 func initializeKeypair() error {
 	dir := envconfig.ConfigDir()
 	privKeyPath := filepath.Join(dir, "id_ed25519")
@@ -1495,6 +1780,22 @@ func NewCLI() *cobra.Command {
 		RunE:    RunServer,
 	}
 
+	registryCmd := &cobra.Command{
+		Use:   "registry",
+		Short: "Host models for other goobla instances",
+	}
+
+	registryServeCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve local models as a registry to push to and pull from",
+		Args:  cobra.ExactArgs(0),
+		RunE:  RunRegistry,
+	}
+
+	registryServeCmd.Flags().String("address", ":5000", "Address to listen on")
+	registryServeCmd.Flags().String("authorized-keys", "", "File of client public keys allowed to push and pull (default authorized_keys in the configuration directory)")
+	registryCmd.AddCommand(registryServeCmd)
+
 	pullCmd := &cobra.Command{
 		Use:     "pull MODEL",
 		Short:   "Pull a model from a registry",
@@ -1514,6 +1815,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().Bool("sign", false, "Sign the model with this instance's key")
 
 	listCmd := &cobra.Command{
 		Use:     "list",
@@ -1529,6 +1831,23 @@ func NewCLI() *cobra.Command {
 		PreRunE: checkServerHeartbeat,
 		RunE:    ListRunningHandler,
 	}
+
+	requestsCmd := &cobra.Command{
+		Use:     "requests [MODEL]",
+		Short:   "List queued and running requests",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ListRequestsHandler,
+	}
+
+	cancelCmd := &cobra.Command{
+		Use:     "cancel REQUEST [REQUEST...]",
+		Short:   "Cancel queued or running requests",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    CancelRequestHandler,
+	}
+
 	copyCmd := &cobra.Command{
 		Use:     "cp SOURCE DESTINATION",
 		Short:   "Copy a model",
@@ -1537,6 +1856,51 @@ func NewCLI() *cobra.Command {
 		RunE:    CopyHandler,
 	}
 
+	tagCmd := &cobra.Command{
+		Use:     "tag MODEL ALIAS",
+		Short:   "Create or retarget an alias of a model",
+		Args:    cobra.ExactArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    TagHandler,
+	}
+
+	exportCmd := &cobra.Command{
+		Use:     "export MODEL",
+		Short:   "Export a model to a bundle for offline use",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ExportHandler,
+	}
+
+	exportCmd.Flags().StringP("output", "o", "", "File to write the bundle to")
+	_ = exportCmd.MarkFlagRequired("output")
+
+	importCmd := &cobra.Command{
+		Use:     "import FILE",
+		Short:   "Import models from a bundle",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ImportHandler,
+	}
+
+	duCmd := &cobra.Command{
+		Use:     "du",
+		Short:   "Show disk usage of models",
+		Args:    cobra.NoArgs,
+		PreRunE: checkServerHeartbeat,
+		RunE:    DiskUsageHandler,
+	}
+
+	gcCmd := &cobra.Command{
+		Use:     "gc",
+		Short:   "Remove unused blobs and evict models over the quota",
+		Args:    cobra.NoArgs,
+		PreRunE: checkServerHeartbeat,
+		RunE:    GCHandler,
+	}
+
+	gcCmd.Flags().Bool("dry-run", false, "Report what would be removed without removing it")
+
 	deleteCmd := &cobra.Command{
 		Use:     "rm MODEL [MODEL...]",
 		Short:   "Remove a model",
@@ -1570,9 +1934,17 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		requestsCmd,
+		cancelCmd,
 		copyCmd,
+		tagCmd,
+		exportCmd,
+		importCmd,
+		duCmd,
+		gcCmd,
 		deleteCmd,
 		serveCmd,
+		registryServeCmd,
 	} {
 		switch cmd {
 		case runCmd:
@@ -1584,10 +1956,14 @@ func NewCLI() *cobra.Command {
 				envVars["GOOBLA_KEEP_ALIVE"],
 				envVars["GOOBLA_MAX_LOADED_MODELS"],
 				envVars["GOOBLA_MAX_QUEUE"],
+				envVars["GOOBLA_MIRRORS"],
 				envVars["GOOBLA_MODELS"],
+				envVars["GOOBLA_MODELS_QUOTA"],
 				envVars["GOOBLA_NUM_PARALLEL"],
 				envVars["GOOBLA_NOPRUNE"],
 				envVars["GOOBLA_ORIGINS"],
+				envVars["GOOBLA_PINNED_MODELS"],
+				envVars["GOOBLA_REQUIRE_SIGNATURES"],
 				envVars["GOOBLA_SCHED_SPREAD"],
 				envVars["GOOBLA_FLASH_ATTENTION"],
 				envVars["GOOBLA_KV_CACHE_TYPE"],
@@ -1595,6 +1971,13 @@ func NewCLI() *cobra.Command {
 				envVars["GOOBLA_GPU_OVERHEAD"],
 				envVars["GOOBLA_LOAD_TIMEOUT"],
 			})
+		case registryServeCmd:
+			appendEnvDocs(cmd, []envconfig.EnvVar{
+				envVars["GOOBLA_DEBUG"],
+				envVars["GOOBLA_MODELS"],
+				envVars["GOOBLA_TLS_CERT"],
+				envVars["GOOBLA_TLS_KEY"],
+			})
 		default:
 			appendEnvDocs(cmd, envs)
 		}
@@ -1610,8 +1993,16 @@ func NewCLI() *cobra.Command {
 		pushCmd,
 		listCmd,
 		psCmd,
+		requestsCmd,
+		cancelCmd,
 		copyCmd,
+		tagCmd,
+		exportCmd,
+		importCmd,
+		duCmd,
+		gcCmd,
 		deleteCmd,
+		registryCmd,
 		runnerCmd,
 	)
 
@@ -36,6 +36,40 @@ func TestShowInfo(t *testing.T) {
     parameters      7B      
     quantization    FP16    
 
+`
+
+		if diff := cmp.Diff(expect, b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showInfo(&api.ShowResponse{
+			Details: api.ModelDetails{
+				Family:            "test",
+				ParameterSize:     "7B",
+				QuantizationLevel: "FP16",
+			},
+			Signer: &api.Signer{
+				Fingerprint: "SHA256:abc",
+				Name:        "alice@example.com",
+				Trusted:     true,
+			},
+		}, false, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := `  Model
+    architecture    test    
+    parameters      7B      
+    quantization    FP16    
+
+  Signature
+    signer         alice@example.com    
+    fingerprint    SHA256:abc           
+    trusted        true                 
+
 `
 
 		if diff := cmp.Diff(expect, b.String()); diff != "" {
@@ -525,6 +559,7 @@ func TestPushHandler(t *testing.T) {
 
 			cmd := &cobra.Command{}
 			cmd.Flags().Bool("insecure", false, "")
+			cmd.Flags().Bool("sign", false, "")
 			cmd.SetContext(t.Context())
 
 			// Redirect stderr to capture progress output
@@ -570,6 +605,68 @@ func TestPushHandler(t *testing.T) {
 	}
 }
 
+func TestRequestsHandlers(t *testing.T) {
+	var canceled []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/requests" && r.Method == http.MethodGet:
+			if err := json.NewEncoder(w).Encode(api.ListRequestsResponse{Requests: []api.RequestResponse{
+				{ID: "req1", Model: "model1", Client: "app", Priority: api.PriorityHigh, State: api.RequestStateRunning, CreatedAt: time.Now().Add(-time.Minute), Tokens: 42},
+				{ID: "req2", Model: "model2", Client: "batch", Priority: api.PriorityLow, State: api.RequestStateQueued, CreatedAt: time.Now().Add(-time.Second)},
+			}}); err != nil {
+				t.Fatal(err)
+			}
+		case strings.HasPrefix(r.URL.Path, "/api/requests/") && r.Method == http.MethodDelete:
+			id := strings.TrimPrefix(r.URL.Path, "/api/requests/")
+			if id != "req1" {
+				http.Error(w, `{"error":"request not found"}`, http.StatusNotFound)
+				return
+			}
+			canceled = append(canceled, id)
+		default:
+			t.Errorf("unexpected request to %s %s", r.Method, r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	t.Setenv("GOOBLA_HOST", mockServer.URL)
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(t.Context())
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := ListRequestsHandler(cmd, []string{"model1"})
+
+	w.Close()
+	os.Stdout = oldStdout
+	output, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID      MODEL     CLIENT    PRIORITY    STATE      CREATED               TOKENS \n" +
+		"req1    model1    app       high        running    About a minute ago    42        \n"
+	if got := string(output); got != expected {
+		t.Errorf("expected output:\n%s\ngot:\n%s", expected, got)
+	}
+
+	if err := CancelRequestHandler(cmd, []string{"req1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(canceled) != 1 || canceled[0] != "req1" {
+		t.Errorf("expected req1 to be canceled, got %v", canceled)
+	}
+
+	if err := CancelRequestHandler(cmd, []string{"missing"}); err == nil || !strings.Contains(err.Error(), "request not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
 func TestListHandler(t *testing.T) {
 	tests := []struct {
 		name           string
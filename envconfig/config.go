@@ -105,6 +105,37 @@ func AllowedOrigins() (origins []string) {
 	return origins
 }
 
+// Mirrors returns a list of mirrors that models are pulled from before their
+// registry. Each mirror is either the URL of a registry or the path to a
+// models directory. Mirrors can be configured via the GOOBLA_MIRRORS
+// environment variable as a comma separated list.
+func Mirrors() (mirrors []string) {
+	if s := Var("GOOBLA_MIRRORS"); s != "" {
+		for _, m := range strings.Split(s, ",") {
+			if trimmed := strings.TrimSpace(m); trimmed != "" {
+				mirrors = append(mirrors, trimmed)
+			}
+		}
+	}
+
+	return mirrors
+}
+
+// PinnedModels returns a list of models that are never evicted to keep the
+// models directory under [ModelsQuota]. PinnedModels can be configured via
+// the GOOBLA_PINNED_MODELS environment variable as a comma separated list.
+func PinnedModels() (models []string) {
+	if s := Var("GOOBLA_PINNED_MODELS"); s != "" {
+		for _, m := range strings.Split(s, ",") {
+			if trimmed := strings.TrimSpace(m); trimmed != "" {
+				models = append(models, trimmed)
+			}
+		}
+	}
+
+	return models
+}
+
 // Models returns the path to the models directory. Models directory can be configured via the GOOBLA_MODELS environment variable.
 // Default is $HOME/.goobla/models
 func Models() (string, error) {
@@ -264,6 +295,9 @@ var (
 	NoHistory = Bool("GOOBLA_NOHISTORY")
 	// NoPrune disables pruning of model blobs on startup.
 	NoPrune = Bool("GOOBLA_NOPRUNE")
+	// RequireSignatures refuses to pull models that are not signed by a
+	// trusted key.
+	RequireSignatures = Bool("GOOBLA_REQUIRE_SIGNATURES")
 	// SchedSpread allows scheduling models across all GPUs.
 	SchedSpread = Bool("GOOBLA_SCHED_SPREAD")
 	// IntelGPU enables experimental Intel GPU detection.
@@ -276,6 +310,14 @@ var (
 	ContextLength = Uint("GOOBLA_CONTEXT_LENGTH", 4096)
 	// Auth enables authentication between the Goobla client and server
 	UseAuth = Bool("GOOBLA_AUTH")
+	// APIKey is sent by the client as a bearer token to servers requiring API keys
+	APIKey = String("GOOBLA_API_KEY")
+	// Metrics exposes Prometheus metrics on /metrics
+	Metrics = Bool("GOOBLA_METRICS")
+	// TraceEndpoint is an OTLP/HTTP endpoint that request traces are exported to
+	TraceEndpoint = String("GOOBLA_TRACE_ENDPOINT")
+	// TraceFile is a file that request traces are appended to as OTLP/JSON
+	TraceFile = String("GOOBLA_TRACE_FILE")
 	// TLSCert specifies a path to a TLS certificate to enable HTTPS
 	TLSCert = String("GOOBLA_TLS_CERT")
 	// TLSKey specifies the TLS private key when HTTPS is enabled
@@ -342,6 +384,12 @@ func Uint64(key string, defaultValue uint64) func() uint64 {
 // Set aside VRAM per GPU
 var GpuOverhead = Uint64("GOOBLA_GPU_OVERHEAD", 0)
 
+// PromptCacheSize sets the maximum size in bytes of prompt caches saved to disk. PromptCacheSize can be configured via the GOOBLA_PROMPT_CACHE_SIZE environment variable.
+var PromptCacheSize = Uint64("GOOBLA_PROMPT_CACHE_SIZE", 2<<30)
+
+// ModelsQuota sets the maximum size in bytes of the models directory. Least recently used models are removed to stay under it. ModelsQuota can be configured via the GOOBLA_MODELS_QUOTA environment variable.
+var ModelsQuota = Uint64("GOOBLA_MODELS_QUOTA", 0)
+
 type EnvVar struct {
 	Name        string
 	Value       any
@@ -366,23 +414,31 @@ func AsMap() map[string]EnvVar {
 		"GOOBLA_SHUTDOWN_TIMEOUT":   {"GOOBLA_SHUTDOWN_TIMEOUT", ShutdownTimeout(), "HTTP server shutdown timeout (default \"5s\")"},
 		"GOOBLA_MAX_LOADED_MODELS":  {"GOOBLA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
 		"GOOBLA_MAX_QUEUE":          {"GOOBLA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"GOOBLA_METRICS":            {"GOOBLA_METRICS", Metrics(), "Expose Prometheus metrics on /metrics"},
+		"GOOBLA_PROMPT_CACHE_SIZE":  {"GOOBLA_PROMPT_CACHE_SIZE", PromptCacheSize(), "Maximum size in bytes of prompt caches saved to disk, 0 to disable (default 2 GiB)"},
 		"GOOBLA_MODELS": func() EnvVar {
 			m, _ := Models()
 			return EnvVar{"GOOBLA_MODELS", m, "The path to the models directory"}
 		}(),
-		"GOOBLA_CONFIG":          {"GOOBLA_CONFIG", String("GOOBLA_CONFIG")(), "Path to the configuration file"},
-		"GOOBLA_CONFIG_DIR":      {"GOOBLA_CONFIG_DIR", configDir(), "Base directory for configuration and models"},
-		"GOOBLA_NOHISTORY":       {"GOOBLA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"GOOBLA_NOPRUNE":         {"GOOBLA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"GOOBLA_NUM_PARALLEL":    {"GOOBLA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"GOOBLA_ORIGINS":         {"GOOBLA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
-		"GOOBLA_SCHED_SPREAD":    {"GOOBLA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"GOOBLA_MULTIUSER_CACHE": {"GOOBLA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
-		"GOOBLA_CONTEXT_LENGTH":  {"GOOBLA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 4096)"},
-		"GOOBLA_NEW_ENGINE":      {"GOOBLA_NEW_ENGINE", NewEngine(), "Enable the new Goobla engine"},
-		"GOOBLA_PPROF":           {"GOOBLA_PPROF", PprofAddr(), "Bind pprof to this address or 'off' to disable"},
-		"GOOBLA_TLS_CERT":        {"GOOBLA_TLS_CERT", TLSCert(), "Path to TLS certificate"},
-		"GOOBLA_TLS_KEY":         {"GOOBLA_TLS_KEY", TLSKey(), "Path to TLS private key"},
+		"GOOBLA_MODELS_QUOTA":       {"GOOBLA_MODELS_QUOTA", ModelsQuota(), "Maximum size in bytes of the models directory, evicting least recently used models, 0 for no limit"},
+		"GOOBLA_PINNED_MODELS":      {"GOOBLA_PINNED_MODELS", PinnedModels(), "A comma separated list of models never evicted to stay under GOOBLA_MODELS_QUOTA"},
+		"GOOBLA_CONFIG":             {"GOOBLA_CONFIG", String("GOOBLA_CONFIG")(), "Path to the configuration file"},
+		"GOOBLA_CONFIG_DIR":         {"GOOBLA_CONFIG_DIR", configDir(), "Base directory for configuration and models"},
+		"GOOBLA_NOHISTORY":          {"GOOBLA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"GOOBLA_MIRRORS":            {"GOOBLA_MIRRORS", Mirrors(), "A comma separated list of registry URLs or models directories to pull models from before their registry"},
+		"GOOBLA_NOPRUNE":            {"GOOBLA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"GOOBLA_REQUIRE_SIGNATURES": {"GOOBLA_REQUIRE_SIGNATURES", RequireSignatures(), "Only pull models signed by a key in the trusted_keys file"},
+		"GOOBLA_NUM_PARALLEL":       {"GOOBLA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"GOOBLA_ORIGINS":            {"GOOBLA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
+		"GOOBLA_SCHED_SPREAD":       {"GOOBLA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"GOOBLA_MULTIUSER_CACHE":    {"GOOBLA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"GOOBLA_CONTEXT_LENGTH":     {"GOOBLA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 4096)"},
+		"GOOBLA_NEW_ENGINE":         {"GOOBLA_NEW_ENGINE", NewEngine(), "Enable the new Goobla engine"},
+		"GOOBLA_PPROF":              {"GOOBLA_PPROF", PprofAddr(), "Bind pprof to this address or 'off' to disable"},
+		"GOOBLA_TLS_CERT":           {"GOOBLA_TLS_CERT", TLSCert(), "Path to TLS certificate"},
+		"GOOBLA_TLS_KEY":            {"GOOBLA_TLS_KEY", TLSKey(), "Path to TLS private key"},
+		"GOOBLA_TRACE_ENDPOINT":     {"GOOBLA_TRACE_ENDPOINT", TraceEndpoint(), "Export request traces to this OTLP/HTTP endpoint"},
+		"GOOBLA_TRACE_FILE":         {"GOOBLA_TRACE_FILE", TraceFile(), "Append request traces to this file as OTLP/JSON"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -6,7 +6,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/goobla/goobla/logutil"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -351,7 +350,7 @@ func TestLogLevel(t *testing.T) {
 
 		// Positive values increase verbosity
 		"1": slog.LevelDebug,
-		"2": logutil.LevelTrace,
+		"2": slog.Level(-8),
 
 		// Negative values decrease verbosity
 		"-1": slog.LevelWarn,
@@ -85,6 +85,15 @@ func (kv KV) ContextLength() uint64 {
 	return uint64(kv.Uint("context_length"))
 }
 
+// VocabSize returns the number of tokens in the vocabulary, or 0 if the
+// vocabulary is missing.
+func (kv KV) VocabSize() int {
+	if a, ok := kv["tokenizer.ggml.tokens"].(*array[string]); ok {
+		return a.size
+	}
+	return 0
+}
+
 func (kv KV) ChatTemplate() string {
 	return kv.String("tokenizer.chat_template")
 }
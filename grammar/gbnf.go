@@ -0,0 +1,63 @@
+package grammar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/goobla/goobla/llama"
+)
+
+// FromRegex compiles a regular expression to a GBNF grammar matching the
+// strings it matches in full.
+func FromRegex(pattern string) (string, error) {
+	expr, err := regexExpr(pattern, false)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex: %w", err)
+	}
+	return "root ::= " + expr + "\n", nil
+}
+
+// Check reports whether src is a GBNF grammar that can be used for sampling.
+// The grammar is built the way the sampler builds it, which fails unless it
+// parses, defines a root rule and every rule it refers to, and is free of left
+// recursion.
+func Check(src string) error {
+	g := llama.NewGrammar(src, nil, nil, nil)
+	if g == nil {
+		return errors.New("invalid grammar: it must parse, define a root rule and every rule it refers to, and not be left recursive")
+	}
+	g.Free()
+	return nil
+}
+
+// FromFormat compiles a format object to a GBNF grammar. The object is either
+// {"type":"regex","pattern":...}, {"type":"gbnf","grammar":...} or a JSON
+// schema, in which case schema is true.
+func FromFormat(format []byte) (g string, schema bool, err error) {
+	var f struct {
+		Type    any    `json:"type"`
+		Pattern string `json:"pattern"`
+		Grammar string `json:"grammar"`
+	}
+	if err := json.Unmarshal(format, &f); err != nil {
+		return "", false, err
+	}
+
+	switch f.Type {
+	case "regex":
+		if f.Pattern == "" {
+			return "", false, errors.New("regex format requires a pattern")
+		}
+		g, err = FromRegex(f.Pattern)
+		return g, false, err
+	case "gbnf":
+		if err := Check(f.Grammar); err != nil {
+			return "", false, err
+		}
+		return f.Grammar, false, nil
+	default:
+		g, err = FromJSONSchema(format)
+		return g, true, err
+	}
+}
@@ -0,0 +1,108 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFromRegex(t *testing.T) {
+	cases := []struct {
+		pattern string
+		valid   []string
+		invalid []string
+	}{
+		{`[A-Z]{3}-\d{4}`, []string{"ABC-1234"}, []string{"AB-1234", "ABC-12345", "abc-1234"}},
+		{`^\d{4}-\d{2}-\d{2}$`, []string{"2024-01-31"}, []string{"2024-1-31", " 2024-01-31"}},
+		{`(?i)select \w+ from \w+;`, []string{"SELECT id FROM users;", "select a from b;"}, []string{"select from users;"}},
+		{`yes|no|maybe`, []string{"yes", "no", "maybe"}, []string{"", "yesno"}},
+		{`a.c`, []string{"abc", "a-c"}, []string{"a\nc", "ac"}},
+		{`[^\s"]+`, []string{"a-b^c"}, []string{"a b", `a"b`}},
+		{`x*y+z?`, []string{"y", "xxyyz"}, []string{"x", "yzz"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.pattern, func(t *testing.T) {
+			g, err := FromRegex(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.valid {
+				if !accepts(t, g, s) {
+					t.Errorf("grammar rejected %q\n%s", s, g)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if accepts(t, g, s) {
+					t.Errorf("grammar accepted %q\n%s", s, g)
+				}
+			}
+		})
+	}
+
+	for _, pattern := range []string{`(a`, `\bword`} {
+		if _, err := FromRegex(pattern); err == nil {
+			t.Errorf("expected error for %q", pattern)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	valid := []string{
+		`root ::= "yes" | "no"`,
+		"# answers\nroot ::= answer (\",\" answer)*\nanswer ::= [a-z]+ # words\n",
+		"root ::= (\n  \"a\" |\n  \"b\"\n){2,3} [\\x2D\\u00e9] .\n",
+		`root ::= "(" root? ")"`,
+	}
+	for _, g := range valid {
+		if err := Check(g); err != nil {
+			t.Errorf("Check(%q) = %v", g, err)
+		}
+	}
+
+	invalid := []string{
+		``,
+		`answer ::= "yes"`,
+		`root ::= answer`,
+		`root ::= "yes`,
+		`root ::= [a-z`,
+		`root ::= ("a" | "b"`,
+		`root ::= * "a"`,
+		`root ::= "\q"`,
+		"root = \"a\"",
+		"root ::= root \"a\" | \"b\"",
+		"root ::= a\na ::= \"b\"? root",
+	}
+	for _, g := range invalid {
+		if err := Check(g); err == nil {
+			t.Errorf("Check(%q) = nil, want an error", g)
+		}
+	}
+}
+
+func TestFromFormat(t *testing.T) {
+	cases := []struct {
+		format string
+		schema bool
+		err    bool
+	}{
+		{`{"type":"regex","pattern":"[0-9]+"}`, false, false},
+		{`{"type":"gbnf","grammar":"root ::= \"a\""}`, false, false},
+		{`{"type":"object","properties":{"a":{"type":"string"}}}`, true, false},
+		{`{"type":"regex"}`, false, true},
+		{`{"type":"regex","pattern":"(a"}`, false, true},
+		{`{"type":"gbnf","grammar":"root ::= a"}`, false, true},
+	}
+
+	for _, tt := range cases {
+		g, schema, err := FromFormat([]byte(tt.format))
+		if (err != nil) != tt.err {
+			t.Errorf("FromFormat(%s) error = %v", tt.format, err)
+			continue
+		}
+		if err == nil && (schema != tt.schema || !strings.Contains(g, "root ::=")) {
+			t.Errorf("FromFormat(%s) = %q, %v", tt.format, g, schema)
+		}
+	}
+}
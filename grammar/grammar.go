@@ -0,0 +1,163 @@
+// Package grammar compiles output constraints, such as JSON schemas, to the
+// GBNF grammars used for constrained sampling.
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// builder accumulates the named rules of a grammar.
+type builder struct {
+	rules map[string]string
+	order []string
+}
+
+func newBuilder() *builder {
+	return &builder{rules: make(map[string]string)}
+}
+
+// add defines a rule named after name, returning the name it was given. If a
+// different rule already has the name, a numeric suffix is added to it.
+func (b *builder) add(name, body string) string {
+	name = sanitize(name)
+	key := name
+	for i := 1; ; i++ {
+		existing, ok := b.rules[key]
+		if !ok {
+			break
+		}
+		if existing == body {
+			return key
+		}
+		key = fmt.Sprintf("%s%d", name, i)
+	}
+
+	b.set(key, body)
+	return key
+}
+
+// reserve claims a unique rule name to be defined later with set, which
+// allows rules to refer to themselves.
+func (b *builder) reserve(name string) string {
+	name = sanitize(name)
+	key := name
+	for i := 1; ; i++ {
+		if _, ok := b.rules[key]; !ok {
+			break
+		}
+		key = fmt.Sprintf("%s%d", name, i)
+	}
+
+	b.set(key, "")
+	return key
+}
+
+func (b *builder) set(name, body string) {
+	if _, ok := b.rules[name]; !ok {
+		b.order = append(b.order, name)
+	}
+	b.rules[name] = body
+}
+
+func (b *builder) String() string {
+	var sb strings.Builder
+	for _, name := range b.order {
+		fmt.Fprintf(&sb, "%s ::= %s\n", name, b.rules[name])
+	}
+	return sb.String()
+}
+
+var invalidRuleChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+func sanitize(name string) string {
+	name = invalidRuleChars.ReplaceAllString(name, "-")
+	if name = strings.Trim(name, "-"); name == "" {
+		return "rule"
+	}
+	return name
+}
+
+// literal returns s as a GBNF string literal.
+func literal(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\x%02X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// classChar returns r escaped for use in a GBNF character class.
+func classChar(r rune) string {
+	switch r {
+	case '\\', ']', '[', '"':
+		return `\` + string(r)
+	case '-', '^':
+		// GBNF has no escapes for these, so they are given by code point
+		return fmt.Sprintf(`\x%02X`, r)
+	case '\n':
+		return `\n`
+	case '\r':
+		return `\r`
+	case '\t':
+		return `\t`
+	}
+
+	switch {
+	case r < 0x20 || r == 0x7f:
+		return fmt.Sprintf(`\x%02X`, r)
+	case r > 0xffff:
+		return fmt.Sprintf(`\U%08X`, r)
+	case r > 0x7e && r < 0xa0:
+		return fmt.Sprintf(`\u%04X`, r)
+	default:
+		return string(r)
+	}
+}
+
+// repeat returns a GBNF repetition of expr between min and max times, or
+// at least min times if max is negative.
+func repeat(expr string, min, max int) string {
+	switch {
+	case min == 0 && max < 0:
+		return expr + "*"
+	case min == 1 && max < 0:
+		return expr + "+"
+	case min == 0 && max == 1:
+		return expr + "?"
+	case max < 0:
+		return fmt.Sprintf("%s{%d,}", expr, min)
+	case min == max:
+		return fmt.Sprintf("%s{%d}", expr, min)
+	default:
+		return fmt.Sprintf("%s{%d,%d}", expr, min, max)
+	}
+}
+
+// group wraps alternatives in parentheses.
+func group(alternatives ...string) string {
+	if len(alternatives) == 1 {
+		return "(" + alternatives[0] + ")"
+	}
+	return "(" + strings.Join(alternatives, " | ") + ")"
+}
@@ -0,0 +1,829 @@
+package grammar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedSchema is returned for JSON schemas using features that
+// cannot be enforced by a grammar.
+var ErrUnsupportedSchema = errors.New("unsupported schema")
+
+func unsupported(path, format string, args ...any) error {
+	return fmt.Errorf("%w: %s: %s", ErrUnsupportedSchema, path, fmt.Sprintf(format, args...))
+}
+
+func invalid(path, format string, args ...any) error {
+	return fmt.Errorf("invalid schema: %s: %s", path, fmt.Sprintf(format, args...))
+}
+
+type primitive struct {
+	body string
+	deps []string
+}
+
+var primitives = map[string]primitive{
+	"space":   {`| " " | "\n" [ \t]{0,20}`, nil},
+	"boolean": {`("true" | "false") space`, []string{"space"}},
+	"null":    {`"null" space`, []string{"space"}},
+	"integer": {`"-"? ("0" | [1-9] [0-9]*) space`, []string{"space"}},
+	"number":  {`"-"? ("0" | [1-9] [0-9]*) ("." [0-9]+)? ([eE] [\x2D+]? [0-9]+)? space`, []string{"space"}},
+	"char":    {`[^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F]{4})`, nil},
+	"string":  {`"\"" char* "\"" space`, []string{"char", "space"}},
+	"value":   {`object | array | string | number | boolean | null`, []string{"object", "array", "string", "number", "boolean", "null"}},
+	"object":  {`"{" space (string ":" space value ("," space string ":" space value)*)? "}" space`, []string{"string", "value", "space"}},
+	"array":   {`"[" space (value ("," space value)*)? "]" space`, []string{"value", "space"}},
+
+	"date":             {`[0-9]{4} "-" ("0" [1-9] | "1" [0-2]) "-" ("0" [1-9] | [12] [0-9] | "3" [01])`, nil},
+	"time":             {`([01] [0-9] | "2" [0-3]) ":" [0-5] [0-9] ":" [0-5] [0-9] ("." [0-9]{1,9})? ("Z" | [+\x2D] ([01] [0-9] | "2" [0-3]) ":" [0-5] [0-9])`, nil},
+	"uuid":             {`[0-9a-fA-F]{8} "-" [0-9a-fA-F]{4} "-" [0-9a-fA-F]{4} "-" [0-9a-fA-F]{4} "-" [0-9a-fA-F]{12}`, nil},
+	"date-string":      {`"\"" date "\"" space`, []string{"date", "space"}},
+	"time-string":      {`"\"" time "\"" space`, []string{"time", "space"}},
+	"date-time-string": {`"\"" date "T" time "\"" space`, []string{"date", "time", "space"}},
+	"uuid-string":      {`"\"" uuid "\"" space`, []string{"uuid", "space"}},
+}
+
+// formats maps the string formats enforced by the grammar to their rules.
+// Other formats are annotations and accept any string.
+var formats = map[string]string{
+	"date":      "date-string",
+	"time":      "time-string",
+	"date-time": "date-time-string",
+	"uuid":      "uuid-string",
+}
+
+// FromJSONSchema compiles a JSON schema to a GBNF grammar accepting the JSON
+// values valid under the schema. Object properties are generated in the order
+// the schema lists them, with required properties first, and properties not
+// listed are only allowed if additionalProperties says so. Patterns must match
+// the whole string. Keywords that cannot be enforced while sampling, such as
+// multipleOf or bounds on non-integer numbers, result in an error wrapping
+// ErrUnsupportedSchema.
+func FromJSONSchema(schema []byte) (string, error) {
+	s, err := decode(schema)
+	if err != nil {
+		return "", fmt.Errorf("invalid schema: %w", err)
+	}
+
+	c := &schemaCompiler{b: newBuilder(), root: s, refs: map[string]string{}}
+	c.refs["#"] = c.b.reserve("root")
+
+	body, err := c.expr(s, "root", "#")
+	if err != nil {
+		return "", err
+	}
+	c.b.set("root", body)
+	return c.b.String(), nil
+}
+
+type schemaCompiler struct {
+	b    *builder
+	root any
+	// refs maps references to the rules compiled for them
+	refs map[string]string
+}
+
+func (c *schemaCompiler) primitive(name string) string {
+	if _, ok := c.b.rules[name]; !ok {
+		p := primitives[name]
+		c.b.set(name, p.body)
+		for _, dep := range p.deps {
+			c.primitive(dep)
+		}
+	}
+	return name
+}
+
+// rule compiles s into a rule named after name, returning the rule name.
+func (c *schemaCompiler) rule(s any, name, path string) (string, error) {
+	body, err := c.expr(s, name, path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := c.b.rules[body]; ok {
+		return body, nil
+	}
+	return c.b.add(name, body), nil
+}
+
+// expr compiles s into a GBNF expression, adding any rules it needs.
+func (c *schemaCompiler) expr(s any, name, path string) (string, error) {
+	switch s := s.(type) {
+	case bool:
+		if !s {
+			return "", unsupported(path, "false schemas are not supported")
+		}
+		return c.primitive("value"), nil
+	case *object:
+		return c.schema(s, name, path)
+	default:
+		return "", invalid(path, "expected an object or a boolean")
+	}
+}
+
+func (c *schemaCompiler) schema(s *object, name, path string) (string, error) {
+	for _, k := range unsupportedKeywords {
+		if _, ok := s.get(k); ok {
+			return "", unsupported(path, "%q is not supported", k)
+		}
+	}
+
+	if v, ok := s.get("$ref"); ok {
+		ref, ok := v.(string)
+		if !ok {
+			return "", invalid(path, "$ref must be a string")
+		}
+		return c.ref(ref, path)
+	}
+
+	if v, ok := s.get("const"); ok {
+		return literal(encode(v)) + " " + c.primitive("space"), nil
+	}
+
+	if v, ok := s.get("enum"); ok {
+		values, ok := v.([]any)
+		if !ok || len(values) == 0 {
+			return "", invalid(path, "enum must be a non-empty array")
+		}
+
+		alternatives := make([]string, len(values))
+		for i, v := range values {
+			alternatives[i] = literal(encode(v))
+		}
+		return group(alternatives...) + " " + c.primitive("space"), nil
+	}
+
+	for _, k := range []string{"anyOf", "oneOf"} {
+		if v, ok := s.get(k); ok {
+			return c.alternatives(v, name, path+"/"+k)
+		}
+	}
+
+	if v, ok := s.get("allOf"); ok {
+		merged, err := c.allOf(s, v, path)
+		if err != nil {
+			return "", err
+		}
+		return c.schema(merged, name, path)
+	}
+
+	types, err := schemaTypes(s, path)
+	if err != nil {
+		return "", err
+	}
+
+	if len(types) == 1 {
+		return c.typed(s, types[0], name, path)
+	}
+
+	alternatives := make([]string, len(types))
+	for i, t := range types {
+		body, err := c.typed(s, t, name+"-"+t, path)
+		if err != nil {
+			return "", err
+		}
+		alternatives[i] = body
+	}
+	return group(alternatives...), nil
+}
+
+func (c *schemaCompiler) ref(ref, path string) (string, error) {
+	if name, ok := c.refs[ref]; ok {
+		return name, nil
+	}
+
+	target, err := resolve(c.root, ref)
+	if err != nil {
+		if strings.HasPrefix(ref, "#") {
+			return "", invalid(path, "%v", err)
+		}
+		return "", unsupported(path, "%v", err)
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(ref, "#/"), "$")
+	if _, ok := primitives[sanitize(name)]; ok {
+		name = "ref-" + name
+	}
+
+	// the rule is named before it is compiled so recursive references
+	// resolve to it
+	name = c.b.reserve(name)
+	c.refs[ref] = name
+
+	body, err := c.expr(target, name, ref)
+	if err != nil {
+		return "", err
+	}
+	c.b.set(name, body)
+	return name, nil
+}
+
+func (c *schemaCompiler) alternatives(v any, name, path string) (string, error) {
+	schemas, ok := v.([]any)
+	if !ok || len(schemas) == 0 {
+		return "", invalid(path, "expected a non-empty array")
+	}
+
+	alternatives := make([]string, len(schemas))
+	for i, s := range schemas {
+		rule, err := c.rule(s, fmt.Sprintf("%s-%d", name, i), fmt.Sprintf("%s/%d", path, i))
+		if err != nil {
+			return "", err
+		}
+		alternatives[i] = rule
+	}
+	return group(alternatives...), nil
+}
+
+// allOf merges the object schemas in v into s. Only schemas made of object
+// keywords can be merged.
+func (c *schemaCompiler) allOf(s *object, v any, path string) (*object, error) {
+	schemas, ok := v.([]any)
+	if !ok || len(schemas) == 0 {
+		return nil, invalid(path+"/allOf", "expected a non-empty array")
+	}
+
+	merged := &object{values: make(map[string]any)}
+	properties := &object{values: make(map[string]any)}
+	var required []any
+
+	add := func(s *object, path string) error {
+		for _, k := range s.keys {
+			v := s.values[k]
+			switch k {
+			case "allOf":
+			case "type":
+				if v != "object" {
+					return unsupported(path, "allOf is only supported for objects")
+				}
+			case "properties":
+				p, ok := v.(*object)
+				if !ok {
+					return invalid(path, "properties must be an object")
+				}
+				for _, k := range p.keys {
+					properties.set(k, p.values[k])
+				}
+			case "required":
+				r, ok := v.([]any)
+				if !ok {
+					return invalid(path, "required must be an array")
+				}
+				required = append(required, r...)
+			case "additionalProperties":
+				merged.set(k, v)
+			default:
+				if !annotations[k] {
+					return unsupported(path, "%q in allOf is not supported", k)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := add(s, path); err != nil {
+		return nil, err
+	}
+
+	for i, sub := range schemas {
+		subpath := fmt.Sprintf("%s/allOf/%d", path, i)
+		o, ok := sub.(*object)
+		if ok {
+			if ref, ok := o.values["$ref"].(string); ok {
+				target, err := resolve(c.root, ref)
+				if err != nil {
+					return nil, invalid(subpath, "%v", err)
+				}
+				subpath = ref
+				o, ok = target.(*object)
+			}
+		}
+		if !ok {
+			return nil, unsupported(subpath, "allOf is only supported for objects")
+		}
+
+		if err := add(o, subpath); err != nil {
+			return nil, err
+		}
+	}
+
+	merged.set("type", "object")
+	merged.set("properties", properties)
+	merged.set("required", required)
+	return merged, nil
+}
+
+// schemaTypes returns the types s allows, inferring them from its keywords
+// if it has no type. An empty type allows any value.
+func schemaTypes(s *object, path string) ([]string, error) {
+	switch v := s.values["type"].(type) {
+	case nil:
+	case string:
+		return []string{v}, nil
+	case []any:
+		var types []string
+		for _, t := range v {
+			t, ok := t.(string)
+			if !ok {
+				return nil, invalid(path, "type must be a string or an array of strings")
+			}
+			types = append(types, t)
+		}
+		if len(types) > 0 {
+			return types, nil
+		}
+	default:
+		return nil, invalid(path, "type must be a string or an array of strings")
+	}
+
+	has := func(keys ...string) bool {
+		return slices.ContainsFunc(keys, func(k string) bool {
+			_, ok := s.get(k)
+			return ok
+		})
+	}
+
+	switch {
+	case has("properties", "required", "additionalProperties"):
+		return []string{"object"}, nil
+	case has("items", "prefixItems", "minItems", "maxItems"):
+		return []string{"array"}, nil
+	case has("pattern", "format", "minLength", "maxLength"):
+		return []string{"string"}, nil
+	case has("minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"):
+		return []string{"number"}, nil
+	default:
+		return []string{""}, nil
+	}
+}
+
+func (c *schemaCompiler) typed(s *object, t, name, path string) (string, error) {
+	switch t {
+	case "":
+		return c.primitive("value"), nil
+	case "object":
+		return c.object(s, name, path)
+	case "array":
+		return c.array(s, name, path)
+	case "string":
+		return c.string(s, path)
+	case "integer":
+		return c.integer(s, path)
+	case "number":
+		for _, k := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"} {
+			if _, ok := s.get(k); ok {
+				return "", unsupported(path, "%q is only supported for integers", k)
+			}
+		}
+		return c.primitive("number"), nil
+	case "boolean", "null":
+		return c.primitive(t), nil
+	default:
+		return "", invalid(path, "unknown type %q", t)
+	}
+}
+
+func (c *schemaCompiler) object(s *object, name, path string) (string, error) {
+	properties := &object{values: make(map[string]any)}
+	if v, ok := s.get("properties"); ok {
+		if properties, ok = v.(*object); !ok {
+			return "", invalid(path, "properties must be an object")
+		}
+	}
+
+	required := make(map[string]bool)
+	if v, ok := s.get("required"); ok && v != nil {
+		names, ok := v.([]any)
+		if !ok {
+			return "", invalid(path, "required must be an array")
+		}
+		for _, n := range names {
+			n, ok := n.(string)
+			if !ok {
+				return "", invalid(path, "required must be an array of strings")
+			}
+			required[n] = true
+		}
+	}
+
+	// required properties missing from properties may have any value
+	keys := slices.Clone(properties.keys)
+	for n := range required {
+		if _, ok := properties.get(n); !ok {
+			keys = append(keys, n)
+		}
+	}
+	slices.SortStableFunc(keys[len(properties.keys):], strings.Compare)
+
+	additional, hasAdditional := s.get("additionalProperties")
+	if len(keys) == 0 && (!hasAdditional || additional == true) {
+		return c.primitive("object"), nil
+	}
+
+	space := c.primitive("space")
+
+	var extra string
+	switch v := additional.(type) {
+	case nil, bool:
+		if v == true {
+			extra = c.b.add(name+"-additional-kv", c.primitive("string")+` ":" space `+c.primitive("value"))
+		}
+	default:
+		value, err := c.rule(v, name+"-additional", path+"/additionalProperties")
+		if err != nil {
+			return "", err
+		}
+		extra = c.b.add(name+"-additional-kv", c.primitive("string")+` ":" space `+value)
+	}
+
+	var requiredKVs, optionalKVs []string
+	for _, k := range keys {
+		v, ok := properties.get(k)
+		if !ok {
+			v = true
+		}
+
+		value, err := c.rule(v, name+"-"+k, path+"/properties/"+pointerToken(k))
+		if err != nil {
+			return "", err
+		}
+
+		kv := c.b.add(name+"-"+k+"-kv", literal(encode(k))+` space ":" space `+value)
+		if required[k] {
+			requiredKVs = append(requiredKVs, kv)
+		} else {
+			optionalKVs = append(optionalKVs, kv)
+		}
+	}
+
+	// optional properties are matched by a chain of rules, each matching a
+	// non-empty ordered subset of the properties from one onwards
+	var optional string
+	for i := len(optionalKVs) - 1; i >= 0; i-- {
+		body := optionalKVs[i]
+		if optional != "" {
+			body = fmt.Sprintf(`%s ("," space %s)? | %s`, optionalKVs[i], optional, optional)
+		}
+		optional = c.b.add(fmt.Sprintf("%s-optional-%d", name, i), body)
+	}
+
+	var members string
+	if len(requiredKVs) > 0 {
+		members = strings.Join(requiredKVs, ` "," space `)
+		if optional != "" {
+			members += ` ("," space ` + optional + `)?`
+		}
+		if extra != "" {
+			members += ` ("," space ` + extra + `)*`
+		}
+	} else {
+		var alternatives []string
+		if optional != "" {
+			alternative := optional
+			if extra != "" {
+				alternative += ` ("," space ` + extra + `)*`
+			}
+			alternatives = append(alternatives, alternative)
+		}
+		if extra != "" {
+			alternatives = append(alternatives, extra+` ("," space `+extra+`)*`)
+		}
+		if len(alternatives) == 0 {
+			return `"{" ` + space + ` "}" ` + space, nil
+		}
+		members = group(alternatives...) + "?"
+	}
+
+	return `"{" ` + space + " " + members + ` "}" ` + space, nil
+}
+
+func (c *schemaCompiler) array(s *object, name, path string) (string, error) {
+	minItems, err := intKeyword(s, "minItems", 0, path)
+	if err != nil {
+		return "", err
+	}
+	maxItems, err := intKeyword(s, "maxItems", -1, path)
+	if err != nil {
+		return "", err
+	}
+	if maxItems >= 0 && minItems > maxItems {
+		return "", invalid(path, "minItems is greater than maxItems")
+	}
+
+	space := c.primitive("space")
+	items, hasItems := s.get("items")
+	prefix, hasPrefix := s.get("prefixItems")
+	if tuple, ok := items.([]any); ok {
+		// before draft 2020-12, tuples were given as an array of items
+		prefix, hasPrefix = tuple, true
+		items, hasItems = false, true
+	}
+
+	if hasPrefix {
+		schemas, ok := prefix.([]any)
+		if !ok {
+			return "", invalid(path, "prefixItems must be an array")
+		}
+		if minItems > 0 || maxItems >= 0 {
+			return "", unsupported(path, "minItems and maxItems are not supported with prefixItems")
+		}
+
+		parts := make([]string, len(schemas))
+		for i, s := range schemas {
+			rule, err := c.rule(s, fmt.Sprintf("%s-%d", name, i), fmt.Sprintf("%s/prefixItems/%d", path, i))
+			if err != nil {
+				return "", err
+			}
+			parts[i] = rule
+		}
+
+		body := `"[" ` + space + " " + strings.Join(parts, ` "," space `)
+		if hasItems && items != false {
+			item, err := c.rule(items, name+"-item", path+"/items")
+			if err != nil {
+				return "", err
+			}
+			if len(parts) > 0 {
+				body += ` ("," space ` + item + `)*`
+			} else {
+				body += ` (` + item + ` ("," space ` + item + `)*)?`
+			}
+		}
+		return body + ` "]" ` + space, nil
+	}
+
+	if !hasItems && minItems == 0 && maxItems < 0 {
+		return c.primitive("array"), nil
+	}
+
+	if items == false || maxItems == 0 {
+		if minItems > 0 {
+			return "", invalid(path, "minItems is greater than the number of items allowed")
+		}
+		return `"[" ` + space + ` "]" ` + space, nil
+	}
+
+	item := c.primitive("value")
+	if hasItems {
+		if item, err = c.rule(items, name+"-item", path+"/items"); err != nil {
+			return "", err
+		}
+	}
+
+	rest := ""
+	if maxItems != 1 {
+		more := max(maxItems-1, -1)
+		rest = " " + repeat(`("," space `+item+`)`, max(minItems-1, 0), more)
+	}
+
+	list := item + rest
+	if minItems == 0 {
+		list = "(" + list + ")?"
+	}
+	return `"[" ` + space + " " + list + ` "]" ` + space, nil
+}
+
+func (c *schemaCompiler) string(s *object, path string) (string, error) {
+	minLength, err := intKeyword(s, "minLength", 0, path)
+	if err != nil {
+		return "", err
+	}
+	maxLength, err := intKeyword(s, "maxLength", -1, path)
+	if err != nil {
+		return "", err
+	}
+	if maxLength >= 0 && minLength > maxLength {
+		return "", invalid(path, "minLength is greater than maxLength")
+	}
+
+	if v, ok := s.get("pattern"); ok {
+		pattern, ok := v.(string)
+		if !ok {
+			return "", invalid(path, "pattern must be a string")
+		}
+		if minLength > 0 || maxLength >= 0 {
+			return "", unsupported(path, "minLength and maxLength are not supported with pattern")
+		}
+
+		expr, err := regexExpr(pattern, true)
+		if err != nil {
+			var serr *syntax.Error
+			if errors.As(err, &serr) {
+				return "", invalid(path, "%v", err)
+			}
+			return "", unsupported(path, "%v", err)
+		}
+		return `"\"" ` + expr + ` "\"" ` + c.primitive("space"), nil
+	}
+
+	if format, ok := s.values["format"].(string); ok {
+		if rule, ok := formats[format]; ok {
+			return c.primitive(rule), nil
+		}
+	}
+
+	if minLength == 0 && maxLength < 0 {
+		return c.primitive("string"), nil
+	}
+	return `"\"" ` + repeat(c.primitive("char"), minLength, maxLength) + ` "\"" ` + c.primitive("space"), nil
+}
+
+func (c *schemaCompiler) integer(s *object, path string) (string, error) {
+	bound := func(k string, round func(float64) float64, offset float64) (*int64, error) {
+		v, ok := s.get(k)
+		if !ok {
+			return nil, nil
+		}
+
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, invalid(path, "%s must be a number", k)
+		}
+
+		f, err := n.Float64()
+		if err != nil {
+			return nil, invalid(path, "%s: %v", k, err)
+		}
+
+		f = round(f) + offset
+		if f < math.MinInt64 || f > math.MaxInt64 {
+			return nil, unsupported(path, "%s is out of range", k)
+		}
+
+		i := int64(f)
+		return &i, nil
+	}
+
+	tighter := func(a, b *int64, less func(a, b int64) bool) *int64 {
+		if a == nil || (b != nil && less(*b, *a)) {
+			return b
+		}
+		return a
+	}
+
+	minimum, err := bound("minimum", math.Ceil, 0)
+	if err != nil {
+		return "", err
+	}
+	exclusiveMinimum, err := bound("exclusiveMinimum", math.Floor, 1)
+	if err != nil {
+		return "", err
+	}
+	maximum, err := bound("maximum", math.Floor, 0)
+	if err != nil {
+		return "", err
+	}
+	exclusiveMaximum, err := bound("exclusiveMaximum", math.Ceil, -1)
+	if err != nil {
+		return "", err
+	}
+
+	lo := tighter(minimum, exclusiveMinimum, func(a, b int64) bool { return a > b })
+	hi := tighter(maximum, exclusiveMaximum, func(a, b int64) bool { return a < b })
+	if lo == nil && hi == nil {
+		return c.primitive("integer"), nil
+	}
+	if lo != nil && hi != nil && *lo > *hi {
+		return "", invalid(path, "no integer is between the minimum and the maximum")
+	}
+
+	return intRange(lo, hi) + " " + c.primitive("space"), nil
+}
+
+func intKeyword(s *object, key string, fallback int, path string) (int, error) {
+	v, ok := s.get(key)
+	if !ok {
+		return fallback, nil
+	}
+
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, invalid(path, "%s must be a non-negative integer", key)
+	}
+
+	i, err := strconv.Atoi(n.String())
+	if err != nil || i < 0 {
+		return 0, invalid(path, "%s must be a non-negative integer", key)
+	}
+	return i, nil
+}
+
+// intRange returns an expression matching the decimal integers from lo to hi
+// inclusive, where a nil bound is unbounded.
+func intRange(lo, hi *int64) string {
+	var alternatives []string
+
+	// negative integers are matched by their magnitude
+	if lo == nil || *lo < 0 {
+		var from uint64 = 1
+		if hi != nil && *hi < 0 {
+			from = magnitude(*hi)
+		}
+
+		var to *uint64
+		if lo != nil {
+			m := magnitude(*lo)
+			to = &m
+		}
+		alternatives = append(alternatives, `"-" `+uintRange(from, to))
+	}
+
+	if hi == nil || *hi >= 0 {
+		var from uint64
+		if lo != nil && *lo > 0 {
+			from = uint64(*lo)
+		}
+
+		var to *uint64
+		if hi != nil {
+			m := uint64(*hi)
+			to = &m
+		}
+		alternatives = append(alternatives, uintRange(from, to))
+	}
+
+	return group(alternatives...)
+}
+
+func magnitude(i int64) uint64 {
+	return uint64(-(i + 1)) + 1
+}
+
+// uintRange returns an expression matching the decimal integers from lo to
+// hi inclusive, without leading zeros. A nil hi is unbounded.
+func uintRange(lo uint64, hi *uint64) string {
+	from := strconv.FormatUint(lo, 10)
+	if hi == nil {
+		// numbers with as many digits as lo, or more
+		return group(
+			digitRange(from, strings.Repeat("9", len(from))),
+			fmt.Sprintf("[1-9] [0-9]{%d,}", len(from)),
+		)
+	}
+
+	to := strconv.FormatUint(*hi, 10)
+	var alternatives []string
+	for n := len(from); n <= len(to); n++ {
+		a, b := from, to
+		if n > len(from) {
+			a = "1" + strings.Repeat("0", n-1)
+		}
+		if n < len(to) {
+			b = strings.Repeat("9", n)
+		}
+		alternatives = append(alternatives, digitRange(a, b))
+	}
+	return group(alternatives...)
+}
+
+// digitRange returns an expression matching the digit strings from lo to hi,
+// which have the same length.
+func digitRange(lo, hi string) string {
+	if lo == "" {
+		return `""`
+	}
+
+	rest := len(lo) - 1
+	digits := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return " " + repeat("[0-9]", n, n)
+	}
+
+	if lo[0] == hi[0] {
+		if rest == 0 {
+			return literal(lo)
+		}
+		return literal(lo[:1]) + " " + digitRange(lo[1:], hi[1:])
+	}
+
+	start, end := lo[0], hi[0]
+	var alternatives []string
+	if strings.Trim(lo[1:], "0") != "" {
+		alternatives = append(alternatives, literal(lo[:1])+" "+digitRange(lo[1:], strings.Repeat("9", rest)))
+		start++
+	}
+
+	last := ""
+	if strings.Trim(hi[1:], "9") != "" {
+		last = literal(hi[:1]) + " " + digitRange(strings.Repeat("0", rest), hi[1:])
+		end--
+	}
+
+	if start <= end {
+		alternatives = append(alternatives, "["+string(start)+"-"+string(end)+"]"+digits(rest))
+	}
+
+	if last != "" {
+		alternatives = append(alternatives, last)
+	}
+	return group(alternatives...)
+}
@@ -0,0 +1,338 @@
+package grammar
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/goobla/goobla/llama"
+)
+
+// accepts reports whether grammar matches s in full. It uses a vocabulary of
+// single ASCII characters so every byte of s is its own token.
+func accepts(t *testing.T, grammar, s string) bool {
+	t.Helper()
+
+	const eos = 128
+	var ids []uint32
+	var pieces []string
+	for c := range 128 {
+		ids = append(ids, uint32(c))
+		if c < ' ' && c != '\n' && c != '\t' {
+			pieces = append(pieces, "\x00")
+		} else {
+			pieces = append(pieces, string(rune(c)))
+		}
+	}
+	ids = append(ids, eos)
+	pieces = append(pieces, "")
+
+	g := llama.NewGrammar(grammar, ids, pieces, []int32{eos})
+	if g == nil {
+		t.Fatalf("invalid grammar:\n%s", grammar)
+	}
+	defer g.Free()
+
+	allowed := func(id int32) bool {
+		tokens := make([]llama.TokenData, len(ids))
+		for i := range ids {
+			tokens[i] = llama.TokenData{ID: int32(ids[i]), Logit: 1}
+		}
+		g.Apply(tokens)
+		return !math.IsInf(float64(tokens[id].Logit), -1)
+	}
+
+	for _, c := range []byte(s) {
+		if !allowed(int32(c)) {
+			return false
+		}
+		g.Accept(int32(c))
+	}
+
+	return allowed(eos)
+}
+
+func TestFromJSONSchema(t *testing.T) {
+	cases := []struct {
+		name    string
+		schema  string
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "empty",
+			schema:  `{}`,
+			valid:   []string{`1`, `"a"`, `{"a":[true,null]}`, `[ 1, 2 ]`},
+			invalid: []string{`tru`, `{"a"}`},
+		},
+		{
+			name:    "string",
+			schema:  `{"type":"string"}`,
+			valid:   []string{`""`, `"hello"`, `"a \"quoted\" \\ word\n"`},
+			invalid: []string{`hello`, `1`, `"unterminated`},
+		},
+		{
+			name:    "string length",
+			schema:  `{"type":"string","minLength":2,"maxLength":3}`,
+			valid:   []string{`"ab"`, `"abc"`},
+			invalid: []string{`"a"`, `"abcd"`},
+		},
+		{
+			name:    "integer",
+			schema:  `{"type":"integer"}`,
+			valid:   []string{`0`, `-12`, `345`},
+			invalid: []string{`1.5`, `"1"`},
+		},
+		{
+			name:    "integer range",
+			schema:  `{"type":"integer","minimum":-15,"maximum":120}`,
+			valid:   []string{`-15`, `-9`, `0`, `7`, `99`, `100`, `119`, `120`},
+			invalid: []string{`-16`, `-20`, `121`, `130`, `200`, `1000`},
+		},
+		{
+			name:    "integer exclusive range",
+			schema:  `{"type":"integer","exclusiveMinimum":10,"exclusiveMaximum":1000}`,
+			valid:   []string{`11`, `500`, `999`},
+			invalid: []string{`10`, `9`, `1000`, `-1`},
+		},
+		{
+			name:    "integer minimum",
+			schema:  `{"type":"integer","minimum":42}`,
+			valid:   []string{`42`, `99`, `100`, `123456`},
+			invalid: []string{`41`, `0`, `-42`},
+		},
+		{
+			name:    "number",
+			schema:  `{"type":"number"}`,
+			valid:   []string{`1`, `-0.5`, `6.02e23`, `1E-7`},
+			invalid: []string{`.5`, `01`, `"1"`},
+		},
+		{
+			name:    "boolean or null",
+			schema:  `{"type":["boolean","null"]}`,
+			valid:   []string{`true`, `false`, `null`},
+			invalid: []string{`0`, `"true"`},
+		},
+		{
+			name:    "enum",
+			schema:  `{"enum":["red","green",1,null,{"a":[1]}]}`,
+			valid:   []string{`"red"`, `"green"`, `1`, `null`, `{"a":[1]}`},
+			invalid: []string{`"blue"`, `2`, `{"a":[2]}`},
+		},
+		{
+			name:    "const",
+			schema:  `{"const":"fixed"}`,
+			valid:   []string{`"fixed"`},
+			invalid: []string{`"other"`},
+		},
+		{
+			name:    "pattern",
+			schema:  `{"type":"string","pattern":"^[A-Z]{2}-\\d{3,4}$"}`,
+			valid:   []string{`"AB-123"`, `"XY-9876"`},
+			invalid: []string{`"ab-123"`, `"AB-12"`, `"AB123"`},
+		},
+		{
+			name:    "pattern with escapes",
+			schema:  `{"type":"string","pattern":"^(a|\"|\\\\)+$"}`,
+			valid:   []string{`"a"`, `"a\"\\"`},
+			invalid: []string{`"b"`, `"\n"`},
+		},
+		{
+			name:    "formats",
+			schema:  `{"type":"array","prefixItems":[{"type":"string","format":"date"},{"type":"string","format":"date-time"},{"type":"string","format":"uuid"}]}`,
+			valid:   []string{`["2024-02-29","2024-02-29T12:30:00Z","123e4567-e89b-12d3-a456-426614174000"]`},
+			invalid: []string{`["2024-13-01","2024-02-29T12:30:00Z","123e4567-e89b-12d3-a456-426614174000"]`, `["2024-02-29","2024-02-29 12:30:00","123e4567-e89b-12d3-a456-426614174000"]`},
+		},
+		{
+			name:   "object",
+			schema: `{"type":"object","properties":{"name":{"type":"string"},"age":{"type":"integer"},"email":{"type":"string"}},"required":["name","age"]}`,
+			valid: []string{
+				`{"name":"a","age":1}`,
+				`{ "name": "a", "age": 1, "email": "e" }`,
+			},
+			invalid: []string{`{"name":"a"}`, `{"name":"a","age":"1"}`, `{"age":1}`},
+		},
+		{
+			name:   "optional properties",
+			schema: `{"type":"object","properties":{"a":{"type":"integer"},"b":{"type":"integer"},"c":{"type":"integer"}}}`,
+			valid:  []string{`{}`, `{"a":1}`, `{"b":1}`, `{"a":1,"c":3}`, `{"a":1,"b":2,"c":3}`},
+			invalid: []string{
+				`{"a":"1"}`,
+				`{"c":3,"d":4,}`,
+			},
+		},
+		{
+			name:    "additional properties",
+			schema:  `{"type":"object","properties":{"a":{"type":"integer"}},"required":["a"],"additionalProperties":{"type":"string"}}`,
+			valid:   []string{`{"a":1}`, `{"a":1,"x":"y","z":"w"}`},
+			invalid: []string{`{"a":1,"x":2}`},
+		},
+		{
+			name:    "no additional properties",
+			schema:  `{"type":"object","additionalProperties":false}`,
+			valid:   []string{`{}`},
+			invalid: []string{`{"a":1}`},
+		},
+		{
+			name:    "map",
+			schema:  `{"type":"object","additionalProperties":{"type":"integer"}}`,
+			valid:   []string{`{}`, `{"a":1,"b":2}`},
+			invalid: []string{`{"a":"1"}`},
+		},
+		{
+			name:    "array",
+			schema:  `{"type":"array","items":{"type":"integer"},"minItems":2,"maxItems":3}`,
+			valid:   []string{`[1,2]`, `[1, 2, 3]`},
+			invalid: []string{`[]`, `[1]`, `[1,2,3,4]`, `[1,"2"]`},
+		},
+		{
+			name:    "array min items",
+			schema:  `{"type":"array","items":{"type":"boolean"},"minItems":1}`,
+			valid:   []string{`[true]`, `[true,false,true]`},
+			invalid: []string{`[]`},
+		},
+		{
+			name:    "tuple",
+			schema:  `{"type":"array","prefixItems":[{"type":"string"},{"type":"integer"}],"items":false}`,
+			valid:   []string{`["a",1]`},
+			invalid: []string{`[1,"a"]`, `["a",1,2]`},
+		},
+		{
+			name:    "anyOf",
+			schema:  `{"anyOf":[{"type":"string"},{"type":"object","properties":{"n":{"type":"integer"}},"required":["n"]}]}`,
+			valid:   []string{`"s"`, `{"n":1}`},
+			invalid: []string{`1`, `{"n":"1"}`},
+		},
+		{
+			name:    "oneOf",
+			schema:  `{"oneOf":[{"type":"integer"},{"type":"boolean"}]}`,
+			valid:   []string{`1`, `true`},
+			invalid: []string{`"1"`},
+		},
+		{
+			name:    "allOf",
+			schema:  `{"allOf":[{"type":"object","properties":{"a":{"type":"integer"}},"required":["a"]},{"properties":{"b":{"type":"string"}},"required":["b"]}]}`,
+			valid:   []string{`{"a":1,"b":"x"}`},
+			invalid: []string{`{"a":1}`, `{"b":"x"}`},
+		},
+		{
+			name: "ref",
+			schema: `{
+				"$defs": {"point": {"type":"object","properties":{"x":{"type":"integer"},"y":{"type":"integer"}},"required":["x","y"]}},
+				"type": "object",
+				"properties": {"from": {"$ref":"#/$defs/point"}, "to": {"$ref":"#/$defs/point"}},
+				"required": ["from","to"]
+			}`,
+			valid:   []string{`{"from":{"x":1,"y":2},"to":{"x":3,"y":4}}`},
+			invalid: []string{`{"from":{"x":1},"to":{"x":3,"y":4}}`},
+		},
+		{
+			name: "recursive ref",
+			schema: `{
+				"definitions": {"node": {"type":"object","properties":{"value":{"type":"integer"},"children":{"type":"array","items":{"$ref":"#/definitions/node"}}},"required":["value"]}},
+				"$ref": "#/definitions/node"
+			}`,
+			valid: []string{
+				`{"value":1}`,
+				`{"value":1,"children":[{"value":2,"children":[{"value":3}]},{"value":4}]}`,
+			},
+			invalid: []string{`{"value":1,"children":[{"children":[]}]}`},
+		},
+		{
+			name:    "root ref",
+			schema:  `{"type":"object","properties":{"next":{"anyOf":[{"$ref":"#"},{"type":"null"}]}},"required":["next"]}`,
+			valid:   []string{`{"next":null}`, `{"next":{"next":null}}`},
+			invalid: []string{`{"next":1}`},
+		},
+		{
+			name:    "annotations",
+			schema:  `{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"t","description":"d","type":"string","examples":["x"],"default":"x"}`,
+			valid:   []string{`"x"`},
+			invalid: []string{`1`},
+		},
+		{
+			name:    "annotations in allOf",
+			schema:  `{"allOf":[{"title":"a","type":"object","properties":{"a":{"type":"integer","description":"d"}},"required":["a"]},{"$comment":"c","deprecated":true,"properties":{"b":{"type":"string"}}}]}`,
+			valid:   []string{`{"a":1}`, `{"a":1,"b":"x"}`},
+			invalid: []string{`{"b":"x"}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := FromJSONSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.valid {
+				if !accepts(t, g, s) {
+					t.Errorf("grammar rejected %s\n%s", s, g)
+				}
+				if err := ValidateJSONSchema([]byte(tt.schema), []byte(s)); err != nil {
+					t.Errorf("validation failed for %s: %v", s, err)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if accepts(t, g, s) {
+					t.Errorf("grammar accepted %s\n%s", s, g)
+				}
+				if err := ValidateJSONSchema([]byte(tt.schema), []byte(s)); err == nil {
+					t.Errorf("validation passed for %s", s)
+				}
+			}
+		})
+	}
+}
+
+func TestFromJSONSchemaErrors(t *testing.T) {
+	cases := []struct {
+		name        string
+		schema      string
+		unsupported bool
+	}{
+		{"invalid json", `{"type":`, false},
+		{"unknown type", `{"type":"decimal"}`, false},
+		{"missing ref", `{"$ref":"#/$defs/missing"}`, false},
+		{"invalid pattern", `{"type":"string","pattern":"(a"}`, false},
+		{"minItems over maxItems", `{"type":"array","minItems":3,"maxItems":2}`, false},
+		{"multipleOf", `{"type":"integer","multipleOf":2}`, true},
+		{"not", `{"not":{"type":"string"}}`, true},
+		{"uniqueItems", `{"type":"array","uniqueItems":true}`, true},
+		{"number bounds", `{"type":"number","minimum":0.5}`, true},
+		{"remote ref", `{"$ref":"https://example.com/schema.json"}`, true},
+		{"word boundary", `{"type":"string","pattern":"\\bword\\b"}`, true},
+		{"nested", `{"type":"object","properties":{"a":{"if":{"type":"string"}}}}`, true},
+		{"allOf with strings", `{"allOf":[{"type":"string"},{"minLength":1}]}`, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromJSONSchema([]byte(tt.schema))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if errors.Is(err, ErrUnsupportedSchema) != tt.unsupported {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := []byte(`{"type":"object","properties":{"items":{"type":"array","items":{"type":"integer","maximum":5}}}}`)
+
+	if err := ValidateJSONSchema(schema, []byte(`{"items":[1,5]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ValidateJSONSchema(schema, []byte(`{"items":[1,6]}`))
+	if err == nil || err.Error() != "/items/1: expected a number at most 5, got 6" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := ValidateJSONSchema(schema, []byte(`{"items":[1,`)); err == nil {
+		t.Error("expected error for truncated output")
+	}
+}
@@ -0,0 +1,177 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// regexExpr compiles a regular expression to a GBNF expression matching the
+// same strings. Anchors are ignored since the expression always has to match
+// in full. If inString is true, the expression matches the JSON encoding of
+// the strings instead, for use inside a JSON string literal.
+func regexExpr(pattern string, inString bool) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	c := regexCompiler{inString: inString}
+	return c.compile(re)
+}
+
+type regexCompiler struct {
+	inString bool
+}
+
+func (c regexCompiler) compile(re *syntax.Regexp) (string, error) {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return `""`, nil
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			var parts []string
+			for _, r := range re.Rune {
+				folded := []rune{r, r}
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					folded = append(folded, f, f)
+				}
+				parts = append(parts, c.class(folded))
+			}
+			return strings.Join(parts, " "), nil
+		}
+		return c.literal(string(re.Rune)), nil
+	case syntax.OpCharClass:
+		return c.class(re.Rune), nil
+	case syntax.OpAnyCharNotNL:
+		return c.class([]rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}), nil
+	case syntax.OpAnyChar:
+		return c.class([]rune{0, unicode.MaxRune}), nil
+	case syntax.OpCapture:
+		sub, err := c.compile(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+		return group(sub), nil
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		sub, err := c.compile(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+
+		min, max := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			min, max = 0, -1
+		case syntax.OpPlus:
+			min, max = 1, -1
+		case syntax.OpQuest:
+			min, max = 0, 1
+		}
+		return repeat(group(sub), min, max), nil
+	case syntax.OpConcat, syntax.OpAlternate:
+		parts := make([]string, len(re.Sub))
+		for i, sub := range re.Sub {
+			part, err := c.compile(sub)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = part
+		}
+
+		if re.Op == syntax.OpAlternate {
+			return group(parts...), nil
+		}
+		return strings.Join(parts, " "), nil
+	default:
+		return "", fmt.Errorf("regular expression %q is not supported", re)
+	}
+}
+
+// literal returns a GBNF literal matching s.
+func (c regexCompiler) literal(s string) string {
+	if c.inString {
+		var sb strings.Builder
+		for _, r := range s {
+			sb.WriteString(jsonEscape(r))
+		}
+		s = sb.String()
+	}
+	return literal(s)
+}
+
+// class returns a GBNF expression matching the runes in ranges, given as
+// pairs of inclusive bounds. Inside JSON strings, characters that have to be
+// escaped are matched by their escape sequences.
+func (c regexCompiler) class(ranges []rune) string {
+	var escapes []string
+	var sb strings.Builder
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		// code point 0 terminates grammar strings so it is never matched
+		lo = max(lo, 1)
+		if c.inString {
+			for r := lo; r <= min(hi, 0x1f); r++ {
+				escapes = append(escapes, literal(jsonEscape(r)))
+			}
+			lo = max(lo, 0x20)
+
+			for _, r := range []rune{'"', '\\'} {
+				if lo <= r && r <= hi {
+					escapes = append(escapes, literal(jsonEscape(r)))
+					if lo < r {
+						sb.WriteString(classRange(lo, r-1))
+					}
+					lo = r + 1
+				}
+			}
+		}
+
+		if lo <= hi {
+			sb.WriteString(classRange(lo, hi))
+		}
+	}
+
+	var alternatives []string
+	if sb.Len() > 0 {
+		alternatives = append(alternatives, "["+sb.String()+"]")
+	}
+	alternatives = append(alternatives, escapes...)
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+	return group(alternatives...)
+}
+
+func classRange(lo, hi rune) string {
+	if lo == hi {
+		return classChar(lo)
+	}
+	return classChar(lo) + "-" + classChar(hi)
+}
+
+// jsonEscape returns r as it appears in a JSON string.
+func jsonEscape(r rune) string {
+	switch r {
+	case '"':
+		return `\"`
+	case '\\':
+		return `\\`
+	case '\n':
+		return `\n`
+	case '\r':
+		return `\r`
+	case '\t':
+		return `\t`
+	case '\b':
+		return `\b`
+	case '\f':
+		return `\f`
+	}
+
+	if r < 0x20 {
+		return fmt.Sprintf(`\u%04x`, r)
+	}
+	return string(r)
+}
@@ -0,0 +1,227 @@
+package grammar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// object is a decoded JSON object that remembers the order of its keys, so
+// that properties are generated in the order the schema lists them.
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *object) get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *object) set(key string, value any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// decode decodes a JSON value, keeping numbers as json.Number and objects as
+// *object.
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		o := &object{values: make(map[string]any)}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			o.set(t.(string), v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return o, nil
+	case json.Delim('['):
+		a := []any{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	default:
+		return t, nil
+	}
+}
+
+// encode returns the compact JSON encoding of a decoded value.
+func encode(v any) string {
+	switch v := v.(type) {
+	case *object:
+		parts := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			parts[i] = encode(k) + ":" + encode(v.values[k])
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case []any:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = encode(e)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "null"
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// equal reports whether two decoded values are the same JSON value.
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case *object:
+		b, ok := b.(*object)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for _, k := range a.keys {
+			bv, ok := b.values[k]
+			if !ok || !equal(a.values[k], bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, xok := new(big.Rat).SetString(a.String())
+		y, yok := new(big.Rat).SetString(b.String())
+		return xok && yok && x.Cmp(y) == 0
+	default:
+		return a == b
+	}
+}
+
+// resolve returns the schema referred to by a local reference such as
+// "#/$defs/item".
+func resolve(root any, ref string) (any, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("remote reference %q is not supported", ref)
+	}
+
+	pointer, err := url.PathUnescape(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	if pointer == "" {
+		return root, nil
+	}
+
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("anchor reference %q is not supported", ref)
+	}
+
+	v := root
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch t := v.(type) {
+		case *object:
+			if v, ok = t.get(token); !ok {
+				return nil, fmt.Errorf("reference %q not found", ref)
+			}
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, fmt.Errorf("reference %q not found", ref)
+			}
+			v = t[i]
+		default:
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+	}
+
+	return v, nil
+}
+
+// pointerToken escapes a key for use in a JSON pointer.
+func pointerToken(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// annotations are keywords with no effect on the values a schema accepts.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "$defs": true, "definitions": true,
+	"title": true, "description": true, "default": true, "examples": true,
+	"deprecated": true, "readOnly": true, "writeOnly": true,
+	"contentEncoding": true, "contentMediaType": true,
+}
+
+// unsupportedKeywords constrain values in ways that cannot be enforced while
+// sampling.
+var unsupportedKeywords = []string{
+	"not", "if", "then", "else", "multipleOf", "uniqueItems",
+	"patternProperties", "propertyNames", "minProperties", "maxProperties",
+	"dependencies", "dependentRequired", "dependentSchemas",
+	"contains", "minContains", "maxContains", "additionalItems",
+	"unevaluatedProperties", "unevaluatedItems",
+	"$anchor", "$dynamicRef", "$dynamicAnchor", "$recursiveRef", "$recursiveAnchor",
+}
@@ -0,0 +1,321 @@
+package grammar
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidateJSONSchema reports whether output is a JSON value valid under
+// schema, returning an error describing the first mismatch if not.
+func ValidateJSONSchema(schema, output []byte) error {
+	s, err := decode(schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+
+	v, err := decode(output)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return validator{root: s}.validate(s, v, "")
+}
+
+type validator struct {
+	root any
+}
+
+func mismatch(path, format string, args ...any) error {
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
+
+func (vr validator) validate(s, v any, path string) error {
+	switch s := s.(type) {
+	case bool:
+		if !s {
+			return mismatch(path, "no value is allowed")
+		}
+		return nil
+	case *object:
+		return vr.validateObject(s, v, path)
+	default:
+		return fmt.Errorf("invalid schema: expected an object or a boolean")
+	}
+}
+
+func (vr validator) validateObject(s *object, v any, path string) error {
+	if ref, ok := s.values["$ref"].(string); ok {
+		target, err := resolve(vr.root, ref)
+		if err != nil {
+			return err
+		}
+		return vr.validate(target, v, path)
+	}
+
+	if c, ok := s.get("const"); ok && !equal(c, v) {
+		return mismatch(path, "expected %s", encode(c))
+	}
+
+	if enum, ok := s.values["enum"].([]any); ok {
+		var found bool
+		for _, e := range enum {
+			if equal(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return mismatch(path, "expected one of %s", encode(enum))
+		}
+	}
+
+	if schemas, ok := s.values["anyOf"].([]any); ok {
+		var err error
+		for _, sub := range schemas {
+			if err = vr.validate(sub, v, path); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if schemas, ok := s.values["oneOf"].([]any); ok {
+		var matches int
+		var err error
+		for _, sub := range schemas {
+			if e := vr.validate(sub, v, path); e == nil {
+				matches++
+			} else if err == nil {
+				err = e
+			}
+		}
+		switch {
+		case matches == 0:
+			return err
+		case matches > 1:
+			return mismatch(path, "expected exactly one schema of oneOf to match, got %d", matches)
+		}
+	}
+
+	if schemas, ok := s.values["allOf"].([]any); ok {
+		for _, sub := range schemas {
+			if err := vr.validate(sub, v, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t, ok := s.get("type"); ok {
+		types, ok := t.([]any)
+		if !ok {
+			types = []any{t}
+		}
+
+		var found bool
+		for _, t := range types {
+			if t, ok := t.(string); ok && hasType(v, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return mismatch(path, "expected %s, got %s", encode(t), typeOf(v))
+		}
+	}
+
+	switch v := v.(type) {
+	case *object:
+		return vr.validateProperties(s, v, path)
+	case []any:
+		return vr.validateItems(s, v, path)
+	case string:
+		return validateString(s, v, path)
+	case json.Number:
+		return validateNumber(s, v, path)
+	}
+	return nil
+}
+
+func (vr validator) validateProperties(s *object, v *object, path string) error {
+	if required, ok := s.values["required"].([]any); ok {
+		for _, n := range required {
+			if n, ok := n.(string); ok {
+				if _, ok := v.get(n); !ok {
+					return mismatch(path, "missing required property %q", n)
+				}
+			}
+		}
+	}
+
+	properties, _ := s.values["properties"].(*object)
+	for _, k := range v.keys {
+		if properties != nil {
+			if sub, ok := properties.get(k); ok {
+				if err := vr.validate(sub, v.values[k], path+"/"+pointerToken(k)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if additional, ok := s.get("additionalProperties"); ok {
+			if additional == false {
+				return mismatch(path, "unexpected property %q", k)
+			}
+			if err := vr.validate(additional, v.values[k], path+"/"+pointerToken(k)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (vr validator) validateItems(s *object, v []any, path string) error {
+	if n, err := intKeyword(s, "minItems", 0, path); err == nil && len(v) < n {
+		return mismatch(path, "expected at least %d items, got %d", n, len(v))
+	}
+	if n, err := intKeyword(s, "maxItems", -1, path); err == nil && n >= 0 && len(v) > n {
+		return mismatch(path, "expected at most %d items, got %d", n, len(v))
+	}
+
+	items, hasItems := s.get("items")
+	prefix, _ := s.values["prefixItems"].([]any)
+	if tuple, ok := items.([]any); ok {
+		prefix = tuple
+		items, hasItems = true, true
+		if additional, ok := s.get("additionalItems"); ok {
+			items = additional
+		}
+	}
+
+	for i, e := range v {
+		sub := items
+		switch {
+		case i < len(prefix):
+			sub = prefix[i]
+		case !hasItems:
+			continue
+		}
+
+		if err := vr.validate(sub, e, fmt.Sprintf("%s/%d", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func validateString(s *object, v, path string) error {
+	length := utf8.RuneCountInString(v)
+	if n, err := intKeyword(s, "minLength", 0, path); err == nil && length < n {
+		return mismatch(path, "expected at least %d characters, got %d", n, length)
+	}
+	if n, err := intKeyword(s, "maxLength", -1, path); err == nil && n >= 0 && length > n {
+		return mismatch(path, "expected at most %d characters, got %d", n, length)
+	}
+
+	if pattern, ok := s.values["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema: %w", err)
+		}
+		if !re.MatchString(v) {
+			return mismatch(path, "expected a string matching %q", pattern)
+		}
+	}
+
+	var err error
+	switch format, _ := s.values["format"].(string); format {
+	case "date":
+		_, err = time.Parse(time.DateOnly, v)
+	case "time":
+		_, err = time.Parse("15:04:05Z07:00", v)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, v)
+	case "uuid":
+		if !uuidRegexp.MatchString(v) {
+			err = fmt.Errorf("invalid uuid %q", v)
+		}
+	}
+	if err != nil {
+		return mismatch(path, "expected a %s", s.values["format"])
+	}
+
+	return nil
+}
+
+func validateNumber(s *object, v json.Number, path string) error {
+	n, ok := new(big.Rat).SetString(v.String())
+	if !ok {
+		return mismatch(path, "invalid number %s", v)
+	}
+
+	checks := []struct {
+		key string
+		ok  func(cmp int) bool
+		msg string
+	}{
+		{"minimum", func(cmp int) bool { return cmp >= 0 }, "at least"},
+		{"exclusiveMinimum", func(cmp int) bool { return cmp > 0 }, "greater than"},
+		{"maximum", func(cmp int) bool { return cmp <= 0 }, "at most"},
+		{"exclusiveMaximum", func(cmp int) bool { return cmp < 0 }, "less than"},
+	}
+
+	for _, check := range checks {
+		bound, ok := s.values[check.key].(json.Number)
+		if !ok {
+			continue
+		}
+
+		b, ok := new(big.Rat).SetString(bound.String())
+		if ok && !check.ok(n.Cmp(b)) {
+			return mismatch(path, "expected a number %s %s, got %s", check.msg, bound, v)
+		}
+	}
+	return nil
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		r, ok := new(big.Rat).SetString(n.String())
+		return ok && r.IsInt()
+	default:
+		return typeOf(v) == t
+	}
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case *object:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
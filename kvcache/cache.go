@@ -77,3 +77,55 @@ type Cache interface {
 	// removed by calling Remove(seq, 0, math.MaxInt32)
 	Remove(seq int, beginIndex, endIndex int32) error
 }
+
+// Snapshotter is implemented by caches that can copy the contents of a
+// sequence out of the cache and back in, such as to persist them across
+// restarts.
+type Snapshotter interface {
+	// Snapshot returns the contents of positions [0, len) of seq. It
+	// returns an error if any of these positions are not in the cache.
+	Snapshot(seq int, len int32) (*Snapshot, error)
+
+	// Restore replaces the contents of seq with the snapshot
+	Restore(seq int, snapshot *Snapshot) error
+}
+
+// Snapshot holds the keys and values of a sequence, independent of the
+// backend. They are kept in the data type of the cache.
+type Snapshot struct {
+	// Len is the number of positions in the snapshot, starting from 0
+	Len int32
+
+	// Layers holds the contents of each layer that stores data
+	Layers map[int]LayerSnapshot
+}
+
+// LayerSnapshot holds the keys and values of one layer as the raw bytes of
+// DType. Keys are of shape KHeadDim * NumKVHeads, Len and values are of shape
+// VHeadDim * NumKVHeads, Len.
+type LayerSnapshot struct {
+	KHeadDim   int
+	VHeadDim   int
+	NumKVHeads int
+	DType      ml.DType
+
+	Keys   []byte
+	Values []byte
+}
+
+// Truncate returns a snapshot of the first n positions of s
+func (s *Snapshot) Truncate(n int32) *Snapshot {
+	if n >= s.Len {
+		return s
+	}
+
+	out := &Snapshot{Len: n, Layers: make(map[int]LayerSnapshot, len(s.Layers))}
+	for i, layer := range s.Layers {
+		// every position takes the same number of bytes
+		layer.Keys = layer.Keys[:len(layer.Keys)/int(s.Len)*int(n)]
+		layer.Values = layer.Values[:len(layer.Values)/int(s.Len)*int(n)]
+		out.Layers[i] = layer
+	}
+
+	return out
+}
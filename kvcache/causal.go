@@ -176,10 +176,10 @@ func (c *Causal) StartForward(ctx ml.Context, batch input.Batch, reserve bool) e
 		c.updateSlidingWindow()
 
 		var err error
-		c.curLoc, err = c.findStartLoc()
+		c.curLoc, err = c.findStartLoc(c.curBatchSize)
 		if errors.Is(err, ErrKvCacheFull) {
 			c.defrag()
-			c.curLoc, err = c.findStartLoc()
+			c.curLoc, err = c.findStartLoc(c.curBatchSize)
 		}
 		if err != nil {
 			return err
@@ -231,13 +231,13 @@ func newRange() cellRange {
 	}
 }
 
-// Find the first contiguous block of at least curBatchSize
-func (c *Causal) findStartLoc() (int, error) {
+// Find the first contiguous block of at least size cells
+func (c *Causal) findStartLoc(size int) (int, error) {
 	var start, count int
 	for i := range c.cells {
 		if len(c.cells[i].sequences) == 0 {
 			count++
-			if count >= c.curBatchSize {
+			if count >= size {
 				return start, nil
 			}
 		} else {
@@ -246,7 +246,7 @@ func (c *Causal) findStartLoc() (int, error) {
 		}
 	}
 
-	return 0, fmt.Errorf("%w (cache: %v batch: %v)", ErrKvCacheFull, len(c.cells), c.curBatchSize)
+	return 0, fmt.Errorf("%w (cache: %v batch: %v)", ErrKvCacheFull, len(c.cells), size)
 }
 
 func (c *Causal) updateSlidingWindow() {
@@ -553,21 +553,7 @@ func (c *Causal) Put(ctx ml.Context, key, value ml.Tensor) {
 		panic(fmt.Errorf("inconsistent batch sizes (layer: %v, batch size: %v layer batch size: %v)", c.curLayer, c.curBatchSize, batchSize))
 	}
 
-	if _, ok := c.ctxs[c.curLayer]; !ok {
-		c.ctxs[c.curLayer] = c.backend.NewContextSize(2).Layer(c.curLayer)
-	}
-
-	if _, ok := c.keys[c.curLayer]; !ok {
-		c.keys[c.curLayer] = c.ctxs[c.curLayer].Zeros(c.DType, kHeadDim, numKVHeads, len(c.cells))
-	}
-
-	if _, ok := c.values[c.curLayer]; !ok {
-		if c.config.PermutedV {
-			c.values[c.curLayer] = c.ctxs[c.curLayer].Zeros(c.DType, len(c.cells), vHeadDim, numKVHeads)
-		} else {
-			c.values[c.curLayer] = c.ctxs[c.curLayer].Zeros(c.DType, vHeadDim, numKVHeads, len(c.cells))
-		}
-	}
+	c.allocLayer(c.curLayer, kHeadDim, vHeadDim, numKVHeads)
 
 	rowSize := c.keys[c.curLayer].Stride(2)
 	ctx.Forward(key.Copy(ctx, c.keys[c.curLayer].View(ctx, rowSize*c.curLoc, kHeadDim*numKVHeads*batchSize)))
@@ -584,6 +570,26 @@ func (c *Causal) Put(ctx ml.Context, key, value ml.Tensor) {
 	}
 }
 
+// allocLayer allocates storage for the keys and values of layer, if it
+// hasn't been already
+func (c *Causal) allocLayer(layer, kHeadDim, vHeadDim, numKVHeads int) {
+	if _, ok := c.ctxs[layer]; !ok {
+		c.ctxs[layer] = c.backend.NewContextSize(2).Layer(layer)
+	}
+
+	if _, ok := c.keys[layer]; !ok {
+		c.keys[layer] = c.ctxs[layer].Zeros(c.DType, kHeadDim, numKVHeads, len(c.cells))
+	}
+
+	if _, ok := c.values[layer]; !ok {
+		if c.config.PermutedV {
+			c.values[layer] = c.ctxs[layer].Zeros(c.DType, len(c.cells), vHeadDim, numKVHeads)
+		} else {
+			c.values[layer] = c.ctxs[layer].Zeros(c.DType, vHeadDim, numKVHeads, len(c.cells))
+		}
+	}
+}
+
 func (c *Causal) CopyPrefix(srcSeq, dstSeq int, len int32) {
 	seqRange := newRange()
 
@@ -759,3 +765,177 @@ func (c *Causal) Remove(seq int, beginIndex, endIndex int32) error {
 
 	return nil
 }
+
+// CellSize returns the memory used by the keys and values of a single
+// position, across all layers
+func (c *Causal) CellSize() int {
+	var size int
+	for i, key := range c.keys {
+		if key == nil {
+			continue
+		}
+
+		size += key.Stride(2)
+
+		value := c.values[i]
+		if c.config.PermutedV {
+			size += value.Stride(0) * value.Dim(1) * value.Dim(2)
+		} else {
+			size += value.Stride(2)
+		}
+	}
+
+	return size
+}
+
+// Snapshot returns the keys and values of positions [0, length) of seq,
+// in the data type of the cache. The positions don't need to be stored in order or
+// contiguously, but all of them must be present, so sliding window caches
+// can only take snapshots of sequences that fit in their window.
+func (c *Causal) Snapshot(seq int, length int32) (*Snapshot, error) {
+	// the cell holding each position
+	rows := slices.Repeat([]int32{-1}, int(length))
+	for i, cell := range c.cells {
+		if cell.pos >= 0 && cell.pos < length && slices.Contains(cell.sequences, seq) {
+			rows[cell.pos] = int32(i)
+		}
+	}
+
+	if slices.Contains(rows, -1) {
+		return nil, fmt.Errorf("%w: sequence %v does not have positions [0, %v) cached", ErrNotSupported, seq, length)
+	}
+
+	ctx := c.backend.NewContext()
+	defer ctx.Close()
+
+	rowsTensor := ctx.Input().FromIntSlice(rows, int(length))
+
+	snapshot := &Snapshot{Len: length, Layers: make(map[int]LayerSnapshot)}
+	keys := make(map[int]ml.Tensor)
+	values := make(map[int]ml.Tensor)
+	var outputs []ml.Tensor
+	for i, key := range c.keys {
+		if key == nil {
+			continue
+		}
+
+		kHeadDim := key.Dim(0)
+		numKVHeads := key.Dim(1)
+
+		value := c.values[i]
+		var vHeadDim int
+		if c.config.PermutedV {
+			vHeadDim = value.Dim(1)
+			value = value.Reshape(ctx, len(c.cells), vHeadDim*numKVHeads).Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+		} else {
+			vHeadDim = value.Dim(0)
+			value = value.Reshape(ctx, vHeadDim*numKVHeads, len(c.cells))
+		}
+
+		if length == 0 {
+			snapshot.Layers[i] = LayerSnapshot{KHeadDim: kHeadDim, VHeadDim: vHeadDim, NumKVHeads: numKVHeads, DType: key.DType()}
+			continue
+		}
+
+		// gathering rows produces float32, so copy them back into the
+		// type of the cache to not hold twice as much in memory
+		keys[i] = ctx.Input().Empty(key.DType(), kHeadDim*numKVHeads, int(length))
+		ctx.Forward(key.Reshape(ctx, kHeadDim*numKVHeads, len(c.cells)).Rows(ctx, rowsTensor).Copy(ctx, keys[i]))
+
+		values[i] = ctx.Input().Empty(value.DType(), vHeadDim*numKVHeads, int(length))
+		ctx.Forward(value.Rows(ctx, rowsTensor).Copy(ctx, values[i]))
+
+		outputs = append(outputs, keys[i], values[i])
+
+		snapshot.Layers[i] = LayerSnapshot{KHeadDim: kHeadDim, VHeadDim: vHeadDim, NumKVHeads: numKVHeads, DType: key.DType()}
+	}
+
+	if len(outputs) == 0 {
+		return snapshot, nil
+	}
+
+	ctx.Compute(outputs...)
+
+	for i, layer := range snapshot.Layers {
+		layer.Keys = keys[i].Bytes()
+		layer.Values = values[i].Bytes()
+		snapshot.Layers[i] = layer
+	}
+
+	return snapshot, nil
+}
+
+// Restore replaces the contents of seq with a snapshot, storing it in a
+// contiguous block of cells.
+func (c *Causal) Restore(seq int, snapshot *Snapshot) error {
+	if err := c.Remove(seq, 0, math.MaxInt32); err != nil {
+		return err
+	}
+
+	size := int(snapshot.Len)
+	if size == 0 {
+		return nil
+	}
+
+	loc, err := c.findStartLoc(size)
+	if errors.Is(err, ErrKvCacheFull) {
+		c.defrag()
+		loc, err = c.findStartLoc(size)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := c.backend.NewContext()
+	defer ctx.Close()
+
+	for i, layer := range snapshot.Layers {
+		c.allocLayer(i, layer.KHeadDim, layer.VHeadDim, layer.NumKVHeads)
+
+		key := c.keys[i]
+		value := c.values[i]
+		if key.Dim(0) != layer.KHeadDim || key.Dim(1) != layer.NumKVHeads {
+			return fmt.Errorf("snapshot of layer %v has shape %v, %v, cache has %v, %v", i, layer.KHeadDim, layer.NumKVHeads, key.Dim(0), key.Dim(1))
+		}
+
+		if layer.DType != key.DType() {
+			return fmt.Errorf("snapshot of layer %v has type %v, cache has %v", i, layer.DType, key.DType())
+		}
+
+		kSize := layer.KHeadDim * layer.NumKVHeads * size
+		vSize := layer.VHeadDim * layer.NumKVHeads * size
+
+		rowSize := key.Stride(2)
+		vBytes := value.Stride(2) * size
+		if c.config.PermutedV {
+			vBytes = value.Stride(0) * vSize
+		}
+		if len(layer.Keys) != rowSize*size || len(layer.Values) != vBytes {
+			return fmt.Errorf("snapshot of layer %v has %v bytes of keys and %v of values, expected %v and %v", i, len(layer.Keys), len(layer.Values), rowSize*size, vBytes)
+		}
+
+		k := ctx.Input().FromBytes(layer.DType, layer.Keys, kSize)
+		ctx.Forward(k.Copy(ctx, key.View(ctx, rowSize*loc, kSize)))
+
+		if c.config.PermutedV {
+			elemSize := value.Stride(0)
+
+			v := ctx.Input().FromBytes(layer.DType, layer.Values, layer.VHeadDim, layer.NumKVHeads, size).Permute(ctx, 1, 2, 0, 3)
+			ctx.Forward(v.Copy(ctx, value.View(ctx, elemSize*loc, size, len(c.cells)*elemSize, layer.VHeadDim*layer.NumKVHeads)))
+		} else {
+			rowSize := value.Stride(2)
+
+			v := ctx.Input().FromBytes(layer.DType, layer.Values, vSize)
+			ctx.Forward(v.Copy(ctx, value.View(ctx, rowSize*loc, vSize)))
+		}
+	}
+
+	ctx.Compute()
+
+	for i := range size {
+		c.cells[loc+i] = cacheCell{pos: int32(i), sequences: []int{seq}}
+	}
+	c.cellRanges[seq] = cellRange{min: loc, max: loc + size - 1}
+
+	return nil
+}
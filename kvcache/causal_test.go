@@ -1,6 +1,7 @@
 package kvcache
 
 import (
+	"encoding/binary"
 	"math"
 	"slices"
 	"testing"
@@ -537,6 +538,65 @@ func TestSWARemoveWindow(t *testing.T) {
 	})
 }
 
+func TestSnapshot(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	if err := cache.Init(backend, ml.DTypeF16, 2, 16, 16); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{11, 12, 21, 22, 31, 32, 41, 42},
+			inShape:       []int{2, 1, 4},
+			seqs:          []int{0, 0, 0, 0},
+			pos:           []int32{0, 1, 2, 3},
+			expected:      []float32{11, 12, 21, 22, 31, 32, 41, 42},
+			expectedShape: []int{2, 1, 4},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	if _, err := cache.Snapshot(0, 5); err == nil {
+		t.Fatal("expected error taking a snapshot of uncached positions")
+	}
+
+	snapshot, err := cache.Snapshot(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot = snapshot.Truncate(3)
+	if layer := snapshot.Layers[0]; layer.DType != ml.DTypeF16 ||
+		!slices.Equal(testFloats(layer.Keys), []float32{11, 12, 21, 22, 31, 32}) || !slices.Equal(layer.Values, layer.Keys) {
+		t.Fatalf("unexpected snapshot: %+v", layer)
+	}
+
+	if err := cache.Restore(1, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	tests = []testCase{
+		{
+			name:          "Restored",
+			in:            []float32{51, 52},
+			inShape:       []int{2, 1, 1},
+			seqs:          []int{1},
+			pos:           []int32{3},
+			expected:      []float32{11, 12, 21, 22, 31, 32, 51, 52},
+			expectedShape: []int{2, 1, 4},
+			expectedMask:  []float32{0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
+
 type testBackend struct {
 	ml.Backend
 }
@@ -590,6 +650,13 @@ func (c *testContext) FromIntSlice(s []int32, shape ...int) ml.Tensor {
 	return out
 }
 
+func (c *testContext) FromBytes(dtype ml.DType, s []byte, shape ...int) ml.Tensor {
+	out := c.FromFloatSlice(testFloats(s), shape...)
+	out.(*testTensor).dtype = dtype
+
+	return out
+}
+
 func (c *testContext) Arange(start, stop, step float32, dtype ml.DType) ml.Tensor {
 	s := make([]float32, 0, int((stop-start)/step))
 	for i := start; i < stop; i += step {
@@ -646,6 +713,24 @@ func (t *testTensor) DType() ml.DType {
 	return t.dtype
 }
 
+// Bytes returns the data of t as float32, whatever its type.
+func (t *testTensor) Bytes() []byte {
+	b := make([]byte, 0, 4*len(t.data))
+	for _, f := range t.data {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+	}
+	return b
+}
+
+// testFloats decodes the output of [testTensor.Bytes].
+func testFloats(b []byte) []float32 {
+	f := make([]float32, len(b)/4)
+	for i := range f {
+		f[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return f
+}
+
 func (t *testTensor) Floats() []float32 {
 	out := make([]float32, len(t.data))
 	copy(out, t.data)
@@ -696,3 +781,21 @@ func (t *testTensor) Copy(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	copy(t2.(*testTensor).data, t.data)
 	return nil
 }
+
+func (t *testTensor) Reshape(ctx ml.Context, shape ...int) ml.Tensor {
+	view := *t
+	view.shape = shape
+	return &view
+}
+
+func (t *testTensor) Rows(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
+	rowSize := t.shape[0]
+	rows := t2.(*testTensor).data
+
+	out := ctx.Empty(ml.DTypeF32, rowSize, len(rows)).(*testTensor)
+	for i, row := range rows {
+		copy(out.data[i*rowSize:(i+1)*rowSize], t.data[int(row)*rowSize:])
+	}
+
+	return out
+}
@@ -114,3 +114,16 @@ func (c *WrapperCache) Remove(seq int, beginIndex, endIndex int32) error {
 
 	return nil
 }
+
+// CellSize returns the memory used by a single position across the wrapped
+// caches that report it
+func (c *WrapperCache) CellSize() int {
+	var size int
+	for _, cache := range c.caches {
+		if cache, ok := cache.(interface{ CellSize() int }); ok {
+			size += cache.CellSize()
+		}
+	}
+
+	return size
+}
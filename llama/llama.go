@@ -532,6 +532,7 @@ type SamplingParams struct {
 	PenalizeNl     bool
 	Seed           uint32
 	Grammar        string
+	LogitBias      map[int32]float32
 }
 
 func NewSamplingContext(model *Model, params SamplingParams) (*SamplingContext, error) {
@@ -551,6 +552,23 @@ func NewSamplingContext(model *Model, params SamplingParams) (*SamplingContext,
 	defer C.free(unsafe.Pointer(grammar))
 
 	cparams.grammar = grammar
+
+	if len(params.LogitBias) > 0 {
+		logitBias := (*C.struct_llama_logit_bias)(C.malloc(C.size_t(len(params.LogitBias)) * C.size_t(unsafe.Sizeof(C.struct_llama_logit_bias{}))))
+		defer C.free(unsafe.Pointer(logitBias))
+
+		biases := unsafe.Slice(logitBias, len(params.LogitBias))
+		var i int
+		for token, bias := range params.LogitBias {
+			biases[i].token = C.llama_token(token)
+			biases[i].bias = C.float(bias)
+			i++
+		}
+
+		cparams.logit_bias = logitBias
+		cparams.n_logit_bias = C.size_t(len(params.LogitBias))
+	}
+
 	context := &SamplingContext{c: C.common_sampler_cinit(model.c, &cparams)}
 	if context.c == nil {
 		return nil, errors.New("unable to create sampling context")
@@ -21,6 +21,7 @@ struct common_sampler *common_sampler_cinit(const struct llama_model *model, str
         sparams.penalty_present = params->penalty_present;
         sparams.seed = params->seed;
         sparams.grammar = params->grammar;
+        sparams.logit_bias.assign(params->logit_bias, params->logit_bias + params->n_logit_bias);
         sparams.xtc_probability = 0.0;
         sparams.xtc_threshold = 0.5;
         return common_sampler_init(model, sparams);
@@ -22,6 +22,8 @@ extern "C"
         float penalty_present;
         uint32_t seed;
         char *grammar;
+        struct llama_logit_bias *logit_bias;
+        size_t n_logit_bias;
     };
 
     struct common_sampler *common_sampler_cinit(const struct llama_model *model, struct common_sampler_cparams *params);
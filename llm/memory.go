@@ -15,12 +15,12 @@ import (
 )
 
 // This algorithm looks for a complete fit to determine if we need to unload other models
-func PredictServerFit(allGpus discover.GpuInfoList, f *ggml.GGML, adapters, projectors []string, opts api.Options, numParallel int) (bool, uint64) {
+func PredictServerFit(allGpus discover.GpuInfoList, f *ggml.GGML, adapters, projectors []string, draft string, opts api.Options, numParallel int) (bool, uint64) {
 	// Split up the GPUs by type and try them
 	var estimatedVRAM uint64
 	for _, gpus := range allGpus.ByLibrary() {
 		var layerCount int
-		estimate := EstimateGPULayers(gpus, f, projectors, opts, numParallel)
+		estimate := EstimateGPULayers(gpus, f, projectors, draft, opts, numParallel)
 		layerCount, estimatedVRAM = estimate.Layers, estimate.VRAMSize
 		if opts.NumGPU < 0 {
 			if layerCount > 0 && layerCount >= int(f.KV().BlockCount()+1) {
@@ -54,6 +54,10 @@ type MemoryEstimate struct {
 	// For multi-GPU scenarios, this is the size in bytes per GPU
 	GPUSizes []uint64
 
+	// How many layers of the draft model we can load, which are all loaded
+	// on the first GPU or not at all
+	DraftLayers int
+
 	// internal fields for logging purposes
 	inferenceLibrary    string
 	layersRequested     int
@@ -67,11 +71,30 @@ type MemoryEstimate struct {
 	graphPartialOffload uint64
 
 	projectorWeights, projectorGraph uint64
+	draftSize                        uint64
 }
 
 // Given a model and one or more GPU targets, predict how many layers and bytes we can load, and the total size
 // The GPUs provided must all be the same Library
-func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []string, opts api.Options, numParallel int) MemoryEstimate {
+func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []string, draft string, opts api.Options, numParallel int) MemoryEstimate {
+	if draft == "" {
+		return estimateGPULayers(gpus, f, projectors, draft, false, opts, numParallel)
+	}
+
+	// The draft only goes on the GPU if it takes no layers from the model,
+	// otherwise it runs on the CPU
+	estimate := estimateGPULayers(gpus, f, projectors, draft, true, opts, numParallel)
+	if withoutDraft := estimateGPULayers(gpus, f, projectors, draft, false, opts, numParallel); withoutDraft.Layers > estimate.Layers {
+		slog.Debug("draft model does not fit on the GPU with the model", "layers", withoutDraft.Layers, "with_draft", estimate.Layers)
+		return withoutDraft
+	}
+
+	return estimate
+}
+
+// estimateGPULayers is [EstimateGPULayers] with the draft model either
+// loaded on the first GPU or kept in system memory.
+func estimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []string, draft string, draftOnGPU bool, opts api.Options, numParallel int) MemoryEstimate {
 	// Graph size for a partial offload, applies to all GPUs
 	var graphPartialOffload uint64
 
@@ -141,6 +164,12 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 
 	kv, graphPartialOffload, graphFullOffload := f.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)), numParallel, kvct)
 
+	// The draft model, its cache and graph are loaded on the first GPU
+	var draftSize, draftBlocks uint64
+	if draft != "" {
+		draftSize, draftBlocks = draftMemoryRequirements(draft, opts, numParallel, kvct)
+	}
+
 	if len(kv) > 0 {
 		layerSize += kv[0]
 	}
@@ -176,6 +205,11 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	}
 
 	gpuZeroOverhead := llamaEngineProjectorWeights
+	if draftOnGPU {
+		gpuZeroOverhead += draftSize
+	} else {
+		overflow += draftSize
+	}
 
 	// Reduce set of GPUs to only those that have sufficient space to fit overhead and at least one layer
 	var layerCount int
@@ -215,9 +249,13 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	}
 
 	var gpuZeroID int
+	var draftLayers int
 	if len(gpusWithSpace) > 0 {
 		gpuZeroID = gpusWithSpace[0].i
 		gpuAllocations[gpuZeroID] += gpuZeroOverhead
+		if draftOnGPU {
+			draftLayers = int(draftBlocks)
+		}
 	} else {
 		overflow += gpuZeroOverhead
 	}
@@ -337,6 +375,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		graphPartialOffload: graphPartialOffload,
 		projectorWeights:    llamaEngineProjectorWeights + gooblaEngineProjectorWeights,
 		projectorGraph:      gooblaEngineProjectorGraph,
+		draftSize:           draftSize,
 	}
 
 	if gpus[0].Library == "cpu" {
@@ -352,6 +391,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	estimate.TotalSize = memoryRequiredTotal
 	estimate.TensorSplit = tensorSplit
 	estimate.GPUSizes = gpuAllocations
+	estimate.DraftLayers = draftLayers
 	return estimate
 }
 
@@ -412,6 +452,14 @@ func (m MemoryEstimate) LogValue() slog.Value {
 		))
 	}
 
+	if m.draftSize > 0 {
+		attrs = append(attrs, slog.Group(
+			"draft",
+			"layers", m.DraftLayers,
+			"size", format.HumanBytes2(m.draftSize),
+		))
+	}
+
 	return slog.GroupValue(attrs...)
 }
 
@@ -433,3 +481,24 @@ func projectorMemoryRequirements(filename string) (weights uint64) {
 
 	return weights
 }
+
+// draftMemoryRequirements returns the memory used by the draft model at
+// filename, including its cache and graph, along with its number of layers
+func draftMemoryRequirements(filename string, opts api.Options, numParallel int, kvCacheType string) (size, layers uint64) {
+	f, err := LoadModel(filename, 0)
+	if err != nil {
+		slog.Warn("failed to load draft model", "model", filename, "error", err)
+		return 0, 0
+	}
+
+	for _, layer := range f.Tensors().GroupLayers() {
+		size += layer.Size()
+	}
+
+	kv, _, graph := f.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)), numParallel, kvCacheType)
+	for _, kvLayer := range kv {
+		size += kvLayer
+	}
+
+	return size + graph, f.KV().BlockCount() + 1
+}
@@ -61,7 +61,7 @@ func TestEstimateGPULayers(t *testing.T) {
 	projectors := []string{}
 	opts := api.DefaultOptions()
 	t.Run("cpu", func(t *testing.T) {
-		estimate := EstimateGPULayers(gpus, ggml, projectors, opts, 1)
+		estimate := EstimateGPULayers(gpus, ggml, projectors, "", opts, 1)
 		assert.Equal(t, 0, estimate.Layers)
 		assert.Equal(t, uint64(0), estimate.Graph)
 	})
@@ -112,7 +112,7 @@ func TestEstimateGPULayers(t *testing.T) {
 			gpus[1].FreeMemory += gpuMinimumMemory + layerSize + s.layer1*layerSize + 1
 			gpus[0].FreeMemory += max(graphFullOffload, graphPartialOffload)
 			gpus[1].FreeMemory += max(graphFullOffload, graphPartialOffload)
-			estimate := EstimateGPULayers(gpus, ggml, projectors, opts, 1)
+			estimate := EstimateGPULayers(gpus, ggml, projectors, "", opts, 1)
 			assert.Equal(t, int(s.expect0+s.expect1), estimate.Layers, "scenario %d: %v", i, s)
 			assert.Equal(t, fmt.Sprintf("%d,%d", s.expect0, s.expect1), estimate.TensorSplit, "scenario %d: %v", i, s)
 			var layerSums uint64
@@ -128,4 +128,30 @@ func TestEstimateGPULayers(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("draft", func(t *testing.T) {
+		gpus[0].FreeMemory = 8 << 30
+		gpus[1].FreeMemory = 8 << 30
+
+		without := EstimateGPULayers(gpus, ggml, projectors, "", opts, 1)
+		with := EstimateGPULayers(gpus, ggml, projectors, f.Name(), opts, 1)
+
+		// the draft, which is the model itself here, is loaded whole on the first GPU
+		assert.Equal(t, inputLayerCount+1, with.DraftLayers)
+		assert.Equal(t, without.Layers, with.Layers)
+		assert.Greater(t, with.GPUSizes[0], without.GPUSizes[0])
+		assert.Equal(t, without.GPUSizes[1], with.GPUSizes[1])
+
+		// a draft that does not fit is loaded on the CPU, leaving the GPU to
+		// the model
+		gpus[0].FreeMemory = without.GPUSizes[0]
+		gpus[1].FreeMemory = 0
+		without = EstimateGPULayers(gpus, ggml, projectors, "", opts, 1)
+		with = EstimateGPULayers(gpus, ggml, projectors, f.Name(), opts, 1)
+		assert.Equal(t, 0, with.DraftLayers)
+		assert.Positive(t, with.Layers)
+		assert.Equal(t, without.Layers, with.Layers)
+		assert.Equal(t, without.GPUSizes, with.GPUSizes)
+		assert.Greater(t, with.TotalSize, without.TotalSize)
+	})
 }
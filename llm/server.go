@@ -30,9 +30,11 @@ import (
 	"github.com/goobla/goobla/envconfig"
 	"github.com/goobla/goobla/format"
 	"github.com/goobla/goobla/fs/ggml"
+	"github.com/goobla/goobla/grammar"
 	"github.com/goobla/goobla/llama"
 	"github.com/goobla/goobla/logutil"
 	"github.com/goobla/goobla/model"
+	"github.com/goobla/goobla/tracing"
 )
 
 type filteredEnv []string
@@ -69,6 +71,9 @@ type LlamaServer interface {
 	Embedding(ctx context.Context, input string) ([]float32, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
+	Pin(ctx context.Context, name, prompt string) (PinnedPrefix, error)
+	Unpin(ctx context.Context, name string) error
+	Pinned() []PinnedPrefix
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
@@ -103,6 +108,16 @@ type llmServer struct {
 	loadProgress float32
 
 	sem *semaphore.Weighted
+
+	// prefixes pinned in the runner's cache, each of which holds one unit
+	// of sem
+	pinMu  sync.Mutex
+	pinned map[string]pinnedPrefix
+}
+
+type pinnedPrefix struct {
+	PinnedPrefix
+	prompt string
 }
 
 // LoadModel will load a model from disk. The model must be in the GGML format.
@@ -127,7 +142,7 @@ func LoadModel(model string, maxArraySize int) (*ggml.GGML, error) {
 
 // NewLlamaServer will run a server for the given GPUs
 // The gpu list must be a single family.
-func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, adapters, projectors []string, opts api.Options, numParallel int) (LlamaServer, error) {
+func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, adapters, projectors []string, draft string, opts api.Options, numParallel int) (LlamaServer, error) {
 	systemInfo := discover.GetSystemInfo()
 	systemTotalMemory := systemInfo.System.TotalMemory
 	systemFreeMemory := systemInfo.System.FreeMemory
@@ -146,7 +161,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		opts.NumCtx = int(trainCtx) * numParallel
 	}
 
-	estimate := EstimateGPULayers(gpus, f, projectors, opts, numParallel)
+	estimate := EstimateGPULayers(gpus, f, projectors, draft, opts, numParallel)
 	if len(gpus) > 1 || gpus[0].Library != "cpu" {
 		switch {
 		case gpus[0].Library == "metal" && estimate.VRAMSize > systemTotalMemory:
@@ -316,6 +331,14 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		params = append(params, "--mmproj", projectors[0])
 	}
 
+	if draft != "" {
+		if textProcessor == nil {
+			slog.Warn("draft models are not supported by this model, generating without one", "draft", draft)
+		} else {
+			params = append(params, "--draft", draft, "--draft-n-gpu-layers", strconv.Itoa(estimate.DraftLayers), "--draft-tokens", strconv.Itoa(opts.NumDraft))
+		}
+	}
+
 	// iterate through compatible GPU libraries such as 'cuda_v12', 'rocm', etc.
 	// adding each library's respective path to the LD_LIBRARY_PATH, until finally running
 	// without any LD_LIBRARY_PATH flags
@@ -688,12 +711,28 @@ type ImageData struct {
 	ID   int    `json:"id"`
 }
 
+// AudioData is an audio clip referred to by an [audio-<ID>] tag in a prompt.
+type AudioData struct {
+	Data []byte `json:"data"`
+	ID   int    `json:"id"`
+}
+
 type CompletionRequest struct {
 	Prompt  string
 	Format  json.RawMessage
 	Images  []ImageData
+	Audios  []AudioData
 	Options *api.Options
 
+	// Logprobs requests the log probability of each generated token along
+	// with its TopLogprobs most likely alternatives
+	Logprobs    bool
+	TopLogprobs int
+
+	// N is the number of completions to generate from the prompt, which
+	// are streamed interleaved and identified by CompletionResponse.Index
+	N int
+
 	Grammar string // set before sending the request to the subprocess
 }
 
@@ -721,6 +760,7 @@ func (d DoneReason) String() string {
 }
 
 type CompletionResponse struct {
+	Index              int           `json:"index,omitempty"`
 	Content            string        `json:"content"`
 	DoneReason         DoneReason    `json:"done_reason"`
 	Done               bool          `json:"done"`
@@ -728,12 +768,25 @@ type CompletionResponse struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
 	EvalCount          int           `json:"eval_count"`
 	EvalDuration       time.Duration `json:"eval_duration"`
+	DraftCount         int           `json:"draft_count,omitempty"`
+	DraftAcceptedCount int           `json:"draft_accepted_count,omitempty"`
+	Logprobs           []api.Logprob `json:"logprobs,omitempty"`
 }
 
-func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
-	slog.Debug("completion request", "images", len(req.Images), "prompt", len(req.Prompt), "format", string(req.Format))
+func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) (err error) {
+	slog.Debug("completion request", "images", len(req.Images), "audios", len(req.Audios), "prompt", len(req.Prompt), "format", string(req.Format))
 	slog.Log(ctx, logutil.LevelTrace, "completion request", "prompt", req.Prompt)
 
+	ctx, span := tracing.Start(ctx, "llm.completion")
+	span.SetKind(tracing.KindClient)
+	span.SetAttributes("images", len(req.Images), "audios", len(req.Audios), "n", max(req.N, 1))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	// schema is the JSON schema each completion is validated against
+	var schema json.RawMessage
 	if len(req.Format) > 0 {
 		switch string(req.Format) {
 		case `null`, `""`:
@@ -747,12 +800,15 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object", req.Format)
 			}
 
-			// User provided a JSON schema
-			g := llama.SchemaToGrammar(req.Format)
-			if g == nil {
-				return fmt.Errorf("invalid JSON schema in format")
+			// User provided a JSON schema, a regex or a grammar
+			g, isSchema, err := grammar.FromFormat(req.Format)
+			if err != nil {
+				return fmt.Errorf("invalid format: %w", err)
+			}
+			req.Grammar = g
+			if isSchema {
+				schema = req.Format
 			}
-			req.Grammar = string(g)
 		}
 	}
 
@@ -769,7 +825,16 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		}
 		return err
 	}
-	defer s.sem.Release(1)
+
+	// each completion generated at once takes one of the runner's
+	// sequences, so it holds a unit of sem. Units beyond the first are
+	// only taken if they are free, to not hold back other requests.
+	n := max(req.N, 1)
+	size := 1
+	for size < min(n, s.maxCompletions()) && s.sem.TryAcquire(1) {
+		size++
+	}
+	defer s.sem.Release(int64(size))
 
 	// put an upper limit on num_predict to avoid the model running on forever
 	if req.Options.NumPredict < 0 || req.Options.NumPredict > 10*s.options.NumCtx {
@@ -784,51 +849,100 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("unexpected server status: %s", status)
 	}
 
+	// the rest of the completions are generated in groups one after
+	// another, with the seeds they would have had in a single group
+	var promptEvalCount, evalCount int
+	for first := 0; first < n; first += size {
+		group := req
+		group.N = min(size, n-first)
+		if first > 0 && req.Options.Seed != -1 {
+			opts := *req.Options
+			opts.Seed += first
+			group.Options = &opts
+		}
+
+		p, e, err := s.completion(ctx, group, schema, func(c CompletionResponse) {
+			c.Index += first
+			fn(c)
+		})
+		if err != nil {
+			return err
+		}
+		promptEvalCount += p
+		evalCount += e
+	}
+
+	span.SetAttributes("prompt_eval_count", promptEvalCount, "eval_count", evalCount)
+	return nil
+}
+
+// maxCompletions returns how many completions of a request the runner can
+// generate at once: one for each sequence not taken by a pinned prefix, or
+// only one for the llama.cpp runner.
+func (s *llmServer) maxCompletions() int {
+	if s.llamaModel != nil {
+		return 1
+	}
+
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	return max(s.numParallel-len(s.pinned), 1)
+}
+
+// completion generates the req.N completions of req in the runner, returning
+// the number of prompt tokens evaluated and tokens generated. Outputs that
+// stop are validated against schema if it is set.
+func (s *llmServer) completion(ctx context.Context, req CompletionRequest, schema json.RawMessage, fn func(CompletionResponse)) (promptEvalCount, evalCount int, err error) {
 	// Handling JSON marshaling with special characters unescaped.
 	buffer := &bytes.Buffer{}
 	enc := json.NewEncoder(buffer)
 	enc.SetEscapeHTML(false)
 
 	if err := enc.Encode(req); err != nil {
-		return fmt.Errorf("failed to marshal data: %v", err)
+		return 0, 0, fmt.Errorf("failed to marshal data: %v", err)
 	}
 
 	endpoint := fmt.Sprintf("http://127.0.0.1:%d/completion", s.port)
 	serverReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, buffer)
 	if err != nil {
-		return fmt.Errorf("error creating POST request: %v", err)
+		return 0, 0, fmt.Errorf("error creating POST request: %v", err)
 	}
 	serverReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, serverReq.Header)
 
 	res, err := http.DefaultClient.Do(serverReq)
 	if err != nil {
 		slog.Error("post predict", "error", err)
-		return errors.New("model runner has unexpectedly stopped, this may be due to resource limitations or an internal error, check goobla server logs for details")
+		return 0, 0, errors.New("model runner has unexpectedly stopped, this may be due to resource limitations or an internal error, check goobla server logs for details")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode >= 400 {
 		bodyBytes, err := io.ReadAll(res.Body)
 		if err != nil {
-			return fmt.Errorf("failed reading llm error response: %w", err)
+			return 0, 0, fmt.Errorf("failed reading llm error response: %w", err)
 		}
 		log.Printf("llm predict error: %s", bodyBytes)
-		return fmt.Errorf("%s", bodyBytes)
+		return 0, 0, fmt.Errorf("%s", bodyBytes)
 	}
 
 	scanner := bufio.NewScanner(res.Body)
 	buf := make([]byte, 0, maxBufferSize)
 	scanner.Buffer(buf, maxBufferSize)
 
-	// keep track of the last token generated, this is used to abort if the model starts looping
-	var lastToken string
-	var tokenRepeat int
+	// keep track of the last token generated for each completion, this is
+	// used to abort if the model starts looping
+	n := max(req.N, 1)
+	lastToken := make([]string, n)
+	tokenRepeat := make([]int, n)
+	content := make([]strings.Builder, n)
+	remaining := n
 
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
 			// This handles the request cancellation
-			return ctx.Err()
+			return 0, 0, ctx.Err()
 		default:
 			line := scanner.Bytes()
 			if len(line) == 0 {
@@ -842,31 +956,54 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 			var c CompletionResponse
 			if err := json.Unmarshal(evt, &c); err != nil {
-				return fmt.Errorf("error unmarshalling llm prediction response: %v", err)
+				return 0, 0, fmt.Errorf("error unmarshalling llm prediction response: %v", err)
 			}
+			if c.Index < 0 || c.Index >= n {
+				return 0, 0, fmt.Errorf("unexpected completion index %d", c.Index)
+			}
+
 			switch {
-			case strings.TrimSpace(c.Content) == lastToken:
-				tokenRepeat++
+			case strings.TrimSpace(c.Content) == lastToken[c.Index]:
+				tokenRepeat[c.Index]++
 			default:
-				lastToken = strings.TrimSpace(c.Content)
-				tokenRepeat = 0
+				lastToken[c.Index] = strings.TrimSpace(c.Content)
+				tokenRepeat[c.Index] = 0
 			}
 
 			// 30 picked as an arbitrary max token repeat limit, modify as needed
-			if tokenRepeat > 30 {
+			if tokenRepeat[c.Index] > 30 {
 				slog.Debug("prediction aborted, token repeat limit reached")
-				return ctx.Err()
+				return 0, 0, ctx.Err()
+			}
+
+			if schema != nil {
+				content[c.Index].WriteString(c.Content)
 			}
 
-			if c.Content != "" {
+			// only complete outputs can match the schema, and the content
+			// has already been streamed, so a mismatch is reported as an
+			// error in place of the final response
+			if c.Done && c.DoneReason == DoneReasonStop && schema != nil {
+				if err := grammar.ValidateJSONSchema(schema, []byte(content[c.Index].String())); err != nil {
+					return 0, 0, fmt.Errorf("output does not match format schema: %w", err)
+				}
+			}
+
+			if c.Content != "" || len(c.Logprobs) > 0 {
 				fn(CompletionResponse{
-					Content: c.Content,
+					Index:    c.Index,
+					Content:  c.Content,
+					Logprobs: c.Logprobs,
 				})
 			}
 
 			if c.Done {
 				fn(c)
-				return nil
+				evalCount += c.EvalCount
+				remaining--
+				if remaining == 0 {
+					return c.PromptEvalCount, evalCount, nil
+				}
 			}
 		}
 	}
@@ -880,13 +1017,13 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			} else {
 				msg = err.Error()
 			}
-			return fmt.Errorf("an error was encountered while running the model: %s", msg)
+			return 0, 0, fmt.Errorf("an error was encountered while running the model: %s", msg)
 		}
 
-		return fmt.Errorf("error reading llm response: %v", err)
+		return 0, 0, fmt.Errorf("error reading llm response: %v", err)
 	}
 
-	return nil
+	return 0, evalCount, nil
 }
 
 type EmbeddingRequest struct {
@@ -983,6 +1120,20 @@ func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error)
 	return nil, fmt.Errorf("no tokenizer configured")
 }
 
+// PinRequest asks a runner to evaluate Prompt and keep its cache pinned as
+// the prefix Name. Only Name is used when unpinning.
+type PinRequest struct {
+	Name   string `json:"name"`
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// PinnedPrefix is a prompt prefix pinned in a runner's cache
+type PinnedPrefix struct {
+	Name   string `json:"name"`
+	Tokens int    `json:"tokens"`
+	Size   uint64 `json:"size"`
+}
+
 type DetokenizeRequest struct {
 	Tokens []int `json:"tokens"`
 }
@@ -1017,6 +1168,158 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	return "", fmt.Errorf("no tokenizer configured")
 }
 
+// Pin evaluates prompt and keeps it pinned in the runner's cache as the
+// prefix name, so prompts starting with it only evaluate what follows. Pinning
+// the same prompt again returns the existing prefix.
+func (s *llmServer) Pin(ctx context.Context, name, prompt string) (PinnedPrefix, error) {
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return PinnedPrefix{}, err
+	} else if status != ServerStatusReady {
+		return PinnedPrefix{}, fmt.Errorf("unexpected server status: %s", status)
+	}
+
+	// A pinned prefix permanently takes one of the runner's sequences. Wait
+	// for it without holding pinMu, which would otherwise block unpinning
+	// and listing prefixes until a running request finishes.
+	var acquired bool
+	s.pinMu.Lock()
+	for {
+		if _, ok := s.pinned[name]; ok || acquired {
+			break
+		}
+		s.pinMu.Unlock()
+
+		if err := s.sem.Acquire(ctx, 1); err != nil {
+			return PinnedPrefix{}, err
+		}
+		acquired = true
+
+		s.pinMu.Lock()
+	}
+	defer s.pinMu.Unlock()
+
+	old, repin := s.pinned[name]
+	if repin && acquired {
+		// pinned by another request while waiting, which holds its own
+		s.sem.Release(1)
+		acquired = false
+	}
+
+	if repin && old.prompt == prompt {
+		return old.PinnedPrefix, nil
+	}
+
+	var p PinnedPrefix
+	if err := s.pinRequest(ctx, http.MethodPost, PinRequest{Name: name, Prompt: prompt}, &p); err != nil {
+		if acquired {
+			s.sem.Release(1)
+		}
+		return PinnedPrefix{}, err
+	}
+
+	if s.pinned == nil {
+		s.pinned = make(map[string]pinnedPrefix)
+	}
+	s.pinned[name] = pinnedPrefix{p, prompt}
+
+	return p, nil
+}
+
+// Unpin releases the prefix name from the runner's cache
+func (s *llmServer) Unpin(ctx context.Context, name string) error {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	if _, ok := s.pinned[name]; !ok {
+		return nil
+	}
+
+	if err := s.pinRequest(ctx, http.MethodDelete, PinRequest{Name: name}, nil); err != nil {
+		return err
+	}
+
+	delete(s.pinned, name)
+	s.sem.Release(1)
+
+	return nil
+}
+
+// Pinned returns the prefixes pinned in the runner's cache, by name
+func (s *llmServer) Pinned() []PinnedPrefix {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	pinned := make([]PinnedPrefix, 0, len(s.pinned))
+	for _, p := range s.pinned {
+		pinned = append(pinned, p.PinnedPrefix)
+	}
+	slices.SortFunc(pinned, func(a, b PinnedPrefix) int { return strings.Compare(a.Name, b.Name) })
+	return pinned
+}
+
+func (s *llmServer) pinRequest(ctx context.Context, method string, req PinRequest, resp *PinnedPrefix) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://127.0.0.1:%d/prefix", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("error creating prefix request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("do prefix request: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading prefix response: %w", err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound && method == http.MethodPost:
+		return errors.New("model runner does not support pinned prefixes")
+	case res.StatusCode == http.StatusNotFound:
+		// the runner has nothing to release
+		return nil
+	case res.StatusCode >= 400:
+		return fmt.Errorf("%s", bytes.TrimSpace(body))
+	case resp != nil:
+		return json.Unmarshal(body, resp)
+	}
+
+	return nil
+}
+
+// promptCacheSaveTimeout bounds how long stopping a runner waits for it to
+// save its prompt cache.
+const promptCacheSaveTimeout = 30 * time.Second
+
+// saveCache asks the runner to write its prompt cache to disk so that it
+// can be restored when the model is loaded again.
+func (s *llmServer) saveCache() {
+	ctx, cancel := context.WithTimeout(context.Background(), promptCacheSaveTimeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/cache/save", s.port), nil)
+	if err != nil {
+		slog.Debug("failed to save prompt cache", "error", err)
+		return
+	}
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		slog.Debug("failed to save prompt cache", "error", err)
+		return
+	}
+	res.Body.Close()
+}
+
 func (s *llmServer) Close() error {
 	s.llamaModelLock.Lock()
 	if s.llamaModel != nil {
@@ -1026,6 +1329,10 @@ func (s *llmServer) Close() error {
 	s.llamaModelLock.Unlock()
 
 	if s.cmd != nil {
+		if s.textProcessor != nil && envconfig.PromptCacheSize() > 0 {
+			s.saveCache()
+		}
+
 		slog.Debug("stopping llama server", "pid", s.Pid())
 		if err := s.cmd.Process.Kill(); err != nil {
 			return err
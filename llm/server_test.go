@@ -2,10 +2,18 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/goobla/goobla/api"
 	"golang.org/x/sync/semaphore"
@@ -55,6 +63,10 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		// JSON
 		`"json"`,
 		`{"type":"object"}`,
+
+		// regex and grammar
+		`{"type":"regex","pattern":"[0-9]{3}"}`,
+		`{"type":"gbnf","grammar":"root ::= \"yes\" | \"no\""}`,
 	}
 	for _, valid := range valids {
 		err := s.Completion(ctx, CompletionRequest{
@@ -70,3 +82,190 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 	}, nil)
 	checkValid(err)
 }
+
+func TestPinWaitsWithoutLock(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	health := make(chan struct{}, 10)
+	runner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			json.NewEncoder(w).Encode(ServerStatusResponse{Status: ServerStatusReady}) //nolint:errcheck
+			health <- struct{}{}
+		case "/prefix":
+			var req PinRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if r.Method == http.MethodDelete {
+				mu.Lock()
+				deleted = append(deleted, req.Name)
+				mu.Unlock()
+				return
+			}
+			json.NewEncoder(w).Encode(PinnedPrefix{Name: req.Name, Tokens: len(req.Prompt)}) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer runner.Close()
+
+	port, err := strconv.Atoi(runner.URL[strings.LastIndex(runner.URL, ":")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &llmServer{
+		port: port,
+		cmd:  &exec.Cmd{},
+		sem:  semaphore.NewWeighted(2),
+	}
+
+	if _, err := s.Pin(t.Context(), "a", "prompt a"); err != nil {
+		t.Fatal(err)
+	}
+	<-health
+
+	// a request takes the other sequence, so pinning b waits for it
+	if err := s.sem.Acquire(t.Context(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Pin(t.Context(), "b", "prompt b")
+		done <- err
+	}()
+
+	// give pinning b time to start waiting once the runner is ready
+	<-health
+	time.Sleep(50 * time.Millisecond)
+
+	// listing and unpinning prefixes don't wait for the request
+	unpinned := make(chan error, 1)
+	go func() {
+		if pinned := s.Pinned(); len(pinned) != 1 || pinned[0].Name != "a" {
+			unpinned <- fmt.Errorf("pinned = %v, want a", pinned)
+			return
+		}
+		unpinned <- s.Unpin(t.Context(), "a")
+	}()
+
+	select {
+	case err := <-unpinned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("unpinning blocked on a pin waiting for a sequence")
+	}
+
+	// unpinning a released its sequence to b
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pin did not get the sequence released by unpinning")
+	}
+
+	s.sem.Release(1)
+	if pinned := s.Pinned(); len(pinned) != 1 || pinned[0].Name != "b" {
+		t.Errorf("pinned = %v, want b", pinned)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(deleted, []string{"a"}) {
+		t.Errorf("deleted = %v, want [a]", deleted)
+	}
+
+	// b holds one sequence, leaving the other free
+	if !s.sem.TryAcquire(1) || s.sem.TryAcquire(1) {
+		t.Error("expected exactly one free sequence")
+	}
+}
+
+func TestCompletionGroups(t *testing.T) {
+	var mu sync.Mutex
+	var groups []int
+	runner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			json.NewEncoder(w).Encode(ServerStatusResponse{Status: ServerStatusReady}) //nolint:errcheck
+		case "/completion":
+			var req CompletionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			groups = append(groups, req.N)
+			mu.Unlock()
+
+			// each completion returns the seed it was sampled with, as
+			// the runner offsets the seed by the index
+			enc := json.NewEncoder(w)
+			for i := range req.N {
+				enc.Encode(CompletionResponse{Index: i, Content: strconv.Itoa(req.Options.Seed + i)}) //nolint:errcheck
+			}
+			for i := range req.N {
+				enc.Encode(CompletionResponse{Index: i, Done: true}) //nolint:errcheck
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer runner.Close()
+
+	port, err := strconv.Atoi(runner.URL[strings.LastIndex(runner.URL, ":")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one of the three sequences is taken by a pinned prefix
+	s := &llmServer{
+		port:        port,
+		cmd:         &exec.Cmd{},
+		numParallel: 3,
+		sem:         semaphore.NewWeighted(3),
+		pinned:      map[string]pinnedPrefix{"prefix": {}},
+	}
+
+	content := make(map[int]string)
+	err = s.Completion(t.Context(), CompletionRequest{
+		Options: &api.Options{Seed: 10},
+		N:       5,
+	}, func(r CompletionResponse) {
+		content[r.Index] += r.Content
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(groups, []int{2, 2, 1}) {
+		t.Errorf("groups = %v, want [2 2 1]", groups)
+	}
+	for i := range 5 {
+		if want := strconv.Itoa(10 + i); content[i] != want {
+			t.Errorf("completion %d has seed %q, want %s", i, content[i], want)
+		}
+	}
+
+	// sequences taken by other requests are not used
+	if !s.sem.TryAcquire(2) {
+		t.Fatal("expected the sequences of the request to be released")
+	}
+	defer s.sem.Release(2)
+
+	groups = nil
+	if err := s.Completion(t.Context(), CompletionRequest{Options: &api.Options{Seed: 10}, N: 2}, func(CompletionResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(groups, []int{1, 1}) {
+		t.Errorf("groups = %v, want [1 1]", groups)
+	}
+}
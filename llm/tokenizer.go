@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/goobla/goobla/llama"
+	"github.com/goobla/goobla/model"
+)
+
+// Tokenizer converts between text and token ids using only a model's
+// vocabulary, without loading its weights onto a device.
+type Tokenizer interface {
+	Tokenize(ctx context.Context, content string) ([]int, error)
+	Detokenize(ctx context.Context, tokens []int) (string, error)
+	Close() error
+}
+
+// NewTokenizer loads the vocabulary of the model at modelPath. The Goobla
+// engine's [model.TextProcessor] is preferred and the llama.cpp vocabulary is
+// used for architectures it does not yet support.
+func NewTokenizer(modelPath string) (Tokenizer, error) {
+	textProcessor, err := model.NewTextProcessor(modelPath)
+	if err == nil {
+		return &textProcessorTokenizer{textProcessor: textProcessor}, nil
+	}
+	slog.Debug("model not yet supported by Goobla engine, loading llama vocabulary", "model", modelPath, "error", err)
+
+	llamaModel, err := llama.LoadModelFromFile(modelPath, llama.ModelParams{VocabOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &llamaTokenizer{llamaModel: llamaModel}, nil
+}
+
+type textProcessorTokenizer struct {
+	textProcessor model.TextProcessor
+}
+
+func (t *textProcessorTokenizer) Tokenize(_ context.Context, content string) ([]int, error) {
+	tokens, err := t.textProcessor.Encode(content, false)
+	if err != nil {
+		return nil, err
+	}
+
+	toks := make([]int, len(tokens))
+	for i, t := range tokens {
+		toks[i] = int(t)
+	}
+	return toks, nil
+}
+
+func (t *textProcessorTokenizer) Detokenize(_ context.Context, tokens []int) (string, error) {
+	toks := make([]int32, len(tokens))
+	for i, t := range tokens {
+		toks[i] = int32(t)
+	}
+	return t.textProcessor.Decode(toks)
+}
+
+func (t *textProcessorTokenizer) Close() error {
+	return nil
+}
+
+type llamaTokenizer struct {
+	mu         sync.Mutex
+	llamaModel *llama.Model
+}
+
+func (t *llamaTokenizer) Tokenize(_ context.Context, content string) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.llamaModel.Tokenize(content, false, true)
+}
+
+func (t *llamaTokenizer) Detokenize(_ context.Context, tokens []int) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var resp string
+	for _, token := range tokens {
+		resp += t.llamaModel.TokenToPiece(token)
+	}
+	return resp, nil
+}
+
+func (t *llamaTokenizer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.llamaModel != nil {
+		llama.FreeModel(t.llamaModel)
+		t.llamaModel = nil
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+// Package metrics implements a minimal registry of counters, gauges and
+// histograms that can be exposed in the Prometheus text exposition format.
+//
+// Every metric may declare a fixed set of label names. Methods that record a
+// value take the label values, in the same order, as trailing arguments.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram buckets suited to request latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry is a collection of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+type metric interface {
+	desc() *desc
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := m.desc().name
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec[*float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec[*float64](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// in increasing order, and label names. The +Inf bucket is implicit.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %q are not sorted", name))
+	}
+	h := &Histogram{vec: newVec[*histogramValue](name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		d := m.desc()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escape(d.help, false))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+		m.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	name, help, typ string
+	labels          []string
+}
+
+// vec holds the values of a metric for each combination of label values.
+type vec[T any] struct {
+	d desc
+
+	mu     sync.Mutex
+	values map[string]T
+	keys   map[string][]string
+}
+
+func newVec[T any](name, help, typ string, labels []string) vec[T] {
+	return vec[T]{
+		d:      desc{name: name, help: help, typ: typ, labels: labels},
+		values: make(map[string]T),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) desc() *desc {
+	return &v.d
+}
+
+// get returns the value for the label values, creating it with fn if it does
+// not exist. v.mu must be held.
+func (v *vec[T]) get(labels []string, fn func() T) T {
+	if len(labels) != len(v.d.labels) {
+		panic(fmt.Sprintf("metrics: %q has %d labels, got %d", v.d.name, len(v.d.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+	t, ok := v.values[key]
+	if !ok {
+		t = fn()
+		v.values[key] = t
+		v.keys[key] = slices.Clone(labels)
+	}
+	return t
+}
+
+// value returns the value for the label values, or zero if there is none.
+func (v *vec[T]) value(labels []string) (t T) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[strings.Join(labels, "\xff")]
+}
+
+// each calls fn with the label values and value of every series, ordered by
+// label values. v.mu must be held.
+func (v *vec[T]) each(fn func(labels []string, t T)) {
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		fn(v.keys[k], v.values[k])
+	}
+}
+
+// Reset removes all series.
+func (v *vec[T]) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	clear(v.values)
+	clear(v.keys)
+}
+
+func newFloat() *float64 {
+	return new(float64)
+}
+
+// Counter is a value that only increases.
+type Counter struct {
+	vec[*float64]
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add increases the counter by n, which must not be negative.
+func (c *Counter) Add(n float64, labels ...string) {
+	if n < 0 {
+		panic(fmt.Sprintf("metrics: counter %q cannot decrease", c.d.name))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labels, newFloat) += n
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value(labels ...string) float64 {
+	if v := c.value(labels); v != nil {
+		return *v
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.each(func(labels []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.d.name, formatLabels(c.d.labels, labels), formatFloat(*v))
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	vec[*float64]
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labels, newFloat) = v
+}
+
+// Add adds n, which may be negative, to the gauge.
+func (g *Gauge) Add(n float64, labels ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labels, newFloat) += n
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value(labels ...string) float64 {
+	if v := g.value(labels); v != nil {
+		return *v
+	}
+	return 0
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.each(func(labels []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.d.name, formatLabels(g.d.labels, labels), formatFloat(*v))
+	})
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	vec[*histogramValue]
+	buckets []float64
+}
+
+type histogramValue struct {
+	// counts holds the number of observations in each bucket, not
+	// cumulative, with the last element for the +Inf bucket
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hv := h.get(labels, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets)+1)}
+	})
+
+	i, _ := slices.BinarySearch(h.buckets, v)
+	hv.counts[i]++
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bucketNames := append(slices.Clone(h.d.labels), "le")
+	h.each(func(labels []string, hv *histogramValue) {
+		var cumulative uint64
+		for i, n := range hv.counts {
+			cumulative += n
+
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+
+			bucketLabels := append(slices.Clone(labels), formatFloat(le))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, formatLabels(bucketNames, bucketLabels), cumulative)
+		}
+
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.d.name, formatLabels(h.d.labels, labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.d.name, formatLabels(h.d.labels, labels), hv.count)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escape(values[i], true))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func escape(s string, quotes bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quotes {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
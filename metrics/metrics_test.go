@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("test_requests_total", "Requests served.", "path", "code")
+	requests.Inc("/api/chat", "200")
+	requests.Inc("/api/chat", "200")
+	requests.Add(3, "/api/generate", "500")
+
+	queue := r.NewGauge("test_queue_depth", "Queued requests.")
+	queue.Set(4)
+	queue.Add(-1)
+
+	latency := r.NewHistogram("test_latency_seconds", "Request latency\nin seconds.", []float64{0.1, 1}, "model")
+	latency.Observe(0.05, `a"b`)
+	latency.Observe(0.1, `a"b`)
+	latency.Observe(0.5, `a"b`)
+	latency.Observe(7, `a"b`)
+
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{path="/api/chat",code="200"} 2
+test_requests_total{path="/api/generate",code="500"} 3
+# HELP test_queue_depth Queued requests.
+# TYPE test_queue_depth gauge
+test_queue_depth 3
+# HELP test_latency_seconds Request latency\nin seconds.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{model="a\"b",le="0.1"} 2
+test_latency_seconds_bucket{model="a\"b",le="1"} 3
+test_latency_seconds_bucket{model="a\"b",le="+Inf"} 4
+test_latency_seconds_sum{model="a\"b"} 7.65
+test_latency_seconds_count{model="a\"b"} 4
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if v := requests.Value("/api/chat", "200"); v != 2 {
+		t.Errorf("counter value: got %v, want 2", v)
+	}
+
+	requests.Reset()
+	if v := requests.Value("/api/chat", "200"); v != 0 {
+		t.Errorf("counter value after reset: got %v, want 0", v)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	cases := map[string]func(r *Registry){
+		"duplicate": func(r *Registry) {
+			r.NewCounter("dup", "")
+			r.NewGauge("dup", "")
+		},
+		"label count": func(r *Registry) {
+			r.NewCounter("c", "", "a").Inc()
+		},
+		"negative counter": func(r *Registry) {
+			r.NewCounter("c", "").Add(-1)
+		},
+		"unsorted buckets": func(r *Registry) {
+			r.NewHistogram("h", "", []float64{1, 0.5})
+		},
+	}
+
+	for name, fn := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			fn(NewRegistry())
+		})
+	}
+}
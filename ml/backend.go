@@ -218,6 +218,10 @@ type Context interface {
 	FromFloatSlice(s []float32, shape ...int) Tensor
 	FromIntSlice(s []int32, shape ...int) Tensor
 
+	// FromBytes creates a tensor of dtype from its raw data, such as the
+	// output of [Tensor.Bytes]
+	FromBytes(dtype DType, s []byte, shape ...int) Tensor
+
 	// Arange creates a 1D tensor with values within an interval (start, stop] increased by step.
 	Arange(start, stop, step float32, dtype DType) Tensor
 
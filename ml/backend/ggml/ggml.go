@@ -780,6 +780,22 @@ func (c *Context) FromIntSlice(s []int32, shape ...int) ml.Tensor {
 	return t
 }
 
+func (c *Context) FromBytes(dtype ml.DType, s []byte, shape ...int) ml.Tensor {
+	t := c.newTensor(dtype, shape)
+
+	// the size of quantized types isn't a whole number of bytes per element,
+	// so check it against the tensor rather than the shape
+	if n := int(C.ggml_nbytes(t.(*Tensor).t)); len(s) != n {
+		panic(fmt.Errorf("invalid size: %v bytes for a tensor of %v", len(s), n))
+	}
+
+	if len(s) > 0 {
+		C.ggml_backend_tensor_set(t.(*Tensor).t, unsafe.Pointer(&s[0]), 0, C.ggml_nbytes(t.(*Tensor).t))
+	}
+
+	return t
+}
+
 func (c Context) Arange(start, stop, step float32, dtype ml.DType) ml.Tensor {
 	switch dtype {
 	case ml.DTypeF32:
@@ -0,0 +1,163 @@
+// Package audioproc decodes audio and computes the log-mel spectrograms
+// audio encoders take as input.
+package audioproc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SampleRate is the rate most audio encoders, such as Whisper's, expect,
+// and the rate of raw PCM input declared as pcm16.
+const SampleRate = 16000
+
+// WAV wraps raw 16-bit little-endian mono PCM sampled at rate in a WAV file,
+// so that audio declared as raw PCM can be passed to [Decode].
+func WAV(pcm []byte, rate int) ([]byte, error) {
+	if len(pcm)%2 != 0 {
+		return nil, errors.New("invalid PCM audio: odd number of bytes")
+	}
+
+	b := make([]byte, 44, 44+len(pcm))
+	copy(b, "RIFF")
+	binary.LittleEndian.PutUint32(b[4:], uint32(36+len(pcm)))
+	copy(b[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(b[16:], 16)
+	binary.LittleEndian.PutUint16(b[20:], formatPCM)
+	binary.LittleEndian.PutUint16(b[22:], 1)
+	binary.LittleEndian.PutUint32(b[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(b[28:], uint32(2*rate))
+	binary.LittleEndian.PutUint16(b[32:], 2)
+	binary.LittleEndian.PutUint16(b[34:], 16)
+	copy(b[36:], "data")
+	binary.LittleEndian.PutUint32(b[40:], uint32(len(pcm)))
+	return append(b, pcm...), nil
+}
+
+const (
+	formatPCM        = 1
+	formatFloat      = 3
+	formatExtensible = 0xfffe
+)
+
+// Decode decodes a WAV file into mono samples in [-1, 1], returning them
+// with their sample rate. Channels are averaged. Raw PCM has to be wrapped
+// with [WAV] first, as it cannot be told apart from other data.
+func Decode(data []byte) ([]float32, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("unsupported audio: expected a WAV file")
+	}
+
+	var (
+		format, channels, bits int
+		rate                   int
+		pcm                    []byte
+	)
+
+	for p := 12; p+8 <= len(data); {
+		id := string(data[p : p+4])
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		p += 8
+		if size > len(data)-p {
+			// truncated files are common when streaming, use what is there
+			size = len(data) - p
+		}
+		chunk := data[p : p+size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("invalid WAV audio: short fmt chunk")
+			}
+			format = int(binary.LittleEndian.Uint16(chunk))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			rate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:]))
+			if format == formatExtensible && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(chunk[24:]))
+			}
+		case "data":
+			pcm = chunk
+		}
+
+		// chunks are padded to an even size
+		p += size + size%2
+	}
+
+	switch {
+	case format == 0:
+		return nil, 0, errors.New("invalid WAV audio: missing fmt chunk")
+	case pcm == nil:
+		return nil, 0, errors.New("invalid WAV audio: missing data chunk")
+	case channels < 1 || rate < 1:
+		return nil, 0, fmt.Errorf("invalid WAV audio: %d channels at %d Hz", channels, rate)
+	}
+
+	var sample func([]byte) float32
+	switch {
+	case format == formatPCM && bits == 8:
+		sample = func(b []byte) float32 { return (float32(b[0]) - 128) / 128 }
+	case format == formatPCM && bits == 16:
+		sample = func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format == formatPCM && bits == 24:
+		sample = func(b []byte) float32 {
+			return float32(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == formatPCM && bits == 32:
+		sample = func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == formatFloat && bits == 32:
+		sample = func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }
+	default:
+		return nil, 0, fmt.Errorf("unsupported WAV audio: format %d with %d bits per sample", format, bits)
+	}
+
+	width := bits / 8
+	frame := width * channels
+	samples := make([]float32, len(pcm)/frame)
+	for i := range samples {
+		var sum float32
+		for c := range channels {
+			sum += sample(pcm[i*frame+c*width:])
+		}
+		samples[i] = sum / float32(channels)
+	}
+
+	return samples, rate, nil
+}
+
+// Resample converts samples from one sample rate to another with linear
+// interpolation.
+func Resample(samples []float32, from, to int) []float32 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+
+	n := int(int64(len(samples)) * int64(to) / int64(from))
+	out := make([]float32, n)
+	step := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		if j+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+
+		frac := float32(pos - float64(j))
+		out[i] = samples[j]*(1-frac) + samples[j+1]*frac
+	}
+	return out
+}
+
+// PadOrTrim returns samples zero-padded or truncated to n samples.
+func PadOrTrim(samples []float32, n int) []float32 {
+	if len(samples) >= n {
+		return samples[:n]
+	}
+
+	out := make([]float32, n)
+	copy(out, samples)
+	return out
+}
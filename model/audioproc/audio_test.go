@@ -0,0 +1,201 @@
+package audioproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+	"math/rand/v2"
+	"testing"
+)
+
+// wav encodes interleaved 16-bit samples as a WAV file.
+func wav(t *testing.T, rate, channels int, samples []int16) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	write := func(v any) {
+		if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.WriteString("RIFF")
+	write(uint32(36 + 2*len(samples)))
+	b.WriteString("WAVE")
+
+	b.WriteString("fmt ")
+	write(uint32(16))
+	write(uint16(formatPCM))
+	write(uint16(channels))
+	write(uint32(rate))
+	write(uint32(rate * channels * 2))
+	write(uint16(channels * 2))
+	write(uint16(16))
+
+	// chunks other than fmt and data are skipped
+	b.WriteString("LIST")
+	write(uint32(3))
+	b.Write([]byte{1, 2, 3, 0})
+
+	b.WriteString("data")
+	write(uint32(2 * len(samples)))
+	write(samples)
+	return b.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("stereo wav", func(t *testing.T) {
+		samples, rate, err := Decode(wav(t, 44100, 2, []int16{16384, 0, -32768, -32768}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rate != 44100 {
+			t.Errorf("expected rate 44100, got %d", rate)
+		}
+		if len(samples) != 2 || samples[0] != 0.25 || samples[1] != -1 {
+			t.Errorf("unexpected samples %v", samples)
+		}
+	})
+
+	t.Run("raw pcm", func(t *testing.T) {
+		data, err := WAV([]byte{0x00, 0x40, 0x00, 0xc0}, SampleRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		samples, rate, err := Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rate != SampleRate {
+			t.Errorf("expected rate %d, got %d", SampleRate, rate)
+		}
+		if len(samples) != 2 || samples[0] != 0.5 || samples[1] != -0.5 {
+			t.Errorf("unexpected samples %v", samples)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, data := range map[string][]byte{
+			"raw pcm":      {0x00, 0x40, 0x00, 0xc0},
+			"mp3":          []byte("ID3\x04\x00\x00\x00\x00\x00\x00"),
+			"missing fmt":  []byte("RIFF\x04\x00\x00\x00WAVEdata\x00\x00\x00\x00"),
+			"missing data": wav(t, 16000, 1, nil)[:36],
+		} {
+			if _, _, err := Decode(data); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+
+		if _, err := WAV([]byte{1, 2, 3}, SampleRate); err == nil {
+			t.Error("odd pcm: expected an error")
+		}
+	})
+}
+
+func TestResample(t *testing.T) {
+	samples := []float32{0, 1, 2, 3}
+
+	if got := Resample(samples, 16000, 16000); len(got) != 4 {
+		t.Errorf("expected samples to be unchanged, got %v", got)
+	}
+
+	up := Resample(samples, 8000, 16000)
+	want := []float32{0, 0.5, 1, 1.5, 2, 2.5, 3, 3}
+	if len(up) != len(want) {
+		t.Fatalf("expected %d samples, got %d", len(want), len(up))
+	}
+	for i := range want {
+		if up[i] != want[i] {
+			t.Errorf("sample %d: expected %v, got %v", i, want[i], up[i])
+		}
+	}
+
+	if down := Resample(up, 16000, 8000); len(down) != 4 || down[1] != 1 {
+		t.Errorf("unexpected samples %v", down)
+	}
+}
+
+func TestFFT(t *testing.T) {
+	for _, n := range []int{1, 2, 7, 12, 400} {
+		x := make([]complex128, n)
+		for i := range x {
+			x[i] = complex(rand.Float64(), rand.Float64())
+		}
+
+		got, want := fft(x), dft(x)
+		for k := range want {
+			if cmplx.Abs(got[k]-want[k]) > 1e-9 {
+				t.Fatalf("n=%d: bin %d: expected %v, got %v", n, k, want[k], got[k])
+			}
+		}
+	}
+}
+
+func TestMelFilters(t *testing.T) {
+	filters := MelFilters(80, 400, 16000)
+	if len(filters) != 80 || len(filters[0]) != 201 {
+		t.Fatalf("expected 80x201 filters, got %dx%d", len(filters), len(filters[0]))
+	}
+
+	// filters peak in increasing bins
+	peak := -1
+	for m, filter := range filters {
+		var best int
+		for k, w := range filter {
+			if w < 0 {
+				t.Fatalf("filter %d has negative weight %v", m, w)
+			}
+			if w > filter[best] {
+				best = k
+			}
+		}
+		if best < peak {
+			t.Errorf("filter %d peaks at bin %d, before the previous filter's %d", m, best, peak)
+		}
+		peak = best
+	}
+}
+
+func TestLogMelSpectrogram(t *testing.T) {
+	// one second of a 1 kHz tone
+	samples := make([]float32, SampleRate)
+	for i := range samples {
+		samples[i] = float32(math.Sin(2 * math.Pi * 1000 * float64(i) / SampleRate))
+	}
+
+	mel, frames := LogMelSpectrogram(samples, MelOptions{})
+	if frames != 100 || len(mel) != 80*frames {
+		t.Fatalf("expected 80x100 values, got %d frames and %d values", frames, len(mel))
+	}
+
+	// values are clamped to 8 below the maximum before scaling by 1/4
+	lo, hi := mel[0], mel[0]
+	for _, v := range mel {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	if hi-lo > 2 {
+		t.Errorf("expected values within 2 of each other, got [%v, %v]", lo, hi)
+	}
+
+	filters := MelFilters(80, 400, SampleRate)
+	var loudest int
+	frame := frames / 2
+	for m := range 80 {
+		if v := mel[m*frames+frame]; v > mel[loudest*frames+frame] {
+			loudest = m
+		}
+	}
+
+	// the 1 kHz bin is 25 with a 40 Hz resolution
+	if filters[loudest][25] == 0 {
+		t.Errorf("expected the loudest mel bin to cover 1 kHz, got mel %d", loudest)
+	}
+
+	if mel, frames := LogMelSpectrogram(samples[:10], MelOptions{}); mel != nil || frames != 0 {
+		t.Errorf("expected no frames for a short input, got %d", frames)
+	}
+}
@@ -0,0 +1,214 @@
+package audioproc
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// MelOptions describe how a log-mel spectrogram is computed. The zero value
+// of a field uses Whisper's value.
+type MelOptions struct {
+	// SampleRate is the sample rate of the samples, 16000 by default
+	SampleRate int
+	// NumFFT is the size of the window of each frame, 400 by default
+	NumFFT int
+	// HopLength is the number of samples between frames, 160 by default
+	HopLength int
+	// NumMels is the number of mel bins, 80 by default
+	NumMels int
+}
+
+func (o MelOptions) withDefaults() MelOptions {
+	if o.SampleRate == 0 {
+		o.SampleRate = SampleRate
+	}
+	if o.NumFFT == 0 {
+		o.NumFFT = 400
+	}
+	if o.HopLength == 0 {
+		o.HopLength = 160
+	}
+	if o.NumMels == 0 {
+		o.NumMels = 80
+	}
+	return o
+}
+
+// LogMelSpectrogram computes the log-mel spectrogram of mono samples the way
+// Whisper does: a centered short-time Fourier transform with a Hann window,
+// projected onto Slaney-style mel filters, then log10, clamped to 8 below the
+// maximum and scaled to about [-1, 1]. It returns the spectrogram in mel
+// major order, NumMels rows of len(samples)/HopLength frames.
+func LogMelSpectrogram(samples []float32, opts MelOptions) (mel []float32, frames int) {
+	opts = opts.withDefaults()
+	frames = len(samples) / opts.HopLength
+	if frames == 0 {
+		return nil, 0
+	}
+
+	window := make([]float64, opts.NumFFT)
+	for i := range window {
+		window[i] = 0.5 * (1 - math.Cos(2*math.Pi*float64(i)/float64(opts.NumFFT)))
+	}
+
+	filters := MelFilters(opts.NumMels, opts.NumFFT, opts.SampleRate)
+	bins := opts.NumFFT/2 + 1
+
+	// samples are reflected at both ends so frames are centered on the hop
+	pad := opts.NumFFT / 2
+	at := func(i int) float64 {
+		i -= pad
+		switch {
+		case len(samples) == 1:
+			return float64(samples[0])
+		case i < 0:
+			i = -i
+		case i >= len(samples):
+			i = 2*(len(samples)-1) - i
+		}
+		return float64(samples[min(max(i, 0), len(samples)-1)])
+	}
+
+	mel = make([]float32, opts.NumMels*frames)
+	buf := make([]complex128, opts.NumFFT)
+	power := make([]float64, bins)
+	maxLog := math.Inf(-1)
+	for f := range frames {
+		for i := range buf {
+			buf[i] = complex(at(f*opts.HopLength+i)*window[i], 0)
+		}
+
+		spectrum := fft(buf)
+		for k := range power {
+			a := cmplx.Abs(spectrum[k])
+			power[k] = a * a
+		}
+
+		for m, filter := range filters {
+			var sum float64
+			for k, w := range filter {
+				sum += float64(w) * power[k]
+			}
+
+			v := math.Log10(max(sum, 1e-10))
+			maxLog = max(maxLog, v)
+			mel[m*frames+f] = float32(v)
+		}
+	}
+
+	for i, v := range mel {
+		mel[i] = float32((max(float64(v), maxLog-8) + 4) / 4)
+	}
+
+	return mel, frames
+}
+
+// MelFilters returns numMels triangular filters over the numFFT/2+1 bins of
+// a Fourier transform of size numFFT, on the Slaney mel scale with area
+// normalization, matching librosa's defaults.
+func MelFilters(numMels, numFFT, sampleRate int) [][]float32 {
+	bins := numFFT/2 + 1
+	nyquist := float64(sampleRate) / 2
+
+	// numMels+2 points evenly spaced on the mel scale
+	points := make([]float64, numMels+2)
+	lo, hi := hzToMel(0), hzToMel(nyquist)
+	for i := range points {
+		points[i] = melToHz(lo + (hi-lo)*float64(i)/float64(numMels+1))
+	}
+
+	filters := make([][]float32, numMels)
+	for m := range filters {
+		filters[m] = make([]float32, bins)
+		left, center, right := points[m], points[m+1], points[m+2]
+		norm := 2 / (right - left)
+		for k := range bins {
+			hz := float64(k) * float64(sampleRate) / float64(numFFT)
+			w := min((hz-left)/(center-left), (right-hz)/(right-center))
+			if w > 0 {
+				filters[m][k] = float32(w * norm)
+			}
+		}
+	}
+	return filters
+}
+
+const (
+	melLinearHz   = 1000.0
+	melLinearStep = 200.0 / 3
+	melLogStep    = 0.06875177742094912 // log(6.4) / 27
+)
+
+// hzToMel converts a frequency to the Slaney mel scale, which is linear
+// below 1 kHz and logarithmic above.
+func hzToMel(hz float64) float64 {
+	if hz < melLinearHz {
+		return hz / melLinearStep
+	}
+	return melLinearHz/melLinearStep + math.Log(hz/melLinearHz)/melLogStep
+}
+
+func melToHz(mel float64) float64 {
+	if mel < melLinearHz/melLinearStep {
+		return mel * melLinearStep
+	}
+	return melLinearHz * math.Exp(melLogStep*(mel-melLinearHz/melLinearStep))
+}
+
+// fft returns the discrete Fourier transform of x. Sizes are split by their
+// smallest prime factor, so sizes such as Whisper's 400 are fast without
+// padding to a power of two.
+func fft(x []complex128) []complex128 {
+	n := len(x)
+	if n <= 1 {
+		return append([]complex128(nil), x...)
+	}
+
+	p := smallestFactor(n)
+	if p == n {
+		return dft(x)
+	}
+
+	// p interleaved sub-transforms of size n/p
+	m := n / p
+	subs := make([][]complex128, p)
+	sub := make([]complex128, m)
+	for r := range p {
+		for i := range m {
+			sub[i] = x[i*p+r]
+		}
+		subs[r] = fft(sub)
+	}
+
+	out := make([]complex128, n)
+	for k := range n {
+		var sum complex128
+		for r := range p {
+			sum += subs[r][k%m] * cmplx.Exp(complex(0, -2*math.Pi*float64(r*k)/float64(n)))
+		}
+		out[k] = sum
+	}
+	return out
+}
+
+func dft(x []complex128) []complex128 {
+	n := len(x)
+	out := make([]complex128, n)
+	for k := range n {
+		var sum complex128
+		for i, v := range x {
+			sum += v * cmplx.Exp(complex(0, -2*math.Pi*float64(i*k%n)/float64(n)))
+		}
+		out[k] = sum
+	}
+	return out
+}
+
+func smallestFactor(n int) int {
+	for p := 2; p*p <= n; p++ {
+		if n%p == 0 {
+			return p
+		}
+	}
+	return n
+}
@@ -25,7 +25,10 @@ import (
 	"github.com/goobla/goobla/model/input"
 )
 
-var ErrNoVisionModel = errors.New("this model is missing data required for image input")
+var (
+	ErrNoVisionModel = errors.New("this model is missing data required for image input")
+	ErrNoAudioModel  = errors.New("this model is missing data required for audio input")
+)
 
 // Model implements a specific model architecture, defining the forward pass and any model-specific configuration
 type Model interface {
@@ -67,6 +70,19 @@ type MultimodalProcessor interface {
 	PostTokenize([]input.Input) ([]input.Input, error)
 }
 
+// AudioProcessor must be implemented by models that accept audio input.
+// Audio inputs are arranged by PostTokenize the same way as the inputs of a
+// [MultimodalProcessor].
+type AudioProcessor interface {
+	// EncodeAudio processes a single audio clip, the raw bytes of a WAV
+	// file, into one or more tensors like EncodeMultimodal.
+	// Models typically decode it with audioproc.Decode and encode the
+	// result of audioproc.LogMelSpectrogram.
+	EncodeAudio(ml.Context, []byte) ([]input.Multimodal, error)
+
+	PostTokenize([]input.Input) ([]input.Input, error)
+}
+
 // Base implements the common fields and methods for all models
 type Base struct {
 	b ml.Backend
@@ -60,6 +60,7 @@ func CompletionsMiddleware() gin.HandlerFunc {
 			Stream:        req.Stream,
 			ID:            fmt.Sprintf("cmpl-%d", rand.Intn(999)),
 			StreamOptions: req.StreamOptions,
+			N:             genReq.N,
 		}
 		c.Writer = w
 		c.Next()
@@ -123,6 +124,35 @@ func ChatMiddleware() gin.HandlerFunc {
 			Stream:        req.Stream,
 			ID:            fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
 			StreamOptions: req.StreamOptions,
+			N:             chatReq.N,
+		}
+		c.Writer = w
+		c.Next()
+	}
+}
+
+func ResponsesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req opentypes.ResponsesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, opentypes.NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		var b bytes.Buffer
+		chatReq, err := opentypes.FromResponsesRequest(req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, opentypes.NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, opentypes.NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+		c.Request.Body = io.NopCloser(&b)
+		w := &writer.ResponsesWriter{
+			BaseWriter: writer.BaseWriter{ResponseWriter: c.Writer},
+			Stream:     req.Stream,
+			ID:         opentypes.ResponseID(),
 		}
 		c.Writer = w
 		c.Next()
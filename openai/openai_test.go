@@ -330,7 +330,7 @@ func TestChatMiddleware(t *testing.T) {
 				]
 			}`,
 			err: typ.ErrorResponse{
-				Error: Error{
+				Error: typ.Error{
 					Message: "invalid message content type: float64",
 					Type:    "invalid_request_error",
 				},
@@ -524,7 +524,7 @@ func TestCompletionsMiddleware(t *testing.T) {
 				"suffix": "suffix"
 			}`,
 			err: typ.ErrorResponse{
-				Error: Error{
+				Error: typ.Error{
 					Message: "invalid type for 'stop' field: float64",
 					Type:    "invalid_request_error",
 				},
@@ -675,7 +675,7 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 				"model": "test-model"
 			}`,
 			err: typ.ErrorResponse{
-				Error: Error{
+				Error: typ.Error{
 					Message: "invalid input",
 					Type:    "invalid_request_error",
 				},
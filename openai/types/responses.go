@@ -0,0 +1,416 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/goobla/goobla/api"
+)
+
+// ResponsesRequest is a request to the Responses API (/v1/responses).
+type ResponsesRequest struct {
+	Model             string          `json:"model"`
+	Input             json.RawMessage `json:"input"`
+	Instructions      string          `json:"instructions"`
+	Tools             []ResponsesTool `json:"tools"`
+	ToolChoice        json.RawMessage `json:"tool_choice"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls"`
+	Stream            bool            `json:"stream"`
+	Temperature       *float64        `json:"temperature"`
+	TopP              *float64        `json:"top_p"`
+	MaxOutputTokens   *int            `json:"max_output_tokens"`
+	Reasoning         *struct {
+		Effort string `json:"effort"`
+	} `json:"reasoning"`
+	Text *struct {
+		Format *ResponsesTextFormat `json:"format"`
+	} `json:"text"`
+}
+
+// ResponsesTextFormat is the output format of a response.
+type ResponsesTextFormat struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// ResponsesTool is a tool in a Responses API request. Unlike chat
+// completions, function tools are not nested under a "function" key.
+type ResponsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ResponsesInputItem is an item of a Responses API input list: a message,
+// a function call made by the model, the output of a function call or the
+// model's reasoning.
+type ResponsesInputItem struct {
+	Type    string          `json:"type"`
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Output    string `json:"output"`
+
+	Summary []ResponsesContent `json:"summary"`
+}
+
+// ResponsesContent is a content part of an input or output item.
+type ResponsesContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	ImageURL    string `json:"image_url,omitempty"`
+	Annotations []any  `json:"annotations,omitempty"`
+}
+
+// Response is a response object of the Responses API.
+type Response struct {
+	ID                string             `json:"id"`
+	Object            string             `json:"object"`
+	CreatedAt         int64              `json:"created_at"`
+	Status            string             `json:"status"`
+	IncompleteDetails *IncompleteDetails `json:"incomplete_details"`
+	Model             string             `json:"model"`
+	Output            []ResponseItem     `json:"output"`
+	Usage             *ResponsesUsage    `json:"usage"`
+}
+
+// IncompleteDetails explains why a response is incomplete.
+type IncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+// ResponseItem is an output item of a response: a message, a function call
+// or the model's reasoning.
+type ResponseItem struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Status string `json:"status,omitempty"`
+
+	// message
+	Role    string             `json:"role,omitempty"`
+	Content []ResponsesContent `json:"content,omitempty"`
+
+	// function_call
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+
+	// reasoning
+	Summary []ResponsesContent `json:"summary,omitempty"`
+}
+
+// ResponsesUsage is the token usage of a response.
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponseEvent is an event of a streamed response. Type is sent both as
+// the SSE event name and in the payload.
+type ResponseEvent struct {
+	Type           string `json:"type"`
+	SequenceNumber int    `json:"sequence_number"`
+
+	Response *Response `json:"response,omitempty"`
+
+	OutputIndex  *int              `json:"output_index,omitempty"`
+	Item         *ResponseItem     `json:"item,omitempty"`
+	ItemID       string            `json:"item_id,omitempty"`
+	ContentIndex *int              `json:"content_index,omitempty"`
+	SummaryIndex *int              `json:"summary_index,omitempty"`
+	Part         *ResponsesContent `json:"part,omitempty"`
+	Delta        string            `json:"delta,omitempty"`
+	Text         string            `json:"text,omitempty"`
+	Arguments    string            `json:"arguments,omitempty"`
+}
+
+// ResponseID returns a new random response id.
+func ResponseID() string {
+	return itemID("resp")
+}
+
+func itemID(prefix string) string {
+	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 24)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return prefix + "_" + string(b)
+}
+
+// NewReasoningItem, NewMessageItem and NewFunctionCallItem return output
+// items with new ids.
+func NewReasoningItem() ResponseItem {
+	return ResponseItem{Type: "reasoning", ID: itemID("rs"), Summary: []ResponsesContent{}}
+}
+
+func NewMessageItem() ResponseItem {
+	return ResponseItem{Type: "message", ID: itemID("msg"), Status: "in_progress", Role: "assistant", Content: []ResponsesContent{}}
+}
+
+func NewFunctionCallItem(tc api.ToolCall) ResponseItem {
+	args, _ := json.Marshal(tc.Function.Arguments)
+	return ResponseItem{
+		Type:      "function_call",
+		ID:        itemID("fc"),
+		Status:    "completed",
+		CallID:    toolCallID(),
+		Name:      tc.Function.Name,
+		Arguments: string(args),
+	}
+}
+
+// ToResponsesUsage returns the token usage of a chat response.
+func ToResponsesUsage(r api.ChatResponse) *ResponsesUsage {
+	return &ResponsesUsage{
+		InputTokens:  r.PromptEvalCount,
+		OutputTokens: r.EvalCount,
+		TotalTokens:  r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+// ResponseStatus returns the status of a finished response and why it is
+// incomplete, if it is.
+func ResponseStatus(r api.ChatResponse) (string, *IncompleteDetails) {
+	if r.DoneReason == "length" {
+		return "incomplete", &IncompleteDetails{Reason: "max_output_tokens"}
+	}
+	return "completed", nil
+}
+
+// ToResponse converts a complete chat response to a response object.
+func ToResponse(id string, r api.ChatResponse) Response {
+	output := []ResponseItem{}
+	if r.Message.Thinking != "" {
+		item := NewReasoningItem()
+		item.Summary = append(item.Summary, ResponsesContent{Type: "summary_text", Text: r.Message.Thinking})
+		output = append(output, item)
+	}
+
+	if r.Message.Content != "" || len(r.Message.ToolCalls) == 0 {
+		item := NewMessageItem()
+		item.Status = "completed"
+		item.Content = append(item.Content, ResponsesContent{Type: "output_text", Text: r.Message.Content, Annotations: []any{}})
+		output = append(output, item)
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		output = append(output, NewFunctionCallItem(tc))
+	}
+
+	status, details := ResponseStatus(r)
+	return Response{
+		ID:                id,
+		Object:            "response",
+		CreatedAt:         r.CreatedAt.Unix(),
+		Status:            status,
+		IncompleteDetails: details,
+		Model:             r.Model,
+		Output:            output,
+		Usage:             ToResponsesUsage(r),
+	}
+}
+
+// FromResponsesRequest converts a Responses API request to a chat request.
+func FromResponsesRequest(r ResponsesRequest) (*api.ChatRequest, error) {
+	var messages []api.Message
+	if r.Instructions != "" {
+		messages = append(messages, api.Message{Role: "system", Content: r.Instructions})
+	}
+
+	var input string
+	if err := json.Unmarshal(r.Input, &input); err == nil {
+		messages = append(messages, api.Message{Role: "user", Content: input})
+	} else {
+		var items []ResponsesInputItem
+		if err := json.Unmarshal(r.Input, &items); err != nil {
+			return nil, errors.New("invalid input: expected a string or a list of input items")
+		}
+
+		for _, item := range items {
+			msgs, err := fromResponsesInputItem(item)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, msgs...)
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	options := make(map[string]any)
+	if r.MaxOutputTokens != nil {
+		options["num_predict"] = *r.MaxOutputTokens
+	}
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	} else {
+		options["temperature"] = 1.0
+	}
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	} else {
+		options["top_p"] = 1.0
+	}
+
+	var tools []api.Tool
+	for _, t := range r.Tools {
+		if t.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type %q", t.Type)
+		}
+
+		tool := api.Tool{Type: "function"}
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		if len(t.Parameters) > 0 {
+			if err := json.Unmarshal(t.Parameters, &tool.Function.Parameters); err != nil {
+				return nil, fmt.Errorf("invalid parameters for tool %q: %w", t.Name, err)
+			}
+		}
+		tools = append(tools, tool)
+	}
+
+	toolChoice, err := fromResponsesToolChoice(r.ToolChoice)
+	if err != nil {
+		return nil, err
+	}
+
+	var format json.RawMessage
+	if r.Text != nil && r.Text.Format != nil {
+		switch r.Text.Format.Type {
+		case "json_object":
+			format = json.RawMessage(`"json"`)
+		case "json_schema":
+			format = r.Text.Format.Schema
+		}
+	}
+
+	var think *bool
+	if r.Reasoning != nil {
+		enabled := r.Reasoning.Effort != "none"
+		think = &enabled
+	}
+
+	return &api.ChatRequest{
+		Model:             r.Model,
+		Messages:          messages,
+		Format:            format,
+		Options:           options,
+		Stream:            &r.Stream,
+		Tools:             tools,
+		ToolChoice:        toolChoice,
+		ParallelToolCalls: r.ParallelToolCalls,
+		Think:             think,
+	}, nil
+}
+
+func fromResponsesInputItem(item ResponsesInputItem) ([]api.Message, error) {
+	switch item.Type {
+	case "", "message":
+		role := strings.ToLower(item.Role)
+		if role == "developer" {
+			role = "system"
+		}
+
+		var text string
+		if err := json.Unmarshal(item.Content, &text); err == nil {
+			return []api.Message{{Role: role, Content: text}}, nil
+		}
+
+		var parts []ResponsesContent
+		if err := json.Unmarshal(item.Content, &parts); err != nil {
+			return nil, errors.New("invalid message content")
+		}
+
+		var messages []api.Message
+		for _, part := range parts {
+			switch part.Type {
+			case "input_text", "output_text":
+				messages = append(messages, api.Message{Role: role, Content: part.Text})
+			case "input_image":
+				img, err := decodeImageURL(part.ImageURL)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, api.Message{Role: role, Images: []api.ImageData{img}})
+			default:
+				return nil, fmt.Errorf("unsupported content type %q", part.Type)
+			}
+		}
+		return messages, nil
+	case "function_call":
+		var args api.ToolCallFunctionArguments
+		if err := json.Unmarshal([]byte(item.Arguments), &args); err != nil {
+			return nil, errors.New("invalid function call arguments")
+		}
+
+		return []api.Message{{
+			Role:      "assistant",
+			ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: item.Name, Arguments: args}}},
+		}}, nil
+	case "function_call_output":
+		return []api.Message{{Role: "tool", Content: item.Output}}, nil
+	case "reasoning":
+		var sb strings.Builder
+		for _, s := range item.Summary {
+			sb.WriteString(s.Text)
+		}
+		return []api.Message{{Role: "assistant", Thinking: sb.String()}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported input item type %q", item.Type)
+	}
+}
+
+// fromResponsesToolChoice converts a Responses API tool choice, which names
+// a function as {"type": "function", "name": "..."}, to a chat tool choice.
+func fromResponsesToolChoice(b json.RawMessage) (*api.ToolChoice, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return nil, nil
+	}
+
+	var tc struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(b, &tc); err != nil {
+		var choice api.ToolChoice
+		if err := json.Unmarshal(b, &choice); err != nil {
+			return nil, err
+		}
+		return &choice, nil
+	}
+
+	if tc.Type != api.ToolChoiceFunction || tc.Name == "" {
+		return nil, errors.New("invalid tool_choice: expected a function with a name")
+	}
+
+	choice := api.ToolChoice{Type: api.ToolChoiceFunction}
+	choice.Function.Name = tc.Name
+	return &choice, nil
+}
+
+// decodeImageURL decodes a base64 data URL of a JPEG or PNG image.
+func decodeImageURL(url string) (api.ImageData, error) {
+	for _, t := range []string{"jpeg", "jpg", "png"} {
+		if data, ok := strings.CutPrefix(url, "data:image/"+t+";base64,"); ok {
+			img, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return nil, errors.New("invalid image input")
+			}
+			return img, nil
+		}
+	}
+	return nil, errors.New("invalid image input")
+}
@@ -0,0 +1,108 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/goobla/goobla/api"
+)
+
+func TestFromResponsesRequest(t *testing.T) {
+	var r ResponsesRequest
+	if err := json.Unmarshal([]byte(`{
+		"model": "test-model",
+		"instructions": "Be brief.",
+		"input": [
+			{"role": "user", "content": "What's the weather in Paris?"},
+			{"type": "reasoning", "summary": [{"type": "summary_text", "text": "need the weather"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+			{"type": "message", "role": "developer", "content": [{"type": "input_text", "text": "Answer in French."}]}
+		],
+		"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"reasoning": {"effort": "low"},
+		"max_output_tokens": 64,
+		"stream": true
+	}`), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	chat, err := FromResponsesRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []api.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What's the weather in Paris?"},
+		{Role: "assistant", Thinking: "need the weather"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}},
+		{Role: "tool", Content: "sunny"},
+		{Role: "system", Content: "Answer in French."},
+	}
+	if diff := cmp.Diff(want, chat.Messages); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	if len(chat.Tools) != 1 || chat.Tools[0].Function.Name != "get_weather" || chat.Tools[0].Function.Parameters.Properties["city"].Type.String() != "string" {
+		t.Errorf("unexpected tools: %v", chat.Tools)
+	}
+
+	if chat.ToolChoice == nil || chat.ToolChoice.Type != api.ToolChoiceFunction || chat.ToolChoice.Function.Name != "get_weather" {
+		t.Errorf("unexpected tool_choice: %+v", chat.ToolChoice)
+	}
+
+	if chat.Think == nil || !*chat.Think {
+		t.Errorf("expected thinking to be enabled")
+	}
+
+	if chat.Options["num_predict"] != 64 || chat.Stream == nil || !*chat.Stream {
+		t.Errorf("unexpected options: %v stream %v", chat.Options, chat.Stream)
+	}
+}
+
+func TestFromResponsesRequestErrors(t *testing.T) {
+	cases := map[string]string{
+		"missing input":     `{"model": "test-model"}`,
+		"invalid input":     `{"model": "test-model", "input": 42}`,
+		"unknown item":      `{"model": "test-model", "input": [{"type": "web_search_call"}]}`,
+		"unsupported tool":  `{"model": "test-model", "input": "hi", "tools": [{"type": "web_search"}]}`,
+		"invalid arguments": `{"model": "test-model", "input": [{"type": "function_call", "name": "f", "arguments": "{"}]}`,
+	}
+
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			var r ResponsesRequest
+			if err := json.Unmarshal([]byte(body), &r); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := FromResponsesRequest(r); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestToResponse(t *testing.T) {
+	r := ToResponse("resp_1", api.ChatResponse{
+		Model: "test-model",
+		Message: api.Message{
+			Role:      "assistant",
+			ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}},
+		},
+		Done:       true,
+		DoneReason: "stop",
+	})
+
+	if len(r.Output) != 1 {
+		t.Fatalf("expected only a function call, got %#v", r.Output)
+	}
+
+	fc := r.Output[0]
+	if fc.Type != "function_call" || fc.Name != "get_weather" || fc.Arguments != `{"city":"Paris"}` || fc.CallID == "" {
+		t.Errorf("unexpected function call: %#v", fc)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/model/audioproc"
 	"github.com/goobla/goobla/types/model"
 )
 
@@ -35,21 +36,39 @@ type Message struct {
 }
 
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Message      Message         `json:"message"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs,omitempty"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type ChunkChoice struct {
-	Index        int     `json:"index"`
-	Delta        Message `json:"delta"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Delta        Message         `json:"delta"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs,omitempty"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type CompleteChunkChoice struct {
-	Text         string  `json:"text"`
-	Index        int     `json:"index"`
-	FinishReason *string `json:"finish_reason"`
+	Text         string              `json:"text"`
+	Index        int                 `json:"index"`
+	Logprobs     *CompletionLogprobs `json:"logprobs,omitempty"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+// ChoiceLogprobs holds the log probabilities of the tokens in a chat
+// completion choice.
+type ChoiceLogprobs struct {
+	Content []api.Logprob `json:"content"`
+}
+
+// CompletionLogprobs holds the log probabilities of the tokens in a legacy
+// completion choice, as parallel lists indexed by token.
+type CompletionLogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
 }
 
 type Usage struct {
@@ -77,19 +96,25 @@ type StreamOptions struct {
 }
 
 type ChatCompletionRequest struct {
-	Model            string          `json:"model"`
-	Messages         []Message       `json:"messages"`
-	Stream           bool            `json:"stream"`
-	StreamOptions    *StreamOptions  `json:"stream_options"`
-	MaxTokens        *int            `json:"max_tokens"`
-	Seed             *int            `json:"seed"`
-	Stop             any             `json:"stop"`
-	Temperature      *float64        `json:"temperature"`
-	FrequencyPenalty *float64        `json:"frequency_penalty"`
-	PresencePenalty  *float64        `json:"presence_penalty"`
-	TopP             *float64        `json:"top_p"`
-	ResponseFormat   *ResponseFormat `json:"response_format"`
-	Tools            []api.Tool      `json:"tools"`
+	Model             string             `json:"model"`
+	Messages          []Message          `json:"messages"`
+	Stream            bool               `json:"stream"`
+	StreamOptions     *StreamOptions     `json:"stream_options"`
+	MaxTokens         *int               `json:"max_tokens"`
+	Seed              *int               `json:"seed"`
+	Stop              any                `json:"stop"`
+	Temperature       *float64           `json:"temperature"`
+	FrequencyPenalty  *float64           `json:"frequency_penalty"`
+	PresencePenalty   *float64           `json:"presence_penalty"`
+	TopP              *float64           `json:"top_p"`
+	ResponseFormat    *ResponseFormat    `json:"response_format"`
+	Tools             []api.Tool         `json:"tools"`
+	ToolChoice        *api.ToolChoice    `json:"tool_choice"`
+	ParallelToolCalls *bool              `json:"parallel_tool_calls"`
+	Logprobs          *bool              `json:"logprobs"`
+	TopLogprobs       *int               `json:"top_logprobs"`
+	LogitBias         map[string]float32 `json:"logit_bias"`
+	N                 *int               `json:"n"`
 }
 
 type ChatCompletion struct {
@@ -115,18 +140,21 @@ type ChatCompletionChunk struct {
 // Supports using string, []string, []int, or [][]int for the Prompt field.
 
 type CompletionRequest struct {
-	Model            string         `json:"model"`
-	Prompt           any            `json:"prompt"`
-	FrequencyPenalty float32        `json:"frequency_penalty"`
-	MaxTokens        *int           `json:"max_tokens"`
-	PresencePenalty  float32        `json:"presence_penalty"`
-	Seed             *int           `json:"seed"`
-	Stop             any            `json:"stop"`
-	Stream           bool           `json:"stream"`
-	StreamOptions    *StreamOptions `json:"stream_options"`
-	Temperature      *float32       `json:"temperature"`
-	TopP             float32        `json:"top_p"`
-	Suffix           string         `json:"suffix"`
+	Model            string             `json:"model"`
+	Prompt           any                `json:"prompt"`
+	FrequencyPenalty float32            `json:"frequency_penalty"`
+	MaxTokens        *int               `json:"max_tokens"`
+	PresencePenalty  float32            `json:"presence_penalty"`
+	Seed             *int               `json:"seed"`
+	Stop             any                `json:"stop"`
+	Stream           bool               `json:"stream"`
+	StreamOptions    *StreamOptions     `json:"stream_options"`
+	Temperature      *float32           `json:"temperature"`
+	TopP             float32            `json:"top_p"`
+	Suffix           string             `json:"suffix"`
+	Logprobs         *int               `json:"logprobs"`
+	LogitBias        map[string]float32 `json:"logit_bias"`
+	N                *int               `json:"n"`
 }
 
 type Completion struct {
@@ -245,8 +273,9 @@ func ToChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_goobla",
 		Choices: []Choice{{
-			Index:   0,
-			Message: Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Index:    r.Index,
+			Message:  Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs: toChoiceLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(toolCalls) > 0 {
 					reason = "tool_calls"
@@ -270,8 +299,9 @@ func ToChunk(id string, r api.ChatResponse, toolCallSent bool) ChatCompletionChu
 		Model:             r.Model,
 		SystemFingerprint: "fp_goobla",
 		Choices: []ChunkChoice{{
-			Index: 0,
-			Delta: Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
+			Index:    r.Index,
+			Delta:    Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs: toChoiceLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					if toolCallSent {
@@ -301,8 +331,9 @@ func ToCompletion(id string, r api.GenerateResponse) Completion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_goobla",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    r.Index,
+			Logprobs: toCompletionLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -322,8 +353,9 @@ func ToCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 		Model:             r.Model,
 		SystemFingerprint: "fp_goobla",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    r.Index,
+			Logprobs: toCompletionLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -334,6 +366,42 @@ func ToCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 	}
 }
 
+func toChoiceLogprobs(logprobs []api.Logprob) *ChoiceLogprobs {
+	if len(logprobs) == 0 {
+		return nil
+	}
+	return &ChoiceLogprobs{Content: logprobs}
+}
+
+func toCompletionLogprobs(logprobs []api.Logprob) *CompletionLogprobs {
+	if len(logprobs) == 0 {
+		return nil
+	}
+
+	var offset int
+	l := &CompletionLogprobs{
+		Tokens:        make([]string, len(logprobs)),
+		TokenLogprobs: make([]float64, len(logprobs)),
+		TopLogprobs:   make([]map[string]float64, len(logprobs)),
+		TextOffset:    make([]int, len(logprobs)),
+	}
+	for i, lp := range logprobs {
+		l.Tokens[i] = lp.Token
+		l.TokenLogprobs[i] = lp.Logprob
+		l.TextOffset[i] = offset
+		offset += len(lp.Token)
+
+		if len(lp.TopLogprobs) > 0 {
+			top := make(map[string]float64, len(lp.TopLogprobs))
+			for _, t := range lp.TopLogprobs {
+				top[t.Token] = t.Logprob
+			}
+			l.TopLogprobs[i] = top
+		}
+	}
+	return l
+}
+
 func ToListCompletion(r api.ListResponse) ListCompletion {
 	var data []Model
 	for _, m := range r.Models {
@@ -423,6 +491,29 @@ func FromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 						return nil, errors.New("invalid message format")
 					}
 					messages = append(messages, api.Message{Role: msg.Role, Images: []api.ImageData{img}})
+				case "input_audio":
+					audio, ok := data["input_audio"].(map[string]any)
+					if !ok {
+						return nil, errors.New("invalid message format")
+					}
+					if format, _ := audio["format"].(string); format != "wav" && format != "pcm16" {
+						return nil, fmt.Errorf("unsupported audio format %q, must be \"wav\" or \"pcm16\"", format)
+					}
+					encoded, ok := audio["data"].(string)
+					if !ok {
+						return nil, errors.New("invalid message format")
+					}
+					b, err := base64.StdEncoding.DecodeString(encoded)
+					if err != nil {
+						return nil, errors.New("invalid audio input")
+					}
+					// models only take WAV files, which raw PCM is wrapped in
+					if audio["format"] == "pcm16" {
+						if b, err = audioproc.WAV(b, audioproc.SampleRate); err != nil {
+							return nil, err
+						}
+					}
+					messages = append(messages, api.Message{Role: msg.Role, Audios: []api.AudioData{b}})
 				default:
 					return nil, errors.New("invalid message format")
 				}
@@ -477,6 +568,9 @@ func FromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 	} else {
 		options["top_p"] = 1.0
 	}
+	if len(r.LogitBias) > 0 {
+		options["logit_bias"] = r.LogitBias
+	}
 	var format json.RawMessage
 	if r.ResponseFormat != nil {
 		switch strings.ToLower(strings.TrimSpace(r.ResponseFormat.Type)) {
@@ -488,13 +582,30 @@ func FromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 			}
 		}
 	}
+	var logprobs bool
+	if r.Logprobs != nil {
+		logprobs = *r.Logprobs
+	}
+	var topLogprobs int
+	if r.TopLogprobs != nil {
+		topLogprobs = *r.TopLogprobs
+	}
+	var n int
+	if r.N != nil {
+		n = *r.N
+	}
 	return &api.ChatRequest{
-		Model:    r.Model,
-		Messages: messages,
-		Format:   format,
-		Options:  options,
-		Stream:   &r.Stream,
-		Tools:    r.Tools,
+		Model:             r.Model,
+		Messages:          messages,
+		Format:            format,
+		Options:           options,
+		Stream:            &r.Stream,
+		Tools:             r.Tools,
+		ToolChoice:        r.ToolChoice,
+		ParallelToolCalls: r.ParallelToolCalls,
+		Logprobs:          logprobs,
+		TopLogprobs:       topLogprobs,
+		N:                 n,
 	}, nil
 }
 
@@ -532,6 +643,9 @@ func FromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 	} else {
 		options["top_p"] = 1.0
 	}
+	if len(r.LogitBias) > 0 {
+		options["logit_bias"] = r.LogitBias
+	}
 	var prompt string
 	var context []int
 	switch p := r.Prompt.(type) {
@@ -590,12 +704,27 @@ func FromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 	default:
 		return api.GenerateRequest{}, fmt.Errorf("invalid type for 'prompt' field: %T", r.Prompt)
 	}
+	// logprobs is the number of alternatives to return per token and
+	// returns the sampled token's log probability even when zero
+	var logprobs bool
+	var topLogprobs int
+	if r.Logprobs != nil {
+		logprobs = true
+		topLogprobs = *r.Logprobs
+	}
+	var n int
+	if r.N != nil {
+		n = *r.N
+	}
 	return api.GenerateRequest{
-		Model:   r.Model,
-		Prompt:  prompt,
-		Context: context,
-		Options: options,
-		Stream:  &r.Stream,
-		Suffix:  r.Suffix,
+		Model:       r.Model,
+		Prompt:      prompt,
+		Context:     context,
+		Options:     options,
+		Stream:      &r.Stream,
+		Suffix:      r.Suffix,
+		Logprobs:    logprobs,
+		TopLogprobs: topLogprobs,
+		N:           n,
 	}, nil
 }
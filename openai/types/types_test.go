@@ -1,11 +1,15 @@
 package types
 
 import (
+	"encoding/json"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/model/audioproc"
 )
 
 var False = false
@@ -76,3 +80,189 @@ func TestFromCompleteRequestPromptTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestFromRequestLogprobs(t *testing.T) {
+	logprobs, topLogprobs := true, 3
+
+	chat, err := FromChatRequest(ChatCompletionRequest{
+		Model:       "test-model",
+		Messages:    []Message{{Role: "user", Content: "Hello"}},
+		Logprobs:    &logprobs,
+		TopLogprobs: &topLogprobs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chat.Logprobs || chat.TopLogprobs != 3 {
+		t.Errorf("chat: got logprobs %v top_logprobs %d, want true 3", chat.Logprobs, chat.TopLogprobs)
+	}
+
+	var zero int
+	generate, err := FromCompleteRequest(CompletionRequest{Model: "test-model", Prompt: "Hello", Logprobs: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !generate.Logprobs || generate.TopLogprobs != 0 {
+		t.Errorf("completion: got logprobs %v top_logprobs %d, want true 0", generate.Logprobs, generate.TopLogprobs)
+	}
+}
+
+func TestToCompletionLogprobs(t *testing.T) {
+	logprobs := []api.Logprob{
+		{
+			TokenLogprob: api.TokenLogprob{Token: "Hi", Logprob: -0.1},
+			TopLogprobs: []api.TokenLogprob{
+				{Token: "Hi", Logprob: -0.1},
+				{Token: "Hello", Logprob: -2.5},
+			},
+		},
+		{TokenLogprob: api.TokenLogprob{Token: " there", Logprob: -0.5}},
+	}
+
+	chat := ToChatCompletion("id", api.ChatResponse{Model: "test-model", Logprobs: logprobs})
+	if diff := cmp.Diff(&ChoiceLogprobs{Content: logprobs}, chat.Choices[0].Logprobs); diff != "" {
+		t.Errorf("chat logprobs mismatch (-want +got):\n%s", diff)
+	}
+
+	completion := ToCompletion("id", api.GenerateResponse{Model: "test-model", Response: "Hi there", Logprobs: logprobs})
+	want := &CompletionLogprobs{
+		Tokens:        []string{"Hi", " there"},
+		TokenLogprobs: []float64{-0.1, -0.5},
+		TopLogprobs:   []map[string]float64{{"Hi": -0.1, "Hello": -2.5}, nil},
+		TextOffset:    []int{0, 2},
+	}
+	if diff := cmp.Diff(want, completion.Choices[0].Logprobs); diff != "" {
+		t.Errorf("completion logprobs mismatch (-want +got):\n%s", diff)
+	}
+
+	if chunk := ToChunk("id", api.ChatResponse{Model: "test-model"}, false); chunk.Choices[0].Logprobs != nil {
+		t.Errorf("chunk logprobs: got %v, want nil", chunk.Choices[0].Logprobs)
+	}
+}
+
+func TestFromRequestLogitBias(t *testing.T) {
+	bias := map[string]float32{"42": -100, " yes": 5}
+
+	chat, err := FromChatRequest(ChatCompletionRequest{
+		Model:     "test-model",
+		Messages:  []Message{{Role: "user", Content: "Hello"}},
+		LogitBias: bias,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(bias, chat.Options["logit_bias"]); diff != "" {
+		t.Errorf("chat logit_bias mismatch (-want +got):\n%s", diff)
+	}
+
+	generate, err := FromCompleteRequest(CompletionRequest{Model: "test-model", Prompt: "Hello", LogitBias: bias})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(bias, generate.Options["logit_bias"]); diff != "" {
+		t.Errorf("completion logit_bias mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFromRequestN(t *testing.T) {
+	n := 3
+
+	chat, err := FromChatRequest(ChatCompletionRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "Hello"}},
+		N:        &n,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.N != n {
+		t.Errorf("chat n: got %d, want %d", chat.N, n)
+	}
+
+	generate, err := FromCompleteRequest(CompletionRequest{Model: "test-model", Prompt: "Hello", N: &n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generate.N != n {
+		t.Errorf("completion n: got %d, want %d", generate.N, n)
+	}
+
+	chunk := ToChunk("chatcmpl-1", api.ChatResponse{Index: 2, Message: api.Message{Role: "assistant", Content: "hi"}}, false)
+	if chunk.Choices[0].Index != 2 {
+		t.Errorf("chunk index: got %d, want 2", chunk.Choices[0].Index)
+	}
+}
+
+func TestFromRequestToolChoice(t *testing.T) {
+	var r ChatCompletionRequest
+	if err := json.Unmarshal([]byte(`{
+		"model": "test-model",
+		"messages": [{"role": "user", "content": "What's the weather?"}],
+		"tools": [{"type": "function", "function": {"name": "get_weather"}}],
+		"tool_choice": {"type": "function", "function": {"name": "get_weather"}},
+		"parallel_tool_calls": false
+	}`), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	chat, err := FromChatRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chat.ToolChoice == nil || chat.ToolChoice.Type != api.ToolChoiceFunction || chat.ToolChoice.Function.Name != "get_weather" {
+		t.Errorf("tool_choice: got %+v", chat.ToolChoice)
+	}
+
+	if chat.ParallelToolCalls == nil || *chat.ParallelToolCalls {
+		t.Errorf("parallel_tool_calls: got %v, want false", chat.ParallelToolCalls)
+	}
+}
+
+func TestFromRequestInputAudio(t *testing.T) {
+	var r ChatCompletionRequest
+	if err := json.Unmarshal([]byte(`{
+		"model": "test-model",
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "Transcribe this"},
+			{"type": "input_audio", "input_audio": {"data": "UklGRg==", "format": "wav"}}
+		]}]
+	}`), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	chat, err := FromChatRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []api.Message{
+		{Role: "user", Content: "Transcribe this"},
+		{Role: "user", Audios: []api.AudioData{[]byte("RIFF")}},
+	}
+	if diff := cmp.Diff(want, chat.Messages); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	// raw PCM is wrapped in a WAV header
+	r.Messages[0].Content = []any{map[string]any{"type": "input_audio", "input_audio": map[string]any{"data": "AEAAwA==", "format": "pcm16"}}}
+	chat, err = FromChatRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chat.Messages) != 1 || len(chat.Messages[0].Audios) != 1 {
+		t.Fatalf("unexpected messages %+v", chat.Messages)
+	}
+	samples, rate, err := audioproc.Decode(chat.Messages[0].Audios[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != audioproc.SampleRate || !slices.Equal(samples, []float32{0.5, -0.5}) {
+		t.Errorf("decoded %v at %d Hz, want [0.5 -0.5] at %d Hz", samples, rate, audioproc.SampleRate)
+	}
+
+	r.Messages[0].Content = []any{map[string]any{"type": "input_audio", "input_audio": map[string]any{"data": "UklGRg==", "format": "mp3"}}}
+	if _, err := FromChatRequest(r); err == nil || !strings.Contains(err.Error(), "unsupported audio format") {
+		t.Errorf("expected an unsupported audio format error, got %v", err)
+	}
+}
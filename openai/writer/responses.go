@@ -0,0 +1,239 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/goobla/goobla/api"
+	opentypes "github.com/goobla/goobla/openai/types"
+)
+
+// ResponsesWriter translates chat responses to the Responses API. When
+// streaming, the chat response deltas are turned into the typed events that
+// open, extend and close the output items of the response.
+type ResponsesWriter struct {
+	Stream bool
+	ID     string
+	BaseWriter
+
+	seq      int
+	response *opentypes.Response
+
+	// item is the output item that is receiving deltas, if any, and text
+	// accumulates its reasoning summary or output text
+	item *opentypes.ResponseItem
+	text strings.Builder
+}
+
+func (w *ResponsesWriter) writeResponse(data []byte) (int, error) {
+	var r api.ChatResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return 0, err
+	}
+
+	if !w.Stream {
+		w.ResponseWriter.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w.ResponseWriter).Encode(opentypes.ToResponse(w.ID, r)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+	if w.response == nil {
+		w.response = &opentypes.Response{
+			ID:        w.ID,
+			Object:    "response",
+			CreatedAt: r.CreatedAt.Unix(),
+			Status:    "in_progress",
+			Model:     r.Model,
+			Output:    []opentypes.ResponseItem{},
+		}
+		if err := w.writeEvent(opentypes.ResponseEvent{Type: "response.created", Response: w.response}); err != nil {
+			return 0, err
+		}
+		if err := w.writeEvent(opentypes.ResponseEvent{Type: "response.in_progress", Response: w.response}); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.Message.Thinking != "" {
+		if err := w.writeDelta("reasoning", r.Message.Thinking); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.Message.Content != "" {
+		if err := w.writeDelta("message", r.Message.Content); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		if err := w.writeFunctionCall(tc); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.Done {
+		if err := w.closeItem(); err != nil {
+			return 0, err
+		}
+
+		status, details := opentypes.ResponseStatus(r)
+		w.response.Status = status
+		w.response.IncompleteDetails = details
+		w.response.Usage = opentypes.ToResponsesUsage(r)
+		if err := w.writeEvent(opentypes.ResponseEvent{Type: "response." + status, Response: w.response}); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+// writeDelta appends text to the open item of type typ, first closing any
+// other open item and opening a new one if needed.
+func (w *ResponsesWriter) writeDelta(typ, delta string) error {
+	if w.item != nil && w.item.Type != typ {
+		if err := w.closeItem(); err != nil {
+			return err
+		}
+	}
+
+	index := len(w.response.Output)
+	zero := 0
+	if w.item == nil {
+		var item opentypes.ResponseItem
+		var part opentypes.ResponsesContent
+		var event opentypes.ResponseEvent
+		if typ == "reasoning" {
+			item = opentypes.NewReasoningItem()
+			part = opentypes.ResponsesContent{Type: "summary_text"}
+			event = opentypes.ResponseEvent{Type: "response.reasoning_summary_part.added", SummaryIndex: &zero}
+		} else {
+			item = opentypes.NewMessageItem()
+			part = opentypes.ResponsesContent{Type: "output_text", Annotations: []any{}}
+			event = opentypes.ResponseEvent{Type: "response.content_part.added", ContentIndex: &zero}
+		}
+
+		if err := w.writeEvent(opentypes.ResponseEvent{Type: "response.output_item.added", OutputIndex: &index, Item: &item}); err != nil {
+			return err
+		}
+
+		event.ItemID = item.ID
+		event.OutputIndex = &index
+		event.Part = &part
+		if err := w.writeEvent(event); err != nil {
+			return err
+		}
+
+		w.item = &item
+		w.text.Reset()
+	}
+
+	w.text.WriteString(delta)
+
+	event := opentypes.ResponseEvent{ItemID: w.item.ID, OutputIndex: &index, Delta: delta}
+	if typ == "reasoning" {
+		event.Type = "response.reasoning_summary_text.delta"
+		event.SummaryIndex = &zero
+	} else {
+		event.Type = "response.output_text.delta"
+		event.ContentIndex = &zero
+	}
+	return w.writeEvent(event)
+}
+
+// closeItem completes the open item, if any, and adds it to the response.
+func (w *ResponsesWriter) closeItem() error {
+	if w.item == nil {
+		return nil
+	}
+
+	item := *w.item
+	w.item = nil
+
+	index := len(w.response.Output)
+	zero := 0
+	text := w.text.String()
+	var events []opentypes.ResponseEvent
+	if item.Type == "reasoning" {
+		part := opentypes.ResponsesContent{Type: "summary_text", Text: text}
+		item.Summary = append(item.Summary, part)
+		events = []opentypes.ResponseEvent{
+			{Type: "response.reasoning_summary_text.done", SummaryIndex: &zero, Text: text},
+			{Type: "response.reasoning_summary_part.done", SummaryIndex: &zero, Part: &part},
+		}
+	} else {
+		part := opentypes.ResponsesContent{Type: "output_text", Text: text, Annotations: []any{}}
+		item.Status = "completed"
+		item.Content = append(item.Content, part)
+		events = []opentypes.ResponseEvent{
+			{Type: "response.output_text.done", ContentIndex: &zero, Text: text},
+			{Type: "response.content_part.done", ContentIndex: &zero, Part: &part},
+		}
+	}
+
+	for _, event := range events {
+		event.ItemID = item.ID
+		event.OutputIndex = &index
+		if err := w.writeEvent(event); err != nil {
+			return err
+		}
+	}
+
+	w.response.Output = append(w.response.Output, item)
+	return w.writeEvent(opentypes.ResponseEvent{Type: "response.output_item.done", OutputIndex: &index, Item: &item})
+}
+
+// writeFunctionCall writes a function call item. Tool calls are parsed from
+// the model output in full, so the arguments are sent as a single delta.
+func (w *ResponsesWriter) writeFunctionCall(tc api.ToolCall) error {
+	if err := w.closeItem(); err != nil {
+		return err
+	}
+
+	index := len(w.response.Output)
+	item := opentypes.NewFunctionCallItem(tc)
+
+	added := item
+	added.Status = "in_progress"
+	added.Arguments = ""
+	for _, event := range []opentypes.ResponseEvent{
+		{Type: "response.output_item.added", Item: &added},
+		{Type: "response.function_call_arguments.delta", ItemID: item.ID, Delta: item.Arguments},
+		{Type: "response.function_call_arguments.done", ItemID: item.ID, Arguments: item.Arguments},
+		{Type: "response.output_item.done", Item: &item},
+	} {
+		event.OutputIndex = &index
+		if err := w.writeEvent(event); err != nil {
+			return err
+		}
+	}
+
+	w.response.Output = append(w.response.Output, item)
+	return nil
+}
+
+func (w *ResponsesWriter) writeEvent(event opentypes.ResponseEvent) error {
+	event.SequenceNumber = w.seq
+	w.seq++
+
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, d)))
+	return err
+}
+
+func (w *ResponsesWriter) Write(data []byte) (int, error) {
+	if w.ResponseWriter.Status() != http.StatusOK {
+		return w.writeError(data)
+	}
+	return w.writeResponse(data)
+}
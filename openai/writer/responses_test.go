@@ -0,0 +1,171 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goobla/goobla/api"
+	opentypes "github.com/goobla/goobla/openai/types"
+)
+
+func TestResponsesWriter(t *testing.T) {
+	resp := api.ChatResponse{
+		Model:      "test-model",
+		CreatedAt:  time.Unix(1, 0).UTC(),
+		Message:    api.Message{Role: "assistant", Content: "hello", Thinking: "greet the user"},
+		Done:       true,
+		DoneReason: "stop",
+		Metrics:    api.Metrics{PromptEvalCount: 1, EvalCount: 2},
+	}
+	data, _ := json.Marshal(resp)
+
+	w, rec := newTestWriter(http.StatusOK)
+	rw := &ResponsesWriter{ID: "resp_1", BaseWriter: BaseWriter{ResponseWriter: w}}
+	if _, err := rw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var got opentypes.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "resp_1" || got.Status != "completed" || got.Model != "test-model" {
+		t.Fatalf("unexpected response: %#v", got)
+	}
+	if len(got.Output) != 2 || got.Output[0].Type != "reasoning" || got.Output[0].Summary[0].Text != "greet the user" || got.Output[1].Type != "message" || got.Output[1].Content[0].Text != "hello" {
+		t.Fatalf("unexpected output: %#v", got.Output)
+	}
+	if want := (opentypes.ResponsesUsage{InputTokens: 1, OutputTokens: 2, TotalTokens: 3}); *got.Usage != want {
+		t.Fatalf("unexpected usage: %#v", got.Usage)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	serr := api.StatusError{StatusCode: 500, Status: "500", ErrorMessage: "boom"}
+	data, _ = json.Marshal(serr)
+	w, rec = newTestWriter(http.StatusInternalServerError)
+	rw = &ResponsesWriter{ID: "resp_1", BaseWriter: BaseWriter{ResponseWriter: w}}
+	if _, err := rw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var errResp opentypes.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+		t.Fatal(err)
+	}
+	wantErr := opentypes.NewError(http.StatusInternalServerError, serr.Error())
+	if !reflect.DeepEqual(errResp, wantErr) {
+		t.Fatalf("unexpected error response: %#v", errResp)
+	}
+}
+
+func TestResponsesWriterIncomplete(t *testing.T) {
+	data, _ := json.Marshal(api.ChatResponse{Model: "test-model", Message: api.Message{Role: "assistant", Content: "hel"}, Done: true, DoneReason: "length"})
+
+	w, rec := newTestWriter(http.StatusOK)
+	rw := &ResponsesWriter{ID: "resp_1", BaseWriter: BaseWriter{ResponseWriter: w}}
+	if _, err := rw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var got opentypes.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "incomplete" || got.IncompleteDetails == nil || got.IncompleteDetails.Reason != "max_output_tokens" {
+		t.Fatalf("unexpected status: %s %#v", got.Status, got.IncompleteDetails)
+	}
+}
+
+func TestResponsesWriterStream(t *testing.T) {
+	w, rec := newTestWriter(http.StatusOK)
+	rw := &ResponsesWriter{ID: "resp_1", Stream: true, BaseWriter: BaseWriter{ResponseWriter: w}}
+	for _, r := range []api.ChatResponse{
+		{Model: "test-model", Message: api.Message{Role: "assistant", Thinking: "the user "}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", Thinking: "wants weather"}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", Content: "Let me "}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", Content: "check."}},
+		{Model: "test-model", Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}}},
+		{Model: "test-model", Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 3, EvalCount: 5}},
+	} {
+		data, _ := json.Marshal(r)
+		if _, err := rw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	var types []string
+	var events []opentypes.ResponseEvent
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			types = append(types, name)
+		} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var event opentypes.ResponseEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != types[len(types)-1] {
+				t.Fatalf("event name %q does not match payload type %q", types[len(types)-1], event.Type)
+			}
+			if event.SequenceNumber != len(events) {
+				t.Fatalf("unexpected sequence number %d for event %d", event.SequenceNumber, len(events))
+			}
+			events = append(events, event)
+		}
+	}
+
+	want := []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.reasoning_summary_part.added",
+		"response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done",
+		"response.reasoning_summary_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.done",
+		"response.output_item.done",
+		"response.completed",
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("unexpected events:\n got %v\nwant %v", types, want)
+	}
+
+	if text := events[13].Text; text != "Let me check." {
+		t.Errorf("unexpected output text %q", text)
+	}
+
+	if args := events[18].Arguments; args != `{"city":"Paris"}` {
+		t.Errorf("unexpected function call arguments %q", args)
+	}
+
+	completed := events[len(events)-1].Response
+	if completed.Status != "completed" || len(completed.Output) != 3 {
+		t.Fatalf("unexpected completed response: %#v", completed)
+	}
+	if completed.Output[0].Summary[0].Text != "the user wants weather" || completed.Output[2].Name != "get_weather" {
+		t.Errorf("unexpected output: %#v", completed.Output)
+	}
+	if want := (opentypes.ResponsesUsage{InputTokens: 3, OutputTokens: 5, TotalTokens: 8}); *completed.Usage != want {
+		t.Errorf("unexpected usage: %#v", completed.Usage)
+	}
+}
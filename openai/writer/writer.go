@@ -1,9 +1,11 @@
 package writer
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 
@@ -19,15 +21,40 @@ type ChatWriter struct {
 	Stream        bool
 	StreamOptions *opentypes.StreamOptions
 	ID            string
-	ToolCallSent  bool
+	N             int
 	BaseWriter
+
+	// toolCallSent records the choices that have streamed a tool call
+	toolCallSent map[int]bool
+	choices      choices[opentypes.ChatCompletion]
 }
 
 type CompleteWriter struct {
 	Stream        bool
 	StreamOptions *opentypes.StreamOptions
 	ID            string
+	N             int
 	BaseWriter
+
+	choices choices[opentypes.Completion]
+}
+
+// choices collects the finished choices of a request for multiple
+// completions, which the server reports one response at a time.
+type choices[T any] struct {
+	done  int
+	usage opentypes.Usage
+	resp  *T
+}
+
+// add records a finished choice and reports whether it was the last one.
+// Completions share a prompt, so its tokens are only counted once.
+func (c *choices[T]) add(n int, u opentypes.Usage) bool {
+	c.done++
+	c.usage.PromptTokens = max(c.usage.PromptTokens, u.PromptTokens)
+	c.usage.CompletionTokens += u.CompletionTokens
+	c.usage.TotalTokens = c.usage.PromptTokens + c.usage.CompletionTokens
+	return c.done >= max(n, 1)
 }
 
 type ListWriter struct {
@@ -62,21 +89,24 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 		return 0, err
 	}
 	if w.Stream {
-		c := opentypes.ToChunk(w.ID, r, w.ToolCallSent)
+		c := opentypes.ToChunk(w.ID, r, w.toolCallSent[r.Index])
 		d, err := json.Marshal(c)
 		if err != nil {
 			return 0, err
 		}
-		if !w.ToolCallSent && len(c.Choices) > 0 && len(c.Choices[0].Delta.ToolCalls) > 0 {
-			w.ToolCallSent = true
+		if len(c.Choices) > 0 && len(c.Choices[0].Delta.ToolCalls) > 0 {
+			if w.toolCallSent == nil {
+				w.toolCallSent = make(map[int]bool)
+			}
+			w.toolCallSent[r.Index] = true
 		}
 		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
 		if _, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d))); err != nil {
 			return 0, err
 		}
-		if r.Done {
+		if r.Done && w.choices.add(w.N, opentypes.ToUsage(r)) {
 			if w.StreamOptions != nil && w.StreamOptions.IncludeUsage {
-				u := opentypes.ToUsage(r)
+				u := w.choices.usage
 				c.Usage = &u
 				c.Choices = []opentypes.ChunkChoice{}
 				d, err := json.Marshal(c)
@@ -93,8 +123,19 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 		}
 		return len(data), nil
 	}
+	completion := opentypes.ToChatCompletion(w.ID, r)
+	if w.choices.resp == nil {
+		w.choices.resp = &completion
+	} else {
+		w.choices.resp.Choices = append(w.choices.resp.Choices, completion.Choices...)
+	}
+	if !w.choices.add(w.N, completion.Usage) {
+		return len(data), nil
+	}
+	w.choices.resp.Usage = w.choices.usage
+	slices.SortFunc(w.choices.resp.Choices, func(a, b opentypes.Choice) int { return cmp.Compare(a.Index, b.Index) })
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w.ResponseWriter).Encode(opentypes.ToChatCompletion(w.ID, r)); err != nil {
+	if err := json.NewEncoder(w.ResponseWriter).Encode(w.choices.resp); err != nil {
 		return 0, err
 	}
 	return len(data), nil
@@ -125,9 +166,9 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 		if _, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d))); err != nil {
 			return 0, err
 		}
-		if r.Done {
+		if r.Done && w.choices.add(w.N, opentypes.ToUsageGenerate(r)) {
 			if w.StreamOptions != nil && w.StreamOptions.IncludeUsage {
-				u := opentypes.ToUsageGenerate(r)
+				u := w.choices.usage
 				c.Usage = &u
 				c.Choices = []opentypes.CompleteChunkChoice{}
 				d, err := json.Marshal(c)
@@ -144,8 +185,19 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 		}
 		return len(data), nil
 	}
+	completion := opentypes.ToCompletion(w.ID, r)
+	if w.choices.resp == nil {
+		w.choices.resp = &completion
+	} else {
+		w.choices.resp.Choices = append(w.choices.resp.Choices, completion.Choices...)
+	}
+	if !w.choices.add(w.N, completion.Usage) {
+		return len(data), nil
+	}
+	w.choices.resp.Usage = w.choices.usage
+	slices.SortFunc(w.choices.resp.Choices, func(a, b opentypes.CompleteChunkChoice) int { return cmp.Compare(a.Index, b.Index) })
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w.ResponseWriter).Encode(opentypes.ToCompletion(w.ID, r)); err != nil {
+	if err := json.NewEncoder(w.ResponseWriter).Encode(w.choices.resp); err != nil {
 		return 0, err
 	}
 	return len(data), nil
@@ -107,6 +107,34 @@ func TestCompleteWriter(t *testing.T) {
 	}
 }
 
+func TestCompleteWriterMultipleChoices(t *testing.T) {
+	w, rec := newTestWriter(http.StatusOK)
+	cw := &CompleteWriter{ID: "cmpl-1", N: 2, BaseWriter: BaseWriter{ResponseWriter: w}}
+	for _, r := range []api.GenerateResponse{
+		{Model: "test-model", Index: 1, Response: "world", Done: true, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 3, EvalCount: 2}},
+		{Model: "test-model", Index: 0, Response: "hello", Done: true, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 3, EvalCount: 1}},
+	} {
+		if rec.Body.Len() > 0 {
+			t.Fatalf("unexpected response before all choices finished: %s", rec.Body)
+		}
+		data, _ := json.Marshal(r)
+		if _, err := cw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got opentypes.Completion
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Choices) != 2 || got.Choices[0].Index != 0 || got.Choices[0].Text != "hello" || got.Choices[1].Index != 1 || got.Choices[1].Text != "world" {
+		t.Fatalf("unexpected choices: %#v", got.Choices)
+	}
+	if want := (opentypes.Usage{PromptTokens: 3, CompletionTokens: 3, TotalTokens: 6}); got.Usage != want {
+		t.Fatalf("unexpected usage: %#v", got.Usage)
+	}
+}
+
 func TestListWriter(t *testing.T) {
 	resp := api.ListResponse{Models: []api.ListModelResponse{{Name: "test-model", ModifiedAt: time.Unix(3, 0).UTC()}}}
 	data, _ := json.Marshal(resp)
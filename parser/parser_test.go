@@ -27,6 +27,7 @@ func TestParseFileFile(t *testing.T) {
 	input := `
 FROM model1
 ADAPTER adapter1
+DRAFT model2
 LICENSE MIT
 PARAMETER param1 value1
 PARAMETER param2 value2
@@ -47,6 +48,7 @@ TEMPLATE """{{ if .System }}<|start_header_id|>system<|end_header_id|>
 	expectedCommands := []Command{
 		{Name: "model", Args: "model1"},
 		{Name: "adapter", Args: "adapter1"},
+		{Name: "draft", Args: "model2"},
 		{Name: "license", Args: "MIT"},
 		{Name: "param1", Args: "value1"},
 		{Name: "param2", Args: "value2"},
@@ -481,6 +483,7 @@ func TestParseFileParameters(t *testing.T) {
 		"main_gpu 1":                   {"main_gpu", "1"},
 		"use_mmap true":                {"use_mmap", "true"},
 		"num_thread 1":                 {"num_thread", "1"},
+		"num_draft 1":                  {"num_draft", "1"},
 		"num_keep 1":                   {"num_keep", "1"},
 		"seed 1":                       {"seed", "1"},
 		"num_predict 1":                {"num_predict", "1"},
@@ -693,6 +696,17 @@ TEMPLATE some template
 		},
 		{
 			`FROM test
+DRAFT test:small
+PARAMETER num_draft 6
+`,
+			&api.CreateRequest{
+				From:       "test",
+				Draft:      "test:small",
+				Parameters: map[string]any{"num_draft": int64(6)},
+			},
+		},
+		{
+			`FROM test
 LICENSE single license
 PARAMETER temperature 0.5
 MESSAGE user Hello
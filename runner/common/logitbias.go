@@ -0,0 +1,28 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// LogitBias converts a logit bias keyed by token id strings, as resolved by
+// the server, into one keyed by token id. Ids must be less than vocabSize.
+func LogitBias(bias map[string]float32, vocabSize int) (map[int32]float32, error) {
+	if len(bias) == 0 {
+		return nil, nil
+	}
+
+	ids := make(map[int32]float32, len(bias))
+	for k, b := range bias {
+		id, err := strconv.ParseInt(k, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logit bias token %q", k)
+		}
+		if id < 0 || id >= int64(vocabSize) {
+			return nil, fmt.Errorf("logit bias token %d out of range for vocabulary of %d tokens", id, vocabSize)
+		}
+		ids[int32(id)] += b
+	}
+
+	return ids, nil
+}
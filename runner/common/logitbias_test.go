@@ -0,0 +1,51 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogitBias(t *testing.T) {
+	tests := []struct {
+		name     string
+		bias     map[string]float32
+		expected map[int32]float32
+		err      bool
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name:     "Token ids",
+			bias:     map[string]float32{"0": -100, "9": 2.5},
+			expected: map[int32]float32{0: -100, 9: 2.5},
+		},
+		{
+			name: "Text",
+			bias: map[string]float32{"hello": 1},
+			err:  true,
+		},
+		{
+			name: "Out of range",
+			bias: map[string]float32{"10": 1},
+			err:  true,
+		},
+		{
+			name: "Negative",
+			bias: map[string]float32{"-1": 1},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := LogitBias(tt.bias, 10)
+			if (err != nil) != tt.err {
+				t.Fatalf("LogitBias() error = %v, wantErr %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("LogitBias() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
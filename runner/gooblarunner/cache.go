@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"slices"
 	"time"
 
 	"github.com/goobla/goobla/kvcache"
@@ -29,6 +30,9 @@ type InputCache struct {
 	multiUserCache bool
 
 	cache kvcache.Cache
+
+	// persists long prompts across restarts, nil if disabled
+	disk *DiskCache
 }
 
 func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots int, batchSize int, multiUserCache bool) (*InputCache, error) {
@@ -91,6 +95,10 @@ type InputCacheSlot struct {
 
 	// last time this cache was used (as of start of processing)
 	lastUsed time.Time
+
+	// name of the prefix pinned in this cache, which keeps it in use so its
+	// inputs are only ever forked into other slots
+	pinned string
 }
 
 func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []input.Input, error) {
@@ -114,11 +122,18 @@ func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []inp
 	slot.InUse = true
 	slot.lastUsed = time.Now()
 
+	numPast = c.forkPinnedSlot(slot, prompt, numPast)
+
 	if numPast == int32(len(prompt)) {
 		// Leave one input to sample so we can get a response
 		numPast--
 	}
 
+	numPast, err = c.restoreCacheSlot(slot, prompt, numPast)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if c.cache != nil {
 		if numPast > 0 && !c.cache.CanResume(slot.Id, numPast) {
 			numPast = 0
@@ -164,6 +179,8 @@ func (c *InputCache) findLongestCacheSlot(prompt []input.Input) (*InputCacheSlot
 		return nil, 0, errors.New("no available cache slots")
 	}
 
+	c.saveEvictedSlot(longestSlot, longest)
+
 	return longestSlot, longest, nil
 }
 
@@ -198,6 +215,7 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 	if len(oldestSlot.Inputs) != 0 {
 		slog.Debug("evicting cache slot", "id", oldestSlot.Id, "inputs", len(oldestSlot.Inputs),
 			"used", oldestSlot.lastUsed)
+		c.saveEvictedSlot(oldestSlot, countCommonPrefix(oldestSlot.Inputs, prompt))
 	}
 
 	if longest > 0 && longestSlot != oldestSlot {
@@ -213,6 +231,237 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 	return oldestSlot, longest, nil
 }
 
+// ReserveCacheSlot returns the least recently used cache slot that is not in
+// use, for a sequence that will fill it with [InputCache.ForkCacheSlot].
+func (c *InputCache) ReserveCacheSlot() (*InputCacheSlot, error) {
+	var slot *InputCacheSlot
+	for i, s := range c.slots {
+		if !s.InUse && (slot == nil || s.lastUsed.Before(slot.lastUsed)) {
+			slot = &c.slots[i]
+		}
+	}
+
+	if slot == nil {
+		return nil, errors.New("no available cache slots")
+	}
+
+	c.saveEvictedSlot(slot, 0)
+
+	slot.InUse = true
+	slot.lastUsed = time.Now()
+
+	return slot, nil
+}
+
+// ForkCacheSlot replaces the contents of dst with the inputs stored in src,
+// sharing their entries in the KV cache.
+func (c *InputCache) ForkCacheSlot(src, dst *InputCacheSlot) {
+	slog.Debug("forking cache slot", "src", src.Id, "dst", dst.Id, "inputs", len(src.Inputs))
+
+	dst.Inputs = slices.Clone(src.Inputs)
+	if c.cache != nil {
+		c.cache.CopyPrefix(src.Id, dst.Id, int32(len(src.Inputs)))
+	}
+}
+
+// forkPinnedSlot copies the inputs of the pinned slot sharing the longest
+// prefix with prompt into slot, if that prefix is longer than the numPast
+// inputs already cached, and returns the number of inputs now cached.
+func (c *InputCache) forkPinnedSlot(slot *InputCacheSlot, prompt []input.Input, numPast int32) int32 {
+	for i := range c.slots {
+		pinned := &c.slots[i]
+		if pinned.pinned == "" || pinned == slot {
+			continue
+		}
+
+		count := countCommonPrefix(pinned.Inputs, prompt)
+		if count <= numPast {
+			continue
+		}
+
+		slog.Debug("forking pinned cache slot", "src", pinned.Id, "dst", slot.Id, "prefix", pinned.pinned, "inputs", count)
+		slot.Inputs = slices.Clone(pinned.Inputs[:count])
+		if c.cache != nil {
+			c.cache.CopyPrefix(pinned.Id, slot.Id, count)
+		}
+		numPast = count
+	}
+
+	return numPast
+}
+
+// PinCacheSlot pins the inputs stored in slot as the prefix name. The slot
+// stays in use until it is unpinned.
+func (c *InputCache) PinCacheSlot(slot *InputCacheSlot, name string) {
+	slog.Debug("pinning cache slot", "id", slot.Id, "prefix", name, "inputs", len(slot.Inputs))
+	slot.pinned = name
+	slot.InUse = true
+}
+
+// UnpinCacheSlot releases the slot pinned as name, returning false if there
+// is none. Its inputs stay cached until the slot is reused.
+func (c *InputCache) UnpinCacheSlot(name string) bool {
+	slot := c.PinnedSlot(name)
+	if slot == nil {
+		return false
+	}
+
+	slog.Debug("unpinning cache slot", "id", slot.Id, "prefix", name)
+	slot.pinned = ""
+	slot.InUse = false
+	return true
+}
+
+// PinnedSlot returns the slot pinned as name, or nil if there is none
+func (c *InputCache) PinnedSlot(name string) *InputCacheSlot {
+	for i := range c.slots {
+		if c.slots[i].pinned == name {
+			return &c.slots[i]
+		}
+	}
+
+	return nil
+}
+
+// NumPinned returns the number of pinned slots
+func (c *InputCache) NumPinned() int {
+	var n int
+	for _, s := range c.slots {
+		if s.pinned != "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// SlotSize returns the memory used by the KV cache entries of slot, or 0
+// if the cache doesn't report it
+func (c *InputCache) SlotSize(slot *InputCacheSlot) uint64 {
+	if cache, ok := c.cache.(interface{ CellSize() int }); ok {
+		return uint64(len(slot.Inputs)) * uint64(cache.CellSize())
+	}
+
+	return 0
+}
+
+// restoreCacheSlot loads the longest prefix of prompt stored on disk into
+// slot, if it is longer than the numPast inputs already cached, and returns
+// the number of inputs now cached.
+func (c *InputCache) restoreCacheSlot(slot *InputCacheSlot, prompt []input.Input, numPast int32) (int32, error) {
+	snapshotter, ok := c.cache.(kvcache.Snapshotter)
+	if c.disk == nil || !ok {
+		return numPast, nil
+	}
+
+	name, n := c.disk.Find(diskCacheInputs(prompt))
+	n = min(n, int32(len(prompt))-1)
+	if n <= numPast {
+		return numPast, nil
+	}
+
+	snapshot, err := c.disk.Load(name, n)
+	if err == nil {
+		if err = snapshotter.Restore(slot.Id, snapshot); err != nil {
+			// such as an entry saved with a different cache type
+			c.disk.Remove(name)
+		}
+	}
+	if err != nil {
+		slog.Warn("failed to restore prompt cache", "id", slot.Id, "error", err)
+
+		// the slot may have been partially overwritten
+		slot.Inputs = nil
+		return 0, c.cache.Remove(slot.Id, 0, math.MaxInt32)
+	}
+
+	slog.Debug("restored cache slot from disk", "id", slot.Id, "inputs", n)
+	slot.Inputs = prompt[:n]
+
+	return n, nil
+}
+
+// saveEvictedSlot writes the inputs stored in slot to disk in the background
+// before all but the first keep of them are evicted. Slots evicted while
+// another is being written are not saved.
+func (c *InputCache) saveEvictedSlot(slot *InputCacheSlot, keep int32) {
+	if c.disk == nil || int(keep) >= len(slot.Inputs) || !c.disk.saving.TryLock() {
+		return
+	}
+
+	inputs, snapshot := c.snapshotSlot(slot)
+	if snapshot == nil {
+		c.disk.saving.Unlock()
+		return
+	}
+
+	go func() {
+		defer c.disk.saving.Unlock()
+		if err := c.disk.Save(inputs, snapshot); err != nil {
+			slog.Warn("failed to save prompt cache", "error", err)
+		}
+	}()
+}
+
+// SaveCacheSlots writes the inputs stored in every slot to disk, one at a
+// time, such as before the runner is stopped.
+func (c *InputCache) SaveCacheSlots() {
+	if c.disk == nil {
+		return
+	}
+
+	c.disk.saving.Lock()
+	defer c.disk.saving.Unlock()
+
+	for i := range c.slots {
+		inputs, snapshot := c.snapshotSlot(&c.slots[i])
+		if snapshot == nil {
+			continue
+		}
+
+		if err := c.disk.Save(inputs, snapshot); err != nil {
+			slog.Warn("failed to save prompt cache", "error", err)
+		}
+	}
+}
+
+// snapshotSlot returns the inputs stored in slot and a snapshot of their
+// cache entries, or a nil snapshot if they are too short to be worth keeping
+// or already stored.
+func (c *InputCache) snapshotSlot(slot *InputCacheSlot) ([]int32, *kvcache.Snapshot) {
+	snapshotter, ok := c.cache.(kvcache.Snapshotter)
+	if !ok {
+		return nil, nil
+	}
+
+	inputs := diskCacheInputs(slot.Inputs)
+	if len(inputs) < diskCacheMinInputs || c.disk.Covers(inputs) {
+		return nil, nil
+	}
+
+	snapshot, err := snapshotter.Snapshot(slot.Id, int32(len(inputs)))
+	if err != nil {
+		slog.Debug("unable to save prompt cache", "id", slot.Id, "error", err)
+		return nil, nil
+	}
+
+	return inputs, snapshot
+}
+
+// diskCacheInputs returns the tokens of inputs up to the first multimodal
+// input, which can't be stored on disk.
+func diskCacheInputs(inputs []input.Input) []int32 {
+	tokens := make([]int32, 0, len(inputs))
+	for _, inp := range inputs {
+		if inp.Multimodal != nil || inp.MultimodalHash != 0 {
+			break
+		}
+		tokens = append(tokens, inp.Token)
+	}
+
+	return tokens
+}
+
 func countCommonPrefix(a []input.Input, b []input.Input) int32 {
 	var count int32
 
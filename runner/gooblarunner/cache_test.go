@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/goobla/goobla/ml"
 	"github.com/goobla/goobla/model/input"
 )
@@ -424,9 +426,94 @@ func TestLoadCacheSlot(t *testing.T) {
 	}
 }
 
+func TestForkCacheSlot(t *testing.T) {
+	now := time.Now()
+	cache := InputCache{
+		slots: []InputCacheSlot{
+			{Id: 0, Inputs: []input.Input{{Token: 1}, {Token: 2}}, InUse: true, lastUsed: now},
+			{Id: 1, Inputs: []input.Input{{Token: 5}}, lastUsed: now.Add(-time.Second)},
+			{Id: 2, Inputs: []input.Input{{Token: 6}}, lastUsed: now.Add(-2 * time.Second)},
+		},
+	}
+	mock := &mockCache{}
+	cache.cache = mock
+
+	// the least recently used free slot is reserved first
+	for _, want := range []int{2, 1} {
+		slot, err := cache.ReserveCacheSlot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slot.Id != want || !slot.InUse {
+			t.Errorf("ReserveCacheSlot() = slot %d (in use %v), expected slot %d in use", slot.Id, slot.InUse, want)
+		}
+	}
+
+	if _, err := cache.ReserveCacheSlot(); err == nil {
+		t.Error("ReserveCacheSlot() expected error with all slots in use")
+	}
+
+	cache.ForkCacheSlot(&cache.slots[0], &cache.slots[2])
+	if diff := cmp.Diff(cache.slots[0].Inputs, cache.slots[2].Inputs); diff != "" {
+		t.Errorf("ForkCacheSlot() inputs mismatch (-src +dst):\n%s", diff)
+	}
+	if mock.copySrc != 0 || mock.copyDst != 2 || mock.copyLen != 2 {
+		t.Errorf("ForkCacheSlot() copied prefix (%d, %d, %d), expected (0, 2, 2)", mock.copySrc, mock.copyDst, mock.copyLen)
+	}
+
+	// the fork's inputs are independent of the source
+	cache.slots[2].Inputs[0].Token = 9
+	if cache.slots[0].Inputs[0].Token != 1 {
+		t.Error("ForkCacheSlot() shares inputs with the source slot")
+	}
+}
+
+func TestPinCacheSlot(t *testing.T) {
+	now := time.Now()
+	cache := InputCache{
+		numCtx: 10,
+		slots: []InputCacheSlot{
+			{Id: 0, Inputs: []input.Input{{Token: 1}, {Token: 2}, {Token: 3}}, lastUsed: now},
+			{Id: 1, Inputs: []input.Input{{Token: 1}}, lastUsed: now.Add(-time.Second)},
+		},
+	}
+	mock := &mockCache{}
+	cache.cache = mock
+
+	cache.PinCacheSlot(&cache.slots[0], "system")
+	if cache.NumPinned() != 1 || cache.PinnedSlot("system") != &cache.slots[0] || !cache.slots[0].InUse {
+		t.Fatal("expected slot 0 to be pinned and in use")
+	}
+
+	// prompts starting with the prefix fork it instead of using the pinned slot
+	slot, remaining, err := cache.LoadCacheSlot([]input.Input{{Token: 1}, {Token: 2}, {Token: 3}, {Token: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slot.Id != 1 || len(slot.Inputs) != 3 || len(remaining) != 1 {
+		t.Errorf("expected slot 1 with 3 cached and 1 remaining inputs, got slot %d with %d and %d", slot.Id, len(slot.Inputs), len(remaining))
+	}
+	if mock.copySrc != 0 || mock.copyDst != 1 || mock.copyLen != 3 {
+		t.Errorf("copied prefix (%d, %d, %d), expected (0, 1, 3)", mock.copySrc, mock.copyDst, mock.copyLen)
+	}
+
+	if cache.UnpinCacheSlot("other") {
+		t.Error("expected no slot pinned as other")
+	}
+
+	if !cache.UnpinCacheSlot("system") || cache.NumPinned() != 0 || cache.slots[0].InUse {
+		t.Error("expected slot 0 to be unpinned and free")
+	}
+}
+
 // Mock implementation of the Cache interface
 type mockCache struct {
 	shouldFail bool
+
+	// arguments of the last call to CopyPrefix
+	copySrc, copyDst int
+	copyLen          int32
 }
 
 // Implement only the methods needed for the test
@@ -446,7 +533,9 @@ func (m *mockCache) Init(backend ml.Backend, dtype ml.DType, maxSequences, capac
 }
 func (m *mockCache) Close()                                                             {}
 func (m *mockCache) StartForward(ctx ml.Context, batch input.Batch, reserve bool) error { return nil }
-func (m *mockCache) CopyPrefix(srcSeq, dstSeq int, len int32)                           {}
+func (m *mockCache) CopyPrefix(srcSeq, dstSeq int, len int32) {
+	m.copySrc, m.copyDst, m.copyLen = srcSeq, dstSeq, len
+}
 func (m *mockCache) SetConfig(ml.CacheConfig) error {
 	return nil
 }
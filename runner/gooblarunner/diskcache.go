@@ -0,0 +1,449 @@
+package gooblarunner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goobla/goobla/kvcache"
+	"github.com/goobla/goobla/ml"
+)
+
+// diskCacheMinInputs is the shortest prompt worth saving to disk, shorter
+// prompts are cheap enough to evaluate again
+const diskCacheMinInputs = 256
+
+const (
+	diskCacheMagic   = "GKVC"
+	diskCacheVersion = 2
+	diskCacheExt     = ".kvc"
+)
+
+// DiskCache persists the KV cache contents of long prompts so that they can be
+// restored after the runner restarts, such as when the model is unloaded and
+// loaded again. Entries are keyed by the model digest and a hash of their
+// inputs, and the directory is kept under a size limit by deleting the least
+// recently used entries, across all models sharing it.
+type DiskCache struct {
+	dir     string
+	model   string
+	maxSize int64
+
+	mu sync.Mutex
+
+	// entries for this model, by file name
+	entries map[string][]int32
+
+	// saving is held while an entry is being saved, so that only one
+	// snapshot is held in memory at a time
+	saving sync.Mutex
+}
+
+// NewDiskCache opens the cache in dir for the model with the given digest,
+// reading the inputs of the entries already stored for it.
+func NewDiskCache(dir, model string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:     dir,
+		model:   model,
+		maxSize: maxSize,
+		entries: make(map[string][]int32),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+diskCacheExt))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		model, inputs, err := readDiskCacheHeader(file)
+		if err != nil {
+			slog.Debug("skipping prompt cache entry", "file", file, "error", err)
+			continue
+		}
+
+		if model == c.model {
+			c.entries[filepath.Base(file)] = inputs
+		}
+	}
+
+	return c, nil
+}
+
+// diskCacheName returns the name of the file storing inputs for model
+func diskCacheName(model string, inputs []int32) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	binary.Write(h, binary.LittleEndian, inputs) //nolint:errcheck
+	return hex.EncodeToString(h.Sum(nil)) + diskCacheExt
+}
+
+// Find returns the entry sharing the longest prefix with inputs, along with
+// the length of that prefix.
+func (c *DiskCache) Find(inputs []int32) (string, int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var name string
+	var longest int32
+	for n, stored := range c.entries {
+		var count int32
+		for count < int32(min(len(stored), len(inputs))) && stored[count] == inputs[count] {
+			count++
+		}
+
+		if count > longest {
+			name, longest = n, count
+		}
+	}
+
+	return name, longest
+}
+
+// Covers reports whether an entry already holds all of inputs
+func (c *DiskCache) Covers(inputs []int32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, stored := range c.entries {
+		if len(stored) >= len(inputs) && slices.Equal(stored[:len(inputs)], inputs) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Load reads the first n positions of an entry.
+func (c *DiskCache) Load(name string, n int32) (*kvcache.Snapshot, error) {
+	snapshot, err := c.load(name, n)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.entries, name)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(c.dir, name), now, now); err != nil {
+		slog.Debug("failed to update prompt cache entry", "name", name, "error", err)
+	}
+
+	return snapshot, nil
+}
+
+func (c *DiskCache) load(name string, n int32) (*kvcache.Snapshot, error) {
+	f, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	model, inputs, err := decodeDiskCacheHeader(r, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	if model != c.model {
+		return nil, fmt.Errorf("prompt cache entry %s is for model %s", name, model)
+	}
+
+	if n > int32(len(inputs)) {
+		return nil, fmt.Errorf("prompt cache entry %s has %d inputs, requested %d", name, len(inputs), n)
+	}
+
+	var numLayers uint32
+	if err := binary.Read(r, binary.LittleEndian, &numLayers); err != nil {
+		return nil, err
+	}
+
+	snapshot := &kvcache.Snapshot{Len: n, Layers: make(map[int]kvcache.LayerSnapshot, numLayers)}
+	for range numLayers {
+		var header [7]uint32
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			return nil, err
+		}
+
+		layer := kvcache.LayerSnapshot{
+			KHeadDim:   int(header[1]),
+			VHeadDim:   int(header[2]),
+			NumKVHeads: int(header[3]),
+			DType:      ml.DType(header[4]),
+		}
+
+		if int64(header[5])*int64(len(inputs)) > info.Size() || int64(header[6])*int64(len(inputs)) > info.Size() {
+			return nil, errors.New("corrupt prompt cache entry")
+		}
+
+		if layer.Keys, err = readRows(r, int(header[5]), int(n), len(inputs)); err != nil {
+			return nil, err
+		}
+
+		if layer.Values, err = readRows(r, int(header[6]), int(n), len(inputs)); err != nil {
+			return nil, err
+		}
+
+		snapshot.Layers[int(header[0])] = layer
+	}
+
+	return snapshot, nil
+}
+
+// Remove deletes an entry, such as one that can't be restored into the
+// cache.
+func (c *DiskCache) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Debug("failed to remove prompt cache entry", "name", name, "error", err)
+	}
+	delete(c.entries, name)
+}
+
+// Save stores a snapshot of inputs, replacing entries for prefixes of them,
+// then evicts the least recently used entries until the cache fits its
+// limit.
+func (c *DiskCache) Save(inputs []int32, snapshot *kvcache.Snapshot) error {
+	name := diskCacheName(c.model, inputs)
+
+	f, err := os.CreateTemp(c.dir, name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	if err := encodeDiskCache(w, c.model, inputs, snapshot); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(c.dir, name)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n, stored := range c.entries {
+		if n != name && len(stored) < len(inputs) && slices.Equal(stored, inputs[:len(stored)]) {
+			slog.Debug("replacing prompt cache entry", "name", n, "inputs", len(stored))
+			if err := os.Remove(filepath.Join(c.dir, n)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			delete(c.entries, n)
+		}
+	}
+
+	c.entries[name] = inputs
+
+	return c.evict()
+}
+
+// evict removes the least recently used entries, of any model, until the
+// cache is no larger than maxSize.
+func (c *DiskCache) evict() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		name string
+		size int64
+		used time.Time
+	}
+
+	var entries []entry
+	var size int64
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), diskCacheExt) {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, entry{file.Name(), info.Size(), info.ModTime()})
+		size += info.Size()
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int { return a.used.Compare(b.used) })
+
+	for _, e := range entries {
+		if size <= c.maxSize {
+			break
+		}
+
+		slog.Debug("evicting prompt cache entry", "name", e.name, "size", e.size, "used", e.used)
+		if err := os.Remove(filepath.Join(c.dir, e.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		delete(c.entries, e.name)
+		size -= e.size
+	}
+
+	return nil
+}
+
+// An entry is stored as a header holding the model digest and the inputs,
+// followed by the keys and values of each layer in the data type of the
+// cache, one row per input.
+func encodeDiskCache(w io.Writer, model string, inputs []int32, snapshot *kvcache.Snapshot) error {
+	if _, err := io.WriteString(w, diskCacheMagic); err != nil {
+		return err
+	}
+
+	for _, v := range []any{
+		uint32(diskCacheVersion),
+		uint32(len(model)), []byte(model),
+		uint32(len(inputs)), inputs,
+		uint32(len(snapshot.Layers)),
+	} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	layers := make([]int, 0, len(snapshot.Layers))
+	for i := range snapshot.Layers {
+		layers = append(layers, i)
+	}
+	slices.Sort(layers)
+
+	for _, i := range layers {
+		layer := snapshot.Layers[i]
+
+		var kRow, vRow int
+		if snapshot.Len > 0 {
+			kRow, vRow = len(layer.Keys)/int(snapshot.Len), len(layer.Values)/int(snapshot.Len)
+		}
+
+		header := [7]uint32{
+			uint32(i),
+			uint32(layer.KHeadDim), uint32(layer.VHeadDim), uint32(layer.NumKVHeads),
+			uint32(layer.DType),
+			uint32(kRow), uint32(vRow),
+		}
+		if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+			return err
+		}
+
+		for _, b := range [][]byte{layer.Keys, layer.Values} {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readDiskCacheHeader(path string) (string, []int32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return decodeDiskCacheHeader(bufio.NewReader(f), info.Size())
+}
+
+// decodeDiskCacheHeader reads the header of an entry of size bytes, which
+// bounds the lengths it holds so a corrupt entry can't cause large
+// allocations.
+func decodeDiskCacheHeader(r io.Reader, size int64) (string, []int32, error) {
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return "", nil, err
+	}
+
+	if string(header.Magic[:]) != diskCacheMagic || header.Version != diskCacheVersion {
+		return "", nil, errors.New("unsupported prompt cache entry")
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", nil, err
+	}
+
+	if int64(n) > size {
+		return "", nil, errors.New("corrupt prompt cache entry")
+	}
+
+	model := make([]byte, n)
+	if _, err := io.ReadFull(r, model); err != nil {
+		return "", nil, err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", nil, err
+	}
+
+	if 4*int64(n) > size {
+		return "", nil, errors.New("corrupt prompt cache entry")
+	}
+
+	inputs := make([]int32, n)
+	if err := binary.Read(r, binary.LittleEndian, inputs); err != nil {
+		return "", nil, err
+	}
+
+	return string(model), inputs, nil
+}
+
+// readRows reads the first n of size rows of rowSize bytes, skipping the rest
+func readRows(r *bufio.Reader, rowSize, n, size int) ([]byte, error) {
+	b := make([]byte, rowSize*n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Discard(rowSize * (size - n)); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
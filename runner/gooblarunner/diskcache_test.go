@@ -0,0 +1,312 @@
+package gooblarunner
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goobla/goobla/kvcache"
+	"github.com/goobla/goobla/ml"
+	"github.com/goobla/goobla/model/input"
+)
+
+// testSnapshot returns a snapshot of n positions with a single layer whose
+// keys are rows of two bytes holding the position and values are rows of
+// one byte holding its complement.
+func testSnapshot(n int32) *kvcache.Snapshot {
+	layer := kvcache.LayerSnapshot{KHeadDim: 2, VHeadDim: 1, NumKVHeads: 1, DType: ml.DTypeQ80}
+	for i := range n {
+		layer.Keys = append(layer.Keys, byte(i), byte(i>>8))
+		layer.Values = append(layer.Values, ^byte(i))
+	}
+
+	return &kvcache.Snapshot{Len: n, Layers: map[int]kvcache.LayerSnapshot{3: layer}}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewDiskCache(dir, "sha256:abc", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, n := c.Find([]int32{1, 2, 3}); name != "" || n != 0 {
+		t.Fatalf("expected no entry, got %q with %d inputs", name, n)
+	}
+
+	if err := c.Save([]int32{1, 2, 3}, testSnapshot(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a longer prompt replaces the entry for its prefix
+	if err := c.Save([]int32{1, 2, 3, 4}, testSnapshot(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Fatalf("expected a single entry, got %v", files)
+	}
+
+	if !c.Covers([]int32{1, 2}) || c.Covers([]int32{1, 2, 3, 4, 5}) {
+		t.Error("unexpected coverage of inputs")
+	}
+
+	// entries are found again after a restart, for the same model only
+	if other, err := NewDiskCache(dir, "sha256:def", 1<<20); err != nil {
+		t.Fatal(err)
+	} else if _, n := other.Find([]int32{1, 2, 3}); n != 0 {
+		t.Errorf("expected no entries for another model, got %d inputs", n)
+	}
+
+	c, err = NewDiskCache(dir, "sha256:abc", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, n := c.Find([]int32{1, 2, 3, 9})
+	if n != 3 {
+		t.Fatalf("expected 3 common inputs, got %d", n)
+	}
+
+	snapshot, err := c.Load(name, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testSnapshot(3)
+	if snapshot.Len != 3 || len(snapshot.Layers) != 1 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	layer := snapshot.Layers[3]
+	if !slices.Equal(layer.Keys, want.Layers[3].Keys) || !slices.Equal(layer.Values, want.Layers[3].Values) ||
+		layer.KHeadDim != 2 || layer.VHeadDim != 1 || layer.NumKVHeads != 1 || layer.DType != ml.DTypeQ80 {
+		t.Errorf("expected %+v, got %+v", want.Layers[3], layer)
+	}
+}
+
+func TestDiskCacheCorrupt(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewDiskCache(dir, "sha256:abc", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []int32{1, 2, 3}
+	if err := c.Save(inputs, testSnapshot(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, diskCacheName("sha256:abc", inputs))
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lengths of the model digest, the inputs and the keys of the first row
+	for i, offset := range []int{8, 12 + len("sha256:abc"), 20 + len("sha256:abc") + 4*len(inputs) + 20} {
+		corrupt := slices.Clone(bts)
+		binary.LittleEndian.PutUint32(corrupt[offset:], math.MaxUint32)
+		if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := readDiskCacheHeader(path); i < 2 && err == nil {
+			t.Errorf("offset %d: expected an error reading the header", offset)
+		}
+
+		if _, err := c.load(filepath.Base(path), 3); err == nil {
+			t.Errorf("offset %d: expected an error loading the entry", offset)
+		}
+	}
+}
+
+func TestDiskCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewDiskCache(dir, "sha256:abc", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inputs := range [][]int32{{1}, {2}, {3}} {
+		if err := c.Save(inputs, testSnapshot(1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, diskCacheName("sha256:abc", []int32{1})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// {2} is the least recently used once {1} is loaded
+	old := time.Now().Add(-time.Hour)
+	for _, inputs := range [][]int32{{1}, {2}} {
+		if err := os.Chtimes(filepath.Join(dir, diskCacheName("sha256:abc", inputs)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.Load(diskCacheName("sha256:abc", []int32{1}), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.maxSize = 3 * info.Size()
+	if err := c.Save([]int32{4}, testSnapshot(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inputs := range [][]int32{{1}, {2}, {3}, {4}} {
+		_, n := c.Find(inputs)
+		if want := inputs[0] != 2; (n == 1) != want {
+			t.Errorf("inputs %v: expected stored %v, got %d inputs", inputs, want, n)
+		}
+	}
+}
+
+type snapshotCache struct {
+	mockCache
+
+	restored map[int]*kvcache.Snapshot
+}
+
+func (m *snapshotCache) Snapshot(seq int, len int32) (*kvcache.Snapshot, error) {
+	return testSnapshot(len), nil
+}
+
+func (m *snapshotCache) Restore(seq int, snapshot *kvcache.Snapshot) error {
+	m.restored[seq] = snapshot
+	return nil
+}
+
+func TestLoadCacheSlotFromDisk(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir(), "sha256:abc", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := disk.Save([]int32{1, 2, 3, 4}, testSnapshot(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &snapshotCache{restored: make(map[int]*kvcache.Snapshot)}
+	cache := InputCache{
+		slots: []InputCacheSlot{{Id: 0, Inputs: []input.Input{{Token: 1}}}},
+		cache: mock,
+		disk:  disk,
+	}
+
+	tests := []struct {
+		name      string
+		prompt    []input.Input
+		restored  int32
+		remaining int
+	}{
+		{
+			name:      "Prefix",
+			prompt:    []input.Input{{Token: 1}, {Token: 2}, {Token: 3}, {Token: 5}},
+			restored:  3,
+			remaining: 1,
+		},
+		{
+			name:      "Whole prompt",
+			prompt:    []input.Input{{Token: 1}, {Token: 2}, {Token: 3}, {Token: 4}},
+			restored:  3,
+			remaining: 1,
+		},
+		{
+			name:      "Multimodal",
+			prompt:    []input.Input{{Token: 1}, {Token: 2}, {MultimodalHash: 3}, {Token: 4}},
+			restored:  2,
+			remaining: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache.slots[0] = InputCacheSlot{Id: 0, Inputs: []input.Input{{Token: 1}}}
+			clear(mock.restored)
+
+			slot, remaining, err := cache.LoadCacheSlot(tt.prompt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if snapshot := mock.restored[0]; snapshot == nil || snapshot.Len != tt.restored {
+				t.Errorf("expected %d inputs restored, got %+v", tt.restored, snapshot)
+			}
+
+			if len(slot.Inputs) != int(tt.restored) || len(remaining) != tt.remaining {
+				t.Errorf("expected %d cached and %d remaining inputs, got %d and %d", tt.restored, tt.remaining, len(slot.Inputs), len(remaining))
+			}
+		})
+	}
+}
+
+func TestSaveEvictedSlot(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir(), "sha256:abc", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := func(first int32, n int) []input.Input {
+		inputs := make([]input.Input, n)
+		for i := range inputs {
+			inputs[i] = input.Input{Token: first + int32(i)}
+		}
+		return inputs
+	}
+
+	long := prompt(1, diskCacheMinInputs)
+	cache := InputCache{
+		slots: []InputCacheSlot{{Id: 0, Inputs: slices.Clone(long)}},
+		cache: &snapshotCache{restored: make(map[int]*kvcache.Snapshot)},
+		disk:  disk,
+	}
+
+	// continuing the cached inputs evicts nothing
+	slot, _, err := cache.LoadCacheSlot(append(slices.Clone(long), input.Input{Token: -1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot.InUse = false
+
+	disk.saving.Lock()
+	if disk.Covers(diskCacheInputs(long)) {
+		t.Error("expected inputs that are kept in the cache not to be saved")
+	}
+	disk.saving.Unlock()
+
+	// replacing them saves them first
+	slot.Inputs = slices.Clone(long)
+	slot, _, err = cache.LoadCacheSlot(prompt(1000, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot.InUse = false
+
+	disk.saving.Lock()
+	if !disk.Covers(diskCacheInputs(long)) {
+		t.Error("expected the evicted inputs to be saved")
+	}
+	disk.saving.Unlock()
+
+	// saving every slot skips what is already stored
+	other := prompt(2000, diskCacheMinInputs)
+	cache.slots = []InputCacheSlot{{Id: 0, Inputs: long}, {Id: 1, Inputs: other}, {Id: 2, Inputs: prompt(3000, 1)}}
+	cache.SaveCacheSlots()
+
+	if !disk.Covers(diskCacheInputs(other)) || disk.Covers(diskCacheInputs(cache.slots[2].Inputs)) {
+		t.Error("expected only long prompts to be saved")
+	}
+	if len(disk.entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(disk.entries))
+	}
+}
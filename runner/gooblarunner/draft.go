@@ -0,0 +1,258 @@
+package gooblarunner
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/goobla/goobla/kvcache"
+	"github.com/goobla/goobla/ml"
+	"github.com/goobla/goobla/model"
+	"github.com/goobla/goobla/model/input"
+)
+
+// draft is a small model that proposes the tokens that follow a sequence,
+// which the main model then checks in a single batch (speculative decoding).
+// It keeps its own KV cache with a slot for each slot of the input cache.
+type draft struct {
+	model model.Model
+	cache kvcache.Cache
+
+	// maximum number of tokens to propose for each sequence
+	numDraft int
+
+	numCtx    int32
+	batchSize int
+
+	// inputs in the draft cache, by slot
+	inputs [][]int32
+}
+
+// newDraft loads the draft model at mpath for main, which must use the same
+// vocabulary.
+func newDraft(mpath string, params ml.BackendParams, main model.Model, kvCacheType string, numCtx int32, numSlots, batchSize, numDraft int) (*draft, error) {
+	m, err := model.New(mpath, params)
+	if err != nil {
+		return nil, err
+	}
+
+	tp, ok := m.(model.TextProcessor)
+	if !ok {
+		return nil, errors.New("draft model does not generate text")
+	}
+
+	if !slices.Equal(tp.Vocabulary().Values, main.(model.TextProcessor).Vocabulary().Values) {
+		return nil, errors.New("draft model does not share the vocabulary of the model")
+	}
+
+	cache := m.Config().Cache
+	if cache == nil {
+		return nil, errors.New("draft model does not support caching")
+	}
+
+	if err := cache.Init(m.Backend(), kvCacheTypeFromStr(kvCacheType), numSlots, int(numCtx), batchSize); err != nil {
+		return nil, err
+	}
+
+	d := &draft{
+		model:     m,
+		cache:     cache,
+		numDraft:  min(numDraft, batchSize-1),
+		numCtx:    numCtx,
+		batchSize: batchSize,
+		inputs:    make([][]int32, numSlots),
+	}
+
+	if err := d.reserveWorstCaseGraph(numSlots); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *draft) reserveWorstCaseGraph(numSlots int) error {
+	ctx := d.model.Backend().NewContext()
+	defer ctx.Close()
+
+	var batch input.Batch
+	batch.Positions = make([]int32, d.batchSize)
+	batch.Sequences = make([]int, d.batchSize)
+	for i := range batch.Positions {
+		batch.Positions[i] = int32(i)
+	}
+
+	batch.Outputs = make([]int32, min(numSlots, d.batchSize))
+	for i := range batch.Outputs {
+		batch.Outputs[i] = int32(i)
+	}
+
+	batch.Inputs = ctx.Input().FromIntSlice(make([]int32, d.batchSize), d.batchSize)
+
+	if err := d.cache.StartForward(ctx, batch, true); err != nil {
+		return err
+	}
+
+	t, err := d.model.Forward(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	ctx.Forward(t).Reserve()
+
+	return nil
+}
+
+// propose sets the draft of each of seqs to the tokens that the draft model
+// predicts will follow it, choosing the most likely token at each step.
+// Sequences with multimodal inputs are not drafted.
+func (d *draft) propose(seqs []*Sequence) error {
+	type proposal struct {
+		seq *Sequence
+		n   int
+	}
+
+	var active []proposal
+	for _, seq := range seqs {
+		seq.draft = nil
+
+		history := make([]int32, 0, len(seq.cache.Inputs)+len(seq.inputs))
+		for _, inp := range slices.Concat(seq.cache.Inputs, seq.inputs) {
+			if inp.Multimodal != nil || inp.MultimodalHash != 0 {
+				history = nil
+				break
+			}
+			history = append(history, inp.Token)
+		}
+
+		// proposals must fit in the context without shifting it
+		n := min(d.numDraft, int(d.numCtx)-len(history))
+		if seq.numPredict > 0 {
+			n = min(n, seq.numPredict-seq.numPredicted-1)
+		}
+
+		if len(history) == 0 || n <= 0 {
+			continue
+		}
+
+		token, err := d.sync(seq.cache.Id, history)
+		if err != nil {
+			return err
+		}
+
+		seq.draft = append(seq.draft, token)
+		if n > 1 && !d.isEOS(token) {
+			active = append(active, proposal{seq: seq, n: n})
+		}
+	}
+
+	for len(active) > 0 {
+		var batchInputs []int32
+		var batch input.Batch
+		for i, p := range active {
+			slot := p.seq.cache.Id
+			token := p.seq.draft[len(p.seq.draft)-1]
+
+			batchInputs = append(batchInputs, token)
+			batch.Positions = append(batch.Positions, int32(len(d.inputs[slot])))
+			batch.Sequences = append(batch.Sequences, slot)
+			batch.Outputs = append(batch.Outputs, int32(i))
+			d.inputs[slot] = append(d.inputs[slot], token)
+		}
+
+		logits, err := d.forward(batchInputs, batch)
+		if err != nil {
+			return err
+		}
+
+		var next []proposal
+		for i, p := range active {
+			token := argmax(logits, i, len(active))
+			p.seq.draft = append(p.seq.draft, token)
+			if len(p.seq.draft) < p.n && !d.isEOS(token) {
+				next = append(next, p)
+			}
+		}
+		active = next
+	}
+
+	return nil
+}
+
+// sync brings the draft cache of slot up to date with history, evaluating
+// the inputs it does not already hold, and returns the most likely next token.
+func (d *draft) sync(slot int, history []int32) (int32, error) {
+	numPast := 0
+	for numPast < len(d.inputs[slot]) && numPast < len(history) && d.inputs[slot][numPast] == history[numPast] {
+		numPast++
+	}
+
+	// the last input is always evaluated to get its logits
+	numPast = min(numPast, len(history)-1)
+
+	if numPast < len(d.inputs[slot]) {
+		if err := d.cache.Remove(slot, int32(numPast), math.MaxInt32); err != nil {
+			// Some models don't support partial erasure
+			if err := d.cache.Remove(slot, 0, math.MaxInt32); err != nil {
+				return 0, err
+			}
+			numPast = 0
+		}
+		d.inputs[slot] = d.inputs[slot][:numPast]
+	}
+
+	var logits []float32
+	for numPast < len(history) {
+		end := min(numPast+d.batchSize, len(history))
+
+		var batch input.Batch
+		for i := numPast; i < end; i++ {
+			batch.Positions = append(batch.Positions, int32(i))
+			batch.Sequences = append(batch.Sequences, slot)
+		}
+		batch.Outputs = []int32{int32(end - numPast - 1)}
+
+		var err error
+		logits, err = d.forward(history[numPast:end], batch)
+		if err != nil {
+			return 0, err
+		}
+
+		d.inputs[slot] = append(d.inputs[slot], history[numPast:end]...)
+		numPast = end
+	}
+
+	return argmax(logits, 0, 1), nil
+}
+
+func (d *draft) forward(inputs []int32, batch input.Batch) ([]float32, error) {
+	ctx := d.model.Backend().NewContext()
+	defer ctx.Close()
+
+	t, err := model.Forward(ctx, d.model, inputs, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode draft batch: %w", err)
+	}
+
+	return t.Floats(), nil
+}
+
+func (d *draft) isEOS(token int32) bool {
+	return d.model.(model.TextProcessor).Is(token, model.SpecialEOS)
+}
+
+// argmax returns the most likely token in output i of logits, which holds n
+// outputs.
+func argmax(logits []float32, i, n int) int32 {
+	vocabSize := len(logits) / n
+	row := logits[i*vocabSize : (i+1)*vocabSize]
+
+	var best int
+	for j, v := range row {
+		if v > row[best] {
+			best = j
+		}
+	}
+
+	return int32(best)
+}
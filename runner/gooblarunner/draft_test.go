@@ -0,0 +1,364 @@
+package gooblarunner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/goobla/goobla/llm"
+	"github.com/goobla/goobla/ml"
+	"github.com/goobla/goobla/model"
+	"github.com/goobla/goobla/model/input"
+	"github.com/goobla/goobla/sample"
+)
+
+// testVocabulary has a token for each lowercase letter followed by an end
+// of sequence token.
+var testVocabulary = func() *model.Vocabulary {
+	v := model.Vocabulary{EOS: []int32{26}}
+	for c := 'a'; c <= 'z'; c++ {
+		v.Values = append(v.Values, string(c))
+	}
+	v.Values = append(v.Values, "</s>")
+	return &v
+}()
+
+const testEOS = 26
+
+// testTensor holds either the tokens of a batch or the logits computed from
+// them. Other methods are not implemented.
+type testTensor struct {
+	ml.Tensor
+	ints   []int32
+	floats []float32
+}
+
+func (t *testTensor) Floats() []float32 { return t.floats }
+
+type testContext struct{ ml.Context }
+
+func (c *testContext) Input() ml.Context                          { return c }
+func (c *testContext) Forward(...ml.Tensor) ml.Context            { return c }
+func (c *testContext) Compute(...ml.Tensor)                       {}
+func (c *testContext) Close()                                     {}
+func (c *testContext) FromIntSlice(s []int32, _ ...int) ml.Tensor { return &testTensor{ints: s} }
+
+type testBackend struct{ ml.Backend }
+
+func (testBackend) NewContext() ml.Context { return &testContext{} }
+
+// testModel predicts a single token after each input from the input and its
+// position, so its predictions don't depend on the rest of the cache.
+type testModel struct {
+	model.Base
+	next func(token, pos int32) int32
+}
+
+func newTestModel(cache *testCache, next func(token, pos int32) int32) *testModel {
+	m := testModel{next: next}
+	m.Cache = cache
+	return &m
+}
+
+func (m *testModel) Backend() ml.Backend { return testBackend{} }
+
+func (m *testModel) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	tokens := batch.Inputs.(*testTensor).ints
+	vocabSize := len(testVocabulary.Values)
+
+	logits := make([]float32, len(batch.Outputs)*vocabSize)
+	for i, o := range batch.Outputs {
+		logits[i*vocabSize+int(m.next(tokens[o], batch.Positions[o]))] = 1
+	}
+
+	return &testTensor{floats: logits}, nil
+}
+
+func (m *testModel) Encode(string, bool) ([]int32, error) { return nil, errors.New("not implemented") }
+
+func (m *testModel) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.WriteString(testVocabulary.Decode(id))
+	}
+	return sb.String(), nil
+}
+
+func (m *testModel) Is(id int32, special model.Special) bool { return testVocabulary.Is(id, special) }
+
+func (m *testModel) Vocabulary() *model.Vocabulary { return testVocabulary }
+
+// testCache records the token held at each position of each sequence.
+type testCache struct {
+	mockCache
+
+	// failPartial makes removing anything but a whole sequence fail
+	failPartial bool
+
+	tokens map[int][]int32
+}
+
+func (c *testCache) StartForward(ctx ml.Context, batch input.Batch, reserve bool) error {
+	tokens := batch.Inputs.(*testTensor).ints
+	for i, pos := range batch.Positions {
+		seq := batch.Sequences[i]
+		if int(pos) != len(c.tokens[seq]) {
+			return fmt.Errorf("sequence %d: position %d is not after the %d cached tokens", seq, pos, len(c.tokens[seq]))
+		}
+		c.tokens[seq] = append(c.tokens[seq], tokens[i])
+	}
+	return nil
+}
+
+func (c *testCache) Remove(seq int, beginIndex, endIndex int32) error {
+	if c.failPartial && beginIndex > 0 {
+		return errors.New("partial removal not supported")
+	}
+	c.tokens[seq] = c.tokens[seq][:min(int(beginIndex), len(c.tokens[seq]))]
+	return nil
+}
+
+type generateResult struct {
+	content    []string
+	doneReason []llm.DoneReason
+
+	// tokens in the input cache slot of each sequence
+	cached [][]int32
+
+	drafted, accepted int
+	draftDisabled     bool
+}
+
+// generate completes each of prompts in parallel, checking drafts from the
+// draft model if one is given.
+func generate(t *testing.T, prompts [][]int32, numPredict int, stop []string, next, draftNext func(token, pos int32) int32, failPartial bool) generateResult {
+	t.Helper()
+
+	mainCache := &testCache{failPartial: failPartial, tokens: make(map[int][]int32)}
+	s := &Server{
+		model:     newTestModel(mainCache, next),
+		parallel:  len(prompts),
+		batchSize: 8,
+		seqs:      make([]*Sequence, len(prompts)),
+		seqsSem:   semaphore.NewWeighted(int64(len(prompts))),
+		cache: &InputCache{
+			numCtx:  64,
+			enabled: true,
+			slots:   make([]InputCacheSlot, len(prompts)),
+			cache:   mainCache,
+		},
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	var draftCache *testCache
+	if draftNext != nil {
+		draftCache = &testCache{tokens: make(map[int][]int32)}
+		s.draft = &draft{
+			model:     newTestModel(draftCache, draftNext),
+			cache:     draftCache,
+			numDraft:  3,
+			numCtx:    s.cache.numCtx,
+			batchSize: s.batchSize,
+			inputs:    make([][]int32, len(prompts)),
+		}
+	}
+
+	seqs := make([]*Sequence, len(prompts))
+	for i, prompt := range prompts {
+		if err := s.seqsSem.Acquire(t.Context(), 1); err != nil {
+			t.Fatal(err)
+		}
+
+		var inputs []input.Input
+		for _, token := range prompt {
+			inputs = append(inputs, input.Input{Token: token})
+		}
+
+		s.cache.slots[i] = InputCacheSlot{Id: i, InUse: true}
+		seqs[i] = &Sequence{
+			inputs:          inputs,
+			numPromptInputs: len(inputs),
+			cache:           &s.cache.slots[i],
+			numPredict:      numPredict,
+			responses:       make(chan response, 100),
+			quit:            make(chan bool, 1),
+			embedding:       make(chan []float32, 1),
+			sampler:         sample.NewSamplerFromConfig(sample.Config{}, nil),
+			stop:            stop,
+		}
+		s.seqs[i] = seqs[i]
+	}
+
+	for !s.allNil() {
+		if err := s.processBatch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var result generateResult
+	for i, seq := range seqs {
+		var sb strings.Builder
+		for r := range seq.responses {
+			sb.WriteString(r.content)
+		}
+
+		var cached []int32
+		for _, inp := range seq.cache.Inputs {
+			cached = append(cached, inp.Token)
+		}
+
+		// the slot holds the prompt and the tokens returned, other than the
+		// last one if the sequence ran out of tokens to predict, as each
+		// token is a single character
+		want := slices.Clone(prompts[i])
+		for _, c := range sb.String() {
+			want = append(want, c-'a')
+		}
+		if seq.doneReason == llm.DoneReasonLength {
+			want = want[:len(want)-1]
+		}
+		if !slices.Equal(cached, want) {
+			t.Errorf("sequence %d: slot holds %v, want %v", i, cached, want)
+		}
+
+		// the KV cache may hold more than the slot after a stop sequence,
+		// which is removed when the slot is next used
+		kv := mainCache.tokens[i]
+		if len(kv) < len(cached) || !cmp.Equal(kv[:len(cached)], cached) {
+			t.Errorf("sequence %d: KV cache holds %v, want it to start with the slot's inputs %v", i, kv, cached)
+		}
+
+		if draftCache != nil && s.draft != nil && !cmp.Equal(draftCache.tokens[i], s.draft.inputs[i]) {
+			t.Errorf("sequence %d: draft KV cache holds %v, want %v", i, draftCache.tokens[i], s.draft.inputs[i])
+		}
+
+		result.content = append(result.content, sb.String())
+		result.doneReason = append(result.doneReason, seq.doneReason)
+		result.cached = append(result.cached, cached)
+		result.drafted += seq.numDrafted
+		result.accepted += seq.numAccepted
+	}
+	result.draftDisabled = draftNext != nil && s.draft == nil
+
+	return result
+}
+
+func TestSpeculativeDecoding(t *testing.T) {
+	next := func(token, pos int32) int32 {
+		return (7*token + pos) % 26
+	}
+
+	// the draft agrees with the model except at every fourth position
+	draftNext := func(token, pos int32) int32 {
+		if pos%4 == 0 {
+			return (next(token, pos) + 1) % 26
+		}
+		return next(token, pos)
+	}
+
+	prompts := [][]int32{{1, 2, 3}, {4, 5, 6, 7, 8}}
+
+	// a stop sequence made of tokens from the middle of the first completion
+	reference := generate(t, prompts, 20, nil, next, nil, false)
+	stop := reference.content[0][9:12]
+
+	eosNext := func(token, pos int32) int32 {
+		if pos == 14 {
+			return testEOS
+		}
+		return next(token, pos)
+	}
+
+	cases := []struct {
+		name        string
+		numPredict  int
+		stop        []string
+		next        func(token, pos int32) int32
+		draftNext   func(token, pos int32) int32
+		failPartial bool
+
+		wantDisabled bool
+	}{
+		{
+			name:       "partial acceptance",
+			numPredict: 20,
+			next:       next,
+			draftNext:  draftNext,
+		},
+		{
+			name:       "full acceptance",
+			numPredict: 20,
+			next:       next,
+			draftNext:  next,
+		},
+		{
+			name:       "stop sequence",
+			numPredict: 20,
+			stop:       []string{stop},
+			next:       next,
+			draftNext:  next,
+		},
+		{
+			name:       "end of sequence",
+			numPredict: 20,
+			next:       eosNext,
+			draftNext:  next,
+		},
+		{
+			name:       "num predict",
+			numPredict: 5,
+			next:       next,
+			draftNext:  next,
+		},
+		{
+			name:         "no partial removal",
+			numPredict:   20,
+			next:         next,
+			draftNext:    draftNext,
+			failPartial:  true,
+			wantDisabled: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			want := generate(t, prompts, tt.numPredict, tt.stop, tt.next, nil, false)
+			got := generate(t, prompts, tt.numPredict, tt.stop, tt.next, tt.draftNext, tt.failPartial)
+
+			if diff := cmp.Diff(want.content, got.content); diff != "" {
+				t.Errorf("content mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(want.doneReason, got.doneReason); diff != "" {
+				t.Errorf("done reason mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(want.cached, got.cached); diff != "" {
+				t.Errorf("cached inputs mismatch (-want +got):\n%s", diff)
+			}
+
+			if got.draftDisabled != tt.wantDisabled {
+				t.Errorf("draft disabled = %v, want %v", got.draftDisabled, tt.wantDisabled)
+			}
+			if got.drafted == 0 {
+				t.Error("expected tokens to be drafted")
+			}
+		})
+	}
+
+	t.Run("acceptance", func(t *testing.T) {
+		got := generate(t, prompts, 20, nil, next, draftNext, false)
+		if got.accepted == 0 || got.accepted >= got.drafted {
+			t.Errorf("accepted %d of %d drafted tokens, want some but not all", got.accepted, got.drafted)
+		}
+
+		got = generate(t, prompts, 20, nil, next, next, false)
+		if got.accepted != got.drafted {
+			t.Errorf("accepted %d of %d drafted tokens, want all", got.accepted, got.drafted)
+		}
+	})
+}
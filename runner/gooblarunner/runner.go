@@ -11,11 +11,14 @@ import (
 	"image"
 	"log"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +30,7 @@ import (
 
 	"github.com/goobla/goobla/api"
 	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/kvcache"
 	"github.com/goobla/goobla/llm"
 	"github.com/goobla/goobla/logutil"
 	"github.com/goobla/goobla/ml"
@@ -34,6 +38,7 @@ import (
 	"github.com/goobla/goobla/model/input"
 	"github.com/goobla/goobla/runner/common"
 	"github.com/goobla/goobla/sample"
+	"github.com/goobla/goobla/tracing"
 
 	_ "github.com/goobla/goobla/model/models"
 )
@@ -58,11 +63,14 @@ type Sequence struct {
 	// tokens that have been generated but not returned yet (e.g. for stop sequences)
 	pendingResponses []string
 
+	// log probabilities of the tokens in pendingResponses, if requested
+	pendingLogprobs []api.Logprob
+
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
 	// channel to send responses over
-	responses chan string
+	responses chan response
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
@@ -73,6 +81,11 @@ type Sequence struct {
 	// sampler with transforms to run on generated logits
 	sampler sample.Sampler
 
+	// whether to return log probabilities of generated tokens and
+	// how many of the most likely alternatives to include
+	logprobs    bool
+	topLogprobs int
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
@@ -85,6 +98,18 @@ type Sequence struct {
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
+	// sequences that generate other completions of this prompt, started with
+	// a copy of this sequence's cache once the prompt has been processed
+	forks []*Sequence
+
+	// name of the prefix to pin this sequence's cache as once its prompt
+	// has been processed
+	pin string
+
+	// tokens proposed by the draft model that follow the last generated
+	// token, which are checked by the main model in the next batch
+	draft []int32
+
 	doneReason llm.DoneReason
 
 	// Metrics
@@ -92,14 +117,26 @@ type Sequence struct {
 	startGenerationTime time.Time
 	numPredicted        int
 	numPromptInputs     int
+	numDrafted          int
+	numAccepted         int
+}
+
+// response is a chunk of generated text along with the log probabilities
+// of the tokens that make it up
+type response struct {
+	content  string
+	logprobs []api.Logprob
 }
 
 type NewSequenceParams struct {
-	numPredict int
-	stop       []string
-	numKeep    int32
-	sampler    sample.Sampler
-	embedding  bool
+	numPredict  int
+	stop        []string
+	numKeep     int32
+	sampler     sample.Sampler
+	logprobs    bool
+	topLogprobs int
+	embedding   bool
+	audios      []llm.AudioData
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -107,7 +144,7 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 
 	startTime := time.Now()
 
-	inputs, ctxs, mmStore, err := s.inputs(prompt, images)
+	inputs, ctxs, mmStore, err := s.inputs(prompt, images, params.audios)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process inputs: %w", err)
 	} else if len(inputs) == 0 {
@@ -160,6 +197,13 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 
 	// TODO(jessegross): Ingest cached history for grammar
 
+	// the prompt counts towards repetition penalties, as in the llama runner
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			params.sampler.Accept(inp.Token)
+		}
+	}
+
 	return &Sequence{
 		ctxs:                ctxs,
 		mmStore:             mmStore,
@@ -168,20 +212,50 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan response, 100),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		sampler:             params.sampler,
+		logprobs:            params.logprobs,
+		topLogprobs:         params.topLogprobs,
 		embeddingOnly:       params.embedding,
 		stop:                params.stop,
 		numKeep:             params.numKeep,
 	}, nil
 }
 
-// inputs processes the prompt and images into a list of inputs
-// by splitting the prompt on [img-<n>] tags, tokenizing text and
-// decoding images
-func (s *Server) inputs(prompt string, images []llm.ImageData) ([]input.Input, []ml.Context, multimodalStore, error) {
+// fork returns a sequence that generates an independent completion of seq's
+// prompt using sampler. It is started by processBatch once seq has processed
+// the prompt, so the prompt is only evaluated once.
+func (seq *Sequence) fork(sampler sample.Sampler) *Sequence {
+	for _, inp := range seq.inputs {
+		if inp.Multimodal == nil {
+			sampler.Accept(inp.Token)
+		}
+	}
+
+	f := &Sequence{
+		ctxs:             seq.ctxs,
+		mmStore:          seq.mmStore,
+		numPredict:       seq.numPredict,
+		pendingResponses: make([]string, 0),
+		responses:        make(chan response, 100),
+		quit:             make(chan bool, 1),
+		embedding:        make(chan []float32, 1),
+		sampler:          sampler,
+		logprobs:         seq.logprobs,
+		topLogprobs:      seq.topLogprobs,
+		stop:             seq.stop,
+		numKeep:          seq.numKeep,
+	}
+	seq.forks = append(seq.forks, f)
+	return f
+}
+
+// inputs processes the prompt, images and audios into a list of inputs
+// by splitting the prompt on [img-<n>] and [audio-<n>] tags, tokenizing
+// text and encoding images and audios
+func (s *Server) inputs(prompt string, images []llm.ImageData, audios []llm.AudioData) ([]input.Input, []ml.Context, multimodalStore, error) {
 	var inputs []input.Input
 	var ctxs []ml.Context
 	var mmStore multimodalStore
@@ -190,9 +264,18 @@ func (s *Server) inputs(prompt string, images []llm.ImageData) ([]input.Input, [
 	var matches [][]string
 
 	multimodalProcessor, visionModel := s.model.(model.MultimodalProcessor)
+	audioProcessor, audioModel := s.model.(model.AudioProcessor)
 
+	var tags []string
 	if visionModel {
-		re := regexp.MustCompile(`\[img-(\d+)\]`)
+		tags = append(tags, "img")
+	}
+	if audioModel {
+		tags = append(tags, "audio")
+	}
+
+	if len(tags) > 0 {
+		re := regexp.MustCompile(`\[(` + strings.Join(tags, "|") + `)-(\d+)\]`)
 		parts = re.Split(prompt, -1)
 		matches = re.FindAllStringSubmatch(prompt, -1)
 		mmStore = newMultimodalStore()
@@ -212,44 +295,66 @@ func (s *Server) inputs(prompt string, images []llm.ImageData) ([]input.Input, [
 			inputs = append(inputs, input.Input{Token: t})
 		}
 
-		// image - decode and store
+		// image or audio - decode and store
 		if i < len(matches) {
-			n, _ := strconv.Atoi(matches[i][1])
-
-			imageIndex := -1
-			for j := range images {
-				if images[j].ID == n {
-					imageIndex = j
-					break
+			kind := matches[i][1]
+			n, _ := strconv.Atoi(matches[i][2])
+
+			var data []byte
+			if kind == "img" {
+				for j := range images {
+					if images[j].ID == n {
+						data = images[j].Data
+						break
+					}
+				}
+				if data == nil {
+					return nil, nil, nil, fmt.Errorf("invalid image index: %d", n)
+				}
+			} else {
+				for j := range audios {
+					if audios[j].ID == n {
+						data = audios[j].Data
+						break
+					}
+				}
+				if data == nil {
+					return nil, nil, nil, fmt.Errorf("invalid audio index: %d", n)
 				}
-			}
-
-			if imageIndex < 0 {
-				return nil, nil, nil, fmt.Errorf("invalid image index: %d", n)
 			}
 
 			ctx := s.model.Backend().NewContext()
 			runtime.SetFinalizer(ctx, func(c ml.Context) { c.Close() })
 			ctxs = append(ctxs, ctx)
-			imageEmbeddings, err := multimodalProcessor.EncodeMultimodal(ctx, images[imageIndex].Data)
+
+			var embeddings []input.Multimodal
+			if kind == "img" {
+				embeddings, err = multimodalProcessor.EncodeMultimodal(ctx, data)
+			} else {
+				embeddings, err = audioProcessor.EncodeAudio(ctx, data)
+			}
 			if err != nil {
 				return nil, nil, nil, err
 			}
 
 			s.multimodalHash.Reset()
-			_, _ = s.multimodalHash.Write(images[imageIndex].Data)
-			imageHash := s.multimodalHash.Sum64()
+			_, _ = s.multimodalHash.Write(data)
+			hash := s.multimodalHash.Sum64()
 
-			mmStore.addMultimodal(imageEmbeddings)
+			mmStore.addMultimodal(embeddings)
 
-			inputs = append(inputs, input.Input{Multimodal: imageEmbeddings, MultimodalHash: imageHash})
+			inputs = append(inputs, input.Input{Multimodal: embeddings, MultimodalHash: hash})
 			postTokenize = true
 		}
 	}
 
-	if visionModel && postTokenize {
+	if postTokenize {
 		var err error
-		inputs, err = multimodalProcessor.PostTokenize(inputs)
+		if visionModel {
+			inputs, err = multimodalProcessor.PostTokenize(inputs)
+		} else {
+			inputs, err = audioProcessor.PostTokenize(inputs)
+		}
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -296,6 +401,9 @@ type Server struct {
 	// KV cache
 	cache *InputCache
 
+	// draft model for speculative decoding, if any
+	draft *draft
+
 	// next sequence for prompt processing to avoid starvation
 	nextSeq int
 
@@ -315,7 +423,9 @@ func (s *Server) allNil() bool {
 
 func flushPending(seq *Sequence) bool {
 	joined := strings.Join(seq.pendingResponses, "")
+	logprobs := seq.pendingLogprobs
 	seq.pendingResponses = []string{}
+	seq.pendingLogprobs = nil
 
 	// Check if there are any partial UTF-8 characters remaining.
 	// We already check and queue as we are generating but some may
@@ -327,12 +437,12 @@ func flushPending(seq *Sequence) bool {
 		joined = joined[:len(joined)-1]
 	}
 
-	if len(joined) == 0 {
+	if len(joined) == 0 && len(logprobs) == 0 {
 		return true
 	}
 
 	select {
-	case seq.responses <- joined:
+	case seq.responses <- response{content: joined, logprobs: logprobs}:
 		return true
 	case <-seq.quit:
 		return false
@@ -346,9 +456,40 @@ func (s *Server) removeSequence(seqIndex int, reason llm.DoneReason) {
 	seq.doneReason = reason
 	close(seq.responses)
 	close(seq.embedding)
-	seq.cache.InUse = false
 	s.seqs[seqIndex] = nil
-	s.seqsSem.Release(1)
+
+	// pinned caches keep their reservation until they are unpinned
+	if seq.pin != "" && len(seq.cache.Inputs) >= seq.numPromptInputs {
+		s.cache.PinCacheSlot(seq.cache, seq.pin)
+	} else {
+		seq.cache.InUse = false
+		s.seqsSem.Release(1)
+	}
+
+	// forks that have not started yet never will
+	for _, f := range seq.forks {
+		f.doneReason = reason
+		close(f.responses)
+		close(f.embedding)
+		f.cache.InUse = false
+		s.seqsSem.Release(1)
+	}
+	seq.forks = nil
+}
+
+// startForks starts the forks of seq, which has just processed its prompt,
+// from a copy of its cache. Each fork samples from the same logits as seq in
+// this batch.
+func (s *Server) startForks(seq *Sequence) {
+	for _, f := range seq.forks {
+		s.cache.ForkCacheSlot(seq.cache, f.cache)
+		f.iBatch = seq.iBatch
+		f.startProcessingTime = time.Now()
+
+		// forks hold a semaphore reservation so there is always a free entry
+		s.seqs[slices.Index(s.seqs, nil)] = f
+	}
+	seq.forks = nil
 }
 
 func (s *Server) run(ctx context.Context) {
@@ -376,6 +517,12 @@ func (s *Server) processBatch() error {
 	ctx := s.model.Backend().NewContext()
 	defer ctx.Close()
 
+	if s.draft != nil {
+		if err := s.proposeDrafts(); err != nil {
+			return err
+		}
+	}
+
 	var batchInputs []int32
 	var batch input.Batch
 
@@ -456,8 +603,11 @@ func (s *Server) processBatch() error {
 			batch.Positions = append(batch.Positions, int32(len(seq.cache.Inputs)+len(seq.pendingInputs)))
 			batch.Sequences = append(batch.Sequences, seq.cache.Id)
 
-			seq.iBatch = len(batch.Outputs)
-			if i+1 == len(seq.inputs) {
+			// the last input and each draft token after it are sampled
+			if sampled := len(seq.inputs) - len(seq.draft) - 1; i >= sampled {
+				if i == sampled {
+					seq.iBatch = len(batch.Outputs)
+				}
 				batch.Outputs = append(batch.Outputs, int32(len(batchInputs)-1))
 			}
 			seq.pendingInputs = append(seq.pendingInputs, inp)
@@ -483,7 +633,7 @@ func (s *Server) processBatch() error {
 
 	logits := modelOutput.Floats()
 
-	for i, seq := range s.seqs {
+	for _, seq := range s.seqs {
 		if seq == nil {
 			continue
 		}
@@ -494,6 +644,16 @@ func (s *Server) processBatch() error {
 			seq.pendingInputs = []input.Input{}
 		}
 
+		if len(seq.forks) > 0 && len(seq.inputs) == 0 {
+			s.startForks(seq)
+		}
+	}
+
+	for i, seq := range s.seqs {
+		if seq == nil {
+			continue
+		}
+
 		// don't sample prompt processing
 		if len(seq.inputs) != 0 {
 			if !s.cache.enabled {
@@ -515,77 +675,205 @@ func (s *Server) processBatch() error {
 			continue
 		}
 
-		// sample a token
 		vocabSize := len(logits) / len(batch.Outputs)
 
-		token, err := seq.sampler.Sample(logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize])
-		if err != nil {
-			return fmt.Errorf("failed to sample token: %w", err)
+		// The draft tokens are already in the cache. Sample from the logits
+		// following each of them in turn for as long as the sampled token
+		// matches the draft, then remove the rejected tokens from the cache.
+		drafted := seq.draft
+		seq.draft = nil
+		numCached := len(seq.cache.Inputs) - len(drafted)
+
+		for j := 0; j <= len(drafted); j++ {
+			if j > 0 {
+				seq.numPredicted++
+				seq.numAccepted++
+			}
+
+			seq.cache.Inputs = seq.cache.Inputs[:numCached+j]
+
+			seqLogits := logits[(seq.iBatch+j)*vocabSize : (seq.iBatch+j+1)*vocabSize]
+			token, err := seq.sampler.Sample(seqLogits)
+			if err != nil {
+				return fmt.Errorf("failed to sample token: %w", err)
+			}
+			seq.sampler.Accept(token)
+
+			running, err := s.emit(i, seq, seqLogits, token)
+			if err != nil {
+				return err
+			}
+
+			if !running || j == len(drafted) || token != drafted[j] {
+				break
+			}
 		}
 
-		// if it's an end of sequence token, break
-		if s.model.(model.TextProcessor).Is(token, model.SpecialEOS) {
-			// TODO (jmorganca): we should send this back
-			// as it's important for the /api/generate context
-			// seq.responses <- piece
+		if len(drafted) > 0 {
+			seq.numDrafted += len(drafted)
+			if err := s.cache.cache.Remove(seq.cache.Id, int32(len(seq.cache.Inputs)), math.MaxInt32); err != nil {
+				// Some models don't support partial erasure, so evaluate the
+				// whole sequence again without drafting
+				slog.Warn("model does not support removing draft tokens, disabling draft model", "error", err)
+				s.draft = nil
 
-			s.removeSequence(i, llm.DoneReasonStop)
+				if err := s.cache.cache.Remove(seq.cache.Id, 0, math.MaxInt32); err != nil {
+					return err
+				}
+
+				if s.seqs[i] != nil {
+					seq.inputs = append(slices.Clone(seq.cache.Inputs), seq.inputs...)
+				}
+				seq.cache.Inputs = []input.Input{}
+			}
+		}
+	}
+
+	return nil
+}
+
+// proposeDrafts runs the draft model for the sequences that are generating
+// and adds its proposals to their inputs, to be checked in the next batch.
+func (s *Server) proposeDrafts() error {
+	var seqs []*Sequence
+	for _, seq := range s.seqs {
+		if seq == nil || seq.embeddingOnly || seq.numPredicted == 0 ||
+			len(seq.inputs) != 1 || len(seq.pendingInputs) != 0 || len(seq.draft) != 0 {
 			continue
 		}
+		seqs = append(seqs, seq)
+	}
 
-		piece, err := s.model.(model.TextProcessor).Decode([]int32{token})
-		if err != nil {
-			return err
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	if err := s.draft.propose(seqs); err != nil {
+		return err
+	}
+
+	for _, seq := range seqs {
+		if len(seq.draft) == 0 {
+			continue
 		}
 
-		seq.inputs = []input.Input{{Token: token}}
+		// the main model checks all of the proposals in the same batch
+		seq.inputs[0].SameBatch = len(seq.draft)
+		for _, t := range seq.draft {
+			seq.inputs = append(seq.inputs, input.Input{Token: t})
+		}
+	}
+
+	return nil
+}
 
-		seq.pendingResponses = append(seq.pendingResponses, piece)
-		sequence := strings.Join(seq.pendingResponses, "")
+// emit handles the token sampled for the sequence at index i, sending it
+// unless it ends the sequence. It reports whether the sequence is still
+// running.
+func (s *Server) emit(i int, seq *Sequence, logits []float32, token int32) (bool, error) {
+	// if it's an end of sequence token, break
+	if s.model.(model.TextProcessor).Is(token, model.SpecialEOS) {
+		// TODO (jmorganca): we should send this back
+		// as it's important for the /api/generate context
+		// seq.responses <- piece
+
+		s.removeSequence(i, llm.DoneReasonStop)
+		return false, nil
+	}
 
-		if ok, stop := common.FindStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+	piece, err := s.model.(model.TextProcessor).Decode([]int32{token})
+	if err != nil {
+		return false, err
+	}
 
-			var tokenTruncated bool
-			origLen := len(seq.pendingResponses)
-			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
-			newLen := len(seq.pendingResponses)
+	seq.inputs = []input.Input{{Token: token}}
 
-			// Update the cache based on the tokens that will be returned:
-			// - We have 1 token more than is currently in the cache because
-			// the last one generated wasn't submitted to Decode
-			// - Remove any stop sequences that we stripped out
-			// - If truncateStop removed a portion of a token, drop that
-			// - As defense-in-depth, if truncatedToken didn't find a stop token
-			// remove the extra one that we added to the cache len
-			tokenLen := len(seq.cache.Inputs) + 1
-			tokenLen -= origLen - newLen
-			if tokenTruncated || origLen == newLen {
-				tokenLen--
-			}
-			seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
+	seq.pendingResponses = append(seq.pendingResponses, piece)
+	sequence := strings.Join(seq.pendingResponses, "")
 
-			s.removeSequence(i, llm.DoneReasonStop)
-			continue
+	if seq.logprobs {
+		logprob, err := s.logprob(logits, token, piece, seq.topLogprobs)
+		if err != nil {
+			return false, err
 		}
+		seq.pendingLogprobs = append(seq.pendingLogprobs, logprob)
+	}
 
-		if common.ContainsStopSuffix(sequence, seq.stop) {
-			continue
+	if ok, stop := common.FindStop(sequence, seq.stop); ok {
+		slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+
+		var tokenTruncated bool
+		origLen := len(seq.pendingResponses)
+		seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
+		newLen := len(seq.pendingResponses)
+		if len(seq.pendingLogprobs) > newLen {
+			seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
 		}
 
-		if common.IncompleteUnicode(sequence) {
-			continue
+		// Update the cache based on the tokens that will be returned:
+		// - We have 1 token more than is currently in the cache because
+		// the last one generated wasn't submitted to Decode
+		// - Remove any stop sequences that we stripped out
+		// - If truncateStop removed a portion of a token, drop that
+		// - As defense-in-depth, if truncatedToken didn't find a stop token
+		// remove the extra one that we added to the cache len
+		tokenLen := len(seq.cache.Inputs) + 1
+		tokenLen -= origLen - newLen
+		if tokenTruncated || origLen == newLen {
+			tokenLen--
 		}
+		seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
+
+		s.removeSequence(i, llm.DoneReasonStop)
+		return false, nil
+	}
+
+	if common.ContainsStopSuffix(sequence, seq.stop) {
+		return true, nil
+	}
+
+	if common.IncompleteUnicode(sequence) {
+		return true, nil
+	}
+
+	if !flushPending(seq) {
+		s.removeSequence(i, llm.DoneReasonConnectionClosed)
+		return false, nil
+	}
+
+	return true, nil
+}
 
-		if !flushPending(seq) {
-			s.removeSequence(i, llm.DoneReasonConnectionClosed)
+// logprob returns the log probability of the sampled token along with its
+// n most likely alternatives
+func (s *Server) logprob(logits []float32, token int32, piece string, n int) (api.Logprob, error) {
+	selected, top := sample.Logprobs(logits, token, n)
+
+	logprob := api.Logprob{TokenLogprob: tokenLogprob(piece, selected.Logprob)}
+	for _, t := range top {
+		piece, err := s.model.(model.TextProcessor).Decode([]int32{t.ID})
+		if err != nil {
+			return api.Logprob{}, err
 		}
+		logprob.TopLogprobs = append(logprob.TopLogprobs, tokenLogprob(piece, t.Logprob))
 	}
 
-	return nil
+	return logprob, nil
+}
+
+func tokenLogprob(piece string, logprob float32) api.TokenLogprob {
+	bytes := make([]int, len(piece))
+	for i := range len(piece) {
+		bytes[i] = int(piece[i])
+	}
+	return api.TokenLogprob{Token: piece, Logprob: float64(logprob), Bytes: bytes}
 }
 
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "runner.completion")
+	span.SetKind(tracing.KindServer)
+	defer span.End()
+
 	var req llm.CompletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -597,6 +885,21 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		req.Options = &opts
 	}
 
+	logitBias, err := common.LogitBias(req.Options.LogitBias, len(s.model.(model.TextProcessor).Vocabulary().Values))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n := max(req.N, 1)
+	s.mu.Lock()
+	parallel := s.parallel - s.cache.NumPinned()
+	s.mu.Unlock()
+	if n > parallel {
+		http.Error(w, fmt.Sprintf("n (%d) exceeds the number of parallel sequences (%d)", n, parallel), http.StatusBadRequest)
+		return
+	}
+
 	// Set the headers to indicate streaming
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Transfer-Encoding", "chunked")
@@ -607,39 +910,66 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var grammar *sample.GrammarSampler
-	var err error
-	if req.Grammar != "" {
-		grammar, err = sample.NewGrammarSampler(s.model.(model.TextProcessor), req.Grammar)
-		if err != nil {
-			http.Error(w, "failed to load model vocabulary required for format", http.StatusInternalServerError)
-			return
-		}
-		defer grammar.Free()
+	repeatLastN := req.Options.RepeatLastN
+	if repeatLastN < 0 {
+		repeatLastN = int(s.cache.numCtx)
 	}
 
-	sampler := sample.NewSamplerFromConfig(sample.Config{
-		Temperature: req.Options.Temperature,
-		TopK:        req.Options.TopK,
-		TopP:        req.Options.TopP,
-		MinP:        req.Options.MinP,
-		Seed:        req.Options.Seed,
-	}, grammar)
+	// each completion has its own sampler and grammar state
+	samplers := make([]sample.Sampler, n)
+	for i := range samplers {
+		var grammar *sample.GrammarSampler
+		if req.Grammar != "" {
+			grammar, err = sample.NewGrammarSampler(s.model.(model.TextProcessor), req.Grammar)
+			if err != nil {
+				http.Error(w, "failed to load model vocabulary required for format", http.StatusInternalServerError)
+				return
+			}
+			defer grammar.Free()
+		}
+
+		// offset the seed so completions differ but remain reproducible
+		seed := req.Options.Seed
+		if seed != -1 {
+			seed += i
+		}
+
+		samplers[i] = sample.NewSamplerFromConfig(sample.Config{
+			Temperature:      req.Options.Temperature,
+			TopK:             req.Options.TopK,
+			TopP:             req.Options.TopP,
+			MinP:             req.Options.MinP,
+			Seed:             seed,
+			RepeatLastN:      repeatLastN,
+			RepeatPenalty:    req.Options.RepeatPenalty,
+			PresencePenalty:  req.Options.PresencePenalty,
+			FrequencyPenalty: req.Options.FrequencyPenalty,
+			LogitBias:        logitBias,
+		}, grammar)
+	}
 
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
-		numPredict: req.Options.NumPredict,
-		stop:       req.Options.Stop,
-		numKeep:    int32(req.Options.NumKeep),
-		sampler:    sampler,
-		embedding:  false,
+		numPredict:  req.Options.NumPredict,
+		stop:        req.Options.Stop,
+		numKeep:     int32(req.Options.NumKeep),
+		sampler:     samplers[0],
+		logprobs:    req.Logprobs || req.TopLogprobs > 0,
+		topLogprobs: req.TopLogprobs,
+		embedding:   false,
+		audios:      req.Audios,
 	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Ensure there is a place to put the sequence, released when removed from s.seqs
-	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+	seqs := []*Sequence{seq}
+	for _, sampler := range samplers[1:] {
+		seqs = append(seqs, seq.fork(sampler))
+	}
+
+	// Ensure there is a place to put the sequences, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), int64(n)); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting completion request due to client closing the connection")
 		} else {
@@ -655,11 +985,25 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs)
 			if err != nil {
 				s.mu.Unlock()
-				s.seqsSem.Release(1)
+				s.seqsSem.Release(int64(n))
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
 				return
 			}
 
+			for j, f := range seq.forks {
+				f.cache, err = s.cache.ReserveCacheSlot()
+				if err != nil {
+					seq.cache.InUse = false
+					for _, f := range seq.forks[:j] {
+						f.cache.InUse = false
+					}
+					s.mu.Unlock()
+					s.seqsSem.Release(int64(n))
+					http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
+
 			s.seqs[i] = seq
 			s.cond.Signal()
 			found = true
@@ -669,43 +1013,249 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	s.mu.Unlock()
 
 	if !found {
-		s.seqsSem.Release(1)
+		s.seqsSem.Release(int64(n))
 		http.Error(w, "could not find an available sequence", http.StatusInternalServerError)
 		return
 	}
 
-	for {
+	quit := func() {
+		for _, seq := range seqs {
+			close(seq.quit)
+		}
+	}
+
+	// prompt processing ends when the first token of any sequence is
+	// generated, after which the remaining time is spent decoding
+	_, promptSpan := tracing.Start(ctx, "runner.prompt")
+	var decodeSpan *tracing.Span
+	generating := false
+	defer func() {
+		promptSpan.End()
+		decodeSpan.End()
+	}()
+
+	// merge the responses of all sequences, tagged with their index
+	type indexedResponse struct {
+		index int
+		resp  response
+		ok    bool
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	responses := make(chan indexedResponse)
+	for i, seq := range seqs {
+		go func() {
+			for {
+				resp, ok := <-seq.responses
+				select {
+				case responses <- indexedResponse{index: i, resp: resp, ok: ok}:
+				case <-done:
+					return
+				}
+
+				if !ok {
+					return
+				}
+			}
+		}()
+	}
+
+	var evalCount int
+	for remaining := n; remaining > 0; {
 		select {
 		case <-r.Context().Done():
-			close(seq.quit)
+			quit()
 			return
-		case content, ok := <-seq.responses:
-			if ok {
+		case ir := <-responses:
+			if !generating {
+				generating = true
+				promptSpan.End()
+				_, decodeSpan = tracing.Start(ctx, "runner.decode")
+			}
+
+			if ir.ok {
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
+					Index:    ir.index,
+					Content:  ir.resp.content,
+					Logprobs: ir.resp.logprobs,
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
-					close(seq.quit)
+					quit()
 					return
 				}
 
 				flusher.Flush()
 			} else {
+				// forks report the prompt evaluation they share with seq
+				f := seqs[ir.index]
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
+					Index:              ir.index,
 					Done:               true,
-					DoneReason:         seq.doneReason,
+					DoneReason:         f.doneReason,
 					PromptEvalCount:    seq.numPromptInputs,
 					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
-					EvalCount:          seq.numPredicted,
-					EvalDuration:       time.Since(seq.startGenerationTime),
+					EvalCount:          f.numPredicted,
+					EvalDuration:       time.Since(f.startGenerationTime),
+					DraftCount:         f.numDrafted,
+					DraftAcceptedCount: f.numAccepted,
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
+					quit()
+					return
 				}
 
-				return
+				flusher.Flush()
+				remaining--
+				evalCount += f.numPredicted
 			}
 		}
 	}
+
+	span.SetAttributes("prompt_eval_count", seq.numPromptInputs, "eval_count", evalCount)
+}
+
+// pin evaluates a prompt and pins its cache as a named prefix, which later
+// prompts starting with it are forked from.
+func (s *Server) pin(w http.ResponseWriter, r *http.Request) {
+	var req llm.PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	} else if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	s.ready.Wait()
+
+	if !s.cache.enabled {
+		http.Error(w, "model does not support caching", http.StatusBadRequest)
+		return
+	}
+
+	inputs, _, _, err := s.inputs(req.Prompt, nil, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to process inputs: %v", err), http.StatusBadRequest)
+		return
+	} else if int32(len(inputs)) >= s.cache.numCtx {
+		http.Error(w, fmt.Sprintf("prefix of %d tokens does not fit in a context of %d", len(inputs), s.cache.numCtx), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if slot := s.cache.PinnedSlot(req.Name); slot != nil {
+		if len(slot.Inputs) == len(inputs) && countCommonPrefix(slot.Inputs, inputs) == int32(len(inputs)) {
+			resp := llm.PinnedPrefix{Name: req.Name, Tokens: len(slot.Inputs), Size: s.cache.SlotSize(slot)}
+			s.mu.Unlock()
+			writePinned(w, resp)
+			return
+		}
+
+		s.cache.UnpinCacheSlot(req.Name)
+		s.seqsSem.Release(1)
+	}
+
+	// at least one slot has to remain for completions
+	if s.parallel-s.cache.NumPinned() < 2 {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("no cache slots left to pin a prefix (parallel: %d pinned: %d)", s.parallel, s.cache.NumPinned()), http.StatusConflict)
+		return
+	}
+	s.mu.Unlock()
+
+	seq, err := s.NewSequence(req.Prompt, nil, NewSequenceParams{
+		numPredict: 1,
+		numKeep:    -1,
+		sampler:    sample.NewSamplerFromConfig(sample.Config{}, nil),
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		return
+	}
+	seq.pin = req.Name
+
+	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to acquire semaphore: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// the semaphore guarantees a free entry
+	s.mu.Lock()
+	i := slices.Index(s.seqs, nil)
+	seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs)
+	if err != nil {
+		s.mu.Unlock()
+		s.seqsSem.Release(1)
+		http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.seqs[i] = seq
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	// the single token generated after the prompt is discarded
+	for range seq.responses {
+	}
+
+	s.mu.Lock()
+	slot := s.cache.PinnedSlot(req.Name)
+	var resp llm.PinnedPrefix
+	if slot != nil {
+		resp = llm.PinnedPrefix{Name: req.Name, Tokens: len(slot.Inputs), Size: s.cache.SlotSize(slot)}
+	}
+	s.mu.Unlock()
+
+	if slot == nil {
+		http.Error(w, "failed to process prefix", http.StatusInternalServerError)
+		return
+	}
+
+	writePinned(w, resp)
+}
+
+func writePinned(w http.ResponseWriter, resp llm.PinnedPrefix) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// unpin releases the cache of a pinned prefix
+func (s *Server) unpin(w http.ResponseWriter, r *http.Request) {
+	var req llm.PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.cache.UnpinCacheSlot(req.Name) {
+		http.Error(w, fmt.Sprintf("prefix %q is not pinned", req.Name), http.StatusNotFound)
+		return
+	}
+	s.seqsSem.Release(1)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// saveCache writes the prompts in the cache to disk before the runner is
+// stopped.
+func (s *Server) saveCache(w http.ResponseWriter, r *http.Request) {
+	if s.status != llm.ServerStatusReady {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache.SaveCacheSlots()
+	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
@@ -801,7 +1351,13 @@ func (s *Server) reserveWorstCaseGraph() error {
 		batch.Positions[i] = int32(i)
 	}
 
-	batch.Outputs = make([]int32, s.parallel)
+	// each sequence samples its draft tokens along with its last input
+	numOutputs := s.parallel
+	if s.draft != nil {
+		numOutputs = min(s.batchSize, s.parallel*(1+s.draft.numDraft))
+	}
+
+	batch.Outputs = make([]int32, numOutputs)
 	for i := range batch.Outputs {
 		batch.Outputs[i] = int32(i)
 	}
@@ -834,6 +1390,9 @@ func (s *Server) initModel(
 	kvCacheType string,
 	kvSize int,
 	multiUserCache bool,
+	dpath string,
+	dparams ml.BackendParams,
+	numDraft int,
 ) error {
 	var err error
 	s.model, err = model.New(mpath, params)
@@ -851,6 +1410,12 @@ func (s *Server) initModel(
 		return err
 	}
 
+	if size := envconfig.PromptCacheSize(); size > 0 && s.cache.enabled {
+		if err := s.initDiskCache(mpath, int64(size)); err != nil {
+			slog.Warn("prompt cache will not be saved to disk", "error", err)
+		}
+	}
+
 	if !s.cache.enabled && parallel > 1 {
 		parallel = 1
 		slog.Warn("model does not support caching, disabling parallel processing")
@@ -860,9 +1425,38 @@ func (s *Server) initModel(
 	s.seqs = make([]*Sequence, s.parallel)
 	s.seqsSem = semaphore.NewWeighted(int64(s.parallel))
 
+	if dpath != "" && numDraft > 0 {
+		if !s.cache.enabled {
+			slog.Warn("model does not support caching, generating without draft model")
+		} else if s.draft, err = newDraft(dpath, dparams, s.model, kvCacheType, s.cache.numCtx, len(s.cache.slots), s.batchSize, numDraft); err != nil {
+			slog.Warn("failed to load draft model, generating without it", "draft", dpath, "error", err)
+		}
+	}
+
 	return s.reserveWorstCaseGraph()
 }
 
+// initDiskCache saves long prompts for the model at mpath to disk, keyed by
+// its digest, which is part of the name of model blobs.
+func (s *Server) initDiskCache(mpath string, size int64) error {
+	if _, ok := s.cache.cache.(kvcache.Snapshotter); !ok {
+		return errors.New("model cache does not support snapshots")
+	}
+
+	digest, ok := strings.CutPrefix(filepath.Base(mpath), "sha256-")
+	if !ok {
+		return fmt.Errorf("model %s is not a blob", mpath)
+	}
+
+	models, err := envconfig.Models()
+	if err != nil {
+		return err
+	}
+
+	s.cache.disk, err = NewDiskCache(filepath.Join(models, "promptcache"), "sha256:"+digest, size)
+	return err
+}
+
 func (s *Server) load(
 	ctx context.Context,
 	mpath string,
@@ -872,8 +1466,11 @@ func (s *Server) load(
 	kvCacheType string,
 	kvSize int,
 	multiUserCache bool,
+	dpath string,
+	dparams ml.BackendParams,
+	numDraft int,
 ) {
-	err := s.initModel(mpath, params, lpath, parallel, kvCacheType, kvSize, multiUserCache)
+	err := s.initModel(mpath, params, lpath, parallel, kvCacheType, kvSize, multiUserCache, dpath, dparams, numDraft)
 	if err != nil {
 		slog.Error("failed to initialize model", "error", err)
 		s.status = llm.ServerStatusError
@@ -894,6 +1491,15 @@ func (s *Server) load(
 		return
 	}
 
+	if s.draft != nil {
+		if err := s.draft.model.Backend().Load(ctx, func(float32) {}); err != nil {
+			slog.Error("failed to load draft model backend", "error", err)
+			s.status = llm.ServerStatusError
+			s.ready.Done()
+			return
+		}
+	}
+
 	s.status = llm.ServerStatusReady
 	s.ready.Done()
 }
@@ -914,6 +1520,9 @@ func Execute(args []string) error {
 	_ = fs.Bool("no-mmap", false, "do not memory-map model (slower load but may reduce pageouts if not using mlock)")
 	tensorSplit := fs.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := fs.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	draftPath := fs.String("draft", "", "Path to draft model binary file for speculative decoding")
+	draftGPULayers := fs.Int("draft-n-gpu-layers", 0, "Number of draft model layers to offload to GPU")
+	draftTokens := fs.Int("draft-tokens", 4, "Number of tokens to draft for each generated token")
 
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
@@ -928,6 +1537,12 @@ func Execute(args []string) error {
 	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
 	slog.Info("starting goobla engine")
 
+	shutdownTracing, err := tracing.Configure("goobla-runner", envconfig.TraceEndpoint(), envconfig.TraceFile())
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background()) //nolint:errcheck
+
 	server := &Server{
 		batchSize: *batchSize,
 		status:    llm.ServerStatusLoadingModel,
@@ -960,7 +1575,10 @@ func Execute(args []string) error {
 		FlashAttention: *flashAttention,
 	}
 
-	go server.load(ctx, *mpath, params, lpaths, *parallel, *kvCacheType, *kvSize, *multiUserCache)
+	dparams := params
+	dparams.NumGPULayers = *draftGPULayers
+
+	go server.load(ctx, *mpath, params, lpaths, *parallel, *kvCacheType, *kvSize, *multiUserCache, *draftPath, dparams, *draftTokens)
 	go server.run(ctx)
 
 	addr := "127.0.0.1:" + strconv.Itoa(*port)
@@ -979,6 +1597,9 @@ func Execute(args []string) error {
 
 	mux.HandleFunc("POST /completion", server.completion)
 	mux.HandleFunc("GET /health", server.health)
+	mux.HandleFunc("POST /prefix", server.pin)
+	mux.HandleFunc("DELETE /prefix", server.unpin)
+	mux.HandleFunc("POST /cache/save", server.saveCache)
 
 	httpServer := http.Server{
 		Handler: mux,
@@ -27,6 +27,7 @@ import (
 	"github.com/goobla/goobla/llm"
 	"github.com/goobla/goobla/logutil"
 	"github.com/goobla/goobla/runner/common"
+	"github.com/goobla/goobla/tracing"
 )
 
 // input is an element of the prompt to process, either
@@ -537,6 +538,10 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 }
 
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "runner.completion")
+	span.SetKind(tracing.KindServer)
+	defer span.End()
+
 	var req llm.CompletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -548,6 +553,27 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		req.Options = &opts
 	}
 
+	logitBias, err := common.LogitBias(req.Options.LogitBias, s.model.NumVocab())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.N > 1 {
+		http.Error(w, "multiple completions (n > 1) are not supported by this model", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Audios) > 0 {
+		http.Error(w, "audio input is not supported by this model", http.StatusBadRequest)
+		return
+	}
+
+	if req.Logprobs || req.TopLogprobs > 0 {
+		http.Error(w, "logprobs are not supported by this model", http.StatusBadRequest)
+		return
+	}
+
 	// Set the headers to indicate streaming
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Transfer-Encoding", "chunked")
@@ -571,6 +597,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		PenaltyPresent: req.Options.PresencePenalty,
 		Seed:           uint32(req.Options.Seed),
 		Grammar:        req.Grammar,
+		LogitBias:      logitBias,
 	}
 
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
@@ -621,12 +648,28 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// prompt processing ends when the first token is generated, after which
+	// the remaining time is spent decoding
+	_, promptSpan := tracing.Start(ctx, "runner.prompt")
+	var decodeSpan *tracing.Span
+	generating := false
+	defer func() {
+		promptSpan.End()
+		decodeSpan.End()
+	}()
+
 	for {
 		select {
 		case <-r.Context().Done():
 			close(seq.quit)
 			return
 		case content, ok := <-seq.responses:
+			if !generating {
+				generating = true
+				promptSpan.End()
+				_, decodeSpan = tracing.Start(ctx, "runner.decode")
+			}
+
 			if ok {
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
 					Content: content,
@@ -649,6 +692,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
 				}
 
+				span.SetAttributes("prompt_eval_count", seq.numPromptInputs, "eval_count", seq.numDecoded)
 				return
 			}
 		}
@@ -829,6 +873,12 @@ func Execute(args []string) error {
 	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
 	slog.Info("starting go runner")
 
+	shutdownTracing, err := tracing.Configure("goobla-runner", envconfig.TraceEndpoint(), envconfig.TraceFile())
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background()) //nolint:errcheck
+
 	llama.BackendInit()
 
 	server := &Server{
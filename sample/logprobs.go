@@ -0,0 +1,48 @@
+package sample
+
+import (
+	"math"
+)
+
+// Logprob is the log probability of a single token id.
+type Logprob struct {
+	ID      int32
+	Logprob float32
+}
+
+// Logprobs returns the log probability of token id under the distribution
+// described by logits, along with the n most likely tokens, most likely first.
+// Probabilities are computed from the raw logits, before any sampling
+// transforms are applied, and logits is not modified.
+func Logprobs(logits []float32, id int32, n int) (Logprob, []Logprob) {
+	maxLogit := float32(math.Inf(-1))
+	for _, l := range logits {
+		maxLogit = max(maxLogit, l)
+	}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l - maxLogit))
+	}
+	logSum := float32(math.Log(sum)) + maxLogit
+
+	selected := Logprob{ID: id, Logprob: logits[id] - logSum}
+	if n <= 0 {
+		return selected, nil
+	}
+
+	tokens := make([]token, len(logits))
+	for i := range logits {
+		tokens[i].id = int32(i)
+		tokens[i].value = logits[i]
+	}
+
+	// topK also sorts the tokens in descending order of logits
+	tokens = topK(tokens, n)
+	top := make([]Logprob, min(n, len(tokens)))
+	for i := range top {
+		top[i] = Logprob{ID: tokens[i].id, Logprob: tokens[i].value - logSum}
+	}
+
+	return selected, top
+}
@@ -0,0 +1,47 @@
+package sample
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestLogprobs(t *testing.T) {
+	logits := []float32{1, 3, 2, 0}
+	orig := slices.Clone(logits)
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l))
+	}
+	want := func(id int) float32 {
+		return float32(float64(logits[id]) - math.Log(sum))
+	}
+
+	selected, top := Logprobs(logits, 2, 0)
+	if selected.ID != 2 || math.Abs(float64(selected.Logprob-want(2))) > 1e-5 {
+		t.Errorf("selected: got %+v, want {2 %f}", selected, want(2))
+	}
+	if top != nil {
+		t.Errorf("top: got %v, want nil", top)
+	}
+
+	_, top = Logprobs(logits, 2, 3)
+	if len(top) != 3 {
+		t.Fatalf("top: got %d entries, want 3", len(top))
+	}
+	for i, id := range []int32{1, 2, 0} {
+		if top[i].ID != id || math.Abs(float64(top[i].Logprob-want(int(id)))) > 1e-5 {
+			t.Errorf("top[%d]: got %+v, want {%d %f}", i, top[i], id, want(int(id)))
+		}
+	}
+
+	_, top = Logprobs(logits, 0, 10)
+	if len(top) != len(logits) {
+		t.Errorf("top: got %d entries, want %d", len(top), len(logits))
+	}
+
+	if !slices.Equal(logits, orig) {
+		t.Errorf("logits modified: got %v, want %v", logits, orig)
+	}
+}
@@ -24,6 +24,16 @@ type Sampler struct {
 	minP        float32
 	temperature float32
 	grammar     *GrammarSampler
+
+	repeatLastN      int
+	repeatPenalty    float32
+	presencePenalty  float32
+	frequencyPenalty float32
+
+	logitBias map[int32]float32
+
+	// history holds up to repeatLastN of the most recently accepted tokens
+	history []int32
 }
 
 // Config specifies the sampling options used to build a Sampler.  It is
@@ -34,6 +44,17 @@ type Config struct {
 	TopP        float32 `json:"top_p,omitempty"`
 	MinP        float32 `json:"min_p,omitempty"`
 	Seed        int     `json:"seed,omitempty"`
+
+	// RepeatLastN is the number of accepted tokens considered by the
+	// penalties below. A RepeatPenalty of zero disables it.
+	RepeatLastN      int     `json:"repeat_last_n,omitempty"`
+	RepeatPenalty    float32 `json:"repeat_penalty,omitempty"`
+	PresencePenalty  float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32 `json:"frequency_penalty,omitempty"`
+
+	// LogitBias is added to the logits of the given token ids before
+	// any other transforms
+	LogitBias map[int32]float32 `json:"logit_bias,omitempty"`
 }
 
 // UnmarshalJSON implements json.Unmarshaler so a Sampler can be constructed
@@ -91,6 +112,19 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 	return t.id, nil
 }
 
+// Accept records token, either from the prompt or generated, in the history
+// used to penalize repetition.
+func (s *Sampler) Accept(token int32) {
+	if s.repeatLastN <= 0 {
+		return
+	}
+
+	s.history = append(s.history, token)
+	if len(s.history) > s.repeatLastN {
+		s.history = s.history[len(s.history)-s.repeatLastN:]
+	}
+}
+
 // greedy returns the highest probability token from the tokens
 func greedy(tokens []token) token {
 	max := tokens[0]
@@ -106,6 +140,9 @@ func greedy(tokens []token) token {
 // sample returns the highest probability token from the tokens
 // given sampler parameters. It also has side effects of modifying the tokens
 func (s *Sampler) sample(tokens []token) (token, error) {
+	logitBias(tokens, s.logitBias)
+	penalties(tokens, s.history, s.repeatPenalty, s.presencePenalty, s.frequencyPenalty)
+
 	if s.temperature == 0 {
 		return greedy(tokens), nil
 	}
@@ -187,6 +224,13 @@ func NewSamplerFromConfig(cfg Config, grammar *GrammarSampler) Sampler {
 		cfg.MinP = 1.0
 	}
 
+	if cfg.RepeatLastN < 0 {
+		cfg.RepeatLastN = 0
+	}
+	if cfg.RepeatPenalty == 0.0 {
+		cfg.RepeatPenalty = 1.0
+	}
+
 	return Sampler{
 		rng:         rng,
 		topK:        cfg.TopK,
@@ -194,6 +238,12 @@ func NewSamplerFromConfig(cfg Config, grammar *GrammarSampler) Sampler {
 		minP:        cfg.MinP,
 		temperature: cfg.Temperature,
 		grammar:     grammar,
+
+		repeatLastN:      cfg.RepeatLastN,
+		repeatPenalty:    cfg.RepeatPenalty,
+		presencePenalty:  cfg.PresencePenalty,
+		frequencyPenalty: cfg.FrequencyPenalty,
+		logitBias:        cfg.LogitBias,
 	}
 }
 
@@ -84,6 +84,57 @@ func TestNewSamplerJSON(t *testing.T) {
 	}
 }
 
+func TestSamplerLogitBias(t *testing.T) {
+	logits := []float32{3, 2.9, 0}
+
+	for _, temperature := range []float32{0, 0.5} {
+		sampler := NewSamplerFromConfig(Config{
+			Temperature: temperature,
+			LogitBias:   map[int32]float32{0: -100, 1: -100},
+		}, nil)
+
+		got, err := sampler.Sample(logits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 2 {
+			t.Errorf("temperature %v: index mismatch: want %d, got %d", temperature, 2, got)
+		}
+	}
+}
+
+func TestSamplerPenalties(t *testing.T) {
+	logits := []float32{3, 2.9, 0}
+	sampler := NewSamplerFromConfig(Config{RepeatLastN: 2, RepeatPenalty: 1.5}, nil)
+
+	sample := func(want int32) {
+		t.Helper()
+		got, err := sampler.Sample(logits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("index mismatch: want %d, got %d", want, got)
+		}
+	}
+
+	sample(0)
+
+	// a repeated token is penalized below the runner up
+	sampler.Accept(0)
+	sample(1)
+
+	// tokens older than repeat_last_n are no longer penalized
+	sampler.Accept(1)
+	sampler.Accept(2)
+	sample(0)
+
+	// a zero repeat penalty from an unset config leaves logits unchanged
+	sampler = NewSamplerFromConfig(Config{RepeatLastN: 2}, nil)
+	sampler.Accept(0)
+	sample(0)
+}
+
 func modelHelper(t testing.TB) model.BytePairEncoding {
 	t.Helper()
 
@@ -175,11 +226,11 @@ func TestGrammar(t *testing.T) {
 }
 
 func BenchmarkSample(b *testing.B) {
-	samplers := map[string]Sampler{}
-	if err := json.Unmarshal([]byte(`{"temperature":0}`), &samplers["Greedy"]); err != nil {
+	samplers := map[string]*Sampler{"Greedy": {}, "Weighted": {}}
+	if err := json.Unmarshal([]byte(`{"temperature":0}`), samplers["Greedy"]); err != nil {
 		b.Fatal(err)
 	}
-	if err := json.Unmarshal([]byte(`{"temperature":0.5,"top_k":10,"top_p":0.9,"min_p":0.2,"seed":-1}`), &samplers["Weighted"]); err != nil {
+	if err := json.Unmarshal([]byte(`{"temperature":0.5,"top_k":10,"top_p":0.9,"min_p":0.2,"seed":-1}`), samplers["Weighted"]); err != nil {
 		b.Fatal(err)
 	}
 
@@ -128,3 +128,47 @@ func minP(ts []token, p float32) []token {
 	}
 	return ts
 }
+
+// logitBias adds a bias to the logits of the given token ids. It requires ts
+// to be indexed by token id, as it is before any sorting, and ignores ids
+// outside of the vocabulary.
+func logitBias(ts []token, bias map[int32]float32) {
+	for id, b := range bias {
+		if id >= 0 && int(id) < len(ts) {
+			ts[id].value += b
+		}
+	}
+}
+
+// penalties discourages repetition of the tokens in history, matching the
+// llama.cpp penalties sampler. Each token that appears in history has its
+// logit scaled away from zero by repeat, then reduced by presence once and by
+// frequency for every occurrence. Like logitBias, it requires ts to be indexed
+// by token id.
+func penalties(ts []token, history []int32, repeat, presence, frequency float32) {
+	if len(history) == 0 || (repeat == 1 && presence == 0 && frequency == 0) {
+		return
+	}
+
+	counts := make(map[int32]int, len(history))
+	for _, id := range history {
+		counts[id]++
+	}
+
+	for id, count := range counts {
+		if id < 0 || int(id) >= len(ts) {
+			continue
+		}
+		t := &ts[id]
+
+		// dividing negative logits by the penalty would make them more
+		// likely, so they are multiplied instead
+		if t.value <= 0 {
+			t.value *= repeat
+		} else {
+			t.value /= repeat
+		}
+
+		t.value -= float32(count)*frequency + presence
+	}
+}
@@ -295,6 +295,35 @@ func TestMinP(t *testing.T) {
 	}
 }
 
+func TestLogitBias(t *testing.T) {
+	input := []float32{2, -1, 0.5, 3}
+	tokens := toTokens(input)
+	logitBias(tokens, map[int32]float32{1: 5, 3: -100, 4: 10, -1: 10})
+	want := []float32{2, 4, 0.5, -97}
+	compareLogits(t, "logitBias", want, tokens)
+}
+
+func TestPenalties(t *testing.T) {
+	input := []float32{2, -1, 0.5, 3}
+	history := []int32{0, 0, 1, 3}
+
+	tokens := toTokens(input)
+	penalties(tokens, history, 1, 0, 0)
+	compareLogits(t, "penalties(disabled)", input, tokens)
+
+	tokens = toTokens(input)
+	penalties(tokens, nil, 1.5, 0.5, 0.25)
+	compareLogits(t, "penalties(no history)", input, tokens)
+
+	// expected values follow llama.cpp: positive logits are divided and
+	// negative logits multiplied by the repeat penalty, then the presence
+	// penalty and the frequency penalty times the count are subtracted
+	tokens = toTokens(input)
+	penalties(tokens, history, 1.5, 0.5, 0.25)
+	want := []float32{2/1.5 - 0.5 - 2*0.25, -1*1.5 - 0.5 - 0.25, 0.5, 3/1.5 - 0.5 - 0.25}
+	compareLogits(t, "penalties(1.5, 0.5, 0.25)", want, tokens)
+}
+
 func BenchmarkTransforms(b *testing.B) {
 	// Generate random logits
 	tokens := make([]token, 1<<16)
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/types/model"
+)
+
+// An alias is a name that refers to another model, which may itself be an
+// alias. Unlike a copy, an alias follows its target when the target is
+// pulled or created again. The alias n is kept in the aliases directory of
+// the models directory, at n.Filepath(), in a file holding the name of its
+// target. A model of the same name takes the place of an alias.
+
+// maxAliasDepth limits the length of chains of aliases.
+const maxAliasDepth = 16
+
+var (
+	errAliasCycle  = errors.New("alias would create a cycle")
+	errAliasModel  = errors.New("a model with that name exists")
+	errAliasTarget = errors.New("aliases refer to it")
+)
+
+// aliasMu serializes changes to aliases, so that concurrent changes cannot
+// create a cycle.
+var aliasMu sync.Mutex
+
+func getAliasesPath() (string, error) {
+	dir, err := envconfig.Models()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "aliases"), nil
+}
+
+// GetAliasPath returns the path of the alias n.
+func GetAliasPath(n model.Name) (string, error) {
+	if !n.IsFullyQualified() {
+		return "", model.Unqualified(n)
+	}
+
+	dir, err := getAliasesPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, n.Filepath()), nil
+}
+
+// readAlias returns the target of the alias n, or an error satisfying
+// [os.ErrNotExist] if n is not an alias.
+func readAlias(n model.Name) (model.Name, error) {
+	p, err := GetAliasPath(n)
+	if err != nil {
+		return model.Name{}, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return model.Name{}, err
+	}
+
+	target := model.ParseName(strings.TrimSpace(string(data)))
+	if !target.IsFullyQualified() {
+		return model.Name{}, fmt.Errorf("alias %s: invalid target %q", n.DisplayShortest(), data)
+	}
+	return target, nil
+}
+
+func modelExists(n model.Name) bool {
+	fp, err := ParseModelPath(n.String()).GetManifestPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(fp)
+	return err == nil
+}
+
+// resolveAlias follows the chain of aliases starting at n to the name of a
+// model. Names that are neither a model nor an alias resolve to themselves.
+func resolveAlias(n model.Name) (model.Name, error) {
+	for range maxAliasDepth {
+		if modelExists(n) {
+			return n, nil
+		}
+
+		target, err := readAlias(n)
+		if errors.Is(err, os.ErrNotExist) {
+			return n, nil
+		} else if err != nil {
+			return model.Name{}, err
+		}
+		n = target
+	}
+
+	return model.Name{}, fmt.Errorf("%w: more than %d aliases", errAliasCycle, maxAliasDepth)
+}
+
+// SetAlias points the alias n at target, creating the alias if it does not
+// exist. Processes resolving n see either the old or the new target.
+func SetAlias(n, target model.Name) error {
+	if !n.IsFullyQualified() {
+		return model.Unqualified(n)
+	}
+	if !target.IsFullyQualified() {
+		return model.Unqualified(target)
+	}
+
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	if modelExists(n) {
+		return fmt.Errorf("%s: %w", n.DisplayShortest(), errAliasModel)
+	}
+
+	// follow the chain of aliases from target, which must not lead back to
+	// n, to a model that exists
+	t := target
+	for i := 0; ; i++ {
+		if t.EqualFold(n) {
+			return fmt.Errorf("%w: %s refers to %s", errAliasCycle, target.DisplayShortest(), n.DisplayShortest())
+		}
+		if i == maxAliasDepth {
+			return fmt.Errorf("%w: more than %d aliases", errAliasCycle, maxAliasDepth)
+		}
+		if modelExists(t) {
+			break
+		}
+
+		next, err := readAlias(t)
+		if err != nil {
+			return err
+		}
+		t = next
+	}
+
+	p, err := GetAliasPath(n)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+"-partial-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString(target.String()); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+// RemoveAlias removes the alias n, returning an error satisfying
+// [os.ErrNotExist] if n is not an alias.
+func RemoveAlias(n model.Name) error {
+	p, err := GetAliasPath(n)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+
+	dir, err := getAliasesPath()
+	if err != nil {
+		return err
+	}
+	return PruneDirectory(dir)
+}
+
+// replaceAlias removes the alias n, if any, as a model named n is written.
+func replaceAlias(n model.Name) {
+	if err := RemoveAlias(n); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("couldn't remove alias replaced by a model", "name", n, "error", err)
+	}
+}
+
+// Aliases returns the target of each alias.
+func Aliases() (map[model.Name]model.Name, error) {
+	dir, err := getAliasesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[model.Name]model.Name)
+	err = fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == "." {
+			return fs.SkipAll
+		} else if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		n := model.ParseNameFromFilepath(path)
+		if !n.IsValid() {
+			slog.Warn("bad alias name", "path", path)
+			return nil
+		}
+
+		target, err := readAlias(n)
+		if err != nil {
+			slog.Warn("bad alias", "name", n, "error", err)
+			return nil
+		}
+
+		aliases[n] = target
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// aliasesOf returns the aliases whose target is n, sorted by name.
+func aliasesOf(n model.Name) ([]model.Name, error) {
+	aliases, err := Aliases()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []model.Name
+	for alias, target := range aliases {
+		if target.EqualFold(n) {
+			names = append(names, alias)
+		}
+	}
+
+	slices.SortFunc(names, func(a, b model.Name) int {
+		return strings.Compare(a.DisplayShortest(), b.DisplayShortest())
+	})
+	return names, nil
+}
+
+// checkNoAliases returns an error wrapping [errAliasTarget] that lists the
+// aliases whose target is n, if there are any.
+func checkNoAliases(n model.Name) error {
+	aliases, err := aliasesOf(n)
+	if err != nil || len(aliases) == 0 {
+		return err
+	}
+
+	names := make([]string, len(aliases))
+	for i, a := range aliases {
+		names[i] = a.DisplayShortest()
+	}
+	return fmt.Errorf("%s: %w: %s", n.DisplayShortest(), errAliasTarget, strings.Join(names, ", "))
+}
+
+func (s *Server) TagHandler(c *gin.Context) {
+	var r api.TagRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target := model.ParseName(r.Model)
+	if !target.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is invalid", r.Model)})
+		return
+	}
+	target, err := getExistingName(target)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alias := model.ParseName(r.Alias)
+	if !alias.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", r.Alias)})
+		return
+	}
+
+	switch err := SetAlias(alias, target); {
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Model)})
+	case errors.Is(err, errAliasCycle), errors.Is(err, errAliasModel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/types/model"
+)
+
+func TestAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"blue", "green"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"test.gguf": digest},
+			System: "You are " + name + ".",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	tag := func(t *testing.T, target, alias string, code int) {
+		t.Helper()
+		w := createRequest(t, s.TagHandler, api.TagRequest{Model: target, Alias: alias})
+		if w.Code != code {
+			t.Fatalf("tag %s %s: status = %d, want %d: %s", target, alias, w.Code, code, w.Body.String())
+		}
+	}
+
+	system := func(t *testing.T, name string) string {
+		t.Helper()
+		m, err := GetModel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.System
+	}
+
+	tag(t, "blue", "prod", http.StatusOK)
+	if got := system(t, "prod"); got != "You are blue." {
+		t.Errorf("prod resolved to %q, want blue", got)
+	}
+
+	t.Run("list", func(t *testing.T) {
+		blue, err := ParseNamedManifest(model.ParseName("blue"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.ListHandler, nil)
+		var resp api.ListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var found bool
+		for _, m := range resp.Models {
+			switch m.Name {
+			case "prod:latest":
+				found = true
+				if m.Target != "blue:latest" || m.Digest != blue.digest {
+					t.Errorf("alias listed as %+v, want target blue:latest with its digest", m)
+				}
+			case "blue:latest", "green:latest":
+				if m.Target != "" {
+					t.Errorf("model %s listed with target %q", m.Name, m.Target)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected prod:latest to be listed: %+v", resp.Models)
+		}
+	})
+
+	t.Run("show", func(t *testing.T) {
+		resp, err := GetModelInfo(api.ShowRequest{Model: "prod"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.System != "You are blue." {
+			t.Errorf("system = %q, want blue", resp.System)
+		}
+	})
+
+	// retargeting switches every request at once
+	tag(t, "green", "prod", http.StatusOK)
+	if got := system(t, "prod"); got != "You are green." {
+		t.Errorf("prod resolved to %q after retargeting, want green", got)
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		tag(t, "prod", "stage", http.StatusOK)
+		if got := system(t, "stage"); got != "You are green." {
+			t.Errorf("stage resolved to %q, want green", got)
+		}
+
+		w := createRequest(t, s.TagHandler, api.TagRequest{Model: "stage", Alias: "prod"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("cycle: status = %d, want 400: %s", w.Code, w.Body.String())
+		}
+		if err := SetAlias(model.ParseName("prod"), model.ParseName("prod")); !errors.Is(err, errAliasCycle) {
+			t.Errorf("self alias: err = %v, want %v", err, errAliasCycle)
+		}
+		if got := system(t, "stage"); got != "You are green." {
+			t.Errorf("stage resolved to %q after a refused cycle, want green", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tag(t, "green", "blue", http.StatusBadRequest)
+		tag(t, "unknown", "other", http.StatusNotFound)
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		w := createRequest(t, s.CopyHandler, api.CopyRequest{Source: "prod", Destination: "backup"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("copy: status = %d: %s", w.Code, w.Body.String())
+		}
+
+		// a copy is a model, which keeps its manifest when prod moves on
+		tag(t, "blue", "prod", http.StatusOK)
+		if got := system(t, "backup"); got != "You are green." {
+			t.Errorf("backup resolved to %q, want green", got)
+		}
+		if _, err := ParseNamedManifest(model.ParseName("backup")); err != nil {
+			t.Errorf("expected the copy to be a model: %v", err)
+		}
+
+		// a model written under the name of an alias replaces it
+		if err := CopyModel(model.ParseName("blue"), model.ParseName("stage")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readAlias(model.ParseName("stage")); !os.IsNotExist(err) {
+			t.Errorf("expected the alias to be replaced, got %v", err)
+		}
+	})
+
+	t.Run("delete target", func(t *testing.T) {
+		w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "blue"})
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "prod") {
+			t.Fatalf("delete: status = %d, want 409 naming the alias: %s", w.Code, w.Body.String())
+		}
+		if _, err := ParseNamedManifest(model.ParseName("blue")); err != nil {
+			t.Errorf("expected the target to be kept: %v", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "prod"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("delete: status = %d: %s", w.Code, w.Body.String())
+		}
+
+		if _, err := readAlias(model.ParseName("prod")); !os.IsNotExist(err) {
+			t.Errorf("expected the alias to be removed, got %v", err)
+		}
+		if _, err := ParseNamedManifest(model.ParseName("blue")); err != nil {
+			t.Errorf("expected the target to be kept: %v", err)
+		}
+	})
+
+	// the alias is gone, so the model can be deleted
+	w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "blue"})
+	if w.Code != http.StatusOK {
+		t.Errorf("delete: status = %d: %s", w.Code, w.Body.String())
+	}
+}
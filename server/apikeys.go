@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/envconfig"
+)
+
+// API key scopes. Inference covers running and listing models, while models
+// covers pulling, pushing, creating, copying and deleting them, and managing
+// the prefixes and requests shared by every client.
+const (
+	scopeInference = "inference"
+	scopeModels    = "models"
+)
+
+// apiKeyContextKey is the gin context key of the authenticated *apiKey.
+const apiKeyContextKey = "apiKey"
+
+// authorizedKey is the request context key of the *apiKey a request was
+// authorized with before reaching gin, so it is not charged again.
+type authorizedKey struct{}
+
+var (
+	errMissingAPIKey  = errors.New("missing API key")
+	errInvalidAPIKey  = errors.New("invalid API key")
+	errRateLimited    = errors.New("rate limit exceeded, please try again later")
+	errTokenLimited   = errors.New("generated token limit exceeded, please try again later")
+	errScopeForbidden = errors.New("API key is not allowed to access this endpoint")
+)
+
+// apiKeysFile returns the path of the file listing the keys accepted by the
+// server. Authentication is disabled if it does not exist.
+func apiKeysFile() string {
+	return filepath.Join(envconfig.ConfigDir(), "api_keys.json")
+}
+
+type apiKey struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes,omitempty"`
+
+	// RequestsPerMinute and TokensPerMinute limit the requests made and
+	// tokens generated with the key. Zero means unlimited.
+	RequestsPerMinute float64 `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   float64 `json:"tokens_per_minute,omitempty"`
+
+	// HighPriority allows requests made with the key to be queued at high
+	// priority. Otherwise they are queued at normal priority instead.
+	HighPriority bool `json:"high_priority,omitempty"`
+
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+func (k *apiKey) hasScope(scope string) bool {
+	return scope == "" || slices.Contains(k.Scopes, scope)
+}
+
+// id identifies the key in the data it owns, such as batches, without
+// storing the key itself.
+func (k *apiKey) id() string {
+	sum := sha256.Sum256([]byte(k.Key))
+	return hex.EncodeToString(sum[:8])
+}
+
+type apiKeys struct {
+	keys []*apiKey
+}
+
+// loadAPIKeys reads the keys in path. It returns nil if the file does not
+// exist.
+func loadAPIKeys(path string) (*apiKeys, error) {
+	bts, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Keys []*apiKey `json:"keys"`
+	}
+	if err := json.Unmarshal(bts, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, k := range f.Keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("%s: key %d has no value", path, i)
+		} else if seen[k.Key] {
+			return nil, fmt.Errorf("%s: key %q is listed more than once", path, k.Name)
+		}
+		seen[k.Key] = true
+
+		if len(k.Scopes) == 0 {
+			k.Scopes = []string{scopeInference}
+		}
+		for _, scope := range k.Scopes {
+			if scope != scopeInference && scope != scopeModels {
+				return nil, fmt.Errorf("%s: key %q has unknown scope %q", path, k.Name, scope)
+			}
+		}
+
+		if k.RequestsPerMinute < 0 || k.TokensPerMinute < 0 {
+			return nil, fmt.Errorf("%s: key %q has a negative limit", path, k.Name)
+		}
+		k.requests = newTokenBucket(k.RequestsPerMinute)
+		k.tokens = newTokenBucket(k.TokensPerMinute)
+	}
+
+	return &apiKeys{keys: f.Keys}, nil
+}
+
+// authorize checks that r carries a key with scope that is within its
+// limits. It returns the key, or the status code and error to respond with.
+func (ks *apiKeys) authorize(r *http.Request, scope string) (*apiKey, int, error) {
+	token, ok := requestToken(r)
+	if !ok {
+		return nil, http.StatusUnauthorized, errMissingAPIKey
+	}
+
+	var key *apiKey
+	for _, k := range ks.keys {
+		// compare every key in constant time to not leak which one matched
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(token)) == 1 {
+			key = k
+		}
+	}
+
+	if key == nil {
+		return nil, http.StatusUnauthorized, errInvalidAPIKey
+	}
+
+	if !key.hasScope(scope) {
+		return nil, http.StatusForbidden, errScopeForbidden
+	}
+
+	now := time.Now()
+	if wait := key.tokens.wait(1, now); wait > 0 {
+		return key, http.StatusTooManyRequests, &rateLimitError{errTokenLimited, wait}
+	}
+
+	if wait := key.requests.take(1, now); wait > 0 {
+		return key, http.StatusTooManyRequests, &rateLimitError{errRateLimited, wait}
+	}
+
+	return key, http.StatusOK, nil
+}
+
+// byID returns the key with id, or nil if there is none.
+func (ks *apiKeys) byID(id string) *apiKey {
+	for _, k := range ks.keys {
+		if k.id() == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// handler wraps h, requiring a key with scope for requests to paths.
+func (ks *apiKeys) handler(h http.Handler, scope string, paths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(paths, r.URL.Path) {
+			key, status, err := ks.authorize(r, scope)
+			if err != nil {
+				writeAuthError(w, status, err)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), authorizedKey{}, key))
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestToken returns the key sent with r as a bearer token or, as
+// Anthropic clients send it, in the x-api-key header.
+func requestToken(r *http.Request) (string, bool) {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		return token, true
+	}
+
+	token := strings.TrimSpace(r.Header.Get("x-api-key"))
+	return token, token != ""
+}
+
+func bearerToken(s string) (string, bool) {
+	scheme, token, ok := strings.Cut(s, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type rateLimitError struct {
+	error
+	retryAfter time.Duration
+}
+
+func writeAuthError(w http.ResponseWriter, status int, err error) {
+	var rle *rateLimitError
+	if errors.As(err, &rle) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rle.retryAfter.Seconds()))))
+	} else if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="goobla"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(gin.H{"error": err.Error()}) //nolint:errcheck
+}
+
+// requireScope returns middleware that rejects requests without a key with
+// scope. Any valid key is accepted if scope is empty. It does nothing if
+// authentication is disabled.
+func (s *Server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.apiKeys == nil {
+			c.Next()
+			return
+		}
+
+		// requests the registry passes on were authorized and charged
+		// by [apiKeys.handler]
+		if key, ok := c.Request.Context().Value(authorizedKey{}).(*apiKey); ok && key.hasScope(scope) {
+			c.Set(apiKeyContextKey, key)
+			c.Next()
+			return
+		}
+
+		key, status, err := s.apiKeys.authorize(c.Request, scope)
+		if err != nil {
+			writeAuthError(c.Writer, status, err)
+			c.Abort()
+			return
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// requestOwner returns the id of the key used for the request, or an empty
+// string if authentication is disabled.
+func requestOwner(c *gin.Context) string {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		return v.(*apiKey).id()
+	}
+	return ""
+}
+
+// chargeTokens counts n generated tokens against the limit of the key used
+// for the request, if any.
+func chargeTokens(c *gin.Context, n int) {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		v.(*apiKey).tokens.charge(float64(n), time.Now())
+	}
+}
+
+// tokenBucket refills continuously up to its capacity, which is the amount
+// allowed per minute. A nil bucket is unlimited.
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute float64) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{capacity: perMinute, tokens: perMinute}
+}
+
+// refill must be called with b.mu held.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Minutes()*b.capacity)
+	}
+	b.last = now
+}
+
+// wait returns how long until n tokens are available.
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.waitLocked(n)
+}
+
+func (b *tokenBucket) waitLocked(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.capacity * float64(time.Minute))
+}
+
+// take removes n tokens if they are available, otherwise it returns how long
+// until they are.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if wait := b.waitLocked(n); wait > 0 {
+		return wait
+	}
+	b.tokens -= n
+	return 0
+}
+
+// charge removes n tokens, going into debt if there are not enough.
+func (b *tokenBucket) charge(n float64, now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= n
+}
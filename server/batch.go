@@ -0,0 +1,1070 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/envconfig"
+	openaimid "github.com/goobla/goobla/openai/middleware"
+	opentypes "github.com/goobla/goobla/openai/types"
+)
+
+const (
+	// batchCompletionWindow is the time a batch has to finish. Requests
+	// that have not run when it ends fail and the batch expires.
+	batchCompletionWindow = 24 * time.Hour
+
+	batchMaxRequests  = 50_000
+	batchMaxFileBytes = 200 << 20
+)
+
+// nativeBatchEndpoints are the endpoints requests of batches created with
+// /api/batch can use.
+var nativeBatchEndpoints = []string{"/api/generate", "/api/chat", "/api/embed"}
+
+// batchFile is an input or output file of a batch. Its contents are stored
+// next to its metadata.
+type batchFile struct {
+	ID        string    `json:"id"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	Filename  string    `json:"filename"`
+	Purpose   string    `json:"purpose"`
+	// Owner is the id of the key that created the file, or empty if
+	// authentication was disabled
+	Owner string `json:"owner,omitempty"`
+}
+
+// batch is a set of requests run in the background. Its input is a file of
+// [opentypes.BatchInput] lines, and the [opentypes.BatchOutput] lines of its
+// results are written to an output file for successful responses and an
+// error file for everything else.
+type batch struct {
+	ID string `json:"id"`
+	// Endpoint is the endpoint of every request of a batch created with
+	// /v1/batches, or empty for batches created with /api/batch
+	Endpoint string `json:"endpoint,omitempty"`
+	// Client is the client requests are queued under
+	Client string `json:"client"`
+	// Owner is the id of the key that created the batch, or empty if
+	// authentication was disabled. Only that key can see the batch, and its
+	// requests are charged against the key's limits.
+	Owner  string          `json:"owner,omitempty"`
+	Status api.BatchStatus `json:"status"`
+
+	InputFileID  string `json:"input_file_id"`
+	OutputFileID string `json:"output_file_id,omitempty"`
+	ErrorFileID  string `json:"error_file_id,omitempty"`
+
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	InProgressAt *time.Time `json:"in_progress_at,omitempty"`
+	FinalizingAt *time.Time `json:"finalizing_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	FailedAt     *time.Time `json:"failed_at,omitempty"`
+	ExpiredAt    *time.Time `json:"expired_at,omitempty"`
+	CancellingAt *time.Time `json:"cancelling_at,omitempty"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+
+	RequestCounts api.BatchRequestCounts `json:"request_counts"`
+	Errors        []api.BatchError       `json:"errors,omitempty"`
+	Metadata      map[string]string      `json:"metadata,omitempty"`
+}
+
+// batchStore keeps batches and their files under the batches directory of
+// the models directory, so they survive restarts.
+type batchStore struct {
+	dir string
+
+	mu      sync.Mutex
+	batches map[string]*batch
+	files   map[string]*batchFile
+	// cancels cancel the batches being run
+	cancels map[string]context.CancelFunc
+
+	// wake is signaled when a batch is created or cancelled
+	wake chan struct{}
+}
+
+func batchesPath() (string, error) {
+	dir, err := envconfig.Models()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "batches"), nil
+}
+
+// loadBatches loads the batches and files stored in dir.
+func loadBatches(dir string) (*batchStore, error) {
+	st := &batchStore{
+		dir:     dir,
+		batches: make(map[string]*batch),
+		files:   make(map[string]*batchFile),
+		cancels: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+	}
+
+	load := func(pattern string, fn func([]byte) error) error {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			bts, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := fn(bts); err != nil {
+				slog.Warn("skipping corrupt batch metadata", "path", path, "error", err)
+			}
+		}
+		return nil
+	}
+
+	if err := load("*.json", func(bts []byte) error {
+		var b batch
+		if err := json.Unmarshal(bts, &b); err != nil {
+			return err
+		}
+		st.batches[b.ID] = &b
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := load(filepath.Join("files", "*.json"), func(bts []byte) error {
+		var f batchFile
+		if err := json.Unmarshal(bts, &f); err != nil {
+			return err
+		}
+		st.files[f.ID] = &f
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (st *batchStore) filePath(id string) string {
+	return filepath.Join(st.dir, "files", id+".jsonl")
+}
+
+// writeJSON atomically replaces the file at path with v.
+func writeJSON(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// save persists b. It must be called with st.mu held.
+func (st *batchStore) save(b *batch) error {
+	return writeJSON(filepath.Join(st.dir, b.ID+".json"), b)
+}
+
+// saveFile persists the metadata of f. It must be called with st.mu held.
+func (st *batchStore) saveFile(f *batchFile) error {
+	return writeJSON(filepath.Join(st.dir, "files", f.ID+".json"), f)
+}
+
+// visible reports whether the key with id owner can see data owned by
+// id. Everything is visible if authentication is disabled.
+func visible(owner, id string) bool {
+	return owner == "" || owner == id
+}
+
+// createFile stores the contents of r as a new file owned by owner, failing
+// if it is larger than [batchMaxFileBytes].
+func (st *batchStore) createFile(r io.Reader, filename, purpose, owner string) (*batchFile, error) {
+	f := &batchFile{
+		ID:        opentypes.FileID(),
+		CreatedAt: time.Now(),
+		Filename:  filename,
+		Purpose:   purpose,
+		Owner:     owner,
+	}
+
+	path := st.filePath(f.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	w, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	n, err := io.Copy(w, io.LimitReader(r, batchMaxFileBytes+1))
+	if err == nil && n > batchMaxFileBytes {
+		err = fmt.Errorf("file is larger than %d bytes", batchMaxFileBytes)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	f.Bytes = n
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.saveFile(f); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	st.files[f.ID] = f
+	return f, nil
+}
+
+func (st *batchStore) file(id, owner string) (batchFile, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	f, ok := st.files[id]
+	if !ok || !visible(owner, f.Owner) {
+		return batchFile{}, false
+	}
+	return *f, true
+}
+
+// listFiles returns the files owned by owner.
+func (st *batchStore) listFiles(owner string) []batchFile {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	files := make([]batchFile, 0, len(st.files))
+	for _, f := range st.files {
+		if visible(owner, f.Owner) {
+			files = append(files, *f)
+		}
+	}
+	return files
+}
+
+func (st *batchStore) deleteFile(id, owner string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if f, ok := st.files[id]; !ok || !visible(owner, f.Owner) {
+		return false
+	}
+
+	delete(st.files, id)
+	os.Remove(filepath.Join(st.dir, "files", id+".json"))
+	os.Remove(st.filePath(id))
+	return true
+}
+
+// create adds a batch of the requests in the input file, owned by owner, to
+// be run in the background.
+func (st *batchStore) create(inputFileID, endpoint, client, owner string, metadata map[string]string) (batch, error) {
+	now := time.Now()
+	b := &batch{
+		ID:          opentypes.BatchID(),
+		Endpoint:    endpoint,
+		Client:      client,
+		Owner:       owner,
+		Status:      api.BatchStatusValidating,
+		InputFileID: inputFileID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(batchCompletionWindow),
+		Metadata:    metadata,
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.save(b); err != nil {
+		return batch{}, err
+	}
+	st.batches[b.ID] = b
+	st.signal()
+	return *b, nil
+}
+
+func (st *batchStore) get(id, owner string) (batch, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	b, ok := st.batches[id]
+	if !ok || !visible(owner, b.Owner) {
+		return batch{}, false
+	}
+	return *b, true
+}
+
+// list returns the batches owned by owner, newest first.
+func (st *batchStore) list(owner string) []batch {
+	st.mu.Lock()
+	batches := make([]batch, 0, len(st.batches))
+	for _, b := range st.batches {
+		if visible(owner, b.Owner) {
+			batches = append(batches, *b)
+		}
+	}
+	st.mu.Unlock()
+
+	slices.SortFunc(batches, func(a, b batch) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return batches
+}
+
+// cancel cancels a batch owned by owner that has not finished, returning
+// it.
+func (st *batchStore) cancel(id, owner string) (batch, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	b, ok := st.batches[id]
+	if !ok || !visible(owner, b.Owner) {
+		return batch{}, os.ErrNotExist
+	}
+
+	switch {
+	case b.Status.Done() || b.Status == api.BatchStatusCancelling:
+		return *b, nil
+	}
+
+	now := time.Now()
+	b.Status = api.BatchStatusCancelling
+	b.CancellingAt = &now
+	if err := st.save(b); err != nil {
+		return batch{}, err
+	}
+
+	if cancel, ok := st.cancels[id]; ok {
+		cancel()
+	}
+	st.signal()
+	return *b, nil
+}
+
+func (st *batchStore) signal() {
+	select {
+	case st.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest batch that has not finished.
+func (st *batchStore) next() *batch {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var next *batch
+	for _, b := range st.batches {
+		if !b.Status.Done() && (next == nil || b.CreatedAt.Before(next.CreatedAt)) {
+			next = b
+		}
+	}
+	return next
+}
+
+// update changes b with fn and persists it.
+func (st *batchStore) update(b *batch, fn func(*batch)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	fn(b)
+	if err := st.save(b); err != nil {
+		slog.Error("failed to save batch", "id", b.ID, "error", err)
+	}
+}
+
+// batchRoutes returns the routes batch requests are run through. Batches
+// are authorized when they are created, so these only apply the limits of
+// the key that created them.
+func (s *Server) batchRoutes() http.Handler {
+	r := gin.New()
+	r.Use(s.batchLimits)
+	r.POST("/api/generate", s.GenerateHandler)
+	r.POST("/api/chat", s.ChatHandler)
+	r.POST("/api/embed", s.EmbedHandler)
+	r.POST("/v1/chat/completions", openaimid.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", openaimid.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", openaimid.EmbeddingsMiddleware(), s.EmbedHandler)
+	r.POST("/v1/responses", openaimid.ResponsesMiddleware(), s.ChatHandler)
+	return r
+}
+
+// batchLimits is middleware that charges a batch request against the limits
+// of the key that created its batch. Batch requests wait for the limits
+// instead of failing, as nothing is waiting on them.
+func (s *Server) batchLimits(c *gin.Context) {
+	if s.apiKeys == nil {
+		c.Next()
+		return
+	}
+
+	owner, _ := c.Request.Context().Value(batchOwnerKey{}).(string)
+	key := s.apiKeys.byID(owner)
+	if key == nil {
+		// the key was removed after the batch was created
+		writeAuthError(c.Writer, http.StatusUnauthorized, errInvalidAPIKey)
+		c.Abort()
+		return
+	}
+
+	if !key.hasScope(scopeInference) {
+		writeAuthError(c.Writer, http.StatusForbidden, errScopeForbidden)
+		c.Abort()
+		return
+	}
+
+	for {
+		now := time.Now()
+		wait := key.tokens.wait(1, now)
+		if wait == 0 {
+			wait = key.requests.take(1, now)
+		}
+		if wait == 0 {
+			break
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": c.Request.Context().Err().Error()})
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	c.Set(apiKeyContextKey, key)
+	c.Next()
+}
+
+// runBatches runs batches one at a time, oldest first, until ctx is done.
+// Batches interrupted by a restart continue where they left off.
+func (s *Server) runBatches(ctx context.Context) {
+	h := s.batchRoutes()
+	for {
+		if b := s.batches.next(); b != nil {
+			s.runBatch(ctx, h, b)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.batches.wake:
+		}
+	}
+}
+
+// batchConcurrency is the number of requests of a batch queued at once.
+func batchConcurrency() int {
+	if n := int(envconfig.NumParallel()); n > 0 {
+		return n
+	}
+	return defaultParallel
+}
+
+func (s *Server) runBatch(ctx context.Context, h http.Handler, b *batch) {
+	st := s.batches
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	st.mu.Lock()
+	status := b.Status
+	st.cancels[b.ID] = cancel
+	st.mu.Unlock()
+
+	defer func() {
+		st.mu.Lock()
+		delete(st.cancels, b.ID)
+		st.mu.Unlock()
+	}()
+
+	if status == api.BatchStatusCancelling {
+		s.finishBatch(b, false)
+		return
+	}
+
+	inputs, errs, err := st.readInputs(b)
+	if err != nil {
+		errs = []api.BatchError{{Message: err.Error()}}
+	}
+
+	if status == api.BatchStatusValidating {
+		if len(errs) > 0 {
+			st.update(b, func(b *batch) {
+				now := time.Now()
+				b.Status = api.BatchStatusFailed
+				b.FailedAt = &now
+				b.Errors = errs
+			})
+			return
+		}
+
+		outputs, err := st.createFile(strings.NewReader(""), b.ID+"_output.jsonl", "batch_output", b.Owner)
+		if err == nil {
+			var errors *batchFile
+			errors, err = st.createFile(strings.NewReader(""), b.ID+"_error.jsonl", "batch_output", b.Owner)
+			if err == nil {
+				st.update(b, func(b *batch) {
+					now := time.Now()
+					b.Status = api.BatchStatusInProgress
+					b.InProgressAt = &now
+					b.OutputFileID = outputs.ID
+					b.ErrorFileID = errors.ID
+					b.RequestCounts.Total = len(inputs)
+				})
+			}
+		}
+		if err != nil {
+			slog.Error("failed to start batch", "id", b.ID, "error", err)
+			st.update(b, func(b *batch) {
+				now := time.Now()
+				b.Status = api.BatchStatusFailed
+				b.FailedAt = &now
+				b.Errors = []api.BatchError{{Message: err.Error()}}
+			})
+			return
+		}
+	} else if err != nil {
+		slog.Error("failed to resume batch", "id", b.ID, "error", err)
+		return
+	}
+
+	w, err := st.openResults(b)
+	if err != nil {
+		slog.Error("failed to open batch results", "id", b.ID, "error", err)
+		return
+	}
+	defer w.Close()
+
+	slog.Info("running batch", "id", b.ID, "requests", len(inputs), "done", len(w.done))
+
+	sem := make(chan struct{}, batchConcurrency())
+	var wg sync.WaitGroup
+	var expired bool
+	for i, input := range inputs {
+		if w.finished(i) {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if time.Now().After(b.ExpiresAt) {
+			<-sem
+			expired = true
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+
+			out := runBatchRequest(ctx, h, b, i, input)
+			// requests interrupted by a cancellation or shutdown are not
+			// results
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := w.write(out); err != nil {
+				slog.Error("failed to write batch result", "id", b.ID, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if parent.Err() != nil {
+		// shutting down, the batch continues on the next start
+		return
+	}
+
+	if expired {
+		for i, input := range inputs {
+			if !w.done[i] {
+				w.write(opentypes.BatchOutput{ //nolint:errcheck
+					ID:       batchRequestID(b, i),
+					CustomID: input.CustomID,
+					Error:    &opentypes.BatchOutputError{Code: "batch_expired", Message: "This request could not be executed before the completion window expired."},
+				})
+			}
+		}
+	}
+
+	s.finishBatch(b, expired)
+}
+
+// finishBatch sets the final status of a batch that ran all the requests it
+// will run, which is expired if its completion window ended first.
+func (s *Server) finishBatch(b *batch, expired bool) {
+	st := s.batches
+	st.update(b, func(b *batch) {
+		now := time.Now()
+		switch {
+		case b.Status == api.BatchStatusCancelling:
+			b.Status = api.BatchStatusCancelled
+			b.CancelledAt = &now
+		case expired:
+			b.Status = api.BatchStatusExpired
+			b.ExpiredAt = &now
+		default:
+			b.Status = api.BatchStatusFinalizing
+			b.FinalizingAt = &now
+		}
+	})
+
+	for _, id := range []string{b.OutputFileID, b.ErrorFileID} {
+		st.mu.Lock()
+		if f, ok := st.files[id]; ok {
+			if fi, err := os.Stat(st.filePath(id)); err == nil {
+				f.Bytes = fi.Size()
+				if err := st.saveFile(f); err != nil {
+					slog.Error("failed to save batch file", "id", id, "error", err)
+				}
+			}
+		}
+		st.mu.Unlock()
+	}
+
+	st.update(b, func(b *batch) {
+		if b.Status == api.BatchStatusFinalizing {
+			now := time.Now()
+			b.Status = api.BatchStatusCompleted
+			b.CompletedAt = &now
+		}
+	})
+
+	slog.Info("batch finished", "id", b.ID, "status", b.Status)
+}
+
+// readInputs reads and validates the requests of a batch's input file.
+func (st *batchStore) readInputs(b *batch) ([]opentypes.BatchInput, []api.BatchError, error) {
+	f, err := os.Open(st.filePath(b.InputFileID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("input file %q not found", b.InputFileID)
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	endpoints := nativeBatchEndpoints
+	if b.Endpoint != "" {
+		endpoints = []string{b.Endpoint}
+	}
+
+	var inputs []opentypes.BatchInput
+	var errs []api.BatchError
+	ids := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), batchMaxFileBytes)
+	for i := 0; scanner.Scan(); i++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			i--
+			continue
+		}
+
+		invalid := func(format string, args ...any) {
+			errs = append(errs, api.BatchError{Index: i, Message: fmt.Sprintf(format, args...)})
+		}
+
+		var input opentypes.BatchInput
+		switch err := json.Unmarshal(line, &input); {
+		case err != nil:
+			invalid("invalid JSON: %v", err)
+		case input.CustomID == "":
+			invalid("custom_id is required")
+		case ids[input.CustomID]:
+			invalid("custom_id %q is not unique", input.CustomID)
+		case input.Method != http.MethodPost:
+			invalid("method must be POST")
+		case !slices.Contains(endpoints, input.URL):
+			invalid("url must be one of %s", strings.Join(endpoints, ", "))
+		case !bytes.HasPrefix(bytes.TrimSpace(input.Body), []byte("{")):
+			invalid("body must be an object")
+		}
+
+		ids[input.CustomID] = true
+		inputs = append(inputs, input)
+		if len(errs) >= 100 {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case len(errs) > 0:
+	case len(inputs) == 0:
+		errs = append(errs, api.BatchError{Message: "input file has no requests"})
+	case len(inputs) > batchMaxRequests:
+		errs = append(errs, api.BatchError{Message: fmt.Sprintf("input file has more than %d requests", batchMaxRequests)})
+	}
+
+	return inputs, errs, nil
+}
+
+// batchRequestID returns the ID of the result of the i-th request of b.
+func batchRequestID(b *batch, i int) string {
+	return fmt.Sprintf("batch_req_%s_%d", strings.TrimPrefix(b.ID, "batch_"), i)
+}
+
+// batchResults appends the results of a batch to its output and error
+// files.
+type batchResults struct {
+	st       *batchStore
+	b        *batch
+	mu       sync.Mutex
+	outputs  *os.File
+	failures *os.File
+	// done are the requests that have a result
+	done map[int]bool
+}
+
+// openResults opens the result files of b, dropping any line left partially
+// written by a crash.
+func (st *batchStore) openResults(b *batch) (*batchResults, error) {
+	w := &batchResults{st: st, b: b, done: make(map[int]bool)}
+	for _, f := range []struct {
+		id string
+		f  **os.File
+	}{
+		{b.OutputFileID, &w.outputs},
+		{b.ErrorFileID, &w.failures},
+	} {
+		path := st.filePath(f.id)
+		bts, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			w.Close()
+			return nil, err
+		}
+
+		var valid []byte
+		for line := range bytes.Lines(bts) {
+			var out opentypes.BatchOutput
+			if !bytes.HasSuffix(line, []byte("\n")) || json.Unmarshal(line, &out) != nil {
+				break
+			}
+
+			i, err := strconv.Atoi(out.ID[strings.LastIndex(out.ID, "_")+1:])
+			if err != nil {
+				break
+			}
+			w.done[i] = true
+			valid = append(valid, line...)
+		}
+
+		if len(valid) < len(bts) {
+			if err := os.WriteFile(path, valid, 0o644); err != nil {
+				w.Close()
+				return nil, err
+			}
+		}
+
+		*f.f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *batchResults) write(out opentypes.BatchOutput) error {
+	line, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	i, _ := strconv.Atoi(out.ID[strings.LastIndex(out.ID, "_")+1:])
+	ok := out.Response != nil && out.Response.StatusCode < http.StatusBadRequest
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f := w.failures
+	if ok {
+		f = w.outputs
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	w.done[i] = true
+	w.st.update(w.b, func(b *batch) {
+		if ok {
+			b.RequestCounts.Completed++
+		} else {
+			b.RequestCounts.Failed++
+		}
+	})
+	return nil
+}
+
+func (w *batchResults) finished(i int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.done[i]
+}
+
+func (w *batchResults) Close() error {
+	var errs []error
+	for _, f := range []*os.File{w.outputs, w.failures} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// batchPriorityKey is the context key of the priority batch requests are
+// queued at, which replaces the priority in their body.
+type batchPriorityKey struct{}
+
+// batchOwnerKey is the context key of the owner of the batch a request
+// belongs to.
+type batchOwnerKey struct{}
+
+// runBatchRequest runs the i-th request of b through h.
+func runBatchRequest(ctx context.Context, h http.Handler, b *batch, i int, input opentypes.BatchInput) opentypes.BatchOutput {
+	out := opentypes.BatchOutput{ID: batchRequestID(b, i), CustomID: input.CustomID}
+
+	// responses are collected whole, so they are never streamed
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(input.Body, &body); err != nil {
+		out.Error = &opentypes.BatchOutputError{Code: "invalid_request", Message: err.Error()}
+		return out
+	}
+	body["stream"] = json.RawMessage("false")
+
+	bts, err := json.Marshal(body)
+	if err != nil {
+		out.Error = &opentypes.BatchOutputError{Code: "invalid_request", Message: err.Error()}
+		return out
+	}
+
+	ctx = context.WithValue(ctx, batchPriorityKey{}, api.PriorityLow)
+	ctx = context.WithValue(ctx, batchOwnerKey{}, b.Owner)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, input.URL, bytes.NewReader(bts))
+	if err != nil {
+		out.Error = &opentypes.BatchOutputError{Code: "invalid_request", Message: err.Error()}
+		return out
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Goobla-Client", b.Client)
+	r.RemoteAddr = "127.0.0.1:0"
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	h.ServeHTTP(w, r)
+
+	out.Response = &opentypes.BatchOutputResponse{
+		StatusCode: cmp.Or(w.status, http.StatusOK),
+		RequestID:  w.header.Get(requestIDHeader),
+		Body:       bytes.TrimSpace(w.body.Bytes()),
+	}
+	if !json.Valid(out.Response.Body) {
+		out.Response.Body, _ = json.Marshal(w.body.String())
+	}
+	return out
+}
+
+// batchResponseWriter collects the response to a batch request.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Flush() {}
+
+func (b batch) response() api.BatchResponse {
+	resp := api.BatchResponse{
+		ID:            b.ID,
+		Status:        b.Status,
+		RequestCounts: b.RequestCounts,
+		CreatedAt:     b.CreatedAt,
+		ExpiresAt:     b.ExpiresAt,
+		CompletedAt:   cmp.Or(b.CompletedAt, b.FailedAt, b.ExpiredAt, b.CancelledAt),
+		Errors:        b.Errors,
+		Metadata:      b.Metadata,
+	}
+	return resp
+}
+
+func (s *Server) CreateBatchHandler(c *gin.Context) {
+	var req api.BatchRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Requests) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "requests are required"})
+		return
+	}
+
+	// the requests become the input file of the batch
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	enc.SetEscapeHTML(false)
+	for _, r := range req.Requests {
+		if err := enc.Encode(opentypes.BatchInput{
+			CustomID: r.CustomID,
+			Method:   http.MethodPost,
+			URL:      r.URL,
+			Body:     r.Body,
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	owner := requestOwner(c)
+	f, err := s.batches.createFile(&input, "batch_input.jsonl", "batch", owner)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	b, err := s.batches.create(f.ID, "", s.requestClient(c), owner, req.Metadata)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, b.response())
+}
+
+func (s *Server) ListBatchesHandler(c *gin.Context) {
+	batches := s.batches.list(requestOwner(c))
+	resp := api.ListBatchesResponse{Batches: make([]api.BatchResponse, len(batches))}
+	for i, b := range batches {
+		resp.Batches[i] = b.response()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) BatchHandler(c *gin.Context) {
+	b, ok := s.batches.get(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("batch %q not found", c.Param("id"))})
+		return
+	}
+	c.JSON(http.StatusOK, b.response())
+}
+
+func (s *Server) CancelBatchHandler(c *gin.Context) {
+	if _, err := s.batches.cancel(c.Param("id"), requestOwner(c)); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("batch %q not found", c.Param("id"))})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// BatchResultsHandler streams the results of the finished requests of a
+// batch as newline delimited [api.BatchResult] objects, successful results
+// first.
+func (s *Server) BatchResultsHandler(c *gin.Context) {
+	b, ok := s.batches.get(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("batch %q not found", c.Param("id"))})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for _, id := range []string{b.OutputFileID, b.ErrorFileID} {
+		if id == "" {
+			continue
+		}
+
+		f, err := os.Open(s.batches.filePath(id))
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), batchMaxFileBytes)
+		for scanner.Scan() {
+			var out opentypes.BatchOutput
+			if err := json.Unmarshal(scanner.Bytes(), &out); err != nil {
+				// a result being written
+				break
+			}
+
+			result := api.BatchResult{CustomID: out.CustomID}
+			if out.Response != nil {
+				result.StatusCode = out.Response.StatusCode
+				result.Body = out.Response.Body
+			}
+			if out.Error != nil {
+				result.ErrorMessage = out.Error.Message
+			}
+
+			if err := enc.Encode(result); err != nil {
+				f.Close()
+				return
+			}
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	opentypes "github.com/goobla/goobla/openai/types"
+)
+
+func toOpenAIFile(f batchFile) opentypes.File {
+	return opentypes.File{
+		ID:        f.ID,
+		Object:    "file",
+		Bytes:     f.Bytes,
+		CreatedAt: f.CreatedAt.Unix(),
+		Filename:  f.Filename,
+		Purpose:   f.Purpose,
+	}
+}
+
+func toOpenAIBatch(b batch) opentypes.Batch {
+	unix := func(t *time.Time) *int64 {
+		if t == nil {
+			return nil
+		}
+		u := t.Unix()
+		return &u
+	}
+
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+
+	ob := opentypes.Batch{
+		ID:               b.ID,
+		Object:           "batch",
+		Endpoint:         b.Endpoint,
+		InputFileID:      b.InputFileID,
+		CompletionWindow: "24h",
+		Status:           string(b.Status),
+		OutputFileID:     optional(b.OutputFileID),
+		ErrorFileID:      optional(b.ErrorFileID),
+		CreatedAt:        b.CreatedAt.Unix(),
+		InProgressAt:     unix(b.InProgressAt),
+		ExpiresAt:        unix(&b.ExpiresAt),
+		FinalizingAt:     unix(b.FinalizingAt),
+		CompletedAt:      unix(b.CompletedAt),
+		FailedAt:         unix(b.FailedAt),
+		ExpiredAt:        unix(b.ExpiredAt),
+		CancellingAt:     unix(b.CancellingAt),
+		CancelledAt:      unix(b.CancelledAt),
+		RequestCounts: opentypes.BatchRequestCounts{
+			Total:     b.RequestCounts.Total,
+			Completed: b.RequestCounts.Completed,
+			Failed:    b.RequestCounts.Failed,
+		},
+		Metadata: b.Metadata,
+	}
+
+	if len(b.Errors) > 0 {
+		ob.Errors = &opentypes.BatchErrors{Object: "list"}
+		for _, e := range b.Errors {
+			line := e.Index + 1
+			ob.Errors.Data = append(ob.Errors.Data, opentypes.BatchError{
+				Code:    "invalid_request",
+				Message: e.Message,
+				Line:    &line,
+			})
+		}
+	}
+
+	return ob
+}
+
+func openaiError(c *gin.Context, code int, format string, args ...any) {
+	c.AbortWithStatusJSON(code, opentypes.NewError(code, fmt.Sprintf(format, args...)))
+}
+
+func (s *Server) OpenAICreateFileHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, batchMaxFileBytes+1<<20)
+
+	if purpose := c.PostForm("purpose"); purpose != "batch" {
+		openaiError(c, http.StatusBadRequest, "unsupported purpose %q, must be \"batch\"", purpose)
+		return
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		openaiError(c, http.StatusBadRequest, "file is required: %v", err)
+		return
+	}
+
+	r, err := fh.Open()
+	if err != nil {
+		openaiError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+	defer r.Close()
+
+	f, err := s.batches.createFile(r, fh.Filename, "batch", requestOwner(c))
+	if err != nil {
+		openaiError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIFile(*f))
+}
+
+func (s *Server) OpenAIListFilesHandler(c *gin.Context) {
+	owned := s.batches.listFiles(requestOwner(c))
+	files := make([]opentypes.File, 0, len(owned))
+	for _, f := range owned {
+		files = append(files, toOpenAIFile(f))
+	}
+
+	// newest first
+	slices.SortFunc(files, func(a, b opentypes.File) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	c.JSON(http.StatusOK, opentypes.ListFiles{Object: "list", Data: files})
+}
+
+func (s *Server) OpenAIFileHandler(c *gin.Context) {
+	f, ok := s.batches.file(c.Param("id"), requestOwner(c))
+	if !ok {
+		openaiError(c, http.StatusNotFound, "file %q not found", c.Param("id"))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIFile(f))
+}
+
+func (s *Server) OpenAIFileContentHandler(c *gin.Context) {
+	if _, ok := s.batches.file(c.Param("id"), requestOwner(c)); !ok {
+		openaiError(c, http.StatusNotFound, "file %q not found", c.Param("id"))
+		return
+	}
+
+	c.Header("Content-Type", "application/jsonl")
+	http.ServeFile(c.Writer, c.Request, s.batches.filePath(c.Param("id")))
+}
+
+func (s *Server) OpenAIDeleteFileHandler(c *gin.Context) {
+	if !s.batches.deleteFile(c.Param("id"), requestOwner(c)) {
+		openaiError(c, http.StatusNotFound, "file %q not found", c.Param("id"))
+		return
+	}
+	c.JSON(http.StatusOK, opentypes.FileDeleted{ID: c.Param("id"), Object: "file", Deleted: true})
+}
+
+func (s *Server) OpenAICreateBatchHandler(c *gin.Context) {
+	var req opentypes.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openaiError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if !slices.Contains(opentypes.BatchEndpoints, req.Endpoint) {
+		openaiError(c, http.StatusBadRequest, "unsupported endpoint %q, must be one of %s", req.Endpoint, strings.Join(opentypes.BatchEndpoints, ", "))
+		return
+	}
+
+	if req.CompletionWindow != "24h" {
+		openaiError(c, http.StatusBadRequest, "unsupported completion_window %q, must be \"24h\"", req.CompletionWindow)
+		return
+	}
+
+	owner := requestOwner(c)
+	if f, ok := s.batches.file(req.InputFileID, owner); !ok || f.Purpose != "batch" {
+		openaiError(c, http.StatusBadRequest, "input file %q not found", req.InputFileID)
+		return
+	}
+
+	b, err := s.batches.create(req.InputFileID, req.Endpoint, s.requestClient(c), owner, req.Metadata)
+	if err != nil {
+		openaiError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIBatch(b))
+}
+
+func (s *Server) OpenAIListBatchesHandler(c *gin.Context) {
+	resp := opentypes.ListBatches{Object: "list", Data: []opentypes.Batch{}}
+	for _, b := range s.batches.list(requestOwner(c)) {
+		resp.Data = append(resp.Data, toOpenAIBatch(b))
+	}
+
+	if len(resp.Data) > 0 {
+		resp.FirstID = &resp.Data[0].ID
+		resp.LastID = &resp.Data[len(resp.Data)-1].ID
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) OpenAIBatchHandler(c *gin.Context) {
+	b, ok := s.batches.get(c.Param("id"), requestOwner(c))
+	if !ok {
+		openaiError(c, http.StatusNotFound, "batch %q not found", c.Param("id"))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(b))
+}
+
+func (s *Server) OpenAICancelBatchHandler(c *gin.Context) {
+	b, err := s.batches.cancel(c.Param("id"), requestOwner(c))
+	if errors.Is(err, os.ErrNotExist) {
+		openaiError(c, http.StatusNotFound, "batch %q not found", c.Param("id"))
+		return
+	} else if err != nil {
+		openaiError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(b))
+}
@@ -0,0 +1,573 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/discover"
+	"github.com/goobla/goobla/fs/ggml"
+	"github.com/goobla/goobla/llm"
+	opentypes "github.com/goobla/goobla/openai/types"
+)
+
+func newBatchTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	t.Setenv("GOOBLA_NUM_PARALLEL", "1")
+
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "echo " + r.Prompt, Done: true, DoneReason: llm.DoneReasonStop})
+			return nil
+		},
+	}
+
+	s := &Server{
+		sched: &Scheduler{
+			queue:         newPendingQueue(1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{llama: &mock}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []*ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: `{{ .Prompt }}`,
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	router, err := s.GenerateRoutes(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, router
+}
+
+func serve(t *testing.T, h http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var r io.Reader
+	if body != nil {
+		bts, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(bts)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, r))
+	return w
+}
+
+// runNextBatch runs the oldest unfinished batch of s to completion.
+func runNextBatch(t *testing.T, s *Server) {
+	t.Helper()
+	b := s.batches.next()
+	if b == nil {
+		t.Fatal("expected a batch to run")
+	}
+	s.runBatch(t.Context(), s.batchRoutes(), b)
+}
+
+func TestBatch(t *testing.T) {
+	s, router := newBatchTestServer(t)
+
+	w := serve(t, router, http.MethodPost, "/api/batch", api.BatchRequest{
+		Requests: []api.BatchRequestItem{
+			{CustomID: "a", URL: "/api/generate", Body: json.RawMessage(`{"model":"test","prompt":"hello"}`)},
+			{CustomID: "b", URL: "/api/generate", Body: json.RawMessage(`{"model":"missing","prompt":"hello"}`)},
+			{CustomID: "c", URL: "/api/chat", Body: json.RawMessage(`{"model":"test","messages":[{"role":"user","content":"hi"}],"stream":true}`)},
+		},
+		Metadata: map[string]string{"job": "nightly"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var created api.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Status != api.BatchStatusValidating {
+		t.Fatalf("expected status validating, got %q", created.Status)
+	}
+
+	runNextBatch(t, s)
+
+	w = serve(t, router, http.MethodGet, "/api/batch/"+created.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var got api.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != api.BatchStatusCompleted || got.CompletedAt == nil {
+		t.Fatalf("expected completed batch, got %+v", got)
+	}
+	if diff := cmp.Diff(api.BatchRequestCounts{Total: 3, Completed: 2, Failed: 1}, got.RequestCounts); diff != "" {
+		t.Errorf("request counts mismatch (-want +got):\n%s", diff)
+	}
+	if got.Metadata["job"] != "nightly" {
+		t.Errorf("expected metadata to be kept, got %v", got.Metadata)
+	}
+
+	w = serve(t, router, http.MethodGet, "/api/batch/"+created.ID+"/results", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	results := make(map[string]api.BatchResult)
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var r api.BatchResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		results[r.CustomID] = r
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	var generated api.GenerateResponse
+	if err := json.Unmarshal(results["a"].Body, &generated); err != nil {
+		t.Fatal(err)
+	}
+	if results["a"].StatusCode != http.StatusOK || generated.Response != "echo hello" {
+		t.Errorf("unexpected result %+v", results["a"])
+	}
+
+	if results["b"].StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing model, got %d", results["b"].StatusCode)
+	}
+
+	// streaming is turned off, so the body is a single response
+	var chat api.ChatResponse
+	if err := json.Unmarshal(results["c"].Body, &chat); err != nil {
+		t.Fatal(err)
+	}
+	if !chat.Done || chat.Message.Content != "echo hi" {
+		t.Errorf("unexpected chat result %+v", chat)
+	}
+
+	// batches are reloaded from the models directory
+	dir, err := batchesPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadBatches(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := st.get(created.ID, ""); !ok || b.Status != api.BatchStatusCompleted {
+		t.Errorf("expected reloaded batch to be completed, got %+v", b)
+	}
+}
+
+func TestBatchOpenAI(t *testing.T) {
+	s, router := newBatchTestServer(t)
+
+	upload := func(t *testing.T, content string) opentypes.File {
+		t.Helper()
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		if err := mw.WriteField("purpose", "batch"); err != nil {
+			t.Fatal(err)
+		}
+		fw, err := mw.CreateFormFile("file", "input.jsonl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/files", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var f opentypes.File
+		if err := json.NewDecoder(w.Body).Decode(&f); err != nil {
+			t.Fatal(err)
+		}
+		if f.Bytes != int64(len(content)) || f.Purpose != "batch" {
+			t.Fatalf("unexpected file %+v", f)
+		}
+		return f
+	}
+
+	batch := func(t *testing.T, fileID string) opentypes.Batch {
+		t.Helper()
+
+		w := serve(t, router, http.MethodPost, "/v1/batches", opentypes.BatchRequest{
+			InputFileID:      fileID,
+			Endpoint:         "/v1/chat/completions",
+			CompletionWindow: "24h",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var b opentypes.Batch
+		if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		runNextBatch(t, s)
+
+		w = serve(t, router, http.MethodGet, "/v1/batches/"+b.ID, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	t.Run("completed", func(t *testing.T) {
+		f := upload(t, `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"test","messages":[{"role":"user","content":"hi"}]}}
+{"custom_id":"2","method":"POST","url":"/v1/chat/completions","body":{"model":"test","messages":[{"role":"user","content":"bye"}]}}
+`)
+
+		b := batch(t, f.ID)
+		if b.Status != "completed" || b.OutputFileID == nil || b.ErrorFileID == nil {
+			t.Fatalf("expected completed batch with output files, got %+v", b)
+		}
+		if b.RequestCounts.Total != 2 || b.RequestCounts.Completed != 2 {
+			t.Errorf("unexpected request counts %+v", b.RequestCounts)
+		}
+
+		w := serve(t, router, http.MethodGet, "/v1/files/"+*b.OutputFileID+"/content", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		outputs := make(map[string]opentypes.BatchOutput)
+		for line := range strings.Lines(w.Body.String()) {
+			var out opentypes.BatchOutput
+			if err := json.Unmarshal([]byte(line), &out); err != nil {
+				t.Fatal(err)
+			}
+			outputs[out.CustomID] = out
+		}
+
+		out := outputs["2"]
+		if out.Response == nil || out.Response.StatusCode != http.StatusOK || !strings.HasPrefix(out.ID, "batch_req_") {
+			t.Fatalf("unexpected output %+v", out)
+		}
+
+		var completion opentypes.ChatCompletion
+		if err := json.Unmarshal(out.Response.Body, &completion); err != nil {
+			t.Fatal(err)
+		}
+		if completion.Object != "chat.completion" || completion.Choices[0].Message.Content != "echo bye" {
+			t.Errorf("unexpected completion %+v", completion)
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		f := upload(t, `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{}}
+{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{}}
+{"custom_id":"2","method":"GET","url":"/v1/chat/completions","body":{}}
+{"custom_id":"3","method":"POST","url":"/v1/embeddings","body":{}}
+not json
+`)
+
+		b := batch(t, f.ID)
+		if b.Status != "failed" || b.Errors == nil {
+			t.Fatalf("expected failed batch with errors, got %+v", b)
+		}
+
+		var lines []int
+		for _, e := range b.Errors.Data {
+			lines = append(lines, *e.Line)
+		}
+		if diff := cmp.Diff([]int{2, 3, 4, 5}, lines); diff != "" {
+			t.Errorf("error lines mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unsupported endpoint", func(t *testing.T) {
+		w := serve(t, router, http.MethodPost, "/v1/batches", opentypes.BatchRequest{
+			InputFileID:      "file-missing",
+			Endpoint:         "/v1/models",
+			CompletionWindow: "24h",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("delete file", func(t *testing.T) {
+		f := upload(t, "{}\n")
+
+		w := serve(t, router, http.MethodDelete, "/v1/files/"+f.ID, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		w = serve(t, router, http.MethodGet, "/v1/files/"+f.ID, nil)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status 404, got %d", w.Code)
+		}
+	})
+}
+
+func TestBatchCancel(t *testing.T) {
+	s, router := newBatchTestServer(t)
+
+	w := serve(t, router, http.MethodPost, "/api/batch", api.BatchRequest{
+		Requests: []api.BatchRequestItem{
+			{CustomID: "a", URL: "/api/generate", Body: json.RawMessage(`{"model":"test","prompt":"hello"}`)},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var created api.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(t, router, http.MethodDelete, "/api/batch/"+created.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	runNextBatch(t, s)
+
+	b, _ := s.batches.get(created.ID, "")
+	if b.Status != api.BatchStatusCancelled || b.RequestCounts.Completed != 0 {
+		t.Fatalf("expected cancelled batch without results, got %+v", b)
+	}
+
+	if next := s.batches.next(); next != nil {
+		t.Fatalf("expected no batch to run, got %s", next.ID)
+	}
+
+	w = serve(t, router, http.MethodDelete, "/api/batch/batch_missing", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestBatchOwner(t *testing.T) {
+	s, router := newBatchTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	if err := os.WriteFile(path, []byte(`{"keys":[
+		{"name":"a","key":"a-key","requests_per_minute":10},
+		{"name":"b","key":"b-key"}
+	]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.apiKeys = keys
+
+	serveAs := func(t *testing.T, key, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var r io.Reader
+		if body != nil {
+			bts, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = bytes.NewReader(bts)
+		}
+
+		req := httptest.NewRequest(method, path, r)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serveAs(t, "a-key", http.MethodPost, "/api/batch", api.BatchRequest{
+		Requests: []api.BatchRequestItem{
+			{CustomID: "a", URL: "/api/generate", Body: json.RawMessage(`{"model":"test","prompt":"hello"}`)},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var created api.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	runNextBatch(t, s)
+
+	// creating the batch and running its request are both charged
+	if tokens := keys.keys[0].requests.tokens; tokens > 8.5 {
+		t.Errorf("expected two requests charged to the key, %.2f remain", tokens)
+	}
+
+	for _, path := range []string{"/api/batch/" + created.ID, "/api/batch/" + created.ID + "/results"} {
+		if w := serveAs(t, "a-key", http.MethodGet, path, nil); w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200 for the owner, got %d", path, w.Code)
+		}
+		if w := serveAs(t, "b-key", http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404 for another key, got %d", path, w.Code)
+		}
+	}
+
+	if w := serveAs(t, "b-key", http.MethodDelete, "/api/batch/"+created.ID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected another key not to cancel the batch, got %d", w.Code)
+	}
+
+	for key, want := range map[string]int{"a-key": 1, "b-key": 0} {
+		w := serveAs(t, key, http.MethodGet, "/api/batch", nil)
+		var resp api.ListBatchesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Batches) != want {
+			t.Errorf("%s: expected %d batches, got %d", key, want, len(resp.Batches))
+		}
+
+		w = serveAs(t, key, http.MethodGet, "/v1/files", nil)
+		var files opentypes.ListFiles
+		if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
+			t.Fatal(err)
+		}
+		// the input, output and error files
+		if len(files.Data) != 3*want {
+			t.Errorf("%s: expected %d files, got %d", key, 3*want, len(files.Data))
+		}
+	}
+}
+
+func TestBatchResume(t *testing.T) {
+	st, err := loadBatches(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &batch{ID: "batch_test"}
+	for _, id := range []*string{&b.OutputFileID, &b.ErrorFileID} {
+		f, err := st.createFile(strings.NewReader(""), "output.jsonl", "batch_output", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		*id = f.ID
+	}
+
+	// the server stopped while writing the result of the third request
+	output := `{"id":"batch_req_test_0","custom_id":"a","response":{"status_code":200,"request_id":"","body":{}},"error":null}
+{"id":"batch_req_test_2","custom_id":"c","resp`
+	if err := os.WriteFile(st.filePath(b.OutputFileID), []byte(output), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := st.openResults(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(map[int]bool{0: true}, w.done); diff != "" {
+		t.Errorf("done mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := w.write(opentypes.BatchOutput{ID: batchRequestID(b, 2), CustomID: "c", Error: &opentypes.BatchOutputError{Code: "batch_expired"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(st.filePath(b.OutputFileID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := output[:strings.Index(output, "\n")+1]; string(bts) != want {
+		t.Errorf("expected the partial line to be dropped, got %q", bts)
+	}
+
+	bts, err = os.ReadFile(st.filePath(b.ErrorFileID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bts), `"custom_id":"c"`) {
+		t.Errorf("expected the failure in the error file, got %q", bts)
+	}
+
+	if b.RequestCounts.Failed != 1 {
+		t.Errorf("expected 1 failed request, got %d", b.RequestCounts.Failed)
+	}
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/types/model"
+)
+
+// A bundle is a tar archive of models in the OCI image layout, for moving
+// models to machines that cannot reach a registry:
+//
+//	oci-layout
+//	blobs/sha256/<hex>
+//	index.json
+//
+// Manifests, configs and layers are all blobs. index.json lists the manifest
+// of each model, with its name in the org.opencontainers.image.ref.name
+// annotation and its signature, if it is signed, in the
+// com.goobla.signature annotation.
+const (
+	bundleLayoutFile = "oci-layout"
+	bundleIndexFile  = "index.json"
+	bundleBlobsDir   = "blobs/sha256/"
+
+	bundleRefNameAnnotation   = "org.opencontainers.image.ref.name"
+	bundleSignatureAnnotation = "com.goobla.signature"
+
+	// maxBundleIndexSize limits the size of the index of a bundle, which
+	// is read into memory.
+	maxBundleIndexSize = 1 << 20
+)
+
+var errInvalidBundle = errors.New("invalid bundle")
+
+type bundleIndex struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType"`
+	Manifests     []bundleDescriptor `json:"manifests"`
+}
+
+type bundleDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// writeBundle writes a bundle of the model n to w. It returns an error before
+// writing anything if a blob of the model is missing.
+func writeBundle(w io.Writer, n model.Name) error {
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(m.filepath)
+	if err != nil {
+		return err
+	}
+	digest, _ := GetSHA256Digest(bytes.NewReader(data))
+
+	annotations := map[string]string{bundleRefNameAnnotation: n.String()}
+	sig, err := readModelSignature(digest)
+	if err != nil {
+		return err
+	} else if sig != "" {
+		annotations[bundleSignatureAnnotation] = sig
+	}
+
+	var blobs []string
+	seen := make(map[string]bool)
+	for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+		if layer.Digest == "" || seen[layer.Digest] {
+			continue
+		}
+		seen[layer.Digest] = true
+
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(p); err != nil {
+			return fmt.Errorf("missing blob %s: %w", layer.Digest, err)
+		}
+		blobs = append(blobs, layer.Digest)
+	}
+
+	index, err := json.Marshal(bundleIndex{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests: []bundleDescriptor{{
+			MediaType:   cmp.Or(m.MediaType, "application/vnd.docker.distribution.manifest.v2+json"),
+			Digest:      digest,
+			Size:        int64(len(data)),
+			Annotations: annotations,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+
+	tw := tar.NewWriter(w)
+	if err := writeBundleFile(tw, bundleLayoutFile, bytes.NewReader(layout), int64(len(layout))); err != nil {
+		return err
+	}
+
+	if err := writeBundleFile(tw, bundleBlobName(digest), bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+
+	for _, digest := range blobs {
+		if err := writeBundleBlob(tw, digest); err != nil {
+			return err
+		}
+	}
+
+	if err := writeBundleFile(tw, bundleIndexFile, bytes.NewReader(index), int64(len(index))); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func bundleBlobName(digest string) string {
+	return bundleBlobsDir + strings.TrimPrefix(digest, "sha256:")
+}
+
+func writeBundleBlob(tw *tar.Writer, digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeBundleFile(tw, bundleBlobName(digest), f, fi.Size())
+}
+
+func writeBundleFile(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: time.Unix(0, 0),
+		Format:  tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// importBundle imports the models in the bundle read from r, verifying the
+// digest of each blob and the signature of each manifest, and returns their
+// names. Manifests are only written once all of their blobs are present and
+// every signature has been checked, so a bundle that is cut short or holds a
+// model GOOBLA_REQUIRE_SIGNATURES refuses does not leave models behind.
+func importBundle(r io.Reader) ([]model.Name, error) {
+	var index *bundleIndex
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidBundle, err)
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "./")
+		switch {
+		case hdr.Typeflag == tar.TypeDir, name == bundleLayoutFile:
+		case name == bundleIndexFile:
+			index = &bundleIndex{}
+			if err := json.NewDecoder(io.LimitReader(tr, maxBundleIndexSize)).Decode(index); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", errInvalidBundle, name, err)
+			}
+		case strings.HasPrefix(name, bundleBlobsDir) && hdr.Typeflag == tar.TypeReg:
+			digest := "sha256:" + strings.TrimPrefix(name, bundleBlobsDir)
+			if err := importBundleBlob(digest, tr); errors.Is(err, ErrInvalidDigestFormat) {
+				return nil, fmt.Errorf("%w: %s: %w", errInvalidBundle, name, err)
+			} else if err != nil {
+				return nil, err
+			}
+		default:
+			slog.Debug("skipping unknown file in bundle", "name", hdr.Name)
+		}
+	}
+
+	if index == nil {
+		return nil, fmt.Errorf("%w: missing %s", errInvalidBundle, bundleIndexFile)
+	}
+
+	var names []model.Name
+	for _, desc := range index.Manifests {
+		n := model.ParseName(desc.Annotations[bundleRefNameAnnotation])
+		if !n.IsFullyQualified() {
+			return nil, fmt.Errorf("%w: manifest %s has an invalid name", errInvalidBundle, desc.Digest)
+		}
+
+		if _, err := GetBlobsPath(desc.Digest); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidBundle, err)
+		}
+
+		if err := checkModelSignature(desc.Digest, desc.Annotations[bundleSignatureAnnotation]); err != nil {
+			return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), err)
+		}
+		names = append(names, n)
+	}
+
+	for i, desc := range index.Manifests {
+		if err := importBundleManifest(names[i], desc.Digest); err != nil {
+			return nil, err
+		}
+
+		if sig := desc.Annotations[bundleSignatureAnnotation]; sig != "" {
+			if err := writeModelSignature(desc.Digest, sig); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// importBundleBlob copies the blob with digest from r to the blobs directory,
+// unless it is already there. The copy is verified before it is moved into
+// place, so a blob that does not match its digest is never visible to other
+// models.
+func importBundleBlob(digest string, r io.Reader) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+"-partial-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: %w", errInvalidBundle, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		return fmt.Errorf("%w: want %s, got %s", errDigestMismatch, digest, got)
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+// importBundleManifest writes the manifest blob with digest as the manifest
+// of n, after checking that its blobs were imported.
+func importBundleManifest(n model.Name, digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errInvalidBundle, err)
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: missing manifest %s", errInvalidBundle, digest)
+	} else if err != nil {
+		return err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("%w: manifest %s: %w", errInvalidBundle, digest, err)
+	}
+
+	for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errInvalidBundle, err)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return fmt.Errorf("%w: missing blob %s", errInvalidBundle, layer.Digest)
+		}
+	}
+
+	fp, err := ParseModelPath(n.String()).GetManifestPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(fp, data, 0o644); err != nil {
+		return err
+	}
+
+	replaceAlias(n)
+	return nil
+}
+
+// ExportHandler writes a bundle of a model, which can be imported on machines
+// that cannot reach a registry.
+func (s *Server) ExportHandler(c *gin.Context) {
+	var req api.ExportRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	// exporting an alias exports the model it refers to
+	name, err = resolveAlias(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := ParseNamedManifest(name); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	// bundles take longer to write than the server allows for responses
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "application/x-tar")
+	if err := writeBundle(c.Writer, name); err != nil {
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// the status has been sent, so abort the response to keep the
+		// client from mistaking what was written for a whole bundle
+		slog.Error("export failed", "model", name, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// ImportHandler imports the models in a bundle written by [Server.ExportHandler].
+func (s *Server) ImportHandler(c *gin.Context) {
+	// bundles take longer to read than the server allows for requests
+	_ = http.NewResponseController(c.Writer).SetReadDeadline(time.Time{})
+
+	names, err := importBundle(c.Request.Body)
+	if errors.Is(err, errInvalidBundle) || errors.Is(err, errDigestMismatch) || isSignatureError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.enforceQuota(names...)
+
+	var resp api.ImportResponse
+	for _, n := range names {
+		resp.Models = append(resp.Models, n.DisplayShortest())
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/auth"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/client/goobla"
+	"github.com/goobla/goobla/server/internal/registry"
+	"github.com/goobla/goobla/types/model"
+)
+
+// createTestModel creates a model named name in the models directory set
+// with GOOBLA_MODELS and returns its manifest.
+func createTestModel(t *testing.T, name string) *Manifest {
+	t.Helper()
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  name,
+		Files:  map[string]string{"test.gguf": digest},
+		System: "You are a model.",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func importRequest(t *testing.T, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/import", body)
+
+	var s Server
+	s.ImportHandler(c)
+	return w.ResponseRecorder
+}
+
+func TestBundleExportImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	models := t.TempDir()
+	t.Setenv("GOOBLA_MODELS", models)
+	want := createTestModel(t, "test")
+
+	var s Server
+	w := createRequest(t, s.ExportHandler, api.ExportRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status = %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-tar" {
+		t.Errorf("Content-Type = %q, want application/x-tar", ct)
+	}
+	bundle := w.Body.Bytes()
+
+	// import into another models directory, as another machine would
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	w = importRequest(t, bytes.NewReader(bundle))
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.ImportResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Models) != 1 || resp.Models[0] != "test:latest" {
+		t.Errorf("imported models = %v, want [test:latest]", resp.Models)
+	}
+
+	got, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.digest != want.digest {
+		t.Errorf("imported manifest %s, want %s", got.digest, want.digest)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.System != "You are a model." {
+		t.Errorf("expected the system prompt to be imported, got %q", m.System)
+	}
+
+	// importing again finds every blob present
+	if w := importRequest(t, bytes.NewReader(bundle)); w.Code != http.StatusOK {
+		t.Errorf("second import: status = %d: %s", w.Code, w.Body.String())
+	}
+
+	// exporting an alias exports the model it refers to
+	t.Setenv("GOOBLA_MODELS", models)
+	if err := SetAlias(model.ParseName("prod"), model.ParseName("test")); err != nil {
+		t.Fatal(err)
+	}
+	w = createRequest(t, s.ExportHandler, api.ExportRequest{Model: "prod"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("export of alias: status = %d: %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), bundle) {
+		t.Error("expected the export of the alias to be the export of its model")
+	}
+
+	w = createRequest(t, s.ExportHandler, api.ExportRequest{Model: "unknown"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("export of unknown model: status = %d, want 404", w.Code)
+	}
+}
+
+func TestBundleExportAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	m := createTestModel(t, "test")
+
+	// a layer that can be found but not read fails the export partway
+	p, err := GetBlobsPath(m.Layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(p, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Server
+	router, err := s.GenerateRoutes(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/export", "application/json", strings.NewReader(`{"model":"test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 before the failure", resp.StatusCode)
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("expected the response to be aborted")
+	}
+}
+
+func TestBundleImportTampered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	m := createTestModel(t, "test")
+
+	var bundle bytes.Buffer
+	if err := writeBundle(&bundle, model.ParseName("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a byte of the model layer, keeping its name
+	var tampered bytes.Buffer
+	tr := tar.NewReader(&bundle)
+	tw := tar.NewWriter(&tampered)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == bundleBlobName(m.Layers[0].Digest) {
+			data[0] ^= 0xff
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	w := importRequest(t, &tampered)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), errDigestMismatch.Error()) {
+		t.Errorf("status = %d, body = %s; want 400 %q", w.Code, w.Body, errDigestMismatch)
+	}
+
+	if _, _, err := GetManifest(ParseModelPath("test")); !os.IsNotExist(err) {
+		t.Errorf("expected no manifest after a failed import, got %v", err)
+	}
+
+	p, err := GetBlobsPath(m.Layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("expected the tampered blob to be removed, got %v", err)
+	}
+
+	if w := importRequest(t, strings.NewReader("not a bundle")); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid bundle: status = %d, want 400", w.Code)
+	}
+}
+
+func TestBundleSignatures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keyPath := writeTestKey(t)
+	t.Setenv("GOOBLA_PRIVATE_KEY", keyPath)
+	t.Setenv("GOOBLA_CONFIG_DIR", t.TempDir())
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+
+	signed := createTestModel(t, "test")
+
+	var signedBundle, unsignedBundle bytes.Buffer
+	if err := writeBundle(&unsignedBundle, model.ParseName("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := auth.Sign(t.Context(), []byte("sha256:"+signed.digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeModelSignature("sha256:"+signed.digest, sig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeBundle(&signedBundle, model.ParseName("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	// import into another models directory that requires signatures
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	t.Setenv("GOOBLA_REQUIRE_SIGNATURES", "1")
+
+	w := importRequest(t, bytes.NewReader(unsignedBundle.Bytes()))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), goobla.ErrUnsigned.Error()) {
+		t.Errorf("unsigned: status = %d, body = %s; want 400 %q", w.Code, w.Body, goobla.ErrUnsigned)
+	}
+
+	w = importRequest(t, bytes.NewReader(signedBundle.Bytes()))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), goobla.ErrUntrustedSigner.Error()) {
+		t.Errorf("untrusted: status = %d, body = %s; want 400 %q", w.Code, w.Body, goobla.ErrUntrustedSigner)
+	}
+
+	if _, err := ParseNamedManifest(model.ParseName("test")); !os.IsNotExist(err) {
+		t.Errorf("expected no manifest after a refused import, got %v", err)
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(goobla.TrustedKeysFile(), ssh.MarshalAuthorizedKey(key.PublicKey()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	w = importRequest(t, bytes.NewReader(signedBundle.Bytes()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("trusted: status = %d: %s", w.Code, w.Body.String())
+	}
+
+	signer, err := modelSigner("sha256:" + signed.digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer == nil || !signer.Trusted {
+		t.Errorf("signer = %+v, want the imported signature to be kept", signer)
+	}
+}
+
+func TestPullModelMirrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	progress := func(api.ProgressResponse) {}
+
+	mirror := t.TempDir()
+	t.Setenv("GOOBLA_MODELS", mirror)
+	want := createTestModel(t, "test")
+
+	// a mirror that is down is skipped
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	t.Run("directory", func(t *testing.T) {
+		t.Setenv("GOOBLA_MODELS", t.TempDir())
+		t.Setenv("GOOBLA_MIRRORS", down.URL+","+mirror)
+
+		if err := PullModel(t.Context(), "test", &registryOptions{}, progress); err != nil {
+			t.Fatalf("pull: %v", err)
+		}
+
+		got, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.digest != want.digest {
+			t.Errorf("pulled manifest %s, want %s", got.digest, want.digest)
+		}
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		keyPath := writeTestKey(t)
+		t.Setenv("GOOBLA_PRIVATE_KEY", keyPath)
+
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := blob.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(&registry.Server{Cache: c, Logger: slog.Default(), Keys: []ssh.PublicKey{key.PublicKey()}})
+		defer ts.Close()
+
+		// push a copy of the model to the mirror
+		t.Setenv("GOOBLA_MODELS", mirror)
+		name := ts.Listener.Addr().String() + "/library/test:latest"
+		if err := CopyModel(model.ParseName("test"), model.ParseName(name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, progress); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+
+		t.Setenv("GOOBLA_MODELS", t.TempDir())
+		t.Setenv("GOOBLA_MIRRORS", ts.URL)
+		if err := PullModel(t.Context(), "test", &registryOptions{}, progress); err != nil {
+			t.Fatalf("pull: %v", err)
+		}
+
+		got, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.digest != want.digest {
+			t.Errorf("pulled manifest %s, want %s", got.digest, want.digest)
+		}
+
+		if _, err := os.Stat(filepath.Join(os.Getenv("GOOBLA_MODELS"), "manifests", ts.Listener.Addr().String())); !os.IsNotExist(err) {
+			t.Errorf("expected the model to be stored under its own name only, got %v", err)
+		}
+	})
+}
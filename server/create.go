@@ -129,7 +129,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		}
 
 		if err := createModel(r, name, baseLayers, fn); err != nil {
-			if errors.Is(err, errBadTemplate) {
+			if errors.Is(err, errBadTemplate) || errors.Is(err, errBadDraft) {
 				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 				return
 			}
@@ -143,6 +143,8 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			}
 		}
 
+		s.enforceQuota(name)
+
 		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
@@ -372,6 +374,13 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		}
 	}
 
+	if r.Draft != "" {
+		layers, err = setDraft(layers, r.Draft)
+		if err != nil {
+			return err
+		}
+	}
+
 	if r.License != nil {
 		switch l := r.License.(type) {
 		case string:
@@ -573,6 +582,38 @@ func setSystem(layers []Layer, s string) ([]Layer, error) {
 	return layers, nil
 }
 
+// setDraft replaces the draft model of layers with the weights of the model
+// named draft.
+func setDraft(layers []Layer, draft string) ([]Layer, error) {
+	name := model.ParseName(draft)
+	if !name.IsValid() {
+		return nil, fmt.Errorf("%w: %q is not a valid model name", errBadDraft, draft)
+	}
+
+	mf, err := ParseNamedManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: model %q not found, try pulling it first", errBadDraft, draft)
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, l := range mf.Layers {
+		if l.MediaType != "application/vnd.goobla.image.model" {
+			continue
+		}
+
+		layer, err := NewLayerFromLayer(l.Digest, "application/vnd.goobla.image.draft", name.DisplayShortest())
+		if err != nil {
+			return nil, err
+		}
+
+		layers = removeLayer(layers, "application/vnd.goobla.image.draft")
+		return append(layers, layer), nil
+	}
+
+	return nil, fmt.Errorf("%w: model %q has no weights", errBadDraft, draft)
+}
+
 func setLicense(layers []Layer, l string) ([]Layer, error) {
 	blob := strings.NewReader(l)
 	layer, err := NewLayer(blob, "application/vnd.goobla.image.license")
@@ -0,0 +1,466 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/format"
+	"github.com/goobla/goobla/types/model"
+)
+
+// The disk usage of the models directory is computed from the manifests it
+// holds. Blobs no manifest references are garbage, removed by
+// [Server.collectGarbage], which also evicts the least recently used models
+// while the models directory is over GOOBLA_MODELS_QUOTA.
+
+// blobGracePeriod protects recently written blobs that no manifest
+// references yet, such as the layers of a model being pulled or created,
+// from garbage collection.
+const blobGracePeriod = time.Hour
+
+var blobNameRe = regexp.MustCompile("^sha256-[0-9a-fA-F]{64}$")
+
+// gcMu serializes garbage collections.
+var gcMu sync.Mutex
+
+type blobFile struct {
+	// digest is the digest of the blob, or empty for files that are not
+	// named after one, such as partial downloads.
+	digest string
+
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// walkBlobs calls fn for each file in the blobs directory, in both the
+// sharded and the older flat layout.
+func walkBlobs(fn func(blobFile) error) error {
+	p, err := GetBlobsPath("")
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != p && filepath.Dir(path) != p {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		fi, err := d.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		b := blobFile{path: path, size: fi.Size(), modTime: fi.ModTime()}
+		if blobNameRe.MatchString(d.Name()) {
+			b.digest = strings.Replace(d.Name(), "-", ":", 1)
+		}
+		return fn(b)
+	})
+}
+
+// modelUsagePath returns the path of the file whose modification time
+// records when the model n was last used.
+func modelUsagePath(n model.Name) (string, error) {
+	dir, err := envconfig.Models()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage", n.Filepath()), nil
+}
+
+// touchModel records that the model name is being used.
+func touchModel(name string) error {
+	p, err := modelUsagePath(model.ParseName(name))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, nil, 0o644)
+}
+
+// removeModelUsage forgets when the model n was last used.
+func removeModelUsage(n model.Name) error {
+	p, err := modelUsagePath(n)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return PruneDirectory(filepath.Dir(p))
+}
+
+// diskModel is a model in a [diskState].
+type diskModel struct {
+	name     model.Name
+	manifest *Manifest
+	digests  []string
+	lastUsed time.Time
+	pinned   bool
+
+	// aliased is set if aliases refer to the model.
+	aliased bool
+}
+
+// diskState is a snapshot of the models directory.
+type diskState struct {
+	models []*diskModel
+	blobs  map[string]blobFile
+
+	// partial are the files in the blobs directory that are not blobs.
+	partial []blobFile
+}
+
+func loadDiskState() (*diskState, error) {
+	ms, err := Manifests(true)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := Aliases()
+	if err != nil {
+		return nil, err
+	}
+
+	// pinned aliases pin the models they resolve to
+	var pinned []model.Name
+	for _, s := range envconfig.PinnedModels() {
+		n := model.ParseName(s)
+		if r, err := resolveAlias(n); err != nil {
+			slog.Warn("couldn't resolve pinned model", "model", s, "error", err)
+		} else {
+			n = r
+		}
+		pinned = append(pinned, n)
+	}
+
+	st := diskState{blobs: make(map[string]blobFile)}
+	for n, m := range ms {
+		dm := diskModel{name: n, manifest: m, lastUsed: m.fi.ModTime()}
+		for _, l := range append(m.Layers, m.Config) {
+			if l.Digest != "" && !slices.Contains(dm.digests, l.Digest) {
+				dm.digests = append(dm.digests, l.Digest)
+			}
+		}
+
+		if p, err := modelUsagePath(n); err == nil {
+			if fi, err := os.Stat(p); err == nil && fi.ModTime().After(dm.lastUsed) {
+				dm.lastUsed = fi.ModTime()
+			}
+		}
+
+		dm.pinned = slices.ContainsFunc(pinned, n.EqualFold)
+		for _, target := range aliases {
+			if target.EqualFold(n) {
+				dm.aliased = true
+				break
+			}
+		}
+		st.models = append(st.models, &dm)
+	}
+
+	slices.SortFunc(st.models, func(a, b *diskModel) int {
+		return strings.Compare(a.name.DisplayShortest(), b.name.DisplayShortest())
+	})
+
+	err = walkBlobs(func(b blobFile) error {
+		if b.digest == "" {
+			st.partial = append(st.partial, b)
+		} else {
+			st.blobs[b.digest] = b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &st, nil
+}
+
+// refs returns the models using each blob.
+func (st *diskState) refs() map[string][]*diskModel {
+	refs := make(map[string][]*diskModel)
+	for _, m := range st.models {
+		for _, d := range m.digests {
+			refs[d] = append(refs[d], m)
+		}
+	}
+	return refs
+}
+
+// blobSize returns the size of the blob with digest on disk, or the size
+// recorded in the manifests using it if it is missing.
+func (st *diskState) blobSize(digest string) int64 {
+	if b, ok := st.blobs[digest]; ok {
+		return b.size
+	}
+
+	for _, m := range st.models {
+		for _, l := range append(m.manifest.Layers, m.manifest.Config) {
+			if l.Digest == digest {
+				return l.Size
+			}
+		}
+	}
+	return 0
+}
+
+func (st *diskState) size() (size int64) {
+	for _, b := range st.blobs {
+		size += b.size
+	}
+	for _, b := range st.partial {
+		size += b.size
+	}
+	return size
+}
+
+// diskUsage reports the disk usage of the models directory.
+func diskUsage() (*api.DiskUsageResponse, error) {
+	st, err := loadDiskState()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := st.refs()
+	resp := api.DiskUsageResponse{
+		Models: []api.ModelDiskUsage{},
+		Size:   st.size(),
+		Quota:  envconfig.ModelsQuota(),
+	}
+
+	for _, m := range st.models {
+		u := api.ModelDiskUsage{
+			Model:    m.name.DisplayShortest(),
+			LastUsed: m.lastUsed,
+			Pinned:   m.pinned,
+		}
+		for _, d := range m.digests {
+			size := st.blobSize(d)
+			u.Size += size
+			if len(refs[d]) > 1 {
+				u.Shared += size
+			} else {
+				u.Unique += size
+			}
+		}
+		resp.Models = append(resp.Models, u)
+	}
+
+	for d, ms := range refs {
+		if len(ms) < 2 {
+			continue
+		}
+
+		l := api.LayerDiskUsage{Digest: d, Size: st.blobSize(d)}
+		for _, m := range ms {
+			l.Models = append(l.Models, m.name.DisplayShortest())
+		}
+		resp.SharedLayers = append(resp.SharedLayers, l)
+	}
+
+	slices.SortFunc(resp.SharedLayers, func(a, b api.LayerDiskUsage) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Digest, b.Digest))
+	})
+
+	for d, b := range st.blobs {
+		if len(refs[d]) == 0 {
+			resp.Unreferenced += b.size
+		}
+	}
+	for _, b := range st.partial {
+		resp.Unreferenced += b.size
+	}
+
+	return &resp, nil
+}
+
+// loadedModels returns the names of the models loaded by the scheduler.
+func (s *Server) loadedModels() []model.Name {
+	if s.sched == nil {
+		return nil
+	}
+
+	s.sched.loadedMu.Lock()
+	defer s.sched.loadedMu.Unlock()
+
+	var names []model.Name
+	for _, r := range s.sched.loaded {
+		if r.model != nil {
+			names = append(names, model.ParseName(r.model.Name))
+		}
+	}
+	return names
+}
+
+// collectGarbage removes the blobs no model uses that are older than
+// [blobGracePeriod], unless GOOBLA_NOPRUNE is set, then evicts the least
+// recently used models while the models directory is over its quota, along
+// with the blobs only they use. Pinned models, models aliases refer to,
+// loaded models and the models in keep are never evicted. If dryRun is set,
+// nothing is removed.
+func (s *Server) collectGarbage(dryRun bool, keep ...model.Name) (*api.GCResponse, error) {
+	gcMu.Lock()
+	defer gcMu.Unlock()
+
+	st, err := loadDiskState()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := api.GCResponse{DryRun: dryRun, Quota: envconfig.ModelsQuota()}
+	cutoff := time.Now().Add(-blobGracePeriod)
+
+	remove := func(b blobFile) {
+		if !dryRun {
+			if err := os.Remove(b.path); err != nil {
+				slog.Warn("couldn't remove blob", "path", b.path, "error", err)
+				return
+			}
+		}
+
+		if b.digest != "" {
+			resp.Blobs = append(resp.Blobs, b.digest)
+		}
+		resp.Freed += b.size
+	}
+
+	refs := st.refs()
+	if !envconfig.NoPrune() {
+		for d, b := range st.blobs {
+			if len(refs[d]) == 0 && b.modTime.Before(cutoff) {
+				remove(b)
+			}
+		}
+		for _, b := range st.partial {
+			if b.modTime.Before(cutoff) {
+				remove(b)
+			}
+		}
+	}
+
+	quota := int64(resp.Quota)
+	if quota > 0 && st.size()-resp.Freed > quota {
+		keep = append(keep, s.loadedModels()...)
+
+		var candidates []*diskModel
+		for _, m := range st.models {
+			if !m.pinned && !m.aliased && !slices.ContainsFunc(keep, m.name.EqualFold) {
+				candidates = append(candidates, m)
+			}
+		}
+
+		slices.SortFunc(candidates, func(a, b *diskModel) int {
+			return a.lastUsed.Compare(b.lastUsed)
+		})
+
+		for _, m := range candidates {
+			if st.size()-resp.Freed <= quota {
+				break
+			}
+
+			if !dryRun {
+				if err := m.manifest.Remove(); err != nil {
+					return nil, err
+				}
+				if err := removeModelUsage(m.name); err != nil {
+					slog.Warn("couldn't remove model usage", "model", m.name, "error", err)
+				}
+			}
+			resp.Evicted = append(resp.Evicted, m.name.DisplayShortest())
+
+			for _, d := range m.digests {
+				refs[d] = slices.DeleteFunc(refs[d], func(o *diskModel) bool { return o == m })
+				if b, ok := st.blobs[d]; ok && len(refs[d]) == 0 {
+					remove(b)
+				}
+			}
+		}
+
+		if size := st.size() - resp.Freed; size > quota {
+			slog.Warn("models directory is over its quota", "size", format.HumanBytes(size), "quota", format.HumanBytes(quota))
+		}
+	}
+
+	slices.Sort(resp.Blobs)
+	resp.Size = st.size() - resp.Freed
+	return &resp, nil
+}
+
+// enforceQuota evicts the least recently used models other than keep while
+// the models directory is over GOOBLA_MODELS_QUOTA.
+func (s *Server) enforceQuota(keep ...model.Name) {
+	if envconfig.ModelsQuota() == 0 {
+		return
+	}
+
+	resp, err := s.collectGarbage(false, keep...)
+	if err != nil {
+		slog.Warn("couldn't enforce models quota", "error", err)
+		return
+	}
+
+	if len(resp.Evicted) > 0 {
+		slog.Info("evicted least recently used models", "models", resp.Evicted, "freed", format.HumanBytes(resp.Freed))
+	}
+}
+
+func (s *Server) DiskUsageHandler(c *gin.Context) {
+	resp, err := diskUsage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) GCHandler(c *gin.Context) {
+	var req api.GCRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.collectGarbage(req.DryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
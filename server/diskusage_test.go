@@ -0,0 +1,347 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/types/model"
+)
+
+// writeTestBlob writes data to the blobs directory as a blob last modified
+// at modTime and returns its digest.
+func writeTestBlob(t *testing.T, data string, modTime time.Time) string {
+	t.Helper()
+
+	digest, _ := GetSHA256Digest(strings.NewReader(data))
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+// useTestModel records that the model name was last used at t.
+func useTestModel(t *testing.T, name string, at time.Time) {
+	t.Helper()
+
+	if err := touchModel(name); err != nil {
+		t.Fatal(err)
+	}
+	p, err := modelUsagePath(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	t.Setenv("GOOBLA_PINNED_MODELS", "a")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"a", "b"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"test.gguf": digest},
+			System: "You are " + name + ".",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	old := writeTestBlob(t, "old garbage", time.Now().Add(-2*blobGracePeriod))
+	writeTestBlob(t, "new garbage", time.Now())
+
+	w := createRequest(t, s.DiskUsageHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("du: status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var du api.DiskUsageResponse
+	if err := json.NewDecoder(w.Body).Decode(&du); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(du.Models) != 2 || du.Models[0].Model != "a:latest" || du.Models[1].Model != "b:latest" {
+		t.Fatalf("models = %+v, want a:latest and b:latest", du.Models)
+	}
+	if !du.Models[0].Pinned || du.Models[1].Pinned {
+		t.Errorf("expected only a to be pinned: %+v", du.Models)
+	}
+
+	if len(du.SharedLayers) != 1 || du.SharedLayers[0].Digest != digest || !slices.Equal(du.SharedLayers[0].Models, []string{"a:latest", "b:latest"}) && !slices.Equal(du.SharedLayers[0].Models, []string{"b:latest", "a:latest"}) {
+		t.Fatalf("shared layers = %+v, want the model layer shared by a and b", du.SharedLayers)
+	}
+
+	for _, m := range du.Models {
+		if m.Shared != du.SharedLayers[0].Size || m.Unique == 0 || m.Size != m.Shared+m.Unique {
+			t.Errorf("usage of %s = %+v", m.Model, m)
+		}
+	}
+
+	garbage := int64(len("old garbage") + len("new garbage"))
+	if du.Unreferenced != garbage {
+		t.Errorf("unreferenced = %d, want %d", du.Unreferenced, garbage)
+	}
+	if want := du.Models[0].Size + du.Models[1].Unique + garbage; du.Size != want {
+		t.Errorf("size = %d, want %d", du.Size, want)
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		w := createRequest(t, s.GCHandler, api.GCRequest{DryRun: true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("gc: status = %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GCResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.DryRun || !slices.Equal(resp.Blobs, []string{old}) || resp.Freed != int64(len("old garbage")) {
+			t.Errorf("gc = %+v, want only the old blob removed", resp)
+		}
+
+		p, err := GetBlobsPath(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected a dry run to keep the blob: %v", err)
+		}
+	})
+
+	t.Run("no prune", func(t *testing.T) {
+		t.Setenv("GOOBLA_NOPRUNE", "1")
+
+		w := createRequest(t, s.GCHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("gc: status = %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GCResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Blobs) > 0 || resp.Freed != 0 || resp.Size != du.Size {
+			t.Errorf("gc = %+v, want nothing removed", resp)
+		}
+
+		p, err := GetBlobsPath(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected GOOBLA_NOPRUNE to keep the blob: %v", err)
+		}
+	})
+
+	t.Run("gc", func(t *testing.T) {
+		w := createRequest(t, s.GCHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("gc: status = %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GCResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.DryRun || !slices.Equal(resp.Blobs, []string{old}) || len(resp.Evicted) > 0 {
+			t.Errorf("gc = %+v, want only the old blob removed", resp)
+		}
+		if resp.Size != du.Size-resp.Freed {
+			t.Errorf("size = %d, want %d", resp.Size, du.Size-resp.Freed)
+		}
+
+		p, err := GetBlobsPath(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected the blob to be removed, got %v", err)
+		}
+	})
+}
+
+func TestGCQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	t.Setenv("GOOBLA_PINNED_MODELS", "pinned")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"pinned", "old", "new", "loaded"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"test.gguf": digest},
+			System: "You are " + name + ".",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	now := time.Now()
+	useTestModel(t, "pinned", now.Add(-4*time.Hour))
+	useTestModel(t, "loaded", now.Add(-3*time.Hour))
+	useTestModel(t, "old", now.Add(-2*time.Hour))
+	useTestModel(t, "new", now.Add(-time.Hour))
+
+	s.sched = &Scheduler{loaded: map[string]*runnerRef{
+		"loaded": {model: &Model{Name: model.ParseName("loaded").String()}},
+	}}
+
+	du, err := diskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// evicting any one model is enough
+	t.Setenv("GOOBLA_MODELS_QUOTA", strconv.FormatInt(du.Size-1, 10))
+
+	resp, err := s.collectGarbage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resp.Evicted, []string{"old:latest"}) {
+		t.Errorf("dry run evicted %v, want [old:latest]", resp.Evicted)
+	}
+	if _, err := ParseNamedManifest(model.ParseName("old")); err != nil {
+		t.Errorf("expected a dry run to keep the model: %v", err)
+	}
+
+	s.enforceQuota()
+	if _, err := ParseNamedManifest(model.ParseName("old")); !os.IsNotExist(err) {
+		t.Errorf("expected the least recently used model to be evicted, got %v", err)
+	}
+	p, err := modelUsagePath(model.ParseName("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("expected the usage of the evicted model to be removed, got %v", err)
+	}
+
+	// a quota no eviction can meet evicts every model it can
+	t.Setenv("GOOBLA_MODELS_QUOTA", "1")
+	resp, err = s.collectGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resp.Evicted, []string{"new:latest"}) {
+		t.Errorf("evicted %v, want [new:latest]", resp.Evicted)
+	}
+
+	for _, name := range []string{"pinned", "loaded"} {
+		if _, err := ParseNamedManifest(model.ParseName(name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+
+	// the layer shared with the remaining models is kept
+	p, err = GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("expected the shared layer to be kept: %v", err)
+	}
+}
+
+func TestGCQuotaAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	t.Setenv("GOOBLA_PINNED_MODELS", "stable")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"pinned", "aliased", "other"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"test.gguf": digest},
+			System: "You are " + name + ".",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	for alias, target := range map[string]string{"stable": "pinned", "prod": "aliased"} {
+		if err := SetAlias(model.ParseName(alias), model.ParseName(target)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	useTestModel(t, "pinned", now.Add(-3*time.Hour))
+	useTestModel(t, "aliased", now.Add(-2*time.Hour))
+	useTestModel(t, "other", now.Add(-time.Hour))
+
+	du, err := diskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(du.Models) != 3 || du.Models[0].Model != "aliased:latest" || du.Models[0].Pinned || !du.Models[2].Pinned {
+		t.Errorf("expected the model the pinned alias refers to to be pinned: %+v", du.Models)
+	}
+
+	t.Setenv("GOOBLA_MODELS_QUOTA", "1")
+	resp, err := s.collectGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resp.Evicted, []string{"other:latest"}) {
+		t.Errorf("evicted %v, want [other:latest]", resp.Evicted)
+	}
+}
+
+func TestPruneLayersSharded(t *testing.T) {
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+
+	digest := writeTestBlob(t, "garbage", time.Now())
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partial := p + "-partial-0"
+	if err := os.WriteFile(partial, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PruneLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{p, partial} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", filepath.Base(p), err)
+		}
+	}
+}
@@ -40,6 +40,7 @@ var (
 	errCapabilityVision     = errors.New("vision")
 	errCapabilityEmbedding  = errors.New("embedding")
 	errCapabilityThinking   = errors.New("thinking")
+	errCapabilityAudio      = errors.New("audio")
 	errInsecureProtocol     = errors.New("insecure protocol http")
 )
 
@@ -66,6 +67,8 @@ type Model struct {
 	ParentModel    string
 	AdapterPaths   []string
 	ProjectorPaths []string
+	Draft          string
+	DraftPath      string
 	System         string
 	License        []string
 	Digest         string
@@ -93,6 +96,9 @@ func (m *Model) Capabilities() []model.Capability {
 		if f.KeyValue("vision.block_count").Valid() {
 			capabilities = append(capabilities, model.CapabilityVision)
 		}
+		if f.KeyValue("audio.block_count").Valid() {
+			capabilities = append(capabilities, model.CapabilityAudio)
+		}
 	} else {
 		slog.Error("couldn't open model file", "error", err)
 	}
@@ -139,6 +145,7 @@ func (m *Model) CheckCapabilities(want ...model.Capability) error {
 		model.CapabilityVision:     errCapabilityVision,
 		model.CapabilityEmbedding:  errCapabilityEmbedding,
 		model.CapabilityThinking:   errCapabilityThinking,
+		model.CapabilityAudio:      errCapabilityAudio,
 	}
 
 	for _, cap := range want {
@@ -190,6 +197,13 @@ func (m *Model) String() string {
 		})
 	}
 
+	if m.Draft != "" {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "draft",
+			Args: m.Draft,
+		})
+	}
+
 	if m.Template != nil {
 		modelfile.Commands = append(modelfile.Commands, parser.Command{
 			Name: "template",
@@ -279,6 +293,16 @@ func GetManifest(mp ModelPath) (*Manifest, string, error) {
 }
 
 func GetModel(name string) (*Model, error) {
+	if n := model.ParseName(name); n.IsValid() {
+		target, err := resolveAlias(n)
+		if err != nil {
+			return nil, err
+		}
+		if target != n {
+			name = target.String()
+		}
+	}
+
 	mp := ParseModelPath(name)
 	manifest, digest, err := GetManifest(mp)
 	if err != nil {
@@ -325,6 +349,9 @@ func GetModel(name string) (*Model, error) {
 			model.AdapterPaths = append(model.AdapterPaths, filename)
 		case "application/vnd.goobla.image.projector":
 			model.ProjectorPaths = append(model.ProjectorPaths, filename)
+		case "application/vnd.goobla.image.draft":
+			model.Draft = layer.From
+			model.DraftPath = filename
 		case "application/vnd.goobla.image.prompt",
 			"application/vnd.goobla.image.template":
 			bts, err := os.ReadFile(filename)
@@ -411,8 +438,12 @@ func CopyModel(src, dst model.Name) error {
 	}
 	defer dstfile.Close()
 
-	_, err = io.Copy(dstfile, srcfile)
-	return err
+	if _, err := io.Copy(dstfile, srcfile); err != nil {
+		return err
+	}
+
+	replaceAlias(dst)
+	return nil
 }
 
 func deleteUnusedLayers(deleteMap map[string]struct{}) error {
@@ -448,34 +479,21 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 
 func PruneLayers() error {
 	deleteMap := make(map[string]struct{})
-	p, err := GetBlobsPath("")
-	if err != nil {
-		return err
-	}
-
-	blobs, err := os.ReadDir(p)
-	if err != nil {
-		slog.Info(fmt.Sprintf("couldn't read dir '%s': %v", p, err))
-		return err
-	}
-
-	for _, blob := range blobs {
-		name := blob.Name()
-		name = strings.ReplaceAll(name, "-", ":")
-
-		_, err := GetBlobsPath(name)
-		if err != nil {
-			if errors.Is(err, ErrInvalidDigestFormat) {
-				// remove invalid blobs (e.g. partial downloads)
-				if err := os.Remove(filepath.Join(p, blob.Name())); err != nil {
-					slog.Error("couldn't remove blob", "blob", blob.Name(), "error", err)
-				}
+	err := walkBlobs(func(b blobFile) error {
+		if b.digest == "" {
+			// remove invalid blobs (e.g. partial downloads)
+			if err := os.Remove(b.path); err != nil {
+				slog.Error("couldn't remove blob", "blob", b.path, "error", err)
 			}
-
-			continue
+			return nil
 		}
 
-		deleteMap[name] = struct{}{}
+		deleteMap[b.digest] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		slog.Info(fmt.Sprintf("couldn't read blobs: %v", err))
+		return err
 	}
 
 	slog.Info(fmt.Sprintf("total blobs: %d", len(deleteMap)))
@@ -537,6 +555,18 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	fp, err := mp.GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	// push the manifest as it is stored, so the registry has it under the
+	// same digest as it has here, which is the digest signatures sign
+	manifestJSON, err := os.ReadFile(fp)
+	if err != nil {
+		return err
+	}
+
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
@@ -554,11 +584,6 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	requestURL := mp.BaseURL()
 	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
-	manifestJSON, err := json.Marshal(manifest)
-	if err != nil {
-		return err
-	}
-
 	headers := make(http.Header)
 	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
 	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
@@ -595,13 +620,64 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return errInsecureProtocol
 	}
 
-	fn(api.ProgressResponse{Status: "pulling manifest"})
+	pulled, err := pullModelFromMirrors(ctx, mp, regOpts, fn)
+	if err != nil {
+		fn(api.ProgressResponse{Status: "pulling manifest"})
+
+		pulled, err = pullSignedManifest(ctx, mp, regOpts)
+		if err != nil {
+			return fmt.Errorf("pull model manifest: %w", err)
+		}
+
+		if err := pullModelLayers(ctx, mp, pulled.Manifest, regOpts, fn); err != nil {
+			return err
+		}
+	}
+
+	for _, layer := range append([]Layer{pulled.Config}, pulled.Layers...) {
+		delete(deleteMap, layer.Digest)
+	}
+
+	fn(api.ProgressResponse{Status: "writing manifest"})
 
-	manifest, err = pullModelManifest(ctx, mp, regOpts)
+	if pulled.signature != "" {
+		digest, _ := GetSHA256Digest(bytes.NewReader(pulled.data))
+		if err := writeModelSignature(digest, pulled.signature); err != nil {
+			return err
+		}
+	}
+
+	fp, err := mp.GetManifestPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+		return err
+	}
+
+	err = os.WriteFile(fp, pulled.data, 0o644)
 	if err != nil {
-		return fmt.Errorf("pull model manifest: %s", err)
+		slog.Info(fmt.Sprintf("couldn't write to %s", fp))
+		return err
+	}
+
+	replaceAlias(model.ParseName(mp.GetFullTagname()))
+
+	if !envconfig.NoPrune() && len(deleteMap) > 0 {
+		fn(api.ProgressResponse{Status: "removing unused layers"})
+		if err := deleteUnusedLayers(deleteMap); err != nil {
+			fn(api.ProgressResponse{Status: fmt.Sprintf("couldn't remove unused layers: %v", err)})
+		}
 	}
 
+	fn(api.ProgressResponse{Status: "success"})
+
+	return nil
+}
+
+// pullModelLayers downloads the layers of manifest from the registry of mp
+// and verifies their digests.
+func pullModelLayers(ctx context.Context, mp ModelPath, manifest *Manifest, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
@@ -620,81 +696,255 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 			return err
 		}
 		skipVerify[layer.Digest] = cacheHit
-		delete(deleteMap, layer.Digest)
 	}
-	delete(deleteMap, manifest.Config.Digest)
 
 	fn(api.ProgressResponse{Status: "verifying sha256 digest"})
 	for _, layer := range layers {
 		if skipVerify[layer.Digest] {
 			continue
 		}
-		if err := verifyBlob(layer.Digest); err != nil {
-			if errors.Is(err, errDigestMismatch) {
-				// something went wrong, delete the blob
-				fp, err := GetBlobsPath(layer.Digest)
-				if err != nil {
-					return err
-				}
-				if err := os.Remove(fp); err != nil {
-					// log this, but return the original error
-					slog.Info(fmt.Sprintf("couldn't remove file with digest mismatch '%s': %v", fp, err))
-				}
-			}
+		if err := verifyBlobOrRemove(layer.Digest); err != nil {
 			return err
 		}
 	}
 
-	fn(api.ProgressResponse{Status: "writing manifest"})
+	return nil
+}
+
+// verifyBlobOrRemove verifies the blob with digest, removing it if its
+// contents do not match.
+func verifyBlobOrRemove(digest string) error {
+	err := verifyBlob(digest)
+	if errors.Is(err, errDigestMismatch) {
+		// something went wrong, delete the blob
+		fp, err := GetBlobsPath(digest)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(fp); err != nil {
+			// log this, but return the original error
+			slog.Info(fmt.Sprintf("couldn't remove file with digest mismatch '%s': %v", fp, err))
+		}
+	}
+	return err
+}
+
+// pullModelFromMirrors pulls the manifest and layers of mp from the first of
+// the configured mirrors that has them. Mirrors that fail are logged and
+// skipped.
+func pullModelFromMirrors(ctx context.Context, mp ModelPath, regOpts *registryOptions, fn func(api.ProgressResponse)) (*pulledManifest, error) {
+	mirrors := envconfig.Mirrors()
+	if len(mirrors) == 0 {
+		return nil, errors.New("no mirrors")
+	}
+
+	var errs []error
+	for _, mirror := range mirrors {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("pulling manifest from %s", mirror)})
+
+		var m *pulledManifest
+		var err error
+		if strings.HasPrefix(mirror, "http://") || strings.HasPrefix(mirror, "https://") {
+			m, err = pullModelFromRegistryMirror(ctx, mirror, mp, regOpts, fn)
+		} else {
+			m, err = pullModelFromDirMirror(mirror, mp, fn)
+		}
+		if err == nil {
+			return m, nil
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		slog.Info("couldn't pull model from mirror", "mirror", mirror, "model", mp.GetShortTagname(), "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", mirror, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// pullModelFromRegistryMirror pulls mp from the registry at rawURL, with the
+// same namespace, repository and tag as mp.
+func pullModelFromRegistryMirror(ctx context.Context, rawURL string, mp ModelPath, regOpts *registryOptions, fn func(api.ProgressResponse)) (*pulledManifest, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	mmp := mp
+	mmp.ProtocolScheme = u.Scheme
+	mmp.Registry = u.Host
+
+	// mirrors are configured by the operator, so they are trusted over plain
+	// HTTP, and registry credentials are not sent to them
+	mopts := &registryOptions{Insecure: true}
+	if regOpts != nil {
+		mopts.Timeout = regOpts.Timeout
+	}
+
+	m, err := pullSignedManifest(ctx, mmp, mopts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pullModelLayers(ctx, mmp, m.Manifest, mopts, fn); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// pullModelFromDirMirror copies mp from dir, a models directory laid out like
+// the one configured with GOOBLA_MODELS, verifying each blob it copies.
+func pullModelFromDirMirror(dir string, mp ModelPath, fn func(api.ProgressResponse)) (*pulledManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifests", mp.Registry, mp.Namespace, mp.Repository, mp.Tag))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	digest, _ := GetSHA256Digest(bytes.NewReader(data))
+	sig, err := os.ReadFile(filepath.Join(dir, "signatures", strings.Replace(digest, ":", "-", 1)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := checkModelSignature(digest, strings.TrimSpace(string(sig))); err != nil {
+		return nil, err
+	}
+
+	for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		fp, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
 
-	manifestJSON, err := json.Marshal(manifest)
+		fn(api.ProgressResponse{
+			Status: fmt.Sprintf("pulling %s", layer.Digest[7:19]),
+			Digest: layer.Digest,
+			Total:  layer.Size,
+		})
+
+		if _, err := os.Stat(fp); err == nil {
+			fn(api.ProgressResponse{
+				Status:    fmt.Sprintf("pulling %s", layer.Digest[7:19]),
+				Digest:    layer.Digest,
+				Total:     layer.Size,
+				Completed: layer.Size,
+			})
+			continue
+		}
+
+		// blobs are sharded by the first byte of their digest, or at the top
+		// of the blobs directory in older models directories
+		name := strings.ReplaceAll(layer.Digest, ":", "-")
+		src := filepath.Join(dir, "blobs", name[7:9], name)
+		if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+			src = filepath.Join(dir, "blobs", name)
+		}
+
+		if err := copyBlobFile(src, fp); err != nil {
+			return nil, err
+		}
+
+		if err := verifyBlobOrRemove(layer.Digest); err != nil {
+			return nil, err
+		}
+
+		fn(api.ProgressResponse{
+			Status:    fmt.Sprintf("pulling %s", layer.Digest[7:19]),
+			Digest:    layer.Digest,
+			Total:     layer.Size,
+			Completed: layer.Size,
+		})
+	}
+
+	return &pulledManifest{Manifest: &m, data: data, signature: strings.TrimSpace(string(sig))}, nil
+}
+
+// copyBlobFile copies src to dst through a temporary file, so dst is never
+// seen partially written.
+func copyBlobFile(src, dst string) error {
+	sf, err := os.Open(src)
 	if err != nil {
 		return err
 	}
+	defer sf.Close()
 
-	fp, err := mp.GetManifestPath()
+	df, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+"-partial-")
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+	defer os.Remove(df.Name())
+
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
 		return err
 	}
 
-	err = os.WriteFile(fp, manifestJSON, 0o644)
-	if err != nil {
-		slog.Info(fmt.Sprintf("couldn't write to %s", fp))
+	if err := df.Close(); err != nil {
 		return err
 	}
 
-	if !envconfig.NoPrune() && len(deleteMap) > 0 {
-		fn(api.ProgressResponse{Status: "removing unused layers"})
-		if err := deleteUnusedLayers(deleteMap); err != nil {
-			fn(api.ProgressResponse{Status: fmt.Sprintf("couldn't remove unused layers: %v", err)})
-		}
+	return os.Rename(df.Name(), dst)
+}
+
+// A pulledManifest is a manifest pulled from a registry or mirror, with the
+// data it was pulled as and its signature, if it has one.
+type pulledManifest struct {
+	*Manifest
+	data      []byte
+	signature string
+}
+
+// pullSignedManifest pulls the manifest of mp and its signature, and checks
+// the signature before any layers are pulled.
+func pullSignedManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*pulledManifest, error) {
+	m, data, err := pullModelManifest(ctx, mp, regOpts)
+	if err != nil {
+		return nil, err
 	}
 
-	fn(api.ProgressResponse{Status: "success"})
+	digest, _ := GetSHA256Digest(bytes.NewReader(data))
+	sig, err := pullModelSignature(ctx, mp, digest, regOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkModelSignature(digest, sig); err != nil {
+		return nil, err
+	}
 
-	return nil
+	return &pulledManifest{Manifest: m, data: data, signature: sig}, nil
 }
 
-func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, error) {
+func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, []byte, error) {
 	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
 	headers := make(http.Header)
 	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
 	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var m Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
-		return nil, err
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil, err
 	}
 
-	return &m, err
+	return &m, data, nil
 }
 
 // GetSHA256Digest returns the SHA256 hash of a given buffer and returns it, and the size of buffer
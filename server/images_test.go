@@ -21,6 +21,12 @@ func TestModelCapabilities(t *testing.T) {
 		"llama.vision.block_count": uint32(1),
 	}, []*ggml.Tensor{})
 
+	// Create audio model (llama architecture with audio block count)
+	audioModelPath, _ := createBinFile(t, ggml.KV{
+		"general.architecture":    "llama",
+		"llama.audio.block_count": uint32(1),
+	}, []*ggml.Tensor{})
+
 	// Create embedding model (bert architecture with pooling type)
 	embeddingModelPath, _ := createBinFile(t, ggml.KV{
 		"general.architecture": "bert",
@@ -88,6 +94,14 @@ func TestModelCapabilities(t *testing.T) {
 			},
 			expectedCaps: []model.Capability{model.CapabilityCompletion, model.CapabilityVision, model.CapabilityTools, model.CapabilityInsert},
 		},
+		{
+			name: "model with audio capability",
+			model: Model{
+				ModelPath: audioModelPath,
+				Template:  chatTemplate,
+			},
+			expectedCaps: []model.Capability{model.CapabilityCompletion, model.CapabilityAudio},
+		},
 		{
 			name: "model with embedding capability",
 			model: Model{
@@ -146,6 +160,12 @@ func TestModelCheckCapabilities(t *testing.T) {
 		"llama.vision.block_count": uint32(1),
 	}, []*ggml.Tensor{})
 
+	// Create audio model (llama architecture with audio block count)
+	audioModelPath, _ := createBinFile(t, ggml.KV{
+		"general.architecture":    "llama",
+		"llama.audio.block_count": uint32(1),
+	}, []*ggml.Tensor{})
+
 	// Create embedding model (bert architecture with pooling type)
 	embeddingModelPath, _ := createBinFile(t, ggml.KV{
 		"general.architecture": "bert",
@@ -216,6 +236,23 @@ func TestModelCheckCapabilities(t *testing.T) {
 			},
 			checkCaps: []model.Capability{model.CapabilityVision},
 		},
+		{
+			name: "model missing audio capability",
+			model: Model{
+				ModelPath: visionModelPath,
+				Template:  chatTemplate,
+			},
+			checkCaps:      []model.Capability{model.CapabilityAudio},
+			expectedErrMsg: "does not support audio",
+		},
+		{
+			name: "model with audio capability",
+			model: Model{
+				ModelPath: audioModelPath,
+				Template:  chatTemplate,
+			},
+			checkCaps: []model.Capability{model.CapabilityAudio},
+		},
 		{
 			name: "model with embedding capability",
 			model: Model{
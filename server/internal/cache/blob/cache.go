@@ -483,14 +483,14 @@ func (w *checkWriter) Write(p []byte) (int, error) {
 // copyNamedFile copies file into name, expecting it to have the given Digest
 // and size, if that file is not present already.
 func (c *DiskCache) copyNamedFile(name string, file io.Reader, out Digest, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+		return err
+	}
 	unlock, err := c.lockFile(name)
 	if err != nil {
 		return err
 	}
 	defer unlock()
-	if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
-		return err
-	}
 	info, err := os.Stat(name)
 	if err == nil && info.Size() == size {
 		// File already exists with correct size. This is good enough.
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 // Chunk represents a range of bytes in a blob.
@@ -36,6 +37,9 @@ func (c *DiskCache) Chunked(d Digest, size int64) (*Chunker, error) {
 	if err == nil && info.Size() == size {
 		return &Chunker{}, nil
 	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+		return nil, err
+	}
 	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0o666)
 	if err != nil {
 		return nil, err
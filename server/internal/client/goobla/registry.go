@@ -11,7 +11,6 @@ import (
 	"crypto"
 	"crypto/ed25519"
 	"crypto/sha256"
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -229,6 +228,15 @@ type Registry struct {
 	// including the body.
 	// A zero or negative value means there will be no timeout.
 	ReadTimeout time.Duration
+
+	// TrustedKeys are the keys trusted to sign manifests. If TrustedKeys
+	// or RequireSignatures is set, Pull verifies the signature of every
+	// manifest that has one, whether or not its signer is trusted.
+	TrustedKeys []TrustedKey
+
+	// RequireSignatures, if set, makes Pull refuse models that are not
+	// signed by one of TrustedKeys.
+	RequireSignatures bool
 }
 
 func (r *Registry) readTimeout() time.Duration {
@@ -279,6 +287,11 @@ func DefaultRegistry() (*Registry, error) {
 	if err != nil {
 		return nil, err
 	}
+	rc.TrustedKeys, err = LoadTrustedKeys(TrustedKeysFile())
+	if err != nil {
+		return nil, err
+	}
+	rc.RequireSignatures = envconfig.RequireSignatures()
 	maxStreams := os.Getenv("GOOBLA_REGISTRY_MAXSTREAMS")
 	if maxStreams != "" {
 		var err error
@@ -473,6 +486,10 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 		return fmt.Errorf("%w: no layers", ErrManifestInvalid)
 	}
 
+	if err := r.checkSignature(ctx, name, m); err != nil {
+		return err
+	}
+
 	c, err := r.cache()
 	if err != nil {
 		return err
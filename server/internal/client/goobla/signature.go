@@ -0,0 +1,208 @@
+package goobla
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/auth"
+	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/internal/names"
+)
+
+// Manifests are signed with detached signatures. The signature of the
+// manifest with digest sha256:<hex> is pushed to the same repository as the
+// manifest it signs, as the only layer of a manifest tagged
+// sha256-<hex>.sig. The layer holds the signature of the manifest digest
+// made by [auth.Sign].
+
+// MediaTypeSignature is the media type of the layer holding a manifest
+// signature.
+const MediaTypeSignature = "application/vnd.goobla.image.signature"
+
+// maxSignatureSize limits the size of signatures read from registries.
+const maxSignatureSize = 4 << 10
+
+// Signature errors
+var (
+	// ErrUnsigned is returned by [Registry.Pull] when a model is not signed
+	// and [Registry.RequireSignatures] is set.
+	ErrUnsigned = errors.New("model is not signed")
+
+	// ErrUntrustedSigner is returned by [Registry.Pull] when a model is
+	// signed by a key that is not in [Registry.TrustedKeys] and
+	// [Registry.RequireSignatures] is set.
+	ErrUntrustedSigner = errors.New("model is signed by an untrusted key")
+
+	// ErrInvalidSignature is returned when the signature of a manifest does
+	// not verify.
+	ErrInvalidSignature = errors.New("invalid model signature")
+)
+
+// A TrustedKey is a key trusted to sign manifests.
+type TrustedKey struct {
+	Key ssh.PublicKey
+
+	// Comment is the comment of the key in the trust store, which usually
+	// names its owner.
+	Comment string
+}
+
+// TrustedKeysFile returns the path of the trust store, a file in the format
+// of an SSH authorized_keys file listing the keys trusted to sign manifests.
+func TrustedKeysFile() string {
+	return filepath.Join(envconfig.ConfigDir(), "trusted_keys")
+}
+
+// LoadTrustedKeys reads the trust store at path. A missing trust store
+// holds no keys.
+func LoadTrustedKeys(path string) ([]TrustedKey, error) {
+	bts, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []TrustedKey
+	for len(bytes.TrimSpace(bts)) > 0 {
+		key, comment, _, rest, err := ssh.ParseAuthorizedKey(bts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, TrustedKey{Key: key, Comment: comment})
+		bts = rest
+	}
+	return keys, nil
+}
+
+// SignatureTag returns the tag of the signature of the manifest with
+// digest d.
+func SignatureTag(d blob.Digest) string {
+	return strings.Replace(d.String(), ":", "-", 1) + ".sig"
+}
+
+// VerifySignature checks that sig is a signature of the manifest digest d
+// made by [auth.Sign], and returns the key that made it.
+func VerifySignature(d blob.Digest, sig string) (ssh.PublicKey, error) {
+	key, err := auth.Verify([]byte(d.String()), strings.TrimSpace(sig))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	return key, nil
+}
+
+// CheckSignature verifies sig, the signature of the manifest digest d, and
+// returns the trusted key that made it, or nil if the manifest is unsigned
+// or signed by another key. An empty sig means the manifest is unsigned. If
+// require is set, only manifests signed by one of keys are accepted.
+func CheckSignature(d blob.Digest, sig string, keys []TrustedKey, require bool) (*TrustedKey, error) {
+	if sig == "" {
+		if require {
+			return nil, ErrUnsigned
+		}
+		return nil, nil
+	}
+
+	key, err := VerifySignature(d, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if bytes.Equal(k.Key.Marshal(), key.Marshal()) {
+			return &k, nil
+		}
+	}
+
+	if require {
+		return nil, fmt.Errorf("%w: %s", ErrUntrustedSigner, ssh.FingerprintSHA256(key))
+	}
+	return nil, nil
+}
+
+// checkSignature verifies the signature of m, pulled as name, if it has
+// one, and enforces [Registry.RequireSignatures].
+func (r *Registry) checkSignature(ctx context.Context, name string, m *Manifest) error {
+	if len(r.TrustedKeys) == 0 && !r.RequireSignatures {
+		return nil
+	}
+
+	scheme, n, _, err := r.parseNameExtended(name)
+	if err != nil {
+		return err
+	}
+
+	d := blob.DigestFromBytes(m.Data)
+
+	var sig string
+	if n.IsFullyQualified() {
+		sig, err = r.resolveSignature(ctx, scheme, n, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = CheckSignature(d, sig, r.TrustedKeys, r.RequireSignatures)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// resolveSignature returns the signature of the manifest with digest d in
+// the repository of n, or an empty string if it is not signed.
+func (r *Registry) resolveSignature(ctx context.Context, scheme string, n names.Name, d blob.Digest) (string, error) {
+	base := fmt.Sprintf("%s://%s/v2/%s/%s", scheme, n.Host(), n.Namespace(), n.Model())
+
+	res, err := r.send(ctx, "GET", base+"/manifests/"+SignatureTag(d), nil)
+	var re *Error
+	if errors.Is(err, ErrModelNotFound) || errors.As(err, &re) && re.status == http.StatusNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSignatureSize))
+	if err != nil {
+		return "", err
+	}
+
+	m, err := unmarshalManifest(n, data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	// registries that answer for any tag may return a manifest without a
+	// signature, which is no different from none at all
+	i := slices.IndexFunc(m.Layers, func(l *Layer) bool { return l.MediaType == MediaTypeSignature })
+	if i < 0 {
+		return "", nil
+	}
+	l := m.Layers[i]
+
+	res, err = r.send(ctx, "GET", base+"/blobs/"+l.Digest.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	sig, err := io.ReadAll(io.LimitReader(res.Body, maxSignatureSize))
+	if err != nil {
+		return "", err
+	}
+	if blob.DigestFromBytes(sig) != l.Digest {
+		return "", fmt.Errorf("%w: signature does not match its digest", ErrInvalidSignature)
+	}
+	return string(sig), nil
+}
@@ -0,0 +1,712 @@
+package registry
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/auth"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/client/goobla"
+	"github.com/goobla/goobla/server/internal/internal/names"
+)
+
+// DefaultChunkSize is the default size of the chunks listed by the chunksums
+// endpoint of a [Server].
+const DefaultChunkSize = 64 << 20
+
+const (
+	// maxManifestSize is the largest manifest accepted by a push. It
+	// matches the limit [blob.DiskCache.Resolve] reads manifests with.
+	maxManifestSize = 1 << 20
+
+	// tokenTTL is how long the tokens issued by /v2/token are valid.
+	tokenTTL = time.Hour
+
+	// maxClockSkew is how far the timestamp of a signed request may be
+	// from the time of the server.
+	maxClockSkew = 15 * time.Minute
+
+	// uploadTTL is how long an upload may go without receiving data before
+	// it is abandoned and its temporary file removed.
+	uploadTTL = time.Hour
+
+	// maxChunksums is the number of blobs whose chunksums are kept.
+	maxChunksums = 256
+)
+
+// Server implements an http.Handler that serves the models in a
+// [blob.DiskCache] as a registry, which other Goobla instances can push
+// models to and pull models from.
+//
+// It serves the manifest, blob, upload, and chunksums endpoints used by
+// [goobla.Registry] and by the original Goobla client. Clients authenticate
+// with one of Keys, either by signing each request, or by signing a request
+// to /v2/token for a token to use in later requests.
+//
+// Blobs may be read without authenticating, because clients download them
+// without credentials. They are addressed by the digest of their content,
+// which clients learn from manifests, and reading a manifest requires
+// authenticating.
+type Server struct {
+	Cache  *blob.DiskCache // required
+	Logger *slog.Logger    // required
+
+	// Keys are the public keys of the clients allowed to push and pull
+	// models.
+	Keys []ssh.PublicKey
+
+	// Mask is used to complete the names of models, which clients send
+	// without a host. If empty, [goobla.DefaultMask] is used.
+	Mask string
+
+	// ChunkSize is the size of the chunks listed by the chunksums
+	// endpoint. If zero, [DefaultChunkSize] is used.
+	ChunkSize int64
+
+	once   sync.Once
+	mux    *http.ServeMux
+	secret []byte // signs the tokens issued by /v2/token
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+
+	// chunksums are the chunksums listed for recent blobs, which are
+	// evicted in the order they were added
+	chunksums      map[blob.Digest][]byte
+	chunksumsOrder []blob.Digest
+}
+
+// upload is a blob being uploaded, which is written to a temporary file until
+// the upload is complete and its digest verified.
+type upload struct {
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// updated is when data was last written to the upload
+	updated time.Time
+	// expired is set once the upload is abandoned and its file removed
+	expired bool
+}
+
+// registryError is an error in the format of the registry API, which
+// both clients understand.
+type registryError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *registryError) Error() string {
+	return e.Message
+}
+
+// Registry API errors
+var (
+	errUnauthorized      = &registryError{401, "UNAUTHORIZED", "authentication required"}
+	errDenied            = &registryError{401, "DENIED", "requested access to the resource is denied"}
+	errManifestUnknown   = &registryError{404, "MANIFEST_UNKNOWN", "manifest unknown"}
+	errBlobUnknown       = &registryError{404, "BLOB_UNKNOWN", "blob unknown to registry"}
+	errBlobUploadUnknown = &registryError{404, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry"}
+	errNameInvalid       = &registryError{400, "NAME_INVALID", "invalid repository name"}
+	errDigestInvalid     = &registryError{400, "DIGEST_INVALID", "provided digest did not match uploaded content"}
+	errManifestInvalid   = &registryError{400, "MANIFEST_INVALID", "manifest invalid"}
+	errRegistryInternal  = &registryError{500, "UNKNOWN", "internal server error"}
+)
+
+func (s *Server) init() {
+	s.secret = make([]byte, 32)
+	if _, err := rand.Read(s.secret); err != nil {
+		panic(err) // unreachable; rand.Read never fails
+	}
+
+	s.uploads = make(map[string]*upload)
+	s.chunksums = make(map[blob.Digest][]byte)
+
+	s.mux = http.NewServeMux()
+	handle := func(pattern string, h func(http.ResponseWriter, *http.Request) error) {
+		s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if err := h(w, r); err != nil {
+				s.writeError(w, r, err)
+			}
+		})
+	}
+
+	handle("GET /v2/token", s.handleToken)
+	handle("GET /v2/{namespace}/{model}/manifests/{tag}", s.authenticated(s.handleGetManifest))
+	handle("HEAD /v2/{namespace}/{model}/manifests/{tag}", s.authenticated(s.handleGetManifest))
+	handle("PUT /v2/{namespace}/{model}/manifests/{tag}", s.authenticated(s.handlePutManifest))
+	handle("GET /v2/{namespace}/{model}/blobs/{digest}", s.handleGetBlob)
+	handle("HEAD /v2/{namespace}/{model}/blobs/{digest}", s.handleGetBlob)
+	handle("POST /v2/{namespace}/{model}/blobs/uploads/{$}", s.authenticated(s.handleStartUpload))
+	handle("PATCH /v2/{namespace}/{model}/blobs/uploads/{id}", s.authenticated(s.handlePatchUpload))
+	handle("PUT /v2/{namespace}/{model}/blobs/uploads/{id}", s.authenticated(s.handlePutUpload))
+	handle("GET /v2/{namespace}/{model}/chunksums/{digest}", s.authenticated(s.handleChunksums))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(s.init)
+
+	rec := &statusCodeRecorder{ResponseWriter: w}
+	s.mux.ServeHTTP(rec, r)
+
+	var level slog.Level
+	if rec.status() >= 500 {
+		level = slog.LevelError
+	} else if rec.status() >= 400 {
+		level = slog.LevelWarn
+	}
+
+	s.Logger.LogAttrs(r.Context(), level, "http",
+		slog.Int("status", rec.status()),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int64("content-length", r.ContentLength),
+		slog.String("remote", r.RemoteAddr),
+		slog.String("proto", r.Proto),
+	)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *registryError
+	if !errors.As(err, &e) {
+		s.Logger.ErrorContext(r.Context(), "registry", "method", r.Method, "path", r.URL.Path, "error", err)
+		e = errRegistryInternal
+	}
+
+	if e == errUnauthorized {
+		// Send clients that do not sign their requests to /v2/token
+		// for a token.
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="%s/v2/token",service="goobla",scope="repository:%s/%s:pull,push"`,
+			baseURL(r),
+			r.PathValue("namespace"),
+			r.PathValue("model"),
+		))
+	}
+
+	data, jerr := json.Marshal(struct {
+		Errors []*registryError `json:"errors"`
+	}{[]*registryError{e}})
+	if jerr != nil {
+		panic(jerr) // unreachable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(data)
+}
+
+// baseURL returns the scheme and host the client used to make r.
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// The original spec for Goobla tokens was to use the SHA256 of the zero
+// string as part of the signature, which clients still include.
+var zeroSum = func() string {
+	sha256sum := sha256.Sum256(nil)
+	return base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sha256sum[:])))
+}()
+
+// verifySigned verifies that signature, made by [auth.Sign], signs a GET of
+// rawURL with one of the keys of s, and that rawURL has a recent "ts"
+// timestamp so that signatures cannot be replayed indefinitely.
+func (s *Server) verifySigned(rawURL, signature string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errDenied
+	}
+	ts, err := strconv.ParseInt(u.Query().Get("ts"), 10, 64)
+	if err != nil {
+		return errDenied
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return errDenied
+	}
+
+	key, err := auth.Verify(fmt.Appendf(nil, "GET,%s,%s", rawURL, zeroSum), signature)
+	if err != nil {
+		return errDenied
+	}
+	for _, k := range s.Keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+	return errDenied
+}
+
+// handleToken issues a token to clients that sign their request with an
+// authorized key, as the original Goobla client does when challenged.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) error {
+	signature := r.Header.Get("Authorization")
+	if signature == "" {
+		return errDenied
+	}
+
+	if err := s.verifySigned(baseURL(r)+r.URL.RequestURI(), signature); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]string{
+		"token": s.issueToken(time.Now().Add(tokenTTL)),
+	})
+}
+
+// issueToken returns a token that expires at expires, of the form
+// "<expires>.<mac>".
+func (s *Server) issueToken(expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+func (s *Server) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	io.WriteString(h, payload)
+	return h.Sum(nil)
+}
+
+// authenticate checks the credentials of r, which are either a token signed
+// by [goobla.Registry] for each request, or a token issued by /v2/token.
+func (s *Server) authenticate(r *http.Request) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return errUnauthorized
+	}
+
+	// Tokens made by clients are "<base64 url>:<public key>:<signature>"
+	if strings.Count(token, ":") == 2 {
+		encodedURL, signature, _ := strings.Cut(token, ":")
+		rawURL, err := base64.StdEncoding.DecodeString(encodedURL)
+		if err != nil {
+			return errDenied
+		}
+		return s.verifySigned(string(rawURL), signature)
+	}
+
+	payload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return errUnauthorized
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		// The token may have been issued before the server
+		// restarted, so have the client ask for a new one.
+		return errUnauthorized
+	}
+
+	unix, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+func (s *Server) authenticated(h func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := s.authenticate(r); err != nil {
+			return err
+		}
+		return h(w, r)
+	}
+}
+
+// modelName returns the fully qualified name of the model in the path of r.
+func (s *Server) modelName(r *http.Request) (string, error) {
+	n := names.Parse(r.PathValue("namespace") + "/" + r.PathValue("model") + ":" + r.PathValue("tag"))
+	n = names.Merge(n, names.Parse(cmp.Or(s.Mask, goobla.DefaultMask)))
+	if !n.IsFullyQualified() {
+		return "", errNameInvalid
+	}
+	return n.String(), nil
+}
+
+func parseDigest(s string) (blob.Digest, error) {
+	d, err := blob.ParseDigest(s)
+	if err != nil {
+		return blob.Digest{}, &registryError{400, "DIGEST_INVALID", fmt.Sprintf("invalid digest %q", s)}
+	}
+	return d, nil
+}
+
+func (s *Server) handleGetManifest(w http.ResponseWriter, r *http.Request) error {
+	name, err := s.modelName(r)
+	if err != nil {
+		return err
+	}
+
+	d, err := s.Cache.Resolve(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errManifestUnknown
+	} else if err != nil {
+		return err
+	}
+
+	f, err := os.Open(s.Cache.GetFile(d))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+// manifest holds the fields of a manifest that are checked when it is
+// pushed. Digests are strings because clients may send an empty config
+// digest, which [blob.Digest] does not accept.
+type manifest struct {
+	Config *struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	} `json:"config"`
+	Layers []*struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	} `json:"layers"`
+}
+
+func (s *Server) handlePutManifest(w http.ResponseWriter, r *http.Request) error {
+	name, err := s.modelName(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxManifestSize {
+		return &registryError{400, "MANIFEST_INVALID", "manifest too large"}
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return &registryError{400, "MANIFEST_INVALID", err.Error()}
+	}
+	if len(m.Layers) == 0 {
+		return errManifestInvalid
+	}
+
+	// Check that the blobs of the manifest were pushed before it, so
+	// that it can be pulled.
+	for _, l := range m.Layers {
+		if l == nil {
+			return errManifestInvalid
+		}
+		if err := s.checkBlob(l.Digest, l.Size); err != nil {
+			return err
+		}
+	}
+	if m.Config != nil {
+		// Clients without a config send an empty or zero digest.
+		if d, _ := blob.ParseDigest(m.Config.Digest); d.IsValid() {
+			if err := s.checkBlob(m.Config.Digest, m.Config.Size); err != nil {
+				return err
+			}
+		}
+	}
+
+	d := blob.DigestFromBytes(data)
+	if err := blob.PutBytes(s.Cache, d, data); err != nil {
+		return err
+	}
+	if err := s.Cache.Link(name, d); err != nil {
+		return err
+	}
+
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) checkBlob(digest string, size int64) error {
+	d, err := blob.ParseDigest(digest)
+	if err != nil {
+		return errManifestInvalid
+	}
+	info, err := s.Cache.Get(d)
+	if err != nil || info.Size != size {
+		return &registryError{400, "MANIFEST_BLOB_UNKNOWN", fmt.Sprintf("blob unknown to registry: %s", digest)}
+	}
+	return nil
+}
+
+func (s *Server) handleGetBlob(w http.ResponseWriter, r *http.Request) error {
+	d, err := parseDigest(r.PathValue("digest"))
+	if err != nil {
+		return err
+	}
+
+	info, err := s.Cache.Get(d)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errBlobUnknown
+	} else if err != nil {
+		return err
+	}
+
+	f, err := os.Open(s.Cache.GetFile(d))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The original client asks where to download blobs from, and then
+	// downloads them in parts from the Location it is given.
+	w.Header().Set("Location", baseURL(r)+r.URL.Path)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	http.ServeContent(w, r, "", info.Time, f)
+	return nil
+}
+
+// handleStartUpload starts an upload of a blob. If the blob named by the
+// digest or mount query parameter already exists, there is nothing to
+// upload, which is reported with a 201 and no Location.
+func (s *Server) handleStartUpload(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	if v := cmp.Or(query.Get("digest"), query.Get("mount")); v != "" {
+		d, err := parseDigest(v)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Cache.Get(d); err == nil {
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}
+	}
+
+	s.expireUploads(time.Now().Add(-uploadTTL))
+
+	f, err := os.CreateTemp("", "goobla-upload-")
+	if err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	uploadID := hex.EncodeToString(id)
+
+	s.mu.Lock()
+	s.uploads[uploadID] = &upload{file: f, updated: time.Now()}
+	s.mu.Unlock()
+
+	location := fmt.Sprintf("%s/v2/%s/%s/blobs/uploads/%s", baseURL(r), r.PathValue("namespace"), r.PathValue("model"), uploadID)
+	if d := query.Get("digest"); d != "" {
+		location += "?digest=" + d
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Docker-Upload-UUID", uploadID)
+	w.Header().Set("Range", "0-0")
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// expireUploads abandons the uploads that have not received data since
+// before, removing their temporary files. Uploads receiving data are kept.
+// Uploads are expired as new ones start, so the files of uploads abandoned
+// last remain until the next push.
+func (s *Server) expireUploads(before time.Time) {
+	var expired []*upload
+
+	s.mu.Lock()
+	for id, u := range s.uploads {
+		if !u.mu.TryLock() {
+			// data is being written
+			continue
+		}
+		if u.updated.Before(before) {
+			u.expired = true
+			delete(s.uploads, id)
+			expired = append(expired, u)
+		}
+		u.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	for _, u := range expired {
+		u.file.Close()
+		if err := os.Remove(u.file.Name()); err != nil {
+			s.Logger.Warn("couldn't remove abandoned upload", "path", u.file.Name(), "error", err)
+		}
+	}
+}
+
+func (s *Server) upload(r *http.Request) (*upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.uploads[r.PathValue("id")]
+	if u == nil {
+		return nil, errBlobUploadUnknown
+	}
+	return u, nil
+}
+
+// write writes the body of r to u at the offset given by its Content-Range
+// header, or after the data already uploaded if it has none.
+func (u *upload) write(r *http.Request) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.expired {
+		return errBlobUploadUnknown
+	}
+	defer func() { u.updated = time.Now() }()
+
+	offset := u.size
+	if v := r.Header.Get("Content-Range"); v != "" {
+		start, _, ok := strings.Cut(strings.TrimPrefix(v, "bytes="), "-")
+		n, err := strconv.ParseInt(start, 10, 64)
+		if !ok || err != nil || n < 0 {
+			return &registryError{416, "BLOB_UPLOAD_INVALID", fmt.Sprintf("invalid Content-Range %q", v)}
+		}
+		offset = n
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(u.file, offset), r.Body)
+	u.size = max(u.size, offset+n)
+	return err
+}
+
+func (s *Server) handlePatchUpload(w http.ResponseWriter, r *http.Request) error {
+	u, err := s.upload(r)
+	if err != nil {
+		return err
+	}
+
+	if err := u.write(r); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	size := u.size
+	u.mu.Unlock()
+
+	w.Header().Set("Location", baseURL(r)+r.URL.RequestURI())
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// handlePutUpload completes an upload, with any data in its body, and adds
+// the blob to the cache once its digest is verified.
+func (s *Server) handlePutUpload(w http.ResponseWriter, r *http.Request) error {
+	d, err := parseDigest(r.URL.Query().Get("digest"))
+	if err != nil {
+		return err
+	}
+
+	u, err := s.upload(r)
+	if err != nil {
+		return err
+	}
+
+	if err := u.write(r); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.uploads, r.PathValue("id"))
+	s.mu.Unlock()
+
+	defer os.Remove(u.file.Name())
+	defer u.file.Close()
+
+	if err := s.Cache.Put(d, io.NewSectionReader(u.file, 0, u.size), u.size); err != nil {
+		s.Logger.WarnContext(r.Context(), "rejected upload", "digest", d, "error", err)
+		return errDigestInvalid
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/v2/%s/%s/blobs/%s", baseURL(r), r.PathValue("namespace"), r.PathValue("model"), d))
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// handleChunksums lists the digests of the chunks of a blob, for clients to
+// download and verify in parallel. Listing the chunksums of a blob reads all
+// of it, so the lists of the last [maxChunksums] blobs are kept for later
+// requests.
+func (s *Server) handleChunksums(w http.ResponseWriter, r *http.Request) error {
+	d, err := parseDigest(r.PathValue("digest"))
+	if err != nil {
+		return err
+	}
+
+	info, err := s.Cache.Get(d)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errBlobUnknown
+	} else if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	data, ok := s.chunksums[d]
+	s.mu.Unlock()
+
+	if !ok {
+		f, err := os.Open(s.Cache.GetFile(d))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		chunkSize := cmp.Or(s.ChunkSize, DefaultChunkSize)
+
+		var b bytes.Buffer
+		for start := int64(0); start < info.Size; start += chunkSize {
+			end := min(start+chunkSize, info.Size) - 1
+
+			h := sha256.New()
+			if _, err := io.Copy(h, io.NewSectionReader(f, start, end-start+1)); err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "sha256:%x %d-%d\n", h.Sum(nil), start, end)
+		}
+		data = b.Bytes()
+
+		s.mu.Lock()
+		if _, ok := s.chunksums[d]; !ok {
+			if len(s.chunksumsOrder) == maxChunksums {
+				delete(s.chunksums, s.chunksumsOrder[0])
+				s.chunksumsOrder = s.chunksumsOrder[1:]
+			}
+			s.chunksums[d] = data
+			s.chunksumsOrder = append(s.chunksumsOrder, d)
+		}
+		s.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Location", fmt.Sprintf("%s/v2/%s/%s/blobs/%s", baseURL(r), r.PathValue("namespace"), r.PathValue("model"), d))
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,371 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/client/goobla"
+	"github.com/goobla/goobla/server/internal/testutil"
+)
+
+func newTestKey(t *testing.T) (ed25519.PrivateKey, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, key
+}
+
+// newTestRegistry starts a registry serving an empty cache that accepts
+// the keys given, and returns the base name of the models it serves.
+func newTestRegistry(t *testing.T, keys ...ssh.PublicKey) (*Server, string) {
+	t.Helper()
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Cache:     c,
+		Logger:    testutil.Slogger(t),
+		Keys:      keys,
+		ChunkSize: 3,
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, "http://" + ts.Listener.Addr().String() + "/library/smol:latest"
+}
+
+func newTestClient(t *testing.T, key ed25519.PrivateKey) *goobla.Registry {
+	t.Helper()
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &goobla.Registry{
+		Cache:             c,
+		Key:               &key,
+		ChunkingThreshold: 8,
+		Mask:              "example.com/library/_:latest",
+	}
+}
+
+func importModel(t *testing.T, c *blob.DiskCache, name string, layers ...string) {
+	t.Helper()
+	check := testutil.Checker(t)
+
+	var m goobla.Manifest
+	for _, data := range layers {
+		d := blob.DigestFromBytes(data)
+		check(blob.PutBytes(c, d, data))
+		m.Layers = append(m.Layers, &goobla.Layer{Digest: d, MediaType: "application/vnd.goobla.image.model", Size: int64(len(data))})
+	}
+	data, err := json.Marshal(m)
+	check(err)
+	d := blob.DigestFromBytes(data)
+	check(blob.PutBytes(c, d, data))
+	check(c.Link(name, d))
+}
+
+func TestServerPushPull(t *testing.T) {
+	check := testutil.Checker(t)
+
+	priv, key := newTestKey(t)
+	s, name := newTestRegistry(t, key)
+
+	src := newTestClient(t, priv)
+	// one layer under the chunking threshold and one over it, which
+	// is pulled in chunks
+	importModel(t, src.Cache, "example.com/library/smol:latest", "small", "a larger layer")
+
+	check(src.Push(t.Context(), name, &goobla.PushParams{From: "smol"}))
+
+	if _, err := s.Cache.Resolve("registry.goobla.ai/library/smol:latest"); err != nil {
+		t.Fatalf("expected the pushed model in the registry: %v", err)
+	}
+
+	// pushing again finds every layer cached
+	check(src.Push(t.Context(), name, &goobla.PushParams{From: "smol"}))
+
+	dst := newTestClient(t, priv)
+	check(dst.Pull(t.Context(), name))
+
+	want, err := src.ResolveLocal("smol")
+	check(err)
+	got, err := dst.ResolveLocal(name)
+	check(err)
+	if !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("pulled manifest = %s, want %s", got.Data, want.Data)
+	}
+	for _, l := range got.Layers {
+		data, err := os.ReadFile(dst.Cache.GetFile(l.Digest))
+		check(err)
+		if blob.DigestFromBytes(data) != l.Digest {
+			t.Errorf("layer %s not pulled", l.Digest.Short())
+		}
+	}
+
+	if err := dst.Pull(t.Context(), name[:len(name)-len("smol:latest")]+"unknown"); !errors.Is(err, goobla.ErrModelNotFound) {
+		t.Errorf("pull of unknown model: err = %v, want %v", err, goobla.ErrModelNotFound)
+	}
+}
+
+func TestServerUnauthorized(t *testing.T) {
+	priv, key := newTestKey(t)
+	_, name := newTestRegistry(t, key)
+
+	other, _ := newTestKey(t)
+	src := newTestClient(t, other)
+	importModel(t, src.Cache, "example.com/library/smol:latest", "small")
+
+	var re *goobla.Error
+	err := src.Push(t.Context(), name, &goobla.PushParams{From: "smol"})
+	if !errors.As(err, &re) || re.Code != "DENIED" {
+		t.Errorf("push with unknown key: err = %v, want DENIED", err)
+	}
+
+	dst := newTestClient(t, priv)
+	dst.Key = nil
+	err = dst.Pull(t.Context(), name)
+	if !errors.As(err, &re) || re.Code != "UNAUTHORIZED" {
+		t.Errorf("pull without key: err = %v, want UNAUTHORIZED", err)
+	}
+}
+
+func TestServerPutManifest(t *testing.T) {
+	s, _ := newTestRegistry(t)
+	s.once.Do(s.init)
+
+	manifest := fmt.Sprintf(`{"layers":[{"digest":%q,"size":5}]}`, blob.DigestFromBytes("small"))
+
+	put := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/v2/library/smol/manifests/latest", strings.NewReader(manifest))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put("")
+	if w.Code != 401 {
+		t.Errorf("unauthenticated: status = %d, want 401", w.Code)
+	}
+	if got, want := w.Header().Get("WWW-Authenticate"), `Bearer realm="http://example.com/v2/token",service="goobla",scope="repository:library/smol:pull,push"`; got != want {
+		t.Errorf("challenge = %q, want %q", got, want)
+	}
+
+	if w := put(s.issueToken(time.Now().Add(-time.Minute))); w.Code != 401 {
+		t.Errorf("expired token: status = %d, want 401", w.Code)
+	}
+
+	token := s.issueToken(time.Now().Add(time.Minute))
+	w = put(token)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "MANIFEST_BLOB_UNKNOWN") {
+		t.Errorf("missing layer: status = %d, body = %s; want 400 MANIFEST_BLOB_UNKNOWN", w.Code, w.Body)
+	}
+
+	if err := blob.PutBytes(s.Cache, blob.DigestFromBytes("small"), "small"); err != nil {
+		t.Fatal(err)
+	}
+	if w := put(token); w.Code != 201 {
+		t.Errorf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+	if _, err := s.Cache.Resolve("registry.goobla.ai/library/smol:latest"); err != nil {
+		t.Errorf("expected the manifest in the cache: %v", err)
+	}
+}
+
+// signDigest signs the manifest digest d as [auth.Sign] would with priv.
+func signDigest(t *testing.T, priv ed25519.PrivateKey, d blob.Digest) string {
+	t.Helper()
+	check := testutil.Checker(t)
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	check(err)
+	sig, err := signer.Sign(rand.Reader, []byte(d.String()))
+	check(err)
+	return base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal()) + ":" + base64.StdEncoding.EncodeToString(sig.Blob)
+}
+
+// signModel pushes a signature of the manifest of name in c made with priv.
+func signModel(t *testing.T, c *blob.DiskCache, name string, priv ed25519.PrivateKey) {
+	t.Helper()
+	check := testutil.Checker(t)
+
+	d, err := c.Resolve(name)
+	check(err)
+	data := signDigest(t, priv, d)
+
+	sd := blob.DigestFromBytes(data)
+	check(blob.PutBytes(c, sd, data))
+
+	manifest, err := json.Marshal(goobla.Manifest{
+		Layers: []*goobla.Layer{{Digest: sd, MediaType: goobla.MediaTypeSignature, Size: int64(len(data))}},
+	})
+	check(err)
+	md := blob.DigestFromBytes(manifest)
+	check(blob.PutBytes(c, md, manifest))
+
+	// unlink any earlier signature, which Link keeps if it has the same size
+	tag, _, _ := strings.Cut(name, ":")
+	tag += ":" + goobla.SignatureTag(d)
+	_, err = c.Unlink(tag)
+	check(err)
+	check(c.Link(tag, md))
+}
+
+func TestServerSignedPull(t *testing.T) {
+	priv, key := newTestKey(t)
+	s, name := newTestRegistry(t, key)
+
+	importModel(t, s.Cache, "registry.goobla.ai/library/smol:latest", "small")
+	signModel(t, s.Cache, "registry.goobla.ai/library/smol:latest", priv)
+	importModel(t, s.Cache, "registry.goobla.ai/library/unsigned:latest", "other")
+	unsigned := strings.Replace(name, "smol", "unsigned", 1)
+
+	dst := newTestClient(t, priv)
+	if err := dst.Pull(t.Context(), name); err != nil {
+		t.Errorf("pull of signed model without requiring signatures: %v", err)
+	}
+	if err := dst.Pull(t.Context(), unsigned); err != nil {
+		t.Errorf("pull of unsigned model without requiring signatures: %v", err)
+	}
+
+	dst = newTestClient(t, priv)
+	dst.RequireSignatures = true
+	if err := dst.Pull(t.Context(), name); !errors.Is(err, goobla.ErrUntrustedSigner) {
+		t.Errorf("pull with no trusted keys: err = %v, want %v", err, goobla.ErrUntrustedSigner)
+	}
+	if err := dst.Pull(t.Context(), unsigned); !errors.Is(err, goobla.ErrUnsigned) {
+		t.Errorf("pull of unsigned model: err = %v, want %v", err, goobla.ErrUnsigned)
+	}
+
+	dst.TrustedKeys = []goobla.TrustedKey{{Key: key}}
+	if err := dst.Pull(t.Context(), name); err != nil {
+		t.Errorf("pull of trusted model: %v", err)
+	}
+
+	// a model re-signed by another key is no longer trusted
+	other, _ := newTestKey(t)
+	signModel(t, s.Cache, "registry.goobla.ai/library/smol:latest", other)
+	if err := dst.Pull(t.Context(), name); !errors.Is(err, goobla.ErrUntrustedSigner) {
+		t.Errorf("pull of model signed by another key: err = %v, want %v", err, goobla.ErrUntrustedSigner)
+	}
+
+	// a signature of another manifest does not verify
+	d, err := s.Cache.Resolve("registry.goobla.ai/library/unsigned:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := signDigest(t, priv, blob.DigestFromBytes("another manifest"))
+	if _, err := goobla.VerifySignature(d, sig); !errors.Is(err, goobla.ErrInvalidSignature) {
+		t.Errorf("err = %v, want %v", err, goobla.ErrInvalidSignature)
+	}
+}
+
+func TestServerUploadExpiry(t *testing.T) {
+	s, _ := newTestRegistry(t)
+	s.once.Do(s.init)
+	token := s.issueToken(time.Now().Add(time.Minute))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	start := func() (string, *upload) {
+		t.Helper()
+		w := do("POST", "/v2/library/smol/blobs/uploads/", "")
+		if w.Code != 202 {
+			t.Fatalf("start upload: status = %d: %s", w.Code, w.Body)
+		}
+		id := w.Header().Get("Docker-Upload-UUID")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return id, s.uploads[id]
+	}
+
+	id, old := start()
+	if w := do("PATCH", "/v2/library/smol/blobs/uploads/"+id, "data"); w.Code != 202 {
+		t.Fatalf("patch: status = %d: %s", w.Code, w.Body)
+	}
+
+	// an upload receiving data is kept
+	active, u := start()
+	u.mu.Lock()
+	u.updated = time.Now().Add(-2 * uploadTTL)
+
+	old.mu.Lock()
+	old.updated = time.Now().Add(-2 * uploadTTL)
+	old.mu.Unlock()
+
+	start()
+	u.mu.Unlock()
+
+	if _, err := os.Stat(old.file.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the file of the abandoned upload to be removed, got %v", err)
+	}
+	if w := do("PATCH", "/v2/library/smol/blobs/uploads/"+id, "more"); w.Code != 404 || !strings.Contains(w.Body.String(), "BLOB_UPLOAD_UNKNOWN") {
+		t.Errorf("patch of abandoned upload: status = %d, body = %s; want 404 BLOB_UPLOAD_UNKNOWN", w.Code, w.Body)
+	}
+	if w := do("PATCH", "/v2/library/smol/blobs/uploads/"+active, "data"); w.Code != 202 {
+		t.Errorf("patch of active upload: status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestServerChunksumsBounded(t *testing.T) {
+	check := testutil.Checker(t)
+	s, _ := newTestRegistry(t)
+	s.once.Do(s.init)
+	token := s.issueToken(time.Now().Add(time.Minute))
+
+	var digests []blob.Digest
+	for i := range maxChunksums + 1 {
+		data := fmt.Sprintf("blob %d", i)
+		d := blob.DigestFromBytes(data)
+		check(blob.PutBytes(s.Cache, d, data))
+		digests = append(digests, d)
+
+		req := httptest.NewRequest("GET", "/v2/library/smol/chunksums/"+d.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("chunksums: status = %d: %s", w.Code, w.Body)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.chunksums) != maxChunksums {
+		t.Errorf("kept the chunksums of %d blobs, want %d", len(s.chunksums), maxChunksums)
+	}
+	if _, ok := s.chunksums[digests[0]]; ok {
+		t.Error("expected the oldest chunksums to be evicted")
+	}
+	if _, ok := s.chunksums[digests[maxChunksums]]; !ok {
+		t.Error("expected the newest chunksums to be kept")
+	}
+}
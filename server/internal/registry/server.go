@@ -1,5 +1,6 @@
-// Package registry implements an http.Handler for handling local Goobla API
-// model management requests. See [Local] for details.
+// Package registry implements http.Handlers for handling local Goobla API
+// model management requests, and for serving models to other Goobla instances
+// as a registry. See [Local] and [Server] for details.
 package registry
 
 import (
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// maxLogitBias is the magnitude of the largest bias that may be applied to a
+// token, enough to ban or force it
+const maxLogitBias = 100
+
+// resolveLogitBias returns bias keyed by token id. Keys that are integers are
+// taken to be token ids already, as in the OpenAI API. Any other key is
+// tokenized and its bias applied to every token of the text.
+func resolveLogitBias(ctx context.Context, tokenize tokenizeFunc, bias map[string]float32) (map[string]float32, error) {
+	if len(bias) == 0 {
+		return nil, nil
+	}
+
+	resolved := make(map[string]float32, len(bias))
+	for k, b := range bias {
+		if b < -maxLogitBias || b > maxLogitBias {
+			return nil, fmt.Errorf("logit bias for %q must be between %d and %d", k, -maxLogitBias, maxLogitBias)
+		}
+
+		if _, err := strconv.ParseInt(k, 10, 32); err == nil {
+			resolved[k] += b
+			continue
+		}
+
+		tokens, err := tokenize(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("logit bias text %q does not encode to any tokens", k)
+		}
+
+		for _, t := range tokens {
+			resolved[strconv.Itoa(t)] += b
+		}
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveLogitBias(t *testing.T) {
+	// each word of the text is a token, numbered by its length
+	tokenize := func(_ context.Context, s string) ([]int, error) {
+		if s == "fail" {
+			return nil, errors.New("tokenize failed")
+		}
+
+		var tokens []int
+		for _, f := range strings.Fields(s) {
+			tokens = append(tokens, 100+len(f))
+		}
+		return tokens, nil
+	}
+
+	cases := []struct {
+		name string
+		bias map[string]float32
+		want map[string]float32
+		err  string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "token ids",
+			bias: map[string]float32{"1": -100, "42": 5},
+			want: map[string]float32{"1": -100, "42": 5},
+		},
+		{
+			name: "text",
+			bias: map[string]float32{"yes": 10, "no thanks": -100},
+			want: map[string]float32{"103": 10, "102": -100, "106": -100},
+		},
+		{
+			name: "overlapping",
+			bias: map[string]float32{"103": 1, "abc": 2},
+			want: map[string]float32{"103": 3},
+		},
+		{
+			name: "out of range",
+			bias: map[string]float32{"1": -101},
+			err:  `logit bias for "1" must be between -100 and 100`,
+		},
+		{
+			name: "no tokens",
+			bias: map[string]float32{" ": 1},
+			err:  `logit bias text " " does not encode to any tokens`,
+		},
+		{
+			name: "tokenize error",
+			bias: map[string]float32{"fail": 1},
+			err:  "tokenize failed",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveLogitBias(t.Context(), tokenize, tt.bias)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -122,7 +122,12 @@ func WriteManifest(name model.Name, config Layer, layers []Layer) error {
 		Layers:        layers,
 	}
 
-	return json.NewEncoder(f).Encode(m)
+	if err := json.NewEncoder(f).Encode(m); err != nil {
+		return err
+	}
+
+	replaceAlias(name)
+	return nil
 }
 
 func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/metrics"
+)
+
+// serverMetrics holds the metrics exposed on /metrics when GOOBLA_METRICS is
+// set.
+var serverMetrics = metrics.NewRegistry()
+
+// latencyBuckets covers both quick API calls and long generations.
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	requestDuration = serverMetrics.NewHistogram(
+		"goobla_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, including streamed responses.",
+		latencyBuckets, "method", "path", "code")
+
+	queueDepth = serverMetrics.NewGauge(
+		"goobla_scheduler_queue_depth",
+		"Number of requests waiting to be scheduled on a runner.")
+	queueRejected = serverMetrics.NewCounter(
+		"goobla_scheduler_rejected_requests_total",
+		"Number of requests rejected because the queue was full (GOOBLA_MAX_QUEUE).")
+
+	runnersLoaded = serverMetrics.NewGauge(
+		"goobla_runners_loaded",
+		"Number of loaded model runners.")
+	runnerVRAM = serverMetrics.NewGauge(
+		"goobla_runner_vram_bytes",
+		"Estimated VRAM used by each loaded model runner.", "model")
+	runnerLoadDuration = serverMetrics.NewHistogram(
+		"goobla_runner_load_duration_seconds",
+		"Time taken to load a model runner.",
+		latencyBuckets, "model")
+
+	timeToFirstToken = serverMetrics.NewHistogram(
+		"goobla_time_to_first_token_seconds",
+		"Time from receiving a generate or chat request to its first response from the runner.",
+		latencyBuckets, "model")
+	promptTokens = serverMetrics.NewCounter(
+		"goobla_prompt_tokens_total",
+		"Number of prompt tokens evaluated.", "model")
+	generatedTokens = serverMetrics.NewCounter(
+		"goobla_generated_tokens_total",
+		"Number of tokens generated.", "model")
+	tokensPerSecond = serverMetrics.NewHistogram(
+		"goobla_generation_tokens_per_second",
+		"Generation speed of completed responses.",
+		[]float64{1, 5, 10, 20, 30, 50, 75, 100, 150, 200, 500}, "model")
+
+	pullBytes = serverMetrics.NewCounter(
+		"goobla_pull_bytes_total",
+		"Number of bytes downloaded from registries.")
+	pushBytes = serverMetrics.NewCounter(
+		"goobla_push_bytes_total",
+		"Number of bytes uploaded to registries.")
+)
+
+// observeGeneration records the token counts and speed of a completed
+// generation.
+func observeGeneration(model string, m api.Metrics) {
+	promptTokens.Add(float64(m.PromptEvalCount), model)
+	generatedTokens.Add(float64(m.EvalCount), model)
+	if m.EvalDuration > 0 {
+		tokensPerSecond.Observe(float64(m.EvalCount)/m.EvalDuration.Seconds(), model)
+	}
+}
+
+// collectMetrics updates the gauges that describe the scheduler's state.
+func (s *Scheduler) collectMetrics() {
+	queueDepth.Set(float64(s.queue.len()))
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+
+	runnersLoaded.Set(float64(len(s.loaded)))
+	runnerVRAM.Reset()
+	for _, runner := range s.loaded {
+		if m := runner.model; m != nil {
+			runnerVRAM.Set(float64(runner.estimatedVRAM), m.ShortName)
+		}
+	}
+}
+
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	path := c.FullPath()
+	if path == "" {
+		// unmatched routes would otherwise create a series per URL
+		path = "unknown"
+	}
+	requestDuration.Observe(time.Since(start).Seconds(), c.Request.Method, path, strconv.Itoa(c.Writer.Status()))
+}
+
+func (s *Server) MetricsHandler(c *gin.Context) {
+	if s.sched != nil {
+		s.sched.collectMetrics()
+	}
+
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	if _, err := serverMetrics.WriteTo(c.Writer); err != nil {
+		slog.Debug("failed to write metrics", "error", err)
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/llm"
+	"github.com/goobla/goobla/types/model"
+)
+
+// prefixStore keeps the prefixes registered with /api/prefix in the models
+// directory, so that they can be pinned again after the server restarts or
+// the model is reloaded.
+type prefixStore struct {
+	path string
+
+	mu sync.Mutex
+	// prefixes holds the system prompt of each prefix, by model then name
+	prefixes map[string]map[string]string
+}
+
+func prefixesPath() (string, error) {
+	dir, err := envconfig.Models()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "prefixes.json"), nil
+}
+
+// loadPrefixes loads the prefixes stored at path.
+func loadPrefixes(path string) (*prefixStore, error) {
+	st := &prefixStore{path: path, prefixes: make(map[string]map[string]string)}
+
+	bts, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &st.prefixes); err != nil {
+		slog.Warn("skipping corrupt prefixes", "path", path, "error", err)
+		st.prefixes = make(map[string]map[string]string)
+	}
+
+	return st, nil
+}
+
+// get returns the system prompt of the prefix name of model
+func (st *prefixStore) get(model, name string) (string, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	system, ok := st.prefixes[model][name]
+	return system, ok
+}
+
+// set registers the prefix name of model, replacing any prefix with the
+// same name.
+func (st *prefixStore) set(model, name, system string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.prefixes[model] == nil {
+		st.prefixes[model] = make(map[string]string)
+	}
+	st.prefixes[model][name] = system
+
+	return writeJSON(st.path, st.prefixes)
+}
+
+// delete removes the prefix name of model, reporting whether it existed.
+func (st *prefixStore) delete(model, name string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.prefixes[model][name]; !ok {
+		return false, nil
+	}
+
+	delete(st.prefixes[model], name)
+	if len(st.prefixes[model]) == 0 {
+		delete(st.prefixes, model)
+	}
+
+	return true, writeJSON(st.path, st.prefixes)
+}
+
+// pinPrefix pins the start of the prompts of m that use system as their
+// system prompt in r. Pinning a prefix that is already pinned is cheap, so it
+// is called by every request using the prefix to pin it again after the
+// model is reloaded.
+func pinPrefix(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, name, system string) (llm.PinnedPrefix, error) {
+	msgs := append([]api.Message{{Role: "system", Content: system}}, m.Messages...)
+	prompt, _, _, err := chatPrompt(ctx, m, r.Tokenize, opts, msgs, nil, nil)
+	if err != nil {
+		return llm.PinnedPrefix{}, err
+	}
+
+	return r.Pin(ctx, name, prompt)
+}
+
+// PrefixHandler registers a named system prompt for a model and pins its
+// evaluation in the memory of the loaded model.
+func (s *Server) PrefixHandler(c *gin.Context) {
+	var req api.PrefixRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.Name == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	case req.System == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "system is required"})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	// prefixes are kept under the model an alias refers to, as they belong
+	// to its evaluation
+	name, err = resolveAlias(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), []model.Capability{model.CapabilityCompletion}, nil, req.KeepAlive)
+	if errors.Is(err, errCapabilityCompletion) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support prefixes", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	p, err := pinPrefix(c.Request.Context(), r, m, opts, req.Name, req.System)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.prefixes.set(name.String(), req.Name, req.System); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.PrefixResponse{Model: req.Model, PinnedPrefix: pinnedPrefix(p)})
+}
+
+// DeletePrefixHandler removes a prefix and releases its memory if the model
+// is loaded.
+func (s *Server) DeletePrefixHandler(c *gin.Context) {
+	var req api.DeletePrefixRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	// prefixes are kept under the model an alias refers to, as they belong
+	// to its evaluation
+	name, err = resolveAlias(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ok, err := s.prefixes.delete(name.String(), req.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("prefix %q not found", req.Name)})
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.sched.loadedMu.Lock()
+	runner := s.sched.loaded[m.ModelPath]
+	s.sched.loadedMu.Unlock()
+
+	if runner != nil && runner.llama != nil {
+		if err := runner.llama.Unpin(c.Request.Context(), req.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func pinnedPrefix(p llm.PinnedPrefix) api.PinnedPrefix {
+	return api.PinnedPrefix{Name: p.Name, Tokens: p.Tokens, Size: int64(p.Size)}
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/discover"
+	"github.com/goobla/goobla/fs/ggml"
+	"github.com/goobla/goobla/llm"
+	"github.com/goobla/goobla/types/model"
+)
+
+// prefixRunner is a mockRunner that records the prompts it pins
+type prefixRunner struct {
+	mockRunner
+
+	pinned map[string]string
+}
+
+func (m *prefixRunner) Pin(_ context.Context, name, prompt string) (llm.PinnedPrefix, error) {
+	m.pinned[name] = prompt
+	return llm.PinnedPrefix{Name: name, Tokens: len(strings.Fields(prompt)), Size: 1024}, nil
+}
+
+func (m *prefixRunner) Unpin(_ context.Context, name string) error {
+	delete(m.pinned, name)
+	return nil
+}
+
+func (m *prefixRunner) Pinned() []llm.PinnedPrefix {
+	var pinned []llm.PinnedPrefix
+	for name, prompt := range m.pinned {
+		pinned = append(pinned, llm.PinnedPrefix{Name: name, Tokens: len(strings.Fields(prompt)), Size: 1024})
+	}
+	return pinned
+}
+
+func TestPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+
+	mock := prefixRunner{
+		mockRunner: mockRunner{CompletionResponse: llm.CompletionResponse{Done: true, DoneReason: llm.DoneReasonStop}},
+		pinned:     make(map[string]string),
+	}
+
+	prefixes, err := loadPrefixes(filepath.Join(t.TempDir(), "prefixes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			queue:         newPendingQueue(1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn: func(discover.GpuInfoList, string, *ggml.GGML, []string, []string, string, api.Options, int) (llm.LlamaServer, error) {
+				return &mock, nil
+			},
+			getGpuFn:     discover.GetGPUInfo,
+			getCpuFn:     discover.GetCPUInfo,
+			reschedDelay: 250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{llama: &mock}
+			},
+		},
+		prefixes: prefixes,
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []*ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		System: "You are a model.",
+		Template: `
+{{- range .Messages }}
+{{- .Role }}: {{ .Content }}
+{{ end }}`,
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	const system = "You answer questions about the manual."
+
+	t.Run("register", func(t *testing.T) {
+		w := createRequest(t, s.PrefixHandler, api.PrefixRequest{Model: "test", Name: "manual", System: system})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.PrefixResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Name != "manual" || resp.Tokens != 7 || resp.Size != 1024 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+
+		if got, want := mock.pinned["manual"], "system: "+system+"\n"; got != want {
+			t.Errorf("expected %q pinned, got %q", want, got)
+		}
+	})
+
+	t.Run("missing system", func(t *testing.T) {
+		w := createRequest(t, s.PrefixHandler, api.PrefixRequest{Model: "test", Name: "empty"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		clear(mock.pinned)
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Prefix:   "manual",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		// the prefix is pinned again, such as after the model is reloaded
+		if !strings.HasPrefix(mock.CompletionRequest.Prompt, mock.pinned["manual"]) || mock.pinned["manual"] == "" {
+			t.Errorf("expected prompt %q to start with the prefix %q", mock.CompletionRequest.Prompt, mock.pinned["manual"])
+		}
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prefix: "manual",
+			Prompt: "Hello!",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if want := "system: " + system + "\nuser: Hello!\n"; mock.CompletionRequest.Prompt != want {
+			t.Errorf("expected prompt %q, got %q", want, mock.CompletionRequest.Prompt)
+		}
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		if err := SetAlias(model.ParseName("prod"), model.ParseName("test")); err != nil {
+			t.Fatal(err)
+		}
+
+		// the prefix registered for the model is found through the alias
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "prod",
+			Prefix: "manual",
+			Prompt: "Hello!",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if want := "system: " + system + "\nuser: Hello!\n"; mock.CompletionRequest.Prompt != want {
+			t.Errorf("expected prompt %q, got %q", want, mock.CompletionRequest.Prompt)
+		}
+
+		// and one registered through the alias is kept for the model
+		w = createRequest(t, s.PrefixHandler, api.PrefixRequest{Model: "prod", Name: "alias", System: system})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if _, ok := s.prefixes.get(model.ParseName("test").String(), "alias"); !ok {
+			t.Error("expected the prefix to be kept under the model the alias refers to")
+		}
+
+		w = createRequest(t, s.DeletePrefixHandler, api.DeletePrefixRequest{Model: "prod", Name: "alias"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if _, ok := s.prefixes.get(model.ParseName("test").String(), "alias"); ok {
+			t.Error("expected the prefix to be removed through the alias")
+		}
+		delete(mock.pinned, "alias")
+		if err := RemoveAlias(model.ParseName("prod")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("generate with system", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prefix: "manual",
+			System: "You are a model.",
+			Prompt: "Hello!",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("unknown prefix", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Prefix:   "unknown",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("ps", func(t *testing.T) {
+		s.sched.loadedMu.Lock()
+		s.sched.loaded["test"] = &runnerRef{llama: &mock, model: &Model{ShortName: "test"}}
+		s.sched.loadedMu.Unlock()
+		t.Cleanup(func() {
+			s.sched.loadedMu.Lock()
+			delete(s.sched.loaded, "test")
+			s.sched.loadedMu.Unlock()
+		})
+
+		w := createRequest(t, s.PsHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.ProcessResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Models) != 1 || len(resp.Models[0].Prefixes) != 1 || resp.Models[0].Prefixes[0].Name != "manual" {
+			t.Errorf("unexpected prefixes %+v", resp.Models)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := createRequest(t, s.DeletePrefixHandler, api.DeletePrefixRequest{Model: "test", Name: "manual"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if _, ok := s.prefixes.get("registry.goobla.ai/library/test:latest", "manual"); ok {
+			t.Error("expected prefix to be removed")
+		}
+
+		w = createRequest(t, s.DeletePrefixHandler, api.DeletePrefixRequest{Model: "test", Name: "manual"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("persisted", func(t *testing.T) {
+		if err := s.prefixes.set("registry.goobla.ai/library/test:latest", "other", system); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := loadPrefixes(s.prefixes.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, ok := loaded.get("registry.goobla.ai/library/test:latest", "other"); !ok || got != system {
+			t.Errorf("expected %q, got %q", system, got)
+		}
+	})
+}
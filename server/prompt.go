@@ -10,17 +10,24 @@ import (
 	"strings"
 
 	"github.com/goobla/goobla/api"
-	"github.com/goobla/goobla/fs/ggml"
 	"github.com/goobla/goobla/llm"
 	"github.com/goobla/goobla/template"
+	"github.com/goobla/goobla/tracing"
 )
 
 type tokenizeFunc func(context.Context, string) ([]int, error)
 
-// chatPrompt accepts a list of messages and returns the prompt and images that should be used for the next chat turn.
+// chatPrompt accepts a list of messages and returns the prompt, images and audios that should be used for the next chat turn.
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
 // latest message and 2) system messages
-func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool, think *bool) (prompt string, images []llm.ImageData, _ error) {
+func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool, think *bool) (prompt string, images []llm.ImageData, audios []llm.AudioData, err error) {
+	ctx, span := tracing.Start(ctx, "chatPrompt")
+	defer func() {
+		span.SetAttributes("messages", len(msgs))
+		span.SetError(err)
+		span.End()
+	}()
+
 	var system []api.Message
 
 	imageNumTokens := 768
@@ -51,12 +58,12 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		}
 		var b bytes.Buffer
 		if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[i:]...), Tools: tools, Think: thinkVal, IsThinkSet: think != nil}); err != nil {
-			return "", nil, err
+			return "", nil, nil, err
 		}
 
 		s, err := tokenize(ctx, b.String())
 		if err != nil {
-			return "", nil, err
+			return "", nil, nil, err
 		}
 
 		ctxLen := len(s)
@@ -78,7 +85,7 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 
 	for cnt, msg := range msgs[currMsgIdx:] {
 		if slices.Contains(m.Config.ModelFamilies, "mllama") && len(msg.Images) > 1 {
-			return "", nil, errors.New("this model only supports one image while more than one image requested")
+			return "", nil, nil, errors.New("this model only supports one image while more than one image requested")
 		}
 
 		var prefix string
@@ -99,6 +106,22 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 
 			images = append(images, imgData)
 		}
+
+		for _, a := range msg.Audios {
+			audioData := llm.AudioData{
+				ID:   len(audios),
+				Data: a,
+			}
+
+			audioTag := fmt.Sprintf("[audio-%d]", audioData.ID)
+			if !strings.Contains(prompt, "[audio]") {
+				prefix += audioTag
+			} else {
+				prompt = strings.Replace(prompt, "[audio]", audioTag, 1)
+			}
+
+			audios = append(audios, audioData)
+		}
 		msgs[currMsgIdx+cnt].Content = prefix + prompt
 	}
 
@@ -109,10 +132,10 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		thinkVal = *think
 	}
 	if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[currMsgIdx:]...), Tools: tools, Think: thinkVal, IsThinkSet: think != nil}); err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
-	return b.String(), images, nil
+	return b.String(), images, audios, nil
 }
 
 func projectorImageTokens(path string) int {
@@ -14,6 +14,7 @@ func TestChatPrompt(t *testing.T) {
 	type expect struct {
 		prompt string
 		images [][]byte
+		audios [][]byte
 		error  error
 	}
 
@@ -224,6 +225,19 @@ func TestChatPrompt(t *testing.T) {
 				images: [][]byte{[]byte("something")},
 			},
 		},
+		{
+			name:  "audios and images",
+			model: visionModel,
+			limit: 2048,
+			msgs: []api.Message{
+				{Role: "user", Content: "What is said in [audio]?", Images: []api.ImageData{[]byte("a hotdog")}, Audios: []api.AudioData{[]byte("one"), []byte("two")}},
+			},
+			expect: expect{
+				prompt: "[img-0][audio-1]What is said in [audio-0]? ",
+				images: [][]byte{[]byte("a hotdog")},
+				audios: [][]byte{[]byte("one"), []byte("two")},
+			},
+		},
 	}
 
 	for _, tt := range cases {
@@ -231,7 +245,7 @@ func TestChatPrompt(t *testing.T) {
 			model := tt.model
 			opts := api.Options{Runner: api.Runner{NumCtx: tt.limit}}
 			think := false
-			prompt, images, err := chatPrompt(t.Context(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil, &think)
+			prompt, images, audios, err := chatPrompt(t.Context(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil, &think)
 			if tt.error == nil && err != nil {
 				t.Fatal(err)
 			} else if tt.error != nil && err != tt.error {
@@ -257,6 +271,16 @@ func TestChatPrompt(t *testing.T) {
 					}
 				}
 			}
+
+			if len(audios) != len(tt.audios) {
+				t.Fatalf("expected %d audios, got %d", len(tt.audios), len(audios))
+			}
+
+			for i := range audios {
+				if audios[i].ID != i || !bytes.Equal(audios[i].Data, tt.audios[i]) {
+					t.Errorf("expected audio %d %q, got %d %q", i, tt.audios[i], audios[i].ID, audios[i].Data)
+				}
+			}
 		})
 	}
 }
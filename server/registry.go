@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/logutil"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/registry"
+	"github.com/goobla/goobla/version"
+)
+
+// authorizedKeysFile returns the default path of the file listing the public
+// keys of the clients allowed to push to and pull from a registry served by
+// [ServeRegistry].
+func authorizedKeysFile() string {
+	return filepath.Join(envconfig.ConfigDir(), "authorized_keys")
+}
+
+// loadAuthorizedKeys reads the public keys in path, which is in the format of
+// an SSH authorized_keys file.
+func loadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(bts)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(bts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+		bts = rest
+	}
+	return keys, nil
+}
+
+// ServeRegistry serves the models in the models directory on ln as a
+// registry, which other Goobla instances can push models to and pull models
+// from. Clients authenticate with the keys listed in keysFile, or in
+// authorized_keys in the config directory if keysFile is empty.
+func ServeRegistry(ln net.Listener, keysFile string) error {
+	if err := envconfig.Validate(); err != nil {
+		return err
+	}
+	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
+
+	keysFile = cmp.Or(keysFile, authorizedKeysFile())
+	keys, err := loadAuthorizedKeys(keysFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys are authorized to use the registry: add the id_ed25519.pub key of each goobla instance that may push or pull to %s", keysFile)
+	}
+
+	dir, err := envconfig.Models()
+	if err != nil {
+		return err
+	}
+	c, err := blob.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	srvr := newHTTPServer(&registry.Server{
+		Cache:  c,
+		Logger: slog.Default(),
+		Keys:   keys,
+	})
+
+	proto := "http"
+	if envconfig.TLSCert() != "" {
+		proto = "https"
+	}
+	slog.Info(fmt.Sprintf("Serving registry on %s (%s, version %s)", ln.Addr(), proto, version.Version), "models", dir, "keys", len(keys))
+
+	if envconfig.TLSCert() != "" {
+		err = srvr.ServeTLS(ln, envconfig.TLSCert(), envconfig.TLSKey())
+	} else {
+		err = srvr.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/registry"
+	"github.com/goobla/goobla/types/model"
+)
+
+func TestRegistryPushPull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keyPath := writeTestKey(t)
+	t.Setenv("GOOBLA_PRIVATE_KEY", keyPath)
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysFile := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(keysFile, append([]byte("# clients\n"), ssh.MarshalAuthorizedKey(key.PublicKey())...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadAuthorizedKeys(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(&registry.Server{Cache: c, Logger: slog.Default(), Keys: keys})
+	defer ts.Close()
+
+	name := ts.Listener.Addr().String() + "/library/test:latest"
+	progress := func(api.ProgressResponse) {}
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  name,
+		Files:  map[string]string{"test.gguf": digest},
+		System: "You are a model.",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, progress); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	want, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pull into another models directory, as another instance would
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	if err := PullModel(t.Context(), name, &registryOptions{Insecure: true}, progress); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+
+	got, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.digest != want.digest {
+		t.Errorf("pulled manifest %s, want %s", got.digest, want.digest)
+	}
+
+	m, err := GetModel(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.System != "You are a model." {
+		t.Errorf("expected the system prompt to be pulled, got %q", m.System)
+	}
+
+	// clients with other keys are denied
+	t.Setenv("GOOBLA_PRIVATE_KEY", writeTestKey(t))
+	if err := PullModel(t.Context(), name, &registryOptions{Insecure: true}, progress); err == nil {
+		t.Error("expected pulling with an unauthorized key to fail")
+	}
+}
+
+// writeTestKey writes a new private key for signing registry requests and
+// returns its path.
+func writeTestKey(t *testing.T) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
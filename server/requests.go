@@ -0,0 +1,178 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/goobla/goobla/api"
+)
+
+// requestIDHeader is the response header carrying the ID of a request,
+// which can be used to cancel it with DELETE /api/requests/:id.
+const requestIDHeader = "X-Goobla-Request-Id"
+
+// errRequestCanceled is the cause of requests canceled through the API. It
+// wraps [context.Canceled] so it is handled like a closed connection.
+var errRequestCanceled = fmt.Errorf("request canceled: %w", context.Canceled)
+
+// activeRequest is a generate, chat or embed request that is queued or
+// running. It is listed by /api/requests until its handler returns.
+type activeRequest struct {
+	id      string
+	model   string
+	class   queueClass
+	owner   string
+	created time.Time
+	cancel  context.CancelCauseFunc
+
+	// running is set once the request has been given a runner
+	running atomic.Bool
+	// tokens is the number of tokens generated so far
+	tokens atomic.Int64
+}
+
+type activeRequestKey struct{}
+
+func activeRequestFromContext(ctx context.Context) *activeRequest {
+	ar, _ := ctx.Value(activeRequestKey{}).(*activeRequest)
+	return ar
+}
+
+type requestTracker struct {
+	mu   sync.Mutex
+	reqs map[string]*activeRequest
+}
+
+// startRequest tracks the request of c for model and returns it. The request
+// context of c is replaced with one that carries the request and is canceled
+// when the request is, and the request ID is set in the response header.
+// The request is queued under priority and its client. Requests of batches
+// are always queued at low priority, and keys that may not queue requests at
+// high priority have them queued at normal priority.
+// finishRequest must be called once the request is done.
+func (s *Server) startRequest(c *gin.Context, model string, priority api.Priority) (*activeRequest, error) {
+	if p, ok := c.Request.Context().Value(batchPriorityKey{}).(api.Priority); ok {
+		priority = p
+	}
+
+	if priority == "" {
+		priority = api.PriorityNormal
+	} else if _, ok := priorityWeights[priority]; !ok {
+		return nil, fmt.Errorf("invalid priority %q, must be %q, %q or %q", priority, api.PriorityHigh, api.PriorityNormal, api.PriorityLow)
+	}
+
+	if v, ok := c.Get(apiKeyContextKey); ok && priority == api.PriorityHigh && !v.(*apiKey).HighPriority {
+		priority = api.PriorityNormal
+	}
+
+	ar := &activeRequest{
+		id:      uuid.NewString(),
+		model:   model,
+		class:   queueClass{s.requestClient(c), priority},
+		owner:   requestOwner(c),
+		created: time.Now(),
+	}
+
+	var ctx context.Context
+	ctx, ar.cancel = context.WithCancelCause(c.Request.Context())
+	c.Request = c.Request.WithContext(context.WithValue(ctx, activeRequestKey{}, ar))
+	c.Header(requestIDHeader, ar.id)
+
+	s.requests.mu.Lock()
+	defer s.requests.mu.Unlock()
+	if s.requests.reqs == nil {
+		s.requests.reqs = make(map[string]*activeRequest)
+	}
+	s.requests.reqs[ar.id] = ar
+	return ar, nil
+}
+
+// requestClient returns the client of the request of c, which is the name of
+// its API key. Clients can only name themselves with the X-Goobla-Client
+// header if authentication is disabled, and are otherwise identified by their
+// remote address.
+func (s *Server) requestClient(c *gin.Context) string {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		return v.(*apiKey).Name
+	}
+	if s.apiKeys != nil {
+		return c.ClientIP()
+	}
+	return cmp.Or(c.GetHeader("X-Goobla-Client"), c.ClientIP())
+}
+
+// requestVisible reports whether the caller of c can list and cancel ar. A
+// request is visible to the key it was made with and to keys with the models
+// scope. Every request is visible if authentication is disabled.
+func requestVisible(c *gin.Context, ar *activeRequest) bool {
+	if visible(requestOwner(c), ar.owner) {
+		return true
+	}
+
+	v, ok := c.Get(apiKeyContextKey)
+	return ok && v.(*apiKey).hasScope(scopeModels)
+}
+
+func (s *Server) finishRequest(ar *activeRequest) {
+	s.requests.mu.Lock()
+	delete(s.requests.reqs, ar.id)
+	s.requests.mu.Unlock()
+
+	ar.cancel(nil)
+}
+
+func (s *Server) ListRequestsHandler(c *gin.Context) {
+	s.requests.mu.Lock()
+	reqs := make([]api.RequestResponse, 0, len(s.requests.reqs))
+	for _, ar := range s.requests.reqs {
+		if !requestVisible(c, ar) {
+			continue
+		}
+
+		state := api.RequestStateQueued
+		if ar.running.Load() {
+			state = api.RequestStateRunning
+		}
+
+		reqs = append(reqs, api.RequestResponse{
+			ID:        ar.id,
+			Model:     ar.model,
+			Client:    ar.class.client,
+			Priority:  ar.class.priority,
+			State:     state,
+			CreatedAt: ar.created,
+			Tokens:    int(ar.tokens.Load()),
+		})
+	}
+	s.requests.mu.Unlock()
+
+	// oldest first
+	slices.SortFunc(reqs, func(a, b api.RequestResponse) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+
+	c.JSON(http.StatusOK, api.ListRequestsResponse{Requests: reqs})
+}
+
+func (s *Server) CancelRequestHandler(c *gin.Context) {
+	s.requests.mu.Lock()
+	ar, ok := s.requests.reqs[c.Param("id")]
+	s.requests.mu.Unlock()
+
+	if !ok || !requestVisible(c, ar) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("request %q not found", c.Param("id"))})
+		return
+	}
+
+	ar.cancel(errRequestCanceled)
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/fs/ggml"
+)
+
+func TestCancelRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+
+	// the scheduler is not running, so requests stay queued
+	s := &Server{
+		sched: &Scheduler{
+			queue:  newPendingQueue(1),
+			loaded: make(map[string]*runnerRef),
+		},
+	}
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture": "llama",
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	router, err := s.GenerateRoutes(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(api.GenerateRequest{Model: "test", Prompt: "Hello!", Priority: api.PriorityLow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generated := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", bytes.NewReader(body)))
+		generated <- w
+	}()
+
+	var listed api.RequestResponse
+	deadline := time.Now().Add(time.Second)
+	for listed.ID == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the request to be listed")
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/requests", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.ListRequestsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Requests) > 0 && s.sched.queue.len() > 0 {
+			listed = resp.Requests[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if listed.Model != "test" || listed.State != api.RequestStateQueued || listed.Priority != api.PriorityLow || listed.Tokens != 0 {
+		t.Errorf("unexpected request %+v", listed)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/requests/"+listed.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	select {
+	case w := <-generated:
+		if w.Code != 499 {
+			t.Errorf("expected status 499, got %d: %s", w.Code, w.Body)
+		}
+		if id := w.Header().Get(requestIDHeader); id != listed.ID {
+			t.Errorf("expected request ID %q, got %q", listed.ID, id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("canceled request did not return")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/requests/"+listed.ID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a finished request, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/requests", nil))
+
+	var resp api.ListRequestsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Requests) != 0 {
+		t.Errorf("expected no requests, got %+v", resp.Requests)
+	}
+}
+
+func TestRequestOwners(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	if err := os.WriteFile(path, []byte(`{"keys":[
+		{"name":"a","key":"a-key"},
+		{"name":"b","key":"b-key"},
+		{"name":"admin","key":"admin-key","scopes":["inference","models"]}
+	]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{apiKeys: keys}
+	router, err := s.GenerateRoutes(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctxs := make(map[string]context.Context)
+	s.requests.reqs = make(map[string]*activeRequest)
+	for _, k := range keys.keys[:2] {
+		ar := &activeRequest{id: k.Name, owner: k.id(), created: time.Now()}
+		ctxs[k.Name], ar.cancel = context.WithCancelCause(t.Context())
+		s.requests.reqs[ar.id] = ar
+	}
+
+	list := func(t *testing.T, key string) []string {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/api/requests", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ListRequestsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, req := range resp.Requests {
+			ids = append(ids, req.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	cancel := func(t *testing.T, key, id string) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodDelete, "/api/requests/"+id, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if ids := list(t, "a-key"); !slices.Equal(ids, []string{"a"}) {
+		t.Errorf("expected a to list its own request, got %v", ids)
+	}
+	if ids := list(t, "admin-key"); !slices.Equal(ids, []string{"a", "b"}) {
+		t.Errorf("expected admin to list every request, got %v", ids)
+	}
+
+	if code := cancel(t, "a-key", "b"); code != http.StatusNotFound {
+		t.Errorf("expected status 404 canceling another key's request, got %d", code)
+	}
+	if ctxs["b"].Err() != nil {
+		t.Error("expected b's request to not be canceled")
+	}
+
+	if code := cancel(t, "a-key", "a"); code != http.StatusOK {
+		t.Errorf("expected status 200 canceling its own request, got %d", code)
+	}
+	if ctxs["a"].Err() == nil {
+		t.Error("expected a's request to be canceled")
+	}
+
+	if code := cancel(t, "admin-key", "b"); code != http.StatusOK {
+		t.Errorf("expected status 200 canceling as admin, got %d", code)
+	}
+	if ctxs["b"].Err() == nil {
+		t.Error("expected b's request to be canceled")
+	}
+}
+
+func TestRequestClass(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	if err := os.WriteFile(path, []byte(`{"keys":[
+		{"name":"app","key":"app-key"},
+		{"name":"urgent","key":"urgent-key","high_priority":true}
+	]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		keys     *apiKeys
+		key      *apiKey
+		priority api.Priority
+		want     queueClass
+	}{
+		{"no keys", nil, nil, api.PriorityHigh, queueClass{"me", api.PriorityHigh}},
+		{"key", keys, keys.keys[0], api.PriorityLow, queueClass{"app", api.PriorityLow}},
+		{"key without high priority", keys, keys.keys[0], api.PriorityHigh, queueClass{"app", api.PriorityNormal}},
+		{"key with high priority", keys, keys.keys[1], api.PriorityHigh, queueClass{"urgent", api.PriorityHigh}},
+		{"header ignored with keys", keys, nil, api.PriorityNormal, queueClass{"192.0.2.1", api.PriorityNormal}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{apiKeys: tt.keys}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+			c.Request.Header.Set("X-Goobla-Client", "me")
+			if tt.key != nil {
+				c.Set(apiKeyContextKey, tt.key)
+			}
+
+			ar, err := s.startRequest(c, "test", tt.priority)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.finishRequest(ar)
+
+			if ar.class != tt.want {
+				t.Errorf("class = %+v, want %+v", ar.class, tt.want)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"os/signal"
 	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -28,10 +29,12 @@ import (
 	"golang.org/x/image/webp"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/goobla/goobla/anthropic"
 	"github.com/goobla/goobla/api"
 	"github.com/goobla/goobla/discover"
 	"github.com/goobla/goobla/envconfig"
 	"github.com/goobla/goobla/fs/ggml"
+	"github.com/goobla/goobla/grammar"
 	"github.com/goobla/goobla/llm"
 	"github.com/goobla/goobla/logutil"
 	openaimid "github.com/goobla/goobla/openai/middleware"
@@ -40,6 +43,7 @@ import (
 	"github.com/goobla/goobla/template"
 	"github.com/goobla/goobla/thinking"
 	"github.com/goobla/goobla/tools"
+	"github.com/goobla/goobla/tracing"
 	"github.com/goobla/goobla/types/errtypes"
 	"github.com/goobla/goobla/types/model"
 	"github.com/goobla/goobla/version"
@@ -65,6 +69,19 @@ var mode string = gin.DebugMode
 type Server struct {
 	addr  net.Addr
 	sched *Scheduler
+
+	// apiKeys are the keys accepted by the server, or nil if
+	// authentication is disabled
+	apiKeys *apiKeys
+
+	// requests are the generate, chat and embed requests being served
+	requests requestTracker
+
+	// batches are the batches run in the background
+	batches *batchStore
+
+	// prefixes are the prefixes registered with /api/prefix
+	prefixes *prefixStore
 }
 
 func init() {
@@ -82,6 +99,7 @@ func init() {
 var (
 	errRequired    = errors.New("is required")
 	errBadTemplate = errors.New("template error")
+	errBadDraft    = errors.New("draft model error")
 )
 
 func modelOptions(model *Model, requestOpts map[string]any) (api.Options, error) {
@@ -109,6 +127,10 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []model.C
 		return nil, nil, nil, err
 	}
 
+	if err := touchModel(model.Name); err != nil {
+		slog.Warn("couldn't record model use", "model", model.Name, "error", err)
+	}
+
 	if slices.Contains(model.Config.ModelFamilies, "mllama") && len(model.ProjectorPaths) > 0 {
 		return nil, nil, nil, fmt.Errorf("'llama3.2-vision' is no longer compatible with your version of Goobla and has been replaced by a newer version. To re-download, run 'goobla pull llama3.2-vision'")
 	}
@@ -128,6 +150,12 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []model.C
 	case runner = <-runnerCh:
 	case err = <-errCh:
 		return nil, nil, nil, err
+	case <-ctx.Done():
+		return nil, nil, nil, context.Cause(ctx)
+	}
+
+	if ar := activeRequestFromContext(ctx); ar != nil {
+		ar.running.Store(true)
 	}
 
 	return runner.llama, model, &opts, nil
@@ -144,6 +172,21 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkTopLogprobs(req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkFormat(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.N < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "n must be at least 1"})
+		return
+	}
+
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		// Ideally this is "invalid model name" but we're keeping with
@@ -192,10 +235,42 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkTokens(m.ModelPath, req.Context); errors.Is(err, errInvalidToken) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Prefix != "" {
+		if req.Raw || req.System != "" || req.Suffix != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prefix cannot be combined with raw, system, or suffix"})
+			return
+		}
+
+		// prefixes are kept under the model an alias refers to
+		target, err := resolveAlias(name)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		system, ok := s.prefixes.get(target.String(), req.Prefix)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("prefix %q not found", req.Prefix)})
+			return
+		}
+		req.System = system
+	}
+
 	caps := []model.Capability{model.CapabilityCompletion}
 	if req.Suffix != "" {
 		caps = append(caps, model.CapabilityInsert)
 	}
+	if len(req.Audios) > 0 {
+		caps = append(caps, model.CapabilityAudio)
+	}
 	if req.Think != nil && *req.Think {
 		caps = append(caps, model.CapabilityThinking)
 		// TODO(drifkin): consider adding a warning if it's false and the model
@@ -204,6 +279,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		// updated template supporting thinking
 	}
 
+	ar, err := s.startRequest(c, req.Model, req.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer s.finishRequest(ar)
+
 	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
@@ -213,6 +295,18 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	opts.LogitBias, err = resolveLogitBias(c.Request.Context(), r.Tokenize, opts.LogitBias)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Prefix != "" {
+		if _, err := pinPrefix(c.Request.Context(), r, m, opts, req.Prefix, req.System); err != nil {
+			slog.Warn("failed to pin prefix", "prefix", req.Prefix, "error", err)
+		}
+	}
+
 	if req.Think != nil && !*req.Think && !slices.Contains(m.Capabilities(), model.CapabilityThinking) {
 		msg := "model does not support thinking output"
 		if m.Config.ModelFamily == "qwen3" || model.ParseName(m.Name).Model == "deepseek-r1" {
@@ -244,6 +338,11 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		images[i] = llm.ImageData{ID: i, Data: req.Images[i]}
 	}
 
+	audios := make([]llm.AudioData, len(req.Audios))
+	for i := range req.Audios {
+		audios[i] = llm.AudioData{ID: i, Data: req.Audios[i]}
+	}
+
 	prompt := req.Prompt
 	if !req.Raw {
 		tmpl := m.Template
@@ -276,6 +375,10 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				msgs = append(msgs, api.Message{Role: "user", Content: fmt.Sprintf("[img-%d]"+imgPrompt, i.ID)})
 			}
 
+			for _, a := range audios {
+				msgs = append(msgs, api.Message{Role: "user", Content: fmt.Sprintf("[audio-%d]", a.ID)})
+			}
+
 			values.Messages = append(msgs, api.Message{Role: "user", Content: req.Prompt})
 		}
 
@@ -301,62 +404,88 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		prompt = b.String()
 	}
 
-	var thinkingState *thinking.Parser
 	openingTag, closingTag := thinking.InferTags(m.Template.Template)
-	if req.Think != nil && *req.Think && openingTag != "" && closingTag != "" {
-		thinkingState = &thinking.Parser{
-			OpeningTag: openingTag,
-			ClosingTag: closingTag,
-		}
+
+	// each completion is parsed and accumulated independently
+	type completion struct {
+		thinkingState *thinking.Parser
+		sbRaw         strings.Builder
+		sbThinking    strings.Builder
+		sbContent     strings.Builder
 	}
 
-	var sbRaw strings.Builder
-	var sbThinking strings.Builder
-	var sbContent strings.Builder
+	completions := make([]completion, max(req.N, 1))
+	for i := range completions {
+		if req.Think != nil && *req.Think && openingTag != "" && closingTag != "" {
+			completions[i].thinkingState = &thinking.Parser{
+				OpeningTag: openingTag,
+				ClosingTag: closingTag,
+			}
+		}
+	}
 
+	var firstResponse sync.Once
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:      prompt,
+			Images:      images,
+			Audios:      audios,
+			Format:      req.Format,
+			Options:     opts,
+			Logprobs:    req.Logprobs || req.TopLogprobs > 0,
+			TopLogprobs: req.TopLogprobs,
+			N:           req.N,
 		}, func(cr llm.CompletionResponse) {
+			firstResponse.Do(func() {
+				timeToFirstToken.Observe(time.Since(checkpointStart).Seconds(), m.ShortName)
+			})
+			if !cr.Done {
+				ar.tokens.Add(1)
+			}
+
+			cmpl := &completions[cr.Index]
 			res := api.GenerateResponse{
 				Model:     req.Model,
 				CreatedAt: time.Now().UTC(),
 				Response:  cr.Content,
+				Index:     cr.Index,
 				Done:      cr.Done,
+				Logprobs:  cr.Logprobs,
 				Metrics: api.Metrics{
 					PromptEvalCount:    cr.PromptEvalCount,
 					PromptEvalDuration: cr.PromptEvalDuration,
 					EvalCount:          cr.EvalCount,
 					EvalDuration:       cr.EvalDuration,
+					DraftCount:         cr.DraftCount,
+					DraftAcceptedCount: cr.DraftAcceptedCount,
 				},
 			}
 
-			if thinkingState != nil {
-				thinking, content := thinkingState.AddContent(cr.Content)
+			if cmpl.thinkingState != nil {
+				thinking, content := cmpl.thinkingState.AddContent(cr.Content)
 				res.Thinking = thinking
 				res.Response = content
-				sbThinking.WriteString(thinking)
-				sbContent.WriteString(content)
+				cmpl.sbThinking.WriteString(thinking)
+				cmpl.sbContent.WriteString(content)
 			} else {
-				sbContent.WriteString(cr.Content)
+				cmpl.sbContent.WriteString(cr.Content)
 			}
 
-			if _, err := sbRaw.WriteString(cr.Content); err != nil {
+			if _, err := cmpl.sbRaw.WriteString(cr.Content); err != nil {
 				ch <- gin.H{"error": err.Error()}
 			}
 
 			if cr.Done {
+				observeGeneration(m.ShortName, res.Metrics)
+				chargeTokens(c, res.EvalCount)
 				res.DoneReason = cr.DoneReason.String()
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
 
 				if !req.Raw {
-					tokens, err := r.Tokenize(c.Request.Context(), prompt+sbRaw.String())
+					tokens, err := r.Tokenize(c.Request.Context(), prompt+cmpl.sbRaw.String())
 					if err != nil {
 						ch <- gin.H{"error": err.Error()}
 						return
@@ -372,11 +501,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	}()
 
 	if req.Stream != nil && !*req.Stream {
-		var r api.GenerateResponse
+		rs := make([]api.GenerateResponse, len(completions))
+		logprobs := make([][]api.Logprob, len(completions))
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
-				r = t
+				rs[t.Index] = t
+				logprobs[t.Index] = append(logprobs[t.Index], t.Logprobs...)
 			case gin.H:
 				msg, ok := t["error"].(string)
 				if !ok {
@@ -391,10 +522,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			}
 		}
 
-		r.Thinking = sbThinking.String()
-		r.Response = sbContent.String()
+		for i := range rs {
+			rs[i].Thinking = completions[i].sbThinking.String()
+			rs[i].Response = completions[i].sbContent.String()
+			rs[i].Logprobs = logprobs[i]
+		}
 
-		c.JSON(http.StatusOK, r)
+		writeResponses(c, rs)
 		return
 	}
 
@@ -448,6 +582,13 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	ar, err := s.startRequest(c, req.Model, req.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer s.finishRequest(ar)
+
 	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), []model.Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
@@ -556,6 +697,13 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
+	ar, err := s.startRequest(c, req.Model, req.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer s.finishRequest(ar)
+
 	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []model.Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
@@ -585,6 +733,152 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// runnerTokenizer adapts a scheduled runner to [llm.Tokenizer]. Closing it
+// leaves the runner loaded since its lifetime is managed by the scheduler.
+type runnerTokenizer struct {
+	llm.LlamaServer
+}
+
+func (runnerTokenizer) Close() error { return nil }
+
+// scheduleTokenizer returns a tokenizer for the named model. If vocabOnly is
+// set, only the model's vocabulary is loaded and no runner is scheduled. The
+// tokenizer must be closed once it is no longer needed.
+func (s *Server) scheduleTokenizer(ctx context.Context, name string, vocabOnly bool, requestOpts map[string]any, keepAlive *api.Duration) (llm.Tokenizer, error) {
+	if !vocabOnly {
+		r, _, _, err := s.scheduleRunner(ctx, name, []model.Capability{}, requestOpts, keepAlive)
+		if err != nil {
+			return nil, err
+		}
+		return runnerTokenizer{r}, nil
+	}
+
+	m, err := GetModel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return llm.NewTokenizer(m.ModelPath)
+}
+
+func (s *Server) TokenizeHandler(c *gin.Context) {
+	var req api.TokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	t, err := s.scheduleTokenizer(c.Request.Context(), name.String(), req.VocabOnly, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+	defer t.Close()
+
+	tokens, err := t.Tokenize(c.Request.Context(), req.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []int{}
+	}
+
+	c.JSON(http.StatusOK, api.TokenizeResponse{Model: req.Model, Tokens: tokens})
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// checkTokens returns an error wrapping [errInvalidToken] if any of tokens is
+// outside the vocabulary of the model at modelPath. Runners may crash when
+// asked to detokenize such tokens.
+func checkTokens(modelPath string, tokens []int) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	f, err := llm.LoadModel(modelPath, 0)
+	if err != nil {
+		return err
+	}
+
+	n := f.KV().VocabSize()
+	for _, t := range tokens {
+		if t < 0 || t >= n {
+			return fmt.Errorf("%w %d: the vocabulary has %d tokens", errInvalidToken, t, n)
+		}
+	}
+	return nil
+}
+
+func (s *Server) DetokenizeHandler(c *gin.Context) {
+	var req api.DetokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkTokens(m.ModelPath, req.Tokens); errors.Is(err, errInvalidToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := s.scheduleTokenizer(c.Request.Context(), name.String(), req.VocabOnly, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+	defer t.Close()
+
+	content, err := t.Detokenize(c.Request.Context(), req.Tokens)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.DetokenizeResponse{Model: req.Model, Content: content})
+}
+
 func (s *Server) PullHandler(c *gin.Context) {
 	var req api.PullRequest
 	err := c.ShouldBindJSON(&req)
@@ -626,7 +920,10 @@ func (s *Server) PullHandler(c *gin.Context) {
 
 		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
+			return
 		}
+
+		s.enforceQuota(name)
 	}()
 
 	if req.Stream != nil && !*req.Stream {
@@ -680,8 +977,22 @@ func (s *Server) PushHandler(c *gin.Context) {
 			return
 		}
 
+		// pushing an alias pushes the model it refers to
+		name, err = resolveAlias(name)
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
 		if err := PushModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		if req.Sign {
+			if err := PushModelSignature(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
+				ch <- gin.H{"error": err.Error()}
+			}
 		}
 	}()
 
@@ -743,7 +1054,25 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		return
 	}
 
+	// removing a model or alias that aliases refer to would leave them
+	// dangling
+	if err := checkNoAliases(n); errors.Is(err, errAliasTarget) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	m, err := ParseNamedManifest(n)
+	if os.IsNotExist(err) {
+		if err := RemoveAlias(n); err == nil {
+			return
+		} else if !errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
@@ -759,6 +1088,10 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		return
 	}
 
+	if err := removeModelUsage(n); err != nil {
+		slog.Warn("couldn't remove model usage", "model", n, "error", err)
+	}
+
 	if err := m.RemoveLayers(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -812,6 +1145,11 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		return nil, err
 	}
 
+	name, err = resolveAlias(name)
+	if err != nil {
+		return nil, err
+	}
+
 	m, err := GetModel(name.String())
 	if err != nil {
 		return nil, err
@@ -850,6 +1188,11 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		ModifiedAt:   manifest.fi.ModTime(),
 	}
 
+	resp.Signer, err = modelSigner("sha256:" + manifest.digest)
+	if err != nil {
+		slog.Warn("couldn't verify model signature", "model", name, "error", err)
+	}
+
 	var params []string
 	cs := 30
 	for k, v := range m.Options {
@@ -933,26 +1276,25 @@ func (s *Server) ListHandler(c *gin.Context) {
 		return
 	}
 
-	models := []api.ListModelResponse{}
-	for n, m := range ms {
+	listModel := func(n model.Name, m *Manifest) (api.ListModelResponse, bool) {
 		var cf ConfigV2
 
 		if m.Config.Digest != "" {
 			f, err := m.Config.Open()
 			if err != nil {
 				slog.Warn("bad manifest filepath", "name", n, "error", err)
-				continue
+				return api.ListModelResponse{}, false
 			}
 			defer f.Close()
 
 			if err := json.NewDecoder(f).Decode(&cf); err != nil {
 				slog.Warn("bad manifest config", "name", n, "error", err)
-				continue
+				return api.ListModelResponse{}, false
 			}
 		}
 
 		// tag should never be masked
-		models = append(models, api.ListModelResponse{
+		return api.ListModelResponse{
 			Model:      n.DisplayShortest(),
 			Name:       n.DisplayShortest(),
 			Size:       m.Size(),
@@ -965,7 +1307,52 @@ func (s *Server) ListHandler(c *gin.Context) {
 				ParameterSize:     cf.ModelType,
 				QuantizationLevel: cf.FileType,
 			},
-		})
+		}, true
+	}
+
+	models := []api.ListModelResponse{}
+	for n, m := range ms {
+		if lm, ok := listModel(n, m); ok {
+			models = append(models, lm)
+		}
+	}
+
+	aliases, err := Aliases()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for n, target := range aliases {
+		if _, ok := ms[n]; ok {
+			continue
+		}
+
+		// describe an alias as the model it resolves to
+		resolved, err := resolveAlias(n)
+		if err != nil {
+			slog.Warn("bad alias", "name", n, "error", err)
+			continue
+		}
+
+		m, ok := ms[resolved]
+		if !ok {
+			slog.Warn("alias refers to a missing model", "name", n, "target", target)
+			continue
+		}
+
+		lm, ok := listModel(n, m)
+		if !ok {
+			continue
+		}
+
+		if p, err := GetAliasPath(n); err == nil {
+			if fi, err := os.Stat(p); err == nil {
+				lm.ModifiedAt = fi.ModTime()
+			}
+		}
+		lm.Target = target.DisplayShortest()
+		models = append(models, lm)
 	}
 
 	slices.SortStableFunc(models, func(i, j api.ListModelResponse) int {
@@ -997,6 +1384,13 @@ func (s *Server) CopyHandler(c *gin.Context) {
 		return
 	}
 
+	// copying an alias copies the model it refers to
+	src, err = resolveAlias(src)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	dst := model.ParseName(r.Destination)
 	if !dst.IsValid() {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("destination %q is invalid", r.Destination)})
@@ -1112,6 +1506,16 @@ func allowedHost(host string) bool {
 	return false
 }
 
+// recovery responds with an internal server error to handlers that panic,
+// except for [http.ErrAbortHandler], which is raised again so that the
+// server aborts a response that has already been partly written.
+func recovery(c *gin.Context, err any) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
 func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if addr == nil {
@@ -1175,16 +1579,52 @@ func (s *Server) GenerateRoutes(logger *slog.Logger, rc *goobla.Registry) (http.
 		"x-stainless-runtime",
 		"x-stainless-runtime-version",
 		"x-stainless-timeout",
+
+		// Anthropic compatibility headers
+		"anthropic-beta",
+		"anthropic-version",
+		"x-api-key",
 	}
 	corsConfig.AllowOrigins = envconfig.AllowedOrigins()
 
-	r := gin.Default()
+	r := gin.New()
 	r.HandleMethodNotAllowed = true
 	r.Use(
+		gin.Logger(),
+		gin.CustomRecovery(recovery),
 		cors.New(corsConfig),
 		allowedHostsMiddleware(s.addr),
+		tracingMiddleware,
 	)
 
+	if envconfig.Metrics() {
+		r.Use(metricsMiddleware)
+		r.GET("/metrics", s.requireScope(""), s.MetricsHandler)
+	}
+
+	if s.batches == nil {
+		dir, err := batchesPath()
+		if err != nil {
+			return nil, err
+		}
+		if s.batches, err = loadBatches(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.prefixes == nil {
+		path, err := prefixesPath()
+		if err != nil {
+			return nil, err
+		}
+		if s.prefixes, err = loadPrefixes(path); err != nil {
+			return nil, err
+		}
+	}
+
+	inference := s.requireScope(scopeInference)
+	models := s.requireScope(scopeModels)
+
 	// General
 	r.HEAD("/", func(c *gin.Context) { c.String(http.StatusOK, "Goobla is running") })
 	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "Goobla is running") })
@@ -1192,32 +1632,65 @@ func (s *Server) GenerateRoutes(logger *slog.Logger, rc *goobla.Registry) (http.
 	r.GET("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
 
 	// Local model cache management (new implementation is at end of function)
-	r.POST("/api/pull", s.PullHandler)
-	r.POST("/api/push", s.PushHandler)
-	r.HEAD("/api/tags", s.ListHandler)
-	r.GET("/api/tags", s.ListHandler)
-	r.POST("/api/show", s.ShowHandler)
-	r.DELETE("/api/delete", s.DeleteHandler)
+	r.POST("/api/pull", models, s.PullHandler)
+	r.POST("/api/push", models, s.PushHandler)
+	r.HEAD("/api/tags", inference, s.ListHandler)
+	r.GET("/api/tags", inference, s.ListHandler)
+	r.POST("/api/show", inference, s.ShowHandler)
+	r.DELETE("/api/delete", models, s.DeleteHandler)
 
 	// Create
-	r.POST("/api/create", s.CreateHandler)
-	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
-	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
-	r.POST("/api/copy", s.CopyHandler)
+	r.POST("/api/create", models, s.CreateHandler)
+	r.POST("/api/blobs/:digest", models, s.CreateBlobHandler)
+	r.HEAD("/api/blobs/:digest", models, s.HeadBlobHandler)
+	r.POST("/api/copy", models, s.CopyHandler)
+	r.POST("/api/tag", models, s.TagHandler)
+	r.POST("/api/export", models, s.ExportHandler)
+	r.POST("/api/import", models, s.ImportHandler)
+	r.GET("/api/du", inference, s.DiskUsageHandler)
+	r.POST("/api/gc", models, s.GCHandler)
 
 	// Inference
-	r.GET("/api/ps", s.PsHandler)
-	r.POST("/api/generate", s.GenerateHandler)
-	r.POST("/api/chat", s.ChatHandler)
-	r.POST("/api/embed", s.EmbedHandler)
-	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.GET("/api/ps", inference, s.PsHandler)
+	r.POST("/api/generate", inference, s.GenerateHandler)
+	r.POST("/api/chat", inference, s.ChatHandler)
+	r.POST("/api/embed", inference, s.EmbedHandler)
+	r.POST("/api/embeddings", inference, s.EmbeddingsHandler)
+	r.POST("/api/tokenize", inference, s.TokenizeHandler)
+	r.POST("/api/detokenize", inference, s.DetokenizeHandler)
+	r.GET("/api/requests", inference, s.ListRequestsHandler)
+	r.DELETE("/api/requests/:id", inference, s.CancelRequestHandler)
+	r.POST("/api/prefix", models, s.PrefixHandler)
+	r.DELETE("/api/prefix", models, s.DeletePrefixHandler)
+
+	// Batches
+	r.POST("/api/batch", inference, s.CreateBatchHandler)
+	r.GET("/api/batch", inference, s.ListBatchesHandler)
+	r.GET("/api/batch/:id", inference, s.BatchHandler)
+	r.GET("/api/batch/:id/results", inference, s.BatchResultsHandler)
+	r.DELETE("/api/batch/:id", inference, s.CancelBatchHandler)
 
 	// Inference (OpenAI compatibility)
-	r.POST("/v1/chat/completions", openaimid.ChatMiddleware(), s.ChatHandler)
-	r.POST("/v1/completions", openaimid.CompletionsMiddleware(), s.GenerateHandler)
-	r.POST("/v1/embeddings", openaimid.EmbeddingsMiddleware(), s.EmbedHandler)
-	r.GET("/v1/models", openaimid.ListMiddleware(), s.ListHandler)
-	r.GET("/v1/models/:model", openaimid.RetrieveMiddleware(), s.ShowHandler)
+	r.POST("/v1/chat/completions", inference, openaimid.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/responses", inference, openaimid.ResponsesMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", inference, openaimid.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", inference, openaimid.EmbeddingsMiddleware(), s.EmbedHandler)
+	r.GET("/v1/models", inference, openaimid.ListMiddleware(), s.ListHandler)
+	r.GET("/v1/models/:model", inference, openaimid.RetrieveMiddleware(), s.ShowHandler)
+
+	// Batches (OpenAI compatibility)
+	r.POST("/v1/files", inference, s.OpenAICreateFileHandler)
+	r.GET("/v1/files", inference, s.OpenAIListFilesHandler)
+	r.GET("/v1/files/:id", inference, s.OpenAIFileHandler)
+	r.GET("/v1/files/:id/content", inference, s.OpenAIFileContentHandler)
+	r.DELETE("/v1/files/:id", inference, s.OpenAIDeleteFileHandler)
+	r.POST("/v1/batches", inference, s.OpenAICreateBatchHandler)
+	r.GET("/v1/batches", inference, s.OpenAIListBatchesHandler)
+	r.GET("/v1/batches/:id", inference, s.OpenAIBatchHandler)
+	r.POST("/v1/batches/:id/cancel", inference, s.OpenAICancelBatchHandler)
+
+	// Inference (Anthropic compatibility)
+	r.POST("/v1/messages", inference, anthropic.MessagesMiddleware(), s.ChatHandler)
 
 	if rc != nil {
 		// wrap old with new
@@ -1228,6 +1701,11 @@ func (s *Server) GenerateRoutes(logger *slog.Logger, rc *goobla.Registry) (http.
 
 			Prune: PruneLayers,
 		}
+
+		if s.apiKeys != nil {
+			// the registry handles these itself without going through gin
+			return s.apiKeys.handler(rs, scopeModels, "/api/pull", "/api/delete"), nil
+		}
 		return rs, nil
 	}
 
@@ -1241,6 +1719,11 @@ func Serve(ln net.Listener) error {
 	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
 	slog.Info("server config", "env", envconfig.Values())
 
+	shutdownTracing, err := tracing.Configure("goobla", envconfig.TraceEndpoint(), envconfig.TraceFile())
+	if err != nil {
+		return err
+	}
+
 	blobsDir, err := GetBlobsPath("")
 	if err != nil {
 		return err
@@ -1271,6 +1754,17 @@ func Serve(ln net.Listener) error {
 
 	s := &Server{addr: ln.Addr()}
 
+	// evict models if the quota was lowered since the last start
+	s.enforceQuota()
+
+	s.apiKeys, err = loadAPIKeys(apiKeysFile())
+	if err != nil {
+		return err
+	}
+	if s.apiKeys != nil {
+		slog.Info("API key authentication enabled", "keys", len(s.apiKeys.keys), "file", apiKeysFile())
+	}
+
 	var rc *goobla.Registry
 	if useClient2 {
 		var err error
@@ -1330,10 +1824,14 @@ func Serve(ln net.Listener) error {
 		}
 		schedDone()
 		sched.unloadAllRunners()
+		if err := shutdownTracing(ctxShutdown); err != nil {
+			slog.Warn("failed to export traces", "error", err)
+		}
 		done()
 	}()
 
 	s.sched.Run(schedCtx)
+	go s.runBatches(schedCtx)
 
 	// register the experimental webp decoder
 	// so webp images can be used in multimodal inputs
@@ -1410,6 +1908,24 @@ func streamResponse(c *gin.Context, ch chan any) {
 	})
 }
 
+// writeResponses writes the final responses of a non-streaming request. A
+// request for multiple completions returns one response for each, as
+// newline-delimited JSON.
+func writeResponses[T any](c *gin.Context, rs []T) {
+	if len(rs) == 1 {
+		c.JSON(http.StatusOK, rs[0])
+		return
+	}
+
+	ch := make(chan any, len(rs))
+	for _, r := range rs {
+		ch <- r
+	}
+	close(ch)
+
+	streamResponse(c, ch)
+}
+
 func (s *Server) PsHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
@@ -1432,6 +1948,11 @@ func (s *Server) PsHandler(c *gin.Context) {
 			Details:   modelDetails,
 			ExpiresAt: v.expiresAt,
 		}
+		if v.llama != nil {
+			for _, p := range v.llama.Pinned() {
+				mr.Prefixes = append(mr.Prefixes, pinnedPrefix(p))
+			}
+		}
 		// The scheduler waits to set expiresAt, so if a model is loading it's
 		// possible that it will be set to the unix epoch. For those cases, just
 		// calculate the time w/ the sessionDuration instead.
@@ -1448,7 +1969,19 @@ func (s *Server) PsHandler(c *gin.Context) {
 		return cmp.Compare(j.ExpiresAt.Unix(), i.ExpiresAt.Unix())
 	})
 
-	c.JSON(http.StatusOK, api.ProcessResponse{Models: models})
+	var queue []api.ProcessQueueResponse
+	for i, req := range s.sched.queue.queued() {
+		queue = append(queue, api.ProcessQueueResponse{
+			ID:       req.id,
+			Position: i + 1,
+			Model:    req.model.ShortName,
+			Client:   req.class.client,
+			Priority: req.class.priority,
+			QueuedAt: req.queuedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, api.ProcessResponse{Models: models, Queue: queue})
 }
 
 func (s *Server) ChatHandler(c *gin.Context) {
@@ -1463,6 +1996,33 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if err := checkTopLogprobs(req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkFormat(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.N < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "n must be at least 1"})
+		return
+	}
+
+	if err := checkToolChoice(req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the model is not told about tools it is not allowed to call
+	if req.ToolChoice != nil && req.ToolChoice.Type == api.ToolChoiceNone {
+		req.Tools = nil
+	}
+
+	parallelToolCalls := req.ParallelToolCalls == nil || *req.ParallelToolCalls
+
 	// expire the runner
 	if len(req.Messages) == 0 && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
 		model, err := GetModel(req.Model)
@@ -1493,6 +2053,9 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	if len(req.Tools) > 0 {
 		caps = append(caps, model.CapabilityTools)
 	}
+	if slices.ContainsFunc(req.Messages, func(m api.Message) bool { return len(m.Audios) > 0 }) {
+		caps = append(caps, model.CapabilityAudio)
+	}
 	if req.Think != nil && *req.Think {
 		caps = append(caps, model.CapabilityThinking)
 	}
@@ -1508,6 +2071,34 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	var system string
+	if req.Prefix != "" {
+		if len(req.Messages) > 0 && req.Messages[0].Role == "system" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prefix cannot be combined with a system message"})
+			return
+		}
+
+		// prefixes are kept under the model an alias refers to
+		target, err := resolveAlias(name)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ok bool
+		if system, ok = s.prefixes.get(target.String(), req.Prefix); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("prefix %q not found", req.Prefix)})
+			return
+		}
+	}
+
+	ar, err := s.startRequest(c, req.Model, req.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer s.finishRequest(ar)
+
 	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
@@ -1517,6 +2108,18 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	opts.LogitBias, err = resolveLogitBias(c.Request.Context(), r.Tokenize, opts.LogitBias)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Prefix != "" {
+		if _, err := pinPrefix(c.Request.Context(), r, m, opts, req.Prefix, system); err != nil {
+			slog.Warn("failed to pin prefix", "prefix", req.Prefix, "error", err)
+		}
+	}
+
 	checkpointLoaded := time.Now()
 
 	if len(req.Messages) == 0 {
@@ -1531,57 +2134,101 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}
 
 	msgs := append(m.Messages, req.Messages...)
-	if req.Messages[0].Role != "system" && m.System != "" {
-		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+	system = cmp.Or(system, m.System)
+	if req.Messages[0].Role != "system" && system != "" {
+		msgs = append([]api.Message{{Role: "system", Content: system}}, msgs...)
 	}
 	msgs = filterThinkTags(msgs, m)
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools, req.Think)
+	prompt, images, audios, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools, req.Think)
 	if err != nil {
 		slog.Error("chat prompt error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var thinkingState *thinking.Parser
-	openingTag, closingTag := thinking.InferTags(m.Template.Template)
-	if req.Think != nil && *req.Think && openingTag != "" && closingTag != "" {
-		thinkingState = &thinking.Parser{
-			OpeningTag: openingTag,
-			ClosingTag: closingTag,
+	// a required tool call is enforced by constraining the output to the
+	// tool calls the template's parser accepts
+	var grammar string
+	if req.ToolChoice != nil && (req.ToolChoice.Type == api.ToolChoiceRequired || req.ToolChoice.Type == api.ToolChoiceFunction) {
+		grammar, err = tools.Grammar(m.Template.Template, req.Tools, req.ToolChoice.Function.Name, parallelToolCalls)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 	}
 
-	var toolParser *tools.Parser
-	if len(req.Tools) > 0 {
-		toolParser = tools.NewParser(m.Template.Template, req.Tools)
+	openingTag, closingTag := thinking.InferTags(m.Template.Template)
+
+	// each completion is parsed and accumulated independently
+	type completion struct {
+		thinkingState *thinking.Parser
+		toolParser    *tools.Parser
+		toolCalls     int
+
+		// logprobs of tokens held back by the thinking or tool call parsers
+		// until they are attached to the next response that is sent
+		logprobs []api.Logprob
+	}
+
+	completions := make([]completion, max(req.N, 1))
+	for i := range completions {
+		if req.Think != nil && *req.Think && openingTag != "" && closingTag != "" {
+			completions[i].thinkingState = &thinking.Parser{
+				OpeningTag: openingTag,
+				ClosingTag: closingTag,
+			}
+		}
+
+		if len(req.Tools) > 0 {
+			completions[i].toolParser = tools.NewParser(m.Template.Template, req.Tools)
+		}
 	}
 
+	var firstResponse sync.Once
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
 
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:      prompt,
+			Images:      images,
+			Audios:      audios,
+			Format:      req.Format,
+			Grammar:     grammar,
+			Options:     opts,
+			Logprobs:    req.Logprobs || req.TopLogprobs > 0,
+			TopLogprobs: req.TopLogprobs,
+			N:           req.N,
 		}, func(r llm.CompletionResponse) {
+			firstResponse.Do(func() {
+				timeToFirstToken.Observe(time.Since(checkpointStart).Seconds(), m.ShortName)
+			})
+			if !r.Done {
+				ar.tokens.Add(1)
+			}
+
+			cmpl := &completions[r.Index]
 			res := api.ChatResponse{
 				Model:     req.Model,
 				CreatedAt: time.Now().UTC(),
 				Message:   api.Message{Role: "assistant", Content: r.Content},
+				Index:     r.Index,
 				Done:      r.Done,
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
 					EvalCount:          r.EvalCount,
 					EvalDuration:       r.EvalDuration,
+					DraftCount:         r.DraftCount,
+					DraftAcceptedCount: r.DraftAcceptedCount,
 				},
 			}
 
-			if thinkingState != nil {
-				thinkingContent, remainingContent := thinkingState.AddContent(res.Message.Content)
+			cmpl.logprobs = append(cmpl.logprobs, r.Logprobs...)
+
+			if cmpl.thinkingState != nil {
+				thinkingContent, remainingContent := cmpl.thinkingState.AddContent(res.Message.Content)
 				if thinkingContent == "" && remainingContent == "" && !r.Done {
 					// need to accumulate more to decide what to send
 					return
@@ -1591,13 +2238,19 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			}
 
 			if r.Done {
+				observeGeneration(m.ShortName, res.Metrics)
+				chargeTokens(c, res.EvalCount)
 				res.DoneReason = r.DoneReason.String()
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
 			}
 
 			if len(req.Tools) > 0 {
-				toolCalls, content := toolParser.Add(res.Message.Content)
+				toolCalls, content := cmpl.toolParser.Add(res.Message.Content)
+				if !parallelToolCalls {
+					toolCalls = toolCalls[:min(len(toolCalls), max(1-cmpl.toolCalls, 0))]
+				}
+				cmpl.toolCalls += len(toolCalls)
 				if len(content) > 0 {
 					res.Message.Content = content
 				} else if len(toolCalls) > 0 {
@@ -1607,13 +2260,15 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					// don't return
 				} else {
 					if r.Done {
-						res.Message.Content = toolParser.Content()
+						res.Message.Content = cmpl.toolParser.Content()
+						res.Logprobs, cmpl.logprobs = cmpl.logprobs, nil
 						ch <- res
 					}
 					return
 				}
 			}
 
+			res.Logprobs, cmpl.logprobs = cmpl.logprobs, nil
 			ch <- res
 		}); err != nil {
 			ch <- gin.H{"error": err.Error()}
@@ -1621,18 +2276,25 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}()
 
 	if req.Stream != nil && !*req.Stream {
-		var resp api.ChatResponse
-		var toolCalls []api.ToolCall
-		var sbThinking strings.Builder
-		var sbContent strings.Builder
+		type result struct {
+			resp       api.ChatResponse
+			toolCalls  []api.ToolCall
+			sbThinking strings.Builder
+			sbContent  strings.Builder
+			logprobs   []api.Logprob
+		}
+
+		results := make([]result, len(completions))
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.ChatResponse:
-				sbThinking.WriteString(t.Message.Thinking)
-				sbContent.WriteString(t.Message.Content)
-				resp = t
+				res := &results[t.Index]
+				res.sbThinking.WriteString(t.Message.Thinking)
+				res.sbContent.WriteString(t.Message.Content)
+				res.logprobs = append(res.logprobs, t.Logprobs...)
+				res.resp = t
 				if len(req.Tools) > 0 {
-					toolCalls = append(toolCalls, t.Message.ToolCalls...)
+					res.toolCalls = append(res.toolCalls, t.Message.ToolCalls...)
 				}
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -1648,20 +2310,83 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			}
 		}
 
-		resp.Message.Content = sbContent.String()
-		resp.Message.Thinking = sbThinking.String()
+		resps := make([]api.ChatResponse, len(results))
+		for i := range results {
+			resp := results[i].resp
+			resp.Message.Content = results[i].sbContent.String()
+			resp.Message.Thinking = results[i].sbThinking.String()
+			resp.Logprobs = results[i].logprobs
 
-		if len(toolCalls) > 0 {
-			resp.Message.ToolCalls = toolCalls
+			if len(results[i].toolCalls) > 0 {
+				resp.Message.ToolCalls = results[i].toolCalls
+			}
+
+			resps[i] = resp
 		}
 
-		c.JSON(http.StatusOK, resp)
+		writeResponses(c, resps)
 		return
 	}
 
 	streamResponse(c, ch)
 }
 
+// checkToolChoice validates the tool choice of a chat request against the
+// tools it provides.
+func checkToolChoice(req api.ChatRequest) error {
+	if req.ToolChoice == nil {
+		return nil
+	}
+
+	switch req.ToolChoice.Type {
+	case api.ToolChoiceRequired, api.ToolChoiceFunction:
+		if len(req.Tools) == 0 {
+			return fmt.Errorf("tool_choice %q requires tools", req.ToolChoice.Type)
+		}
+
+		if len(req.Format) > 0 {
+			return errors.New("tool_choice cannot be combined with format")
+		}
+
+		// the output is constrained to tool calls from its first token,
+		// which leaves no room for thinking
+		if req.Think != nil && *req.Think {
+			return errors.New("tool_choice cannot be combined with think")
+		}
+
+		if name := req.ToolChoice.Function.Name; name != "" && !slices.ContainsFunc(req.Tools, func(t api.Tool) bool {
+			return t.Function.Name == name
+		}) {
+			return fmt.Errorf("tool_choice function %q is not in tools", name)
+		}
+	}
+
+	return nil
+}
+
+// checkTopLogprobs validates the number of alternative tokens requested
+// alongside each generated token's log probability.
+func checkTopLogprobs(n int) error {
+	if n < 0 || n > api.MaxTopLogprobs {
+		return fmt.Errorf("top_logprobs must be between 0 and %d", api.MaxTopLogprobs)
+	}
+	return nil
+}
+
+// checkFormat compiles a format object, so that schemas that cannot be
+// enforced and invalid regexes or grammars are reported before the model is
+// loaded.
+func checkFormat(format json.RawMessage) error {
+	if len(format) == 0 || format[0] != '{' {
+		return nil
+	}
+
+	if _, _, err := grammar.FromFormat(format); err != nil {
+		return fmt.Errorf("invalid format: %w", err)
+	}
+	return nil
+}
+
 func handleScheduleError(c *gin.Context, name string, err error) {
 	switch {
 	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
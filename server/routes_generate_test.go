@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -46,8 +48,17 @@ func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error
 	return
 }
 
-func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *ggml.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
-	return func(_ discover.GpuInfoList, _ string, _ *ggml.GGML, _, _ []string, _ api.Options, _ int) (llm.LlamaServer, error) {
+func (mockRunner) Detokenize(_ context.Context, tokens []int) (string, error) {
+	fields := make([]string, len(tokens))
+	for i, t := range tokens {
+		fields[i] = strconv.Itoa(t)
+	}
+
+	return strings.Join(fields, " "), nil
+}
+
+func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *ggml.GGML, []string, []string, string, api.Options, int) (llm.LlamaServer, error) {
+	return func(_ discover.GpuInfoList, _ string, _ *ggml.GGML, _, _ []string, _ string, _ api.Options, _ int) (llm.LlamaServer, error) {
 		return mock, nil
 	}
 }
@@ -68,7 +79,7 @@ func TestGenerateChat(t *testing.T) {
 
 	s := Server{
 		sched: &Scheduler{
-			pendingReqCh:  make(chan *LlmRequest, 1),
+			queue:         newPendingQueue(1),
 			finishedReqCh: make(chan *LlmRequest, 1),
 			expiredCh:     make(chan *runnerRef, 1),
 			unloadedCh:    make(chan any, 1),
@@ -613,6 +624,178 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("final tool call mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("tool choice", func(t *testing.T) {
+		var tools []api.Tool
+		if err := json.Unmarshal([]byte(`[
+			{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object", "required": ["location"], "properties": {"location": {"type": "string"}}}}},
+			{"type": "function", "function": {"name": "get_time", "parameters": {"type": "object", "properties": {}}}}
+		]`), &tools); err != nil {
+			t.Fatal(err)
+		}
+
+		mock.CompletionFn = nil
+		mock.CompletionResponse = llm.CompletionResponse{
+			Content:    `{"name":"get_time","arguments":{}} {"name":"get_weather","arguments":{"location":"Seattle"}}`,
+			Done:       true,
+			DoneReason: llm.DoneReasonStop,
+		}
+
+		chat := func(choice string, parallel *bool) *httptest.ResponseRecorder {
+			var tc api.ToolChoice
+			if err := json.Unmarshal([]byte(choice), &tc); err != nil {
+				t.Fatal(err)
+			}
+
+			return createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:             "test-system",
+				Messages:          []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+				Tools:             tools,
+				ToolChoice:        &tc,
+				ParallelToolCalls: parallel,
+				Stream:            &stream,
+			})
+		}
+
+		t.Run("auto", func(t *testing.T) {
+			w := chat(`"auto"`, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if mock.CompletionRequest.Grammar != "" {
+				t.Errorf("expected no grammar, got %q", mock.CompletionRequest.Grammar)
+			}
+
+			if !strings.Contains(mock.CompletionRequest.Prompt, "get_weather") {
+				t.Errorf("expected tools in prompt, got %q", mock.CompletionRequest.Prompt)
+			}
+		})
+
+		t.Run("none", func(t *testing.T) {
+			w := chat(`"none"`, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if strings.Contains(mock.CompletionRequest.Prompt, "get_weather") {
+				t.Errorf("expected no tools in prompt, got %q", mock.CompletionRequest.Prompt)
+			}
+
+			var resp api.ChatResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resp.Message.ToolCalls) > 0 {
+				t.Errorf("expected no tool calls, got %v", resp.Message.ToolCalls)
+			}
+		})
+
+		t.Run("required", func(t *testing.T) {
+			w := chat(`"required"`, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			grammar := mock.CompletionRequest.Grammar
+			if !strings.Contains(grammar, `"\"get_weather\""`) || !strings.Contains(grammar, `"\"get_time\""`) {
+				t.Errorf("expected grammar for all tools, got %q", grammar)
+			}
+
+			var resp api.ChatResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resp.Message.ToolCalls) != 2 {
+				t.Errorf("expected 2 tool calls, got %v", resp.Message.ToolCalls)
+			}
+		})
+
+		t.Run("function", func(t *testing.T) {
+			parallel := false
+			w := chat(`{"type":"function","function":{"name":"get_weather"}}`, &parallel)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			grammar := mock.CompletionRequest.Grammar
+			if !strings.Contains(grammar, `"\"get_weather\""`) || strings.Contains(grammar, `"\"get_time\""`) {
+				t.Errorf("expected grammar for get_weather only, got %q", grammar)
+			}
+
+			var resp api.ChatResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			// parallel tool calls are disabled, so only the first call is returned
+			if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Name != "get_time" {
+				t.Errorf("expected a single tool call, got %v", resp.Message.ToolCalls)
+			}
+		})
+
+		t.Run("unknown function", func(t *testing.T) {
+			w := chat(`{"type":"function","function":{"name":"get_stock_price"}}`, nil)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+
+		t.Run("think", func(t *testing.T) {
+			think := true
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:      "test-system",
+				Messages:   []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+				Tools:      tools,
+				ToolChoice: &api.ToolChoice{Type: api.ToolChoiceRequired},
+				Think:      &think,
+				Stream:     &stream,
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), "cannot be combined with think") {
+				t.Errorf("unexpected error %s", w.Body.String())
+			}
+		})
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-system",
+			Messages: []api.Message{{Role: "user", Content: "Pick an even number"}},
+			Format:   json.RawMessage(`{"type":"integer","multipleOf":2}`),
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), `\"multipleOf\" is not supported`) {
+			t.Errorf("unexpected error %s", w.Body.String())
+		}
+	})
+
+	t.Run("invalid grammar", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-system",
+			Messages: []api.Message{{Role: "user", Content: "Yes or no?"}},
+			Format:   json.RawMessage(`{"type":"gbnf","grammar":"root ::= answer"}`),
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), "invalid grammar") {
+			t.Errorf("unexpected error %s", w.Body.String())
+		}
+	})
 }
 
 func TestGenerate(t *testing.T) {
@@ -631,7 +814,7 @@ func TestGenerate(t *testing.T) {
 
 	s := Server{
 		sched: &Scheduler{
-			pendingReqCh:  make(chan *LlmRequest, 1),
+			queue:         newPendingQueue(1),
 			finishedReqCh: make(chan *LlmRequest, 1),
 			expiredCh:     make(chan *runnerRef, 1),
 			unloadedCh:    make(chan any, 1),
@@ -986,4 +1169,149 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("logprobs", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			TopLogprobs: api.MaxTopLogprobs + 1,
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		logprobs := []api.Logprob{{
+			TokenLogprob: api.TokenLogprob{Token: "Hi!", Logprob: -0.5},
+			TopLogprobs:  []api.TokenLogprob{{Token: "Hi!", Logprob: -0.5}},
+		}}
+		mock.CompletionResponse.Logprobs = logprobs
+		t.Cleanup(func() { mock.CompletionResponse.Logprobs = nil })
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			TopLogprobs: 1,
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !mock.CompletionRequest.Logprobs || mock.CompletionRequest.TopLogprobs != 1 {
+			t.Errorf("expected logprobs with 1 alternative, got %v %d", mock.CompletionRequest.Logprobs, mock.CompletionRequest.TopLogprobs)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Logprobs, logprobs); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("logit bias", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			Options: map[string]any{
+				"logit_bias": map[string]any{"7": 1, "hello world": -100},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Options.LogitBias, map[string]float32{"7": 1, "0": -100, "1": -100}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("multiple completions", func(t *testing.T) {
+		mock.CompletionFn = func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			if r.N != 2 {
+				t.Errorf("expected n 2, got %d", r.N)
+			}
+
+			fn(llm.CompletionResponse{Index: 1, Content: "Hello"})
+			fn(llm.CompletionResponse{Index: 0, Content: "Hi"})
+			fn(llm.CompletionResponse{Index: 1, Content: " there", Done: true, DoneReason: llm.DoneReasonStop})
+			fn(llm.CompletionResponse{Index: 0, Content: "!", Done: true, DoneReason: llm.DoneReasonStop})
+			return nil
+		}
+		t.Cleanup(func() { mock.CompletionFn = nil })
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			N:      -1,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			N:      2,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var got []string
+		dec := json.NewDecoder(w.Body)
+		for i := 0; dec.More(); i++ {
+			var resp api.GenerateResponse
+			if err := dec.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Index != i || !resp.Done {
+				t.Errorf("expected done response %d, got index %d done %v", i, resp.Index, resp.Done)
+			}
+
+			got = append(got, resp.Response)
+		}
+
+		if diff := cmp.Diff(got, []string{"Hi!", "Hello there"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("metrics model name", func(t *testing.T) {
+		before := generatedTokens.Value("test:latest")
+		for _, name := range []string{"test", "TEST:latest"} {
+			w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+				Model:  name,
+				Prompt: "Hello!",
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+		}
+
+		// metrics are labelled with the name of the model, not the name
+		// the request used
+		if got := generatedTokens.Value("test:latest"); got <= before {
+			t.Errorf("expected generated tokens of test:latest to increase from %v, got %v", before, got)
+		}
+		for _, name := range []string{"test", "TEST:latest"} {
+			if got := generatedTokens.Value(name); got != 0 {
+				t.Errorf("expected no generated tokens labelled %q, got %v", name, got)
+			}
+		}
+	})
 }
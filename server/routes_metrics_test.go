@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/api"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{
+		sched: &Scheduler{
+			queue: newPendingQueue(4),
+			loaded: map[string]*runnerRef{
+				"/models/a": {model: &Model{ShortName: "a:latest"}, estimatedVRAM: 1024},
+			},
+		},
+	}
+	if err := s.sched.queue.push(&LlmRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("GOOBLA_METRICS", "")
+		router, err := s.GenerateRoutes(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		t.Setenv("GOOBLA_METRICS", "1")
+		router, err := s.GenerateRoutes(slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("unexpected content type %q", ct)
+		}
+
+		body := w.Body.String()
+		for _, want := range []string{
+			"goobla_scheduler_queue_depth 1\n",
+			"goobla_runners_loaded 1\n",
+			`goobla_runner_vram_bytes{model="a:latest"} 1024` + "\n",
+			`goobla_http_request_duration_seconds_count{method="GET",path="/api/version",code="200"} 1` + "\n",
+			"# TYPE goobla_pull_bytes_total counter\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+			}
+		}
+	})
+}
+
+func TestMetricsRejectedRequests(t *testing.T) {
+	s := &Scheduler{queue: newPendingQueue(0)}
+
+	before := queueRejected.Value()
+	_, errCh := s.GetRunner(t.Context(), &Model{}, api.DefaultOptions(), nil)
+	if err := <-errCh; !errors.Is(err, ErrMaxQueue) {
+		t.Fatalf("expected ErrMaxQueue, got %v", err)
+	}
+
+	if got := queueRejected.Value(); got != before+1 {
+		t.Errorf("expected %v rejected requests, got %v", before+1, got)
+	}
+}
@@ -85,12 +85,6 @@ func createTestFile(t *testing.T, name string) (string, string) {
 	return f.Name(), digest
 }
 
-func blobPath(dir, digest string) string {
-	digest = strings.ReplaceAll(digest, "sha256:", "sha256-")
-	hex := strings.TrimPrefix(digest, "sha256-")
-	return filepath.Join(dir, hex[:2], digest)
-}
-
 // equalStringSlices checks if two slices of strings are equal.
 func equalStringSlices(a, b []string) bool {
 	if len(a) != len(b) {
@@ -21,6 +21,7 @@ import (
 	"github.com/goobla/goobla/format"
 	"github.com/goobla/goobla/fs/ggml"
 	"github.com/goobla/goobla/llm"
+	"github.com/goobla/goobla/tracing"
 	"github.com/goobla/goobla/types/model"
 )
 
@@ -33,10 +34,22 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+
+	// queueSpan measures the time until the scheduler picks up the request
+	queueSpan *tracing.Span
+
+	// id identifies the request in /api/requests and /api/ps
+	id string
+
+	// class, finish and seq order the request in the pending queue
+	class    queueClass
+	finish   float64
+	seq      uint64
+	queuedAt time.Time
 }
 
 type Scheduler struct {
-	pendingReqCh  chan *LlmRequest
+	queue         *pendingQueue
 	finishedReqCh chan *LlmRequest
 	expiredCh     chan *runnerRef
 	unloadedCh    chan any
@@ -45,7 +58,7 @@ type Scheduler struct {
 	loadedMu sync.Mutex
 
 	loadFn       func(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList, numParallel int)
-	newServerFn  func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
+	newServerFn  func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error)
 	getGpuFn     func() discover.GpuInfoList
 	getCpuFn     func() discover.GpuInfoList
 	reschedDelay time.Duration
@@ -66,7 +79,7 @@ var ErrMaxQueue = errors.New("server busy, please try again.  maximum pending re
 func InitScheduler(ctx context.Context) *Scheduler {
 	maxQueue := envconfig.MaxQueue()
 	sched := &Scheduler{
-		pendingReqCh:  make(chan *LlmRequest, maxQueue),
+		queue:         newPendingQueue(int(maxQueue)),
 		finishedReqCh: make(chan *LlmRequest, maxQueue),
 		expiredCh:     make(chan *runnerRef, maxQueue),
 		unloadedCh:    make(chan any, maxQueue),
@@ -93,12 +106,20 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		sessionDuration: sessionDuration,
 		successCh:       make(chan *runnerRef),
 		errCh:           make(chan error, 1),
+		class:           queueClassFromContext(c),
+	}
+	if ar := activeRequestFromContext(c); ar != nil {
+		req.id = ar.id
 	}
 
-	select {
-	case s.pendingReqCh <- req:
-	default:
-		req.errCh <- ErrMaxQueue
+	_, req.queueSpan = tracing.Start(c, "scheduler.queue")
+	req.queueSpan.SetAttributes("model", model.ShortName, "client", req.class.client, "priority", string(req.class.priority))
+
+	if err := s.queue.push(req); err != nil {
+		queueRejected.Inc()
+		req.queueSpan.SetError(err)
+		req.queueSpan.End()
+		req.errCh <- err
 	}
 	return req.successCh, req.errCh
 }
@@ -121,7 +142,13 @@ func (s *Scheduler) processPending(ctx context.Context) {
 		case <-ctx.Done():
 			slog.Debug("shutting down scheduler pending loop")
 			return
-		case pending := <-s.pendingReqCh:
+		case <-s.queue.ready:
+			pending := s.queue.pop()
+			if pending == nil {
+				continue
+			}
+			pending.queueSpan.End()
+
 			// Block other requests until we get this pending request running
 			pending.schedAttempts++
 			if pending.origNumCtx == 0 {
@@ -269,7 +296,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 								// the scheduler if our queue is full
 								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
 								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
+								s.queue.requeue(pending)
 							}()
 							break
 						}
@@ -428,7 +455,11 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 	if pending.sessionDuration != nil {
 		runner.sessionDuration = pending.sessionDuration.Duration
 	}
-	pending.successCh <- runner
+	select {
+	case pending.successCh <- runner:
+	case <-pending.ctx.Done():
+		// the requester is gone, release the runner below
+	}
 	go func() {
 		<-pending.ctx.Done()
 		slog.Debug("context for request finished", "runner", runner)
@@ -444,7 +475,11 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
-	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
+	_, span := tracing.Start(req.ctx, "scheduler.load")
+	span.SetAttributes("model", req.model.ShortName, "num_parallel", numParallel, "num_ctx", req.opts.NumCtx)
+
+	loadStart := time.Now()
+	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
@@ -453,6 +488,8 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 			err = fmt.Errorf("%v: this model may be incompatible with your version of Goobla. If you previously pulled this model, try updating it by running `goobla pull %s`", err, req.model.ShortName)
 		}
 		slog.Info("NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
+		span.SetError(err)
+		span.End()
 		req.errCh <- err
 		return
 	}
@@ -486,6 +523,8 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	go func() {
 		defer runner.refMu.Unlock()
 		if err = llama.WaitUntilRunning(req.ctx); err != nil {
+			span.SetError(err)
+			span.End()
 			slog.Error("error loading llama server", "error", err)
 			req.errCh <- err
 			slog.Debug("triggering expiration for failed load", "runner", runner)
@@ -493,6 +532,9 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 			return
 		}
 		slog.Debug("finished setting up", "runner", runner)
+		runnerLoadDuration.Observe(time.Since(loadStart).Seconds(), req.model.ShortName)
+		span.SetAttributes("vram", runner.estimatedVRAM)
+		span.End()
 		if runner.pid < 0 {
 			runner.pid = llama.Pid()
 		}
@@ -503,7 +545,10 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 			slog.Debug("context for request finished")
 			s.finishedReqCh <- req
 		}()
-		req.successCh <- runner
+		select {
+		case req.successCh <- runner:
+		case <-req.ctx.Done():
+		}
 	}()
 }
 
@@ -813,7 +858,7 @@ func (s *Scheduler) pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus
 			req.opts.NumCtx = req.origNumCtx * p
 			if !envconfig.SchedSpread() {
 				for _, g := range sgl {
-					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, p); ok {
+					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts, p); ok {
 						slog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
 						*numParallel = p
 						return []discover.GpuInfo{g}
@@ -829,7 +874,7 @@ func (s *Scheduler) pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus
 		// Now try all the GPUs
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
-			if ok, estimatedVRAM = llm.PredictServerFit(sgl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, p); ok {
+			if ok, estimatedVRAM = llm.PredictServerFit(sgl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts, p); ok {
 				slog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
 				*numParallel = p
 				return sgl
@@ -852,7 +897,7 @@ func pickBestPartialFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.Gp
 	var bestEstimate uint64
 	var bestFit int
 	for i, gl := range byLibrary {
-		_, estimatedVRAM := llm.PredictServerFit(gl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, *numParallel)
+		_, estimatedVRAM := llm.PredictServerFit(gl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts, *numParallel)
 		if estimatedVRAM > bestEstimate {
 			bestEstimate = estimatedVRAM
 			bestFit = i
@@ -927,7 +972,7 @@ func (s *Scheduler) expireRunner(model *Model) {
 // If not, pick a runner to unload, else return nil and the request can be loaded
 func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList) *runnerRef {
 	slog.Debug("evaluating if CPU model load will fit in available system memory")
-	estimate := llm.EstimateGPULayers(gpus, f, req.model.ProjectorPaths, req.opts, req.opts.NumCtx/req.origNumCtx)
+	estimate := llm.EstimateGPULayers(gpus, f, req.model.ProjectorPaths, req.model.DraftPath, req.opts, req.opts.NumCtx/req.origNumCtx)
 	if estimate.TotalSize <= gpus[0].FreeMemory {
 		slog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
 		return nil
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goobla/goobla/api"
+)
+
+// priorityWeights are the relative shares of scheduling capacity given to
+// clients queueing requests at each priority.
+var priorityWeights = map[api.Priority]float64{
+	api.PriorityHigh:   16,
+	api.PriorityNormal: 4,
+	api.PriorityLow:    1,
+}
+
+// queueClass identifies the flow a pending request is queued in. Each flow
+// gets a share of the scheduler proportional to the weight of its priority,
+// so a client flooding the queue only delays its own requests.
+type queueClass struct {
+	client   string
+	priority api.Priority
+}
+
+func (c queueClass) weight() float64 {
+	return priorityWeights[c.priority]
+}
+
+func queueClassFromContext(ctx context.Context) queueClass {
+	if ar := activeRequestFromContext(ctx); ar != nil {
+		return ar.class
+	}
+	return queueClass{priority: api.PriorityNormal}
+}
+
+// pendingQueue holds requests waiting for the scheduler. Requests are
+// dequeued in order of virtual finish time (start-time fair queueing):
+// each flow advances its own clock by the inverse of its weight per
+// request, so flows share the scheduler by weight and requests within a
+// flow stay in order.
+type pendingQueue struct {
+	mu   sync.Mutex
+	max  int
+	reqs []*LlmRequest
+
+	// vtime is the finish time of the last dequeued request
+	vtime float64
+	// flows is the finish time of the last request queued in each flow
+	flows map[queueClass]float64
+	seq   uint64
+
+	// ready is signaled when the queue is not empty
+	ready chan struct{}
+}
+
+func newPendingQueue(maxQueue int) *pendingQueue {
+	return &pendingQueue{
+		max:   maxQueue,
+		flows: make(map[queueClass]float64),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push queues req, or returns [ErrMaxQueue] if the queue is full.
+func (q *pendingQueue) push(req *LlmRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.reqs) >= q.max {
+		return ErrMaxQueue
+	}
+
+	req.queuedAt = time.Now()
+	q.add(req)
+	return nil
+}
+
+// requeue puts req back in the queue behind requests already waiting in the
+// same flow. It does not check the queue length since req was already
+// accepted.
+func (q *pendingQueue) requeue(req *LlmRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add(req)
+}
+
+// add must be called with q.mu held.
+func (q *pendingQueue) add(req *LlmRequest) {
+	start := max(q.vtime, q.flows[req.class])
+	req.finish = start + 1/req.class.weight()
+	q.flows[req.class] = req.finish
+
+	q.seq++
+	req.seq = q.seq
+
+	q.reqs = append(q.reqs, req)
+	q.signal()
+}
+
+func (q *pendingQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func compareQueued(a, b *LlmRequest) int {
+	return cmp.Or(cmp.Compare(a.finish, b.finish), cmp.Compare(a.seq, b.seq))
+}
+
+// pop removes and returns the next request to schedule, or nil if the queue
+// is empty.
+func (q *pendingQueue) pop() *LlmRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.reqs) == 0 {
+		return nil
+	}
+
+	i := 0
+	for j := range q.reqs {
+		if compareQueued(q.reqs[j], q.reqs[i]) < 0 {
+			i = j
+		}
+	}
+
+	req := q.reqs[i]
+	q.reqs = slices.Delete(q.reqs, i, i+1)
+	q.vtime = req.finish
+
+	// idle flows restart from vtime anyway
+	for class, finish := range q.flows {
+		if finish <= q.vtime {
+			delete(q.flows, class)
+		}
+	}
+
+	if len(q.reqs) > 0 {
+		q.signal()
+	}
+	return req
+}
+
+func (q *pendingQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.reqs)
+}
+
+// queued returns the requests that are still waiting, in the order they
+// would be scheduled if no other requests arrive.
+func (q *pendingQueue) queued() []*LlmRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reqs := slices.DeleteFunc(slices.Clone(q.reqs), func(req *LlmRequest) bool {
+		return req.ctx != nil && req.ctx.Err() != nil
+	})
+	slices.SortFunc(reqs, compareQueued)
+	return reqs
+}
@@ -48,7 +48,7 @@ func TestLoad(t *testing.T) {
 		sessionDuration: &api.Duration{Duration: 2 * time.Second},
 	}
 	// Fail to load model first
-	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 		return nil, errors.New("something failed to load model blah")
 	}
 	gpus := discover.GpuInfoList{}
@@ -62,7 +62,7 @@ func TestLoad(t *testing.T) {
 	require.Contains(t, err.Error(), "this model may be incompatible")
 
 	server := &mockLlm{estimatedVRAM: 10, estimatedVRAMByGPU: map[string]uint64{}}
-	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 		return server, nil
 	}
 	s.load(req, f, gpus, 0)
@@ -103,7 +103,7 @@ type reqBundle struct {
 	f       *ggml.GGML
 }
 
-func (scenario *reqBundle) newServer(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+func (scenario *reqBundle) newServer(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 	return scenario.srv, nil
 }
 
@@ -175,13 +175,13 @@ func TestRequestsSameModelSameRequest(t *testing.T) {
 
 	s.newServerFn = a.newServer
 	slog.Info("a")
-	s.pendingReqCh <- a.req
-	require.Len(t, s.pendingReqCh, 1)
+	require.NoError(t, s.queue.push(a.req))
+	require.Equal(t, 1, s.queue.len())
 	s.Run(ctx)
 	select {
 	case resp := <-a.req.successCh:
 		require.Equal(t, resp.llama, a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, a.req.errCh)
 	case err := <-a.req.errCh:
 		t.Fatal(err.Error())
@@ -192,11 +192,11 @@ func TestRequestsSameModelSameRequest(t *testing.T) {
 	// Same runner as first request due to not needing a reload
 	s.newServerFn = b.newServer
 	slog.Info("b")
-	s.pendingReqCh <- b.req
+	require.NoError(t, s.queue.push(b.req))
 	select {
 	case resp := <-b.req.successCh:
 		require.Equal(t, resp.llama, a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, b.req.errCh)
 	case err := <-b.req.errCh:
 		t.Fatal(err.Error())
@@ -219,13 +219,13 @@ func TestRequestsSimpleReloadSameModel(t *testing.T) {
 
 	s.newServerFn = a.newServer
 	slog.Info("a")
-	s.pendingReqCh <- a.req
-	require.Len(t, s.pendingReqCh, 1)
+	require.NoError(t, s.queue.push(a.req))
+	require.Equal(t, 1, s.queue.len())
 	s.Run(ctx)
 	select {
 	case resp := <-a.req.successCh:
 		require.Equal(t, resp.llama, a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, a.req.errCh)
 	case err := <-a.req.errCh:
 		t.Fatal(err.Error())
@@ -237,14 +237,14 @@ func TestRequestsSimpleReloadSameModel(t *testing.T) {
 	s.newServerFn = b.newServer
 	b.req.model.AdapterPaths = []string{"new"}
 	slog.Info("b")
-	s.pendingReqCh <- b.req
+	require.NoError(t, s.queue.push(b.req))
 	// finish first two requests, so model can reload
 	time.Sleep(1 * time.Millisecond)
 	a.ctxDone()
 	select {
 	case resp := <-b.req.successCh:
 		require.Equal(t, resp.llama, b.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, b.req.errCh)
 	case err := <-b.req.errCh:
 		t.Fatal(err.Error())
@@ -270,12 +270,12 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	t.Setenv("GOOBLA_MAX_LOADED_MODELS", "1")
 	s.newServerFn = a.newServer
 	slog.Info("a")
-	s.pendingReqCh <- a.req
+	require.NoError(t, s.queue.push(a.req))
 	s.Run(ctx)
 	select {
 	case resp := <-a.req.successCh:
 		require.Equal(t, resp.llama, a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, a.req.errCh)
 	case err := <-a.req.errCh:
 		t.Fatal(err.Error())
@@ -289,11 +289,11 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	t.Setenv("GOOBLA_MAX_LOADED_MODELS", "0")
 	s.newServerFn = b.newServer
 	slog.Info("b")
-	s.pendingReqCh <- b.req
+	require.NoError(t, s.queue.push(b.req))
 	select {
 	case resp := <-b.req.successCh:
 		require.Equal(t, resp.llama, b.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, b.req.errCh)
 	case err := <-b.req.errCh:
 		t.Fatal(err.Error())
@@ -307,11 +307,11 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	// This is a CPU load with NumGPU = 0 so it should load
 	s.newServerFn = c.newServer
 	slog.Info("c")
-	s.pendingReqCh <- c.req
+	require.NoError(t, s.queue.push(c.req))
 	select {
 	case resp := <-c.req.successCh:
 		require.Equal(t, resp.llama, c.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, c.req.errCh)
 	case err := <-c.req.errCh:
 		t.Fatal(err.Error())
@@ -330,7 +330,7 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	s.loadedMu.Unlock()
 	a.ctxDone() // Won't help since this one isn't big enough to make room
 	time.Sleep(2 * time.Millisecond)
-	s.pendingReqCh <- d.req
+	require.NoError(t, s.queue.push(d.req))
 	// finish prior request, so new model can load
 	time.Sleep(6 * time.Millisecond)
 	s.loadedMu.Lock()
@@ -340,7 +340,7 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	select {
 	case resp := <-d.req.successCh:
 		require.Equal(t, resp.llama, d.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, d.req.errCh)
 	case <-ctx.Done():
 		t.Fatal("timeout")
@@ -364,10 +364,10 @@ func TestGetRunner(t *testing.T) {
 	s.newServerFn = a.newServer
 	slog.Info("a")
 	successCh1a, errCh1a := s.GetRunner(a.ctx, a.req.model, a.req.opts, a.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 1)
+	require.Equal(t, 1, s.queue.len())
 	slog.Info("b")
 	successCh1b, errCh1b := s.GetRunner(b.ctx, b.req.model, b.req.opts, b.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 1)
+	require.Equal(t, 1, s.queue.len())
 	require.Empty(t, successCh1b)
 	require.Len(t, errCh1b, 1)
 	err := <-errCh1b
@@ -376,7 +376,7 @@ func TestGetRunner(t *testing.T) {
 	select {
 	case resp := <-successCh1a:
 		require.Equal(t, resp.llama, a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, errCh1a)
 	case err := <-errCh1a:
 		t.Fatal(err.Error())
@@ -403,6 +403,102 @@ func TestGetRunner(t *testing.T) {
 	b.ctxDone()
 }
 
+func TestPendingQueue(t *testing.T) {
+	q := newPendingQueue(8)
+
+	push := func(client string, priority api.Priority, n int) {
+		t.Helper()
+		for range n {
+			require.NoError(t, q.push(&LlmRequest{class: queueClass{client, priority}}))
+		}
+	}
+
+	popClients := func() []string {
+		var clients []string
+		for req := q.pop(); req != nil; req = q.pop() {
+			clients = append(clients, req.class.client)
+		}
+		return clients
+	}
+
+	// clients at the same priority take turns
+	push("bulk", api.PriorityNormal, 3)
+	push("other", api.PriorityNormal, 2)
+	require.Equal(t, []string{"bulk", "other", "bulk", "other", "bulk"}, popClients())
+
+	// higher priorities get a larger share
+	push("bulk", api.PriorityLow, 3)
+	push("chat", api.PriorityHigh, 3)
+	require.Equal(t, []string{"chat", "chat", "chat", "bulk", "bulk", "bulk"}, popClients())
+
+	push("bulk", api.PriorityLow, 8)
+	require.ErrorIs(t, q.push(&LlmRequest{}), ErrMaxQueue)
+
+	// requeued requests are allowed past the limit
+	q.requeue(&LlmRequest{class: queueClass{"chat", api.PriorityHigh}})
+	require.Equal(t, 9, q.len())
+	require.Equal(t, "chat", q.queued()[0].class.client)
+}
+
+func TestRequestsInteractiveOvertakeBulk(t *testing.T) {
+	ctx, done := context.WithTimeout(t.Context(), 500*time.Millisecond)
+	defer done()
+
+	a := newScenarioRequest(t, ctx, "goobla-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	s.newServerFn = a.newServer
+	s.Run(ctx)
+
+	bulkCtx := context.WithValue(ctx, activeRequestKey{}, &activeRequest{class: queueClass{"batch-job", api.PriorityLow}})
+	chatCtx := context.WithValue(ctx, activeRequestKey{}, &activeRequest{class: queueClass{"chat-app", api.PriorityHigh}})
+
+	getRunner := func(ctx context.Context) chan *runnerRef {
+		successCh, errCh := s.GetRunner(ctx, a.req.model, a.req.opts, a.req.sessionDuration)
+		require.Empty(t, errCh)
+		return successCh
+	}
+
+	// load the model
+	select {
+	case resp := <-getRunner(bulkCtx):
+		require.Equal(t, resp.llama, a.srv)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// the scheduler blocks handing the runner to a request until it is
+	// received, so bulk requests pile up behind the first one
+	first := getRunner(bulkCtx)
+	require.Eventually(t, func() bool { return s.queue.len() == 0 }, time.Second, time.Millisecond)
+
+	order := map[chan *runnerRef]string{first: "bulk"}
+	for range 4 {
+		order[getRunner(bulkCtx)] = "bulk"
+	}
+	order[getRunner(chatCtx)] = "chat"
+
+	queued := s.queue.queued()
+	require.Len(t, queued, 5)
+	require.Equal(t, "chat-app", queued[0].class.client)
+
+	var got []string
+	for len(order) > 0 {
+		for ch, name := range order {
+			select {
+			case <-ch:
+				got = append(got, name)
+				delete(order, ch)
+			case <-ctx.Done():
+				t.Fatal("timeout")
+			default:
+			}
+		}
+	}
+	require.Equal(t, []string{"bulk", "chat", "bulk", "bulk", "bulk", "bulk"}, got)
+}
+
 func TestExpireRunner(t *testing.T) {
 	ctx, done := context.WithTimeout(t.Context(), 20*time.Millisecond)
 	defer done()
@@ -419,7 +515,7 @@ func TestExpireRunner(t *testing.T) {
 	var f *ggml.GGML
 	gpus := discover.GpuInfoList{}
 	server := &mockLlm{estimatedVRAM: 10, estimatedVRAMByGPU: map[string]uint64{}}
-	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 		return server, nil
 	}
 	s.load(req, f, gpus, 0)
@@ -465,7 +561,7 @@ func TestBogusFinishedEvent(t *testing.T) {
 
 	successCh1, errCh1 := s.GetRunner(scenario1.ctx, scenario1.req.model, scenario1.req.opts, scenario1.req.sessionDuration)
 	successCh2, errCh2 := s.GetRunner(scenario2.ctx, scenario2.req.model, scenario2.req.opts, scenario2.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 2)
+	require.Equal(t, 2, s.queue.len())
 
 	s.Run(ctx)
 
@@ -527,12 +623,12 @@ func TestPrematureExpired(t *testing.T) {
 	}
 	s.newServerFn = scenario1a.newServer
 	successCh1a, errCh1a := s.GetRunner(scenario1a.ctx, scenario1a.req.model, scenario1a.req.opts, scenario1a.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 1)
+	require.Equal(t, 1, s.queue.len())
 	s.Run(ctx)
 	select {
 	case resp := <-successCh1a:
 		require.Equal(t, resp.llama, scenario1a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, errCh1a)
 		s.loadedMu.Lock()
 		require.Len(t, s.loaded, 1)
@@ -760,11 +856,11 @@ func TestAlreadyCanceled(t *testing.T) {
 	scenario1a := newScenarioRequest(t, dctx, "goobla-model-1", 10, &api.Duration{Duration: 0})
 	s := InitScheduler(ctx)
 	slog.Info("scenario1a")
-	s.pendingReqCh <- scenario1a.req
-	require.Len(t, s.pendingReqCh, 1)
+	require.NoError(t, s.queue.push(scenario1a.req))
+	require.Equal(t, 1, s.queue.len())
 	s.Run(ctx)
 	time.Sleep(5 * time.Millisecond)
-	require.Empty(t, s.pendingReqCh)
+	require.Zero(t, s.queue.len())
 	require.Empty(t, scenario1a.req.errCh)
 	require.Empty(t, scenario1a.req.successCh)
 }
@@ -788,18 +884,18 @@ func TestHomogeneousGPUs(t *testing.T) {
 	}
 	s.getCpuFn = getCpuFn
 	a := newScenarioRequest(t, ctx, "goobla-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
-	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 		require.Len(t, gpus, 1)
-		return a.newServer(gpus, model, f, adapters, projectors, opts, numParallel)
+		return a.newServer(gpus, model, f, adapters, projectors, draft, opts, numParallel)
 	}
 	slog.Info("a")
-	s.pendingReqCh <- a.req
-	require.Len(t, s.pendingReqCh, 1)
+	require.NoError(t, s.queue.push(a.req))
+	require.Equal(t, 1, s.queue.len())
 	s.Run(ctx)
 	select {
 	case resp := <-a.req.successCh:
 		require.Equal(t, resp.llama, a.srv)
-		require.Empty(t, s.pendingReqCh)
+		require.Zero(t, s.queue.len())
 		require.Empty(t, a.req.errCh)
 	case err := <-a.req.errCh:
 		t.Fatal(err.Error())
@@ -843,6 +939,11 @@ func (s *mockLlm) Detokenize(ctx context.Context, tokens []int) (string, error)
 	return s.detokenizeResp, s.detonekizeRespErr
 }
 
+func (s *mockLlm) Pin(ctx context.Context, name, prompt string) (llm.PinnedPrefix, error) {
+	return llm.PinnedPrefix{}, errors.New("not implemented")
+}
+func (s *mockLlm) Unpin(ctx context.Context, name string) error { return nil }
+func (s *mockLlm) Pinned() []llm.PinnedPrefix                   { return nil }
 func (s *mockLlm) Close() error {
 	s.closeCalled = true
 	return s.closeResp
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/auth"
+	"github.com/goobla/goobla/envconfig"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/client/goobla"
+)
+
+// Signatures of manifests are kept in the signatures directory of the models
+// directory, in a file named after the digest of the manifest they sign. See
+// [goobla.SignatureTag] for how they are stored in registries.
+
+// GetSignaturePath returns the path of the signature of the manifest with
+// digest.
+func GetSignaturePath(digest string) (string, error) {
+	d, err := blob.ParseDigest(digest)
+	if err != nil {
+		return "", ErrInvalidDigestFormat
+	}
+
+	dir, err := envconfig.Models()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "signatures", strings.Replace(d.String(), ":", "-", 1)), nil
+}
+
+// readModelSignature returns the signature of the manifest with digest, or
+// an empty string if it is not signed.
+func readModelSignature(digest string) (string, error) {
+	p, err := GetSignaturePath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(sig)), nil
+}
+
+func writeModelSignature(digest, sig string) error {
+	p, err := GetSignaturePath(digest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte(sig), 0o644)
+}
+
+// checkModelSignature verifies sig, the signature of the manifest with
+// digest, against the trust store, refusing unsigned models and models
+// signed by untrusted keys if GOOBLA_REQUIRE_SIGNATURES is set.
+func checkModelSignature(digest, sig string) error {
+	d, err := blob.ParseDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	keys, err := goobla.LoadTrustedKeys(goobla.TrustedKeysFile())
+	if err != nil {
+		return err
+	}
+
+	_, err = goobla.CheckSignature(d, sig, keys, envconfig.RequireSignatures())
+	return err
+}
+
+// isSignatureError reports whether err is the refusal of a model because of
+// its signature.
+func isSignatureError(err error) bool {
+	return errors.Is(err, goobla.ErrUnsigned) ||
+		errors.Is(err, goobla.ErrUntrustedSigner) ||
+		errors.Is(err, goobla.ErrInvalidSignature)
+}
+
+// modelSigner describes the key that signed the manifest with digest, or
+// returns nil if it is not signed.
+func modelSigner(digest string) (*api.Signer, error) {
+	sig, err := readModelSignature(digest)
+	if err != nil || sig == "" {
+		return nil, err
+	}
+
+	d, err := blob.ParseDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := goobla.VerifySignature(d, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &api.Signer{Fingerprint: ssh.FingerprintSHA256(key)}
+
+	keys, err := goobla.LoadTrustedKeys(goobla.TrustedKeysFile())
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.Key.Marshal(), key.Marshal()) {
+			signer.Name = k.Comment
+			signer.Trusted = true
+			break
+		}
+	}
+
+	return signer, nil
+}
+
+// pullModelSignature returns the signature of the manifest with digest in the
+// repository of mp, or an empty string if it is not signed.
+func pullModelSignature(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions) (string, error) {
+	d, err := blob.ParseDigest(digest)
+	if err != nil {
+		return "", err
+	}
+
+	smp := mp
+	smp.Tag = goobla.SignatureTag(d)
+
+	m, _, err := pullModelManifest(ctx, smp, regOpts)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	// registries that answer for any tag may return a manifest without a
+	// signature, which is no different from none at all
+	i := slices.IndexFunc(m.Layers, func(l Layer) bool { return l.MediaType == goobla.MediaTypeSignature })
+	if i < 0 {
+		return "", nil
+	}
+	layer := m.Layers[i]
+
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", layer.Digest)
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, regOpts)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return "", err
+	}
+
+	if digest, _ := GetSHA256Digest(bytes.NewReader(sig)); digest != layer.Digest {
+		return "", fmt.Errorf("%w: signature does not match its digest", goobla.ErrInvalidSignature)
+	}
+	return string(sig), nil
+}
+
+// PushModelSignature signs the manifest of the model name with the private
+// key of this instance, and pushes the signature to the registry of name.
+func PushModelSignature(ctx context.Context, name string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	mp := ParseModelPath(name)
+
+	_, digest, err := GetManifest(mp)
+	if err != nil {
+		return err
+	}
+	digest = "sha256:" + digest
+
+	fn(api.ProgressResponse{Status: "signing manifest"})
+	sig, err := auth.Sign(ctx, []byte(digest))
+	if err != nil {
+		return err
+	}
+
+	// the signature is pushed as a layer, which is uploaded from the blobs
+	// directory
+	sigDigest, size := GetSHA256Digest(strings.NewReader(sig))
+	p, err := GetBlobsPath(sigDigest)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, []byte(sig), 0o644); err != nil {
+		return err
+	}
+
+	layer := Layer{MediaType: goobla.MediaTypeSignature, Digest: sigDigest, Size: size}
+	if err := uploadBlob(ctx, mp, layer, regOpts, fn); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		Layers:        []Layer{layer},
+	})
+	if err != nil {
+		return err
+	}
+
+	d, err := blob.ParseDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "pushing signature"})
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", goobla.SignatureTag(d))
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(data), regOpts)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if err := writeModelSignature(digest, sig); err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "success"})
+
+	return nil
+}
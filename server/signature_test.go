@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/server/internal/cache/blob"
+	"github.com/goobla/goobla/server/internal/client/goobla"
+	"github.com/goobla/goobla/server/internal/registry"
+)
+
+func TestPushPullSignedModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keyPath := writeTestKey(t)
+	t.Setenv("GOOBLA_PRIVATE_KEY", keyPath)
+	t.Setenv("GOOBLA_CONFIG_DIR", t.TempDir())
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(&registry.Server{Cache: c, Logger: slog.Default(), Keys: []ssh.PublicKey{key.PublicKey()}})
+	defer ts.Close()
+
+	signed := ts.Listener.Addr().String() + "/library/signed:latest"
+	unsigned := ts.Listener.Addr().String() + "/library/unsigned:latest"
+	progress := func(api.ProgressResponse) {}
+	opts := &registryOptions{Insecure: true}
+
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	want := createTestModel(t, signed)
+	createTestModel(t, unsigned)
+
+	if err := PushModel(t.Context(), signed, opts, progress); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if err := PushModelSignature(t.Context(), signed, opts, progress); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := PushModel(t.Context(), unsigned, opts, progress); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	signer, err := modelSigner("sha256:" + want.digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer == nil || signer.Trusted || signer.Fingerprint != ssh.FingerprintSHA256(key.PublicKey()) {
+		t.Errorf("signer = %+v, want an untrusted signer with the pushing key", signer)
+	}
+
+	// pull into another models directory, as another instance would
+	t.Setenv("GOOBLA_MODELS", t.TempDir())
+	t.Setenv("GOOBLA_REQUIRE_SIGNATURES", "1")
+
+	if err := PullModel(t.Context(), unsigned, opts, progress); !errors.Is(err, goobla.ErrUnsigned) {
+		t.Errorf("pull of unsigned model: err = %v, want %v", err, goobla.ErrUnsigned)
+	}
+
+	if err := PullModel(t.Context(), signed, opts, progress); !errors.Is(err, goobla.ErrUntrustedSigner) {
+		t.Errorf("pull with empty trust store: err = %v, want %v", err, goobla.ErrUntrustedSigner)
+	}
+
+	if _, _, err := GetManifest(ParseModelPath(signed)); !os.IsNotExist(err) {
+		t.Errorf("expected no manifest after a refused pull, got %v", err)
+	}
+
+	trusted := append([]byte("# publishers\n"), ssh.MarshalAuthorizedKey(key.PublicKey())...)
+	trusted = append(trusted[:len(trusted)-1], " publisher\n"...)
+	if err := os.WriteFile(goobla.TrustedKeysFile(), trusted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PullModel(t.Context(), signed, opts, progress); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+
+	resp, err := GetModelInfo(api.ShowRequest{Model: signed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Signer == nil || !resp.Signer.Trusted || resp.Signer.Name != "publisher" {
+		t.Errorf("signer = %+v, want the trusted publisher key", resp.Signer)
+	}
+
+	// a signature that does not match the manifest is refused even
+	// without requiring signatures
+	t.Setenv("GOOBLA_REQUIRE_SIGNATURES", "")
+	if err := writeModelSignature("sha256:"+want.digest, "AAAA:AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkModelSignature("sha256:"+want.digest, "AAAA:AAAA"); !errors.Is(err, goobla.ErrInvalidSignature) {
+		t.Errorf("err = %v, want %v", err, goobla.ErrInvalidSignature)
+	}
+	if _, err := modelSigner("sha256:" + want.digest); !errors.Is(err, goobla.ErrInvalidSignature) {
+		t.Errorf("err = %v, want %v", err, goobla.ErrInvalidSignature)
+	}
+
+	if err := PullModel(t.Context(), unsigned, opts, progress); err != nil {
+		t.Errorf("pull of unsigned model: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("GOOBLA_MODELS"), "signatures")); err != nil {
+		t.Errorf("expected pulled signatures to be kept: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goobla/goobla/tracing"
+)
+
+// tracingMiddleware starts a span for each request, continuing the trace of
+// the caller if it sent a traceparent header.
+func tracingMiddleware(c *gin.Context) {
+	ctx, span := tracing.Start(tracing.Extract(c.Request.Context(), c.Request.Header), c.Request.Method+" "+c.FullPath())
+	if span == nil {
+		c.Next()
+		return
+	}
+
+	span.SetKind(tracing.KindServer)
+	span.SetAttributes("http.request.method", c.Request.Method, "http.route", c.FullPath())
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes("http.response.status_code", status)
+	if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+	span.End()
+}
@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/grammar"
+)
+
+// Grammar returns a GBNF grammar that only accepts tool calls in the format
+// the model's chat template uses, so that constrained output always parses
+// with a [Parser] created from the same template and tools. If name is not
+// empty, only calls to that function are accepted. If parallel is false,
+// exactly one call is accepted, otherwise one or more.
+func Grammar(tmpl *template.Template, tools []api.Tool, name string, parallel bool) (string, error) {
+	return grammarWithTag(parseTag(tmpl), tools, name, parallel)
+}
+
+func grammarWithTag(tag string, tools []api.Tool, name string, parallel bool) (string, error) {
+	var alternatives []any
+	for _, t := range tools {
+		if name != "" && t.Function.Name != name {
+			continue
+		}
+
+		args, err := argumentsSchema(t)
+		if err != nil {
+			return "", err
+		}
+
+		var call callSchema
+		call.Type = "object"
+		call.Properties.Name.Const = t.Function.Name
+		call.Properties.Arguments = args
+		call.Required = []string{"name", "arguments"}
+		alternatives = append(alternatives, call)
+	}
+
+	switch len(alternatives) {
+	case 0:
+		if name != "" {
+			return "", fmt.Errorf("tool %q not found", name)
+		}
+		return "", errors.New("no tools provided")
+	case 1:
+	default:
+		alternatives = []any{map[string]any{"anyOf": alternatives}}
+	}
+
+	schema, err := json.Marshal(alternatives[0])
+	if err != nil {
+		return "", err
+	}
+
+	g, err := grammar.FromJSONSchema(schema)
+	if err != nil {
+		return "", fmt.Errorf("invalid tool parameters schema: %w", err)
+	}
+
+	// the converted schema becomes a single call and a new root wraps it
+	// in the template's tool calling tag
+	var sb strings.Builder
+	for line := range strings.Lines(g) {
+		if rest, ok := strings.CutPrefix(line, "root ::="); ok {
+			line = "tool-call ::=" + rest
+		}
+		sb.WriteString(line)
+	}
+
+	sb.WriteString("\nroot ::= ")
+	switch {
+	case tag == "{":
+		sb.WriteString("tool-call")
+		if parallel {
+			sb.WriteString(" tool-call*")
+		}
+	case strings.HasSuffix(tag, "["):
+		// tags like "[TOOL_CALLS] [" open a list of calls
+		sb.WriteString(quoteGrammar(tag) + " space tool-call")
+		if parallel {
+			sb.WriteString(` ("," space tool-call)*`)
+		}
+		sb.WriteString(` "]"`)
+	default:
+		sb.WriteString(quoteGrammar(tag) + " space tool-call")
+		if parallel {
+			sb.WriteString(" tool-call*")
+		}
+	}
+	sb.WriteString("\n")
+
+	return sb.String(), nil
+}
+
+// callSchema is the JSON schema of a single tool call. It is a struct rather
+// than a map so that "name" is generated before "arguments", matching the
+// order chat templates render tool calls in.
+type callSchema struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Name struct {
+			Const string `json:"const"`
+		} `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"properties"`
+	Required []string `json:"required"`
+}
+
+// argumentsSchema returns the JSON schema of a tool's arguments. Fields that
+// are unset in the tool definition are dropped since null is not a schema.
+func argumentsSchema(t api.Tool) (map[string]any, error) {
+	bts, err := json.Marshal(t.Function.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(bts, &schema); err != nil {
+		return nil, err
+	}
+
+	for k, v := range schema {
+		if v == nil || v == "" {
+			delete(schema, k)
+		}
+	}
+
+	schema["type"] = "object"
+	if _, ok := schema["properties"]; !ok {
+		schema["properties"] = map[string]any{}
+	}
+
+	return schema, nil
+}
+
+// quoteGrammar returns s as a GBNF string literal.
+func quoteGrammar(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/goobla/goobla/api"
+	"github.com/goobla/goobla/llama"
+)
+
+// accepts reports whether grammar matches s in full. It uses a vocabulary of
+// single printable ASCII characters so every byte of s is its own token.
+func accepts(t *testing.T, grammar, s string) bool {
+	t.Helper()
+
+	const eos = 128
+	var ids []uint32
+	var pieces []string
+	for c := range 128 {
+		ids = append(ids, uint32(c))
+		if c < ' ' && c != '\n' {
+			pieces = append(pieces, "\x00")
+		} else {
+			pieces = append(pieces, string(rune(c)))
+		}
+	}
+	ids = append(ids, eos)
+	pieces = append(pieces, "")
+
+	g := llama.NewGrammar(grammar, ids, pieces, []int32{eos})
+	if g == nil {
+		t.Fatalf("invalid grammar:\n%s", grammar)
+	}
+	defer g.Free()
+
+	allowed := func(id int32) bool {
+		tokens := make([]llama.TokenData, len(ids))
+		for i := range ids {
+			tokens[i] = llama.TokenData{ID: int32(ids[i]), Logit: 1}
+		}
+		g.Apply(tokens)
+		return !math.IsInf(float64(tokens[id].Logit), -1)
+	}
+
+	for _, c := range []byte(s) {
+		if !allowed(int32(c)) {
+			return false
+		}
+		g.Accept(int32(c))
+	}
+
+	return allowed(eos)
+}
+
+func TestGrammar(t *testing.T) {
+	var tools []api.Tool
+	if err := json.Unmarshal([]byte(`[
+		{"type": "function", "function": {"name": "get_temperature", "parameters": {"type": "object", "required": ["city"], "properties": {"city": {"type": "string"}, "unit": {"type": "string", "enum": ["celsius", "fahrenheit"]}}}}},
+		{"type": "function", "function": {"name": "get_time", "parameters": {"type": "object", "properties": {}}}}
+	]`), &tools); err != nil {
+		t.Fatal(err)
+	}
+
+	qwen := template.Must(template.New("qwen").Parse(`{{if .ToolCalls}}<tool_call>{{range .ToolCalls}}{"name": "{{.Function.Name}}", "arguments": {{.Function.Arguments}}}{{end}}</tool_call>{{end}}`))
+	mistral := template.Must(template.New("mistral").Parse(`{{if .ToolCalls}}[TOOL_CALLS] [{{range .ToolCalls}}{"name": "{{.Function.Name}}", "arguments": {{.Function.Arguments}}}{{end}}][/TOOL_CALLS]{{end}}`))
+	json := template.Must(template.New("json").Parse(`{{if .ToolCalls}}{{range .ToolCalls}}{"name": "{{.Function.Name}}", "arguments": {{.Function.Arguments}}}{{end}}{{end}}`))
+
+	temperature := `{"name": "get_temperature", "arguments": {"city": "Paris", "unit": "celsius"}}`
+	time := `{"name": "get_time", "arguments": {}}`
+
+	cases := []struct {
+		name     string
+		tmpl     *template.Template
+		function string
+		parallel bool
+		output   string
+		accepted bool
+		calls    []string
+	}{
+		{name: "json", tmpl: json, output: temperature, accepted: true, calls: []string{"get_temperature"}},
+		{name: "json without arguments", tmpl: json, output: time, accepted: true, calls: []string{"get_time"}},
+		{name: "json missing required", tmpl: json, output: `{"name": "get_temperature", "arguments": {"unit": "celsius"}}`},
+		{name: "json invalid enum", tmpl: json, output: `{"name": "get_temperature", "arguments": {"city": "Paris", "unit": "kelvin"}}`},
+		{name: "json unknown tool", tmpl: json, output: `{"name": "get_weather", "arguments": {}}`},
+		{name: "json content", tmpl: json, output: "The temperature is 20 degrees"},
+		{name: "json named", tmpl: json, function: "get_time", output: temperature},
+		{name: "json parallel", tmpl: json, parallel: true, output: temperature + time, accepted: true, calls: []string{"get_temperature", "get_time"}},
+		{name: "json not parallel", tmpl: json, output: temperature + time},
+		{name: "qwen", tmpl: qwen, output: "<tool_call>" + temperature, accepted: true, calls: []string{"get_temperature"}},
+		{name: "qwen missing tag", tmpl: qwen, output: temperature},
+		{name: "mistral", tmpl: mistral, output: "[TOOL_CALLS] [" + time + "]", accepted: true, calls: []string{"get_time"}},
+		{name: "mistral parallel", tmpl: mistral, parallel: true, output: "[TOOL_CALLS] [" + time + ", " + temperature + "]", accepted: true, calls: []string{"get_time", "get_temperature"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Grammar(tt.tmpl, tools, tt.function, tt.parallel)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := accepts(t, g, tt.output); got != tt.accepted {
+				t.Fatalf("accepts(%q) = %v, want %v\n%s", tt.output, got, tt.accepted, g)
+			}
+
+			if !tt.accepted {
+				return
+			}
+
+			p := NewParser(tt.tmpl, tools)
+			var calls []string
+			for _, c := range strings.SplitAfter(tt.output, " ") {
+				tcs, _ := p.Add(c)
+				for _, tc := range tcs {
+					calls = append(calls, tc.Function.Name)
+				}
+			}
+
+			if diff := cmp.Diff(tt.calls, calls); diff != "" {
+				t.Errorf("parsed calls mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGrammarErrors(t *testing.T) {
+	tools := []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_time"}}}
+
+	if _, err := Grammar(nil, tools, "get_weather", false); err == nil || !strings.Contains(err.Error(), `"get_weather" not found`) {
+		t.Errorf("expected tool not found error, got %v", err)
+	}
+
+	if _, err := Grammar(nil, nil, "", false); err == nil {
+		t.Error("expected error for no tools")
+	}
+
+	var even api.Tool
+	if err := json.Unmarshal([]byte(`{"type": "function", "function": {"name": "pick", "parameters": {"type": "object", "properties": {"n": {"type": "array", "items": {"type": "number", "multipleOf": 2}}}}}}`), &even); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Grammar(nil, []api.Tool{even}, "", false); err == nil || !strings.Contains(err.Error(), `"multipleOf" is not supported`) {
+		t.Errorf("expected unsupported schema error, got %v", err)
+	}
+}
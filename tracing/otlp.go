@@ -0,0 +1,406 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goobla/goobla/version"
+)
+
+const (
+	// maxBatch is the number of spans exported together
+	maxBatch = 512
+	// maxQueue is the number of ended spans waiting to be exported before
+	// new spans are dropped
+	maxQueue = 4096
+	// exportInterval is how often queued spans are exported
+	exportInterval = 5 * time.Second
+)
+
+// Exporter sends encoded OTLP/JSON trace export requests to a collector.
+type Exporter interface {
+	Export(ctx context.Context, data []byte) error
+}
+
+// HTTPExporter posts traces to an OTLP/HTTP endpoint.
+type HTTPExporter struct {
+	URL    string
+	Client *http.Client
+}
+
+func (e *HTTPExporter) Export(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export traces: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// FileExporter appends traces to a file, one export request per line, in
+// the format of the OpenTelemetry Collector's file exporter.
+type FileExporter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (e *FileExporter) Export(_ context.Context, data []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, err := os.OpenFile(e.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Tracer batches ended spans and exports them in the background.
+type Tracer struct {
+	service   string
+	exporters []Exporter
+
+	spans chan *Span
+	flush chan chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewTracer returns a tracer that exports spans for service to each of
+// exporters. It must be shut down to export the remaining spans.
+func NewTracer(service string, exporters ...Exporter) *Tracer {
+	t := &Tracer{
+		service:   service,
+		exporters: exporters,
+		spans:     make(chan *Span, maxQueue),
+		flush:     make(chan chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Configure enables tracing for service, exporting spans to an OTLP/HTTP
+// endpoint, a file, or both. Tracing stays disabled if neither is set. The
+// returned function exports any remaining spans and disables tracing.
+func Configure(service, endpoint, file string) (shutdown func(context.Context) error, err error) {
+	var exporters []Exporter
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid trace endpoint %q", endpoint)
+		}
+
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/traces"
+		}
+		exporters = append(exporters, &HTTPExporter{URL: u.String(), Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+
+	if file != "" {
+		exporters = append(exporters, &FileExporter{Path: file})
+	}
+
+	if len(exporters) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	t := NewTracer(service, exporters...)
+	tracer.Store(t)
+	return func(ctx context.Context) error {
+		tracer.CompareAndSwap(t, nil)
+		return t.Shutdown(ctx)
+	}, nil
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		slog.Debug("trace queue full, dropping span", "name", s.name)
+		return
+	}
+
+	if s.root {
+		select {
+		case t.flush <- nil:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	export := func() {
+		// collect everything that is already queued
+	drain:
+		for {
+			select {
+			case s := <-t.spans:
+				batch = append(batch, s)
+			default:
+				break drain
+			}
+		}
+
+		for len(batch) > 0 {
+			n := min(len(batch), maxBatch)
+			t.export(batch[:n])
+			batch = batch[n:]
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= maxBatch {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ch := <-t.flush:
+			export()
+			if ch != nil {
+				close(ch)
+			}
+		case <-t.stop:
+			export()
+			return
+		}
+	}
+}
+
+func (t *Tracer) export(spans []*Span) {
+	data, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		slog.Warn("failed to encode traces", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, e := range t.exporters {
+		if err := e.Export(ctx, data); err != nil {
+			slog.Warn("failed to export traces", "error", err)
+		}
+	}
+}
+
+// Flush exports all ended spans, waiting until they are sent or ctx is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	ch := make(chan struct{})
+	select {
+	case t.flush <- ch:
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-ch:
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Shutdown exports all ended spans and stops the tracer.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() { close(t.stop) })
+
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The types below mirror the JSON encoding of the OTLP
+// ExportTraceServiceRequest message.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpStatusError is the OTLP status code of a failed span.
+const otlpStatusError = 2
+
+func (t *Tracer) encode(spans []*Span) otlpTraces {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		encoded[i] = otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.attrs),
+		}
+		if s.parent != (SpanID{}) {
+			encoded[i].ParentSpanID = s.parent.String()
+		}
+		if s.err != nil {
+			encoded[i].Status = &otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+	}
+
+	return otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: encodeAttributes([]any{"service.name", t.service, "service.version", version.Version}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/goobla/goobla/tracing", Version: version.Version},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func encodeAttributes(args []any) []otlpKeyValue {
+	var kvs []otlpKeyValue
+	for i := 0; i+1 < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprint(args[i])
+		}
+		kvs = append(kvs, otlpKeyValue{Key: key, Value: encodeValue(args[i+1])})
+	}
+	return kvs
+}
+
+func encodeValue(v any) otlpAnyValue {
+	var i int64
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		i = int64(v)
+	case int32:
+		i = int64(v)
+	case int64:
+		i = v
+	case uint32:
+		i = int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			s := strconv.FormatUint(v, 10)
+			return otlpAnyValue{StringValue: &s}
+		}
+		i = int64(v)
+	case float32:
+		f := float64(v)
+		return otlpAnyValue{DoubleValue: &f}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case time.Duration:
+		f := v.Seconds()
+		return otlpAnyValue{DoubleValue: &f}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+
+	s := strconv.FormatInt(i, 10)
+	return otlpAnyValue{IntValue: &s}
+}
@@ -0,0 +1,230 @@
+// Package tracing records spans describing the work done for a request and
+// exports them in the OTLP/JSON format.
+//
+// Tracing is disabled until [Configure] is called with an endpoint or file.
+// While disabled, [Start] returns a nil *Span, and all Span methods are safe
+// to call on a nil Span.
+//
+// Trace context is propagated between processes with the W3C traceparent
+// header, see [Inject] and [Extract].
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether both the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Kind describes the relationship of a span to its parent and children, as
+// defined by OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Span is a timed operation within a trace.
+type Span struct {
+	tracer *Tracer
+
+	sc     SpanContext
+	parent SpanID
+	// root is set for spans without a parent in this process; their end
+	// flushes pending spans so they are not lost if the process exits
+	root bool
+
+	name  string
+	start time.Time
+
+	mu    sync.Mutex
+	kind  Kind
+	end   time.Time
+	attrs []any
+	err   error
+}
+
+// SpanContext returns the IDs of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetKind sets the kind of the span, which defaults to [KindInternal].
+func (s *Span) SetKind(kind Kind) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kind = kind
+}
+
+// SetAttributes adds attributes to the span from alternating keys and
+// values, in the style of [log/slog].
+func (s *Span) SetAttributes(args ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, args...)
+}
+
+// SetError marks the span as failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End completes the span and queues it for export. Only the first call to
+// End has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.enqueue(s)
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span as the parent of
+// spans started from it.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+var tracer atomic.Pointer[Tracer]
+
+// Start starts a span named name as a child of the span in ctx, or of the
+// remote span extracted into ctx, and returns a context carrying the new
+// span. It returns a nil span if tracing is disabled.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t := tracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		kind:   KindInternal,
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.sc.TraceID = parent.sc.TraceID
+		span.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		span.sc.TraceID = remote.TraceID
+		span.parent = remote.SpanID
+		span.root = true
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		span.root = true
+	}
+	rand.Read(span.sc.SpanID[:])
+
+	return ContextWithSpan(ctx, span), span
+}
+
+const traceparentHeader = "traceparent"
+
+// Inject sets the traceparent header of h to the span in ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		h.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01", span.sc.TraceID, span.sc.SpanID))
+	}
+}
+
+// Extract returns a copy of ctx carrying the span context from the
+// traceparent header of h, which spans started from it will use as their
+// parent. ctx is returned unchanged if the header is missing or invalid.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := parseTraceparent(h.Get(traceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func parseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || parts[0] != "00" {
+		return SpanContext{}, fmt.Errorf("unsupported traceparent %q", s)
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != hex.EncodedLen(len(sc.TraceID)) {
+		return SpanContext{}, fmt.Errorf("invalid trace id %q", parts[1])
+	} else if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace id %q: %w", parts[1], err)
+	}
+
+	if len(parts[2]) != hex.EncodedLen(len(sc.SpanID)) {
+		return SpanContext{}, fmt.Errorf("invalid span id %q", parts[2])
+	} else if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid span id %q: %w", parts[2], err)
+	}
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
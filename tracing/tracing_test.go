@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readSpans returns the spans written by a FileExporter, keyed by name.
+func readSpans(t *testing.T, path string) map[string]otlpSpan {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	spans := make(map[string]otlpSpan)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var traces otlpTraces
+		if err := json.Unmarshal(scanner.Bytes(), &traces); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rs := range traces.ResourceSpans {
+			if got := rs.Resource.Attributes[0]; got.Key != "service.name" || *got.Value.StringValue != "test" {
+				t.Errorf("unexpected resource attribute %+v", got)
+			}
+
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	if _, span := Start(t.Context(), "disabled"); span != nil {
+		t.Fatal("expected nil span while tracing is disabled")
+	}
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Configure("test", "", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := Start(t.Context(), "root")
+	root.SetKind(KindServer)
+	root.SetAttributes("model", "llama3.2", "tokens", 42, "ok", true)
+
+	h := make(http.Header)
+	Inject(ctx, h)
+
+	// a span started in another process from the propagated header
+	_, remote := Start(Extract(context.Background(), h), "remote")
+	remote.SetError(errors.New("boom"))
+	remote.End()
+
+	_, child := Start(ctx, "child")
+	child.End()
+	child.End()
+	root.End()
+
+	if err := shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, span := Start(t.Context(), "after shutdown"); span != nil {
+		t.Fatal("expected nil span after shutdown")
+	}
+
+	spans := readSpans(t, path)
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	rs, rm, ch := spans["root"], spans["remote"], spans["child"]
+	if rs.ParentSpanID != "" || rs.Kind != KindServer {
+		t.Errorf("unexpected root span %+v", rs)
+	}
+	if rm.TraceID != rs.TraceID || rm.ParentSpanID != rs.SpanID {
+		t.Errorf("remote span not linked to root: %+v", rm)
+	}
+	if rm.Status == nil || rm.Status.Code != otlpStatusError || rm.Status.Message != "boom" {
+		t.Errorf("unexpected remote status %+v", rm.Status)
+	}
+	if ch.TraceID != rs.TraceID || ch.ParentSpanID != rs.SpanID || ch.Kind != KindInternal {
+		t.Errorf("child span not linked to root: %+v", ch)
+	}
+
+	attrs := make(map[string]otlpAnyValue)
+	for _, kv := range rs.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["model"].StringValue; v == nil || *v != "llama3.2" {
+		t.Errorf("unexpected model attribute %+v", attrs["model"])
+	}
+	if v := attrs["tokens"].IntValue; v == nil || *v != "42" {
+		t.Errorf("unexpected tokens attribute %+v", attrs["tokens"])
+	}
+	if v := attrs["ok"].BoolValue; v == nil || !*v {
+		t.Errorf("unexpected ok attribute %+v", attrs["ok"])
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	var got otlpTraces
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	shutdown, err := Configure("test", srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := Start(t.Context(), "span")
+	span.End()
+
+	if err := shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("unexpected export %+v", got)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":   true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00":   true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":   false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":   false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":   false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736ff-00f067aa0ba902b7-01": false,
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01":   false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":      false,
+		"": false,
+	}
+
+	for s, valid := range cases {
+		if _, err := parseTraceparent(s); (err == nil) != valid {
+			t.Errorf("parseTraceparent(%q): got error %v, want valid %v", s, err, valid)
+		}
+	}
+}